@@ -0,0 +1,148 @@
+// kvdb-server serves a Bitcask database over the Redis protocol, so
+// existing Redis clients can talk to it, and optionally over HTTP and
+// gRPC:
+//
+//	go run ./cmd/kvdb-server -dir ./data -http 127.0.0.1:8080 -grpc 127.0.0.1:9090
+//	redis-cli -p 6380 SET name Alice
+//	curl localhost:8080/v1/keys/name
+//
+// With -follow it's a read-only follower of the primary serving gRPC
+// at that address:
+//
+//	go run ./cmd/kvdb-server -dir ./replica -addr 127.0.0.1:6381 -follow 127.0.0.1:9090
+//
+// Several servers serving gRPC make a sharded store, each key on the
+// one the hash ring of their addresses picks (see internal/sharding):
+//
+//	go run ./cmd/kvdb-server -dir ./shard1 -addr 127.0.0.1:6381 -grpc 127.0.0.1:7001
+//	go run ./cmd/kvdb-server -dir ./shard2 -addr 127.0.0.1:6382 -grpc 127.0.0.1:7002
+//	go run ./cmd/kvdb shard -nodes 127.0.0.1:7001,127.0.0.1:7002 put name Alice
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi"
+	"github.com/yashagw/kvdb/internal/httpapi"
+	"github.com/yashagw/kvdb/internal/replication"
+	"github.com/yashagw/kvdb/internal/resp"
+	"github.com/yashagw/kvdb/internal/sharding"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6380", "address to serve the Redis protocol on")
+	httpAddr := flag.String("http", "", "address to serve the HTTP API on, none if empty")
+	grpcAddr := flag.String("grpc", "", "address to serve gRPC on, none if empty")
+	dir := flag.String("dir", "./data", "database directory")
+	sync := flag.Bool("sync", false, "sync every write to disk")
+	follow := flag.String("follow", "", "gRPC address of a primary to follow, serving only reads")
+	flag.Parse()
+
+	cfg := bitcask.DefaultConfig()
+	cfg.SyncWrites = *sync
+
+	db, err := bitcask.Open(*dir, cfg)
+	if err != nil {
+		log.Fatal("Failed to open database: ", err)
+	}
+
+	srv := resp.New(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	followDone := make(chan struct{})
+	if *follow != "" {
+		conn, err := grpc.NewClient(*follow, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatal("Failed to connect to primary: ", err)
+		}
+		defer conn.Close()
+
+		rcfg := replication.DefaultConfig()
+		rcfg.PositionFile = filepath.Join(*dir, "replication.pos")
+		f, err := replication.NewFollower(db, conn, rcfg)
+		if err != nil {
+			log.Fatal("Failed to start following: ", err)
+		}
+		go func() {
+			defer close(followDone)
+			log.Printf("Following %s", *follow)
+			if err := f.Run(ctx); !errors.Is(err, context.Canceled) {
+				log.Print("Replication failed: ", err)
+				srv.Close()
+			}
+		}()
+	} else {
+		close(followDone)
+	}
+
+	var httpSrv *http.Server
+	if *httpAddr != "" {
+		httpSrv = &http.Server{Addr: *httpAddr, Handler: httpapi.New(db, nil)}
+		go func() {
+			log.Printf("Serving HTTP on %s", *httpAddr)
+			if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Print("HTTP server failed: ", err)
+				srv.Close()
+			}
+		}()
+	}
+
+	var grpcSrv *grpc.Server
+	if *grpcAddr != "" {
+		ln, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatal("Failed to listen for gRPC: ", err)
+		}
+		grpcSrv = grpc.NewServer(grpc.MaxRecvMsgSize(grpcapi.MaxMessageSize(cfg.MaxValueSize)))
+		grpcapi.New(db).Register(grpcSrv)
+		replication.NewServer(db, nil).Register(grpcSrv)
+		sharding.NewServer(db).Register(grpcSrv)
+		go func() {
+			log.Printf("Serving gRPC on %s", *grpcAddr)
+			if err := grpcSrv.Serve(ln); err != nil {
+				log.Print("gRPC server failed: ", err)
+				srv.Close()
+			}
+		}()
+	}
+
+	// Stop cleanly on Ctrl-C so the database is closed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		srv.Close()
+	}()
+
+	log.Printf("Serving %s on %s", *dir, *addr)
+	err = srv.ListenAndServe(*addr)
+	if !errors.Is(err, resp.ErrServerClosed) {
+		log.Print("Server failed: ", err)
+	}
+
+	if httpSrv != nil {
+		httpSrv.Shutdown(context.Background())
+	}
+	if grpcSrv != nil {
+		grpcSrv.Stop() // Watches never finish on their own
+	}
+	cancel()
+	<-followDone
+	if err := db.Close(); err != nil {
+		log.Fatal("Failed to close database: ", err)
+	}
+}
@@ -0,0 +1,151 @@
+// kvdb inspects and edits Bitcask databases, either one command at a
+// time or in an interactive shell:
+//
+//	go run ./cmd/kvdb put ./data name Alice
+//	go run ./cmd/kvdb -format hex get ./data name
+//	go run ./cmd/kvdb shell ./data
+//	go run ./cmd/kvdb fsck -repair ./data-repaired ./data
+//	go run ./cmd/kvdb dump -values utf8 ./data/0000000001.bitcask
+//	go run ./cmd/kvdb export -o data.jsonl ./data
+//	go run ./cmd/kvdb shard -nodes localhost:7001,localhost:7002 get name
+//
+// The directory is opened as it is, nothing in it is deleted.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yashagw/kvdb/internal/cli"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: kvdb [flags] <command> <dir> [args]
+       kvdb [flags] [shell [dir]]
+
+Commands:
+  get <dir> <key>
+  put <dir> <key> <value> [ttl]
+  del <dir> <key>
+  keys <dir> [prefix]
+  scan <dir> [start [end]]
+  stats <dir>
+  merge <dir>
+  backup <dir> <backup dir>
+  shell [dir]              start the interactive shell, see help in it
+  fsck [-repair <new dir>] [-v] <dir>
+                           check every record without opening the database
+  dump [-values none|hex|utf8|json] [-key pattern] [-since t] [-until t] [-jsonl] <file>
+                           print every record of a .bitcask file
+  export [-format jsonl|csv] [-prefix p] [-o file] <dir>
+                           write every key with its value, timestamp and expiry
+  import [-format jsonl|csv] [-batch n] [-checkpoint file] [-dry-run] <dir> <file>
+                           load an export in batches, resuming where a failed one stopped
+  shard -nodes a,b,c [-vnodes n] <get|put|del|locate|rebalance> [args]
+                           use kvdb servers sharded by key, rebalance <new nodes> moves keys
+
+Flags:`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	format := flag.String("format", "raw", "how values are shown and read: raw, hex or base64")
+	flag.Usage = usage
+	flag.Parse()
+
+	cfg := cli.DefaultConfig()
+	var err error
+	if cfg.Format, err = cli.ParseFormat(*format); err != nil {
+		fail(err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 || args[0] == "shell" {
+		if len(args) > 2 {
+			usage()
+			os.Exit(2)
+		}
+		runShell(cfg, args)
+		return
+	}
+
+	switch args[0] {
+	case "fsck", "dump", "export", "import", "shard":
+		tools := map[string]func(io.Writer, []string) error{
+			"fsck":   cli.Fsck,
+			"dump":   cli.Dump,
+			"export": cli.Export,
+			"import": cli.Import,
+			"shard":  cli.Shard,
+		}
+		if err := tools[args[0]](os.Stdout, args[1:]); err != nil {
+			fail(err)
+		}
+		return
+	case "get", "put", "del", "keys", "scan", "stats", "merge", "backup":
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// A mistyped directory shouldn't quietly become a new database
+	if _, err := os.Stat(args[1]); err != nil {
+		fail(err)
+	}
+
+	cfg.Prompt = false
+	s := cli.New(os.Stdout, cfg)
+	if err := s.Open(args[1]); err != nil {
+		fail(err)
+	}
+	err = s.ExecArgs(append([]string{args[0]}, args[2:]...))
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// runShell runs the interactive shell on stdin, opening the directory
+// given after shell if any
+func runShell(cfg *cli.Config, args []string) {
+	if home, err := os.UserHomeDir(); err == nil {
+		cfg.HistoryFile = filepath.Join(home, ".kvdb_history")
+	}
+	// Only prompt a person, not a script piped in
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		cfg.Prompt = false
+	}
+
+	s := cli.New(os.Stdout, cfg)
+	if len(args) == 2 {
+		if err := s.Open(args[1]); err != nil {
+			fail(err)
+		}
+	}
+
+	err := s.Run(os.Stdin)
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2) // Usage was printed
+	}
+	fmt.Fprintln(os.Stderr, "kvdb:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,129 @@
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Stats describes the files and keys of a database
+type Stats struct {
+	Keys         int    // Live keys
+	DataFiles    int    // Data files, including the active one
+	BlobFiles    int    // Blob files
+	DataSize     int64  // Bytes in data files
+	BlobSize     int64  // Bytes in blob files
+	ActiveFileID uint32 // ID of the file being written
+}
+
+// Stats returns the current stats of the database
+func (bc *Bitcask) Stats() Stats {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	stats := Stats{
+		DataFiles:    len(bc.readOnlyFiles) + 1,
+		BlobFiles:    len(bc.blobFiles),
+		DataSize:     bc.activeFile.Size(),
+		ActiveFileID: bc.activeFile.ID(),
+	}
+	for _, e := range bc.keyDir {
+		if !e.expired() {
+			stats.Keys++
+		}
+	}
+	for _, lf := range bc.readOnlyFiles {
+		stats.DataSize += lf.Size()
+	}
+	for _, lf := range bc.blobFiles {
+		stats.BlobSize += lf.Size()
+	}
+	return stats
+}
+
+// Backup copies the database to dir, which must not exist or be
+// empty, while it stays open for reads and writes. Files are only
+// ever appended to, so the backup is the database as it was when
+// Backup started: every file is opened and its size noted under the
+// locks, then copied up to that size after they're released. A merge
+// deleting files meanwhile doesn't matter as they're already open.
+func (bc *Bitcask) Backup(dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("backup directory %s isn't empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	type snapshot struct {
+		file *os.File
+		size int64
+	}
+	var files []snapshot
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	err := func() error {
+		bc.blobMu.Lock()
+		defer bc.blobMu.Unlock()
+		bc.mu.Lock()
+		defer bc.mu.Unlock()
+
+		if err := bc.activeFile.Flush(); err != nil {
+			return fmt.Errorf("failed to flush active file: %w", err)
+		}
+		if bc.activeBlob != nil {
+			if err := bc.activeBlob.Flush(); err != nil {
+				return fmt.Errorf("failed to flush active blob file: %w", err)
+			}
+		}
+
+		all := []*LogFile{bc.activeFile}
+		for _, lf := range bc.readOnlyFiles {
+			all = append(all, lf)
+		}
+		for _, lf := range bc.blobFiles {
+			all = append(all, lf)
+		}
+		for _, lf := range all {
+			file, err := os.Open(lf.Path())
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", lf.Path(), err)
+			}
+			files = append(files, snapshot{file: file, size: lf.Size()})
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := copyFile(filepath.Join(dir, filepath.Base(f.file.Name())), f.file, f.size); err != nil {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+// copyFile copies the first size bytes of src to a new file at path
+// and syncs it
+func copyFile(path string, src *os.File, size int64) error {
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, io.NewSectionReader(src, 0, size)); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src.Name(), err)
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup file: %w", err)
+	}
+	return dst.Close()
+}
@@ -0,0 +1,71 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	big := bytes.Repeat([]byte("blob"), 1000)
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("big", big))
+	assert.NoError(t, db.Put("gone", []byte("v")))
+	assert.NoError(t, db.Delete("gone"))
+
+	stats := db.Stats()
+	assert.Equal(t, 2, stats.Keys)
+	assert.Equal(t, 1, stats.DataFiles)
+	assert.Equal(t, 1, stats.BlobFiles)
+	assert.True(t, stats.BlobSize > int64(len(big)))
+
+	backup := filepath.Join(t.TempDir(), "backup")
+	assert.NoError(t, db.Backup(backup))
+
+	// Writes after the backup started aren't in it
+	assert.NoError(t, db.Put("late", []byte("v")))
+
+	copied, err := Open(backup, blobConfig())
+	assert.NoError(t, err)
+	defer copied.Close()
+	value, err := copied.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	value, err = copied.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, value)
+	for _, key := range []string{"gone", "late"} {
+		_, err = copied.Get(key)
+		assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+	}
+
+	// A directory with files in it isn't overwritten
+	assert.Error(t, db.Backup(backup))
+}
+
+func TestCloseRemovesEmptyActiveFile(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("k", []byte("v")))
+	assert.NoError(t, db.Close())
+
+	// Opening and closing without writing leaves only the first file
+	for i := 0; i < 3; i++ {
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Close())
+	}
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
@@ -0,0 +1,322 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrConflict is returned by Apply when a key isn't at the version
+// the batch requires
+var ErrConflict = errors.New("version conflict")
+
+// AnyVersion passed to Batch.Require only requires the key to exist
+const AnyVersion int64 = -1
+
+// Batch collects writes for Apply to make atomically: readers see all
+// of them or none, and after a crash the database has all of them or
+// none.
+type Batch struct {
+	ops      []batchOp
+	requires []batchRequire
+}
+
+// batchOp is one write in a batch
+type batchOp struct {
+	key    string
+	value  []byte
+	ttl    time.Duration
+	delete bool
+}
+
+// batchRequire is a version a key must be at for a batch to apply
+type batchRequire struct {
+	key     string
+	version int64
+}
+
+// Put adds a write of a key-value pair to the batch
+func (b *Batch) Put(key string, value []byte) {
+	b.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL adds a write of a key-value pair that expires after
+// ttl, or never if ttl isn't positive
+func (b *Batch) PutWithTTL(key string, value []byte, ttl time.Duration) {
+	b.ops = append(b.ops, batchOp{key: key, value: value, ttl: ttl})
+}
+
+// Delete adds a delete of key to the batch. Unlike Bitcask.Delete it
+// isn't an error if the key doesn't exist.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Require makes Apply fail with ErrConflict unless key is at version
+// when the batch is applied. Version 0 requires the key not to exist
+// and AnyVersion only that it does.
+func (b *Batch) Require(key string, version int64) {
+	b.requires = append(b.requires, batchRequire{key: key, version: version})
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Version returns the version of key: the timestamp of the last write
+// to it, which changes whenever its value or expiry does
+func (bc *Bitcask) Version(key string) (int64, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return keyDirEntry.Timestamp, nil
+}
+
+// GetWithVersion retrieves a value by key along with its version
+func (bc *Bitcask) GetWithVersion(key string) ([]byte, int64, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	value, err := bc.readValue(key, keyDirEntry)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, keyDirEntry.Timestamp, nil
+}
+
+// Apply writes a batch atomically and returns the version every key
+// it puts is now at. Its records are written together to the active
+// file, each but the last marked as part of a batch, so rebuilding
+// the key directory can drop a batch that never finished, whether a
+// crash cut it off between two records or inside one.
+func (bc *Bitcask) Apply(b *Batch) (int64, error) {
+	if bc.readOnly.Load() {
+		return 0, ErrReadOnly
+	}
+	for _, op := range b.ops {
+		if err := checkSize("key", len(op.key), bc.config.MaxKeySize); err != nil {
+			return 0, err
+		}
+		if err := checkSize("value", len(op.value), bc.config.MaxValueSize); err != nil {
+			return 0, err
+		}
+	}
+
+	// Values are compressed before taking the lock, like Put does
+	entries := make([]*LogEntry, len(b.ops))
+	blobValues := make([][]byte, len(b.ops))
+	blobs := false
+	for i, op := range b.ops {
+		entry := &LogEntry{
+			KeySize: uint64(len(op.key)),
+			Key:     []byte(op.key),
+		}
+		entries[i] = entry
+		if op.delete {
+			entry.Delete = true
+			continue
+		}
+
+		entry.Expires = expiresAt(op.ttl)
+		if bc.isBlob(int64(len(op.value))) {
+			blobValues[i] = op.value
+			blobs = true
+			continue
+		}
+
+		stored, codec, err := bc.compressValue(op.value)
+		if err != nil {
+			return 0, err
+		}
+		entry.Value = stored
+		entry.ValueSize = uint64(len(stored))
+		entry.Codec = codec
+	}
+
+	// Large values go to blob files first, just as in putBlob. The
+	// versions are checked before, so a conflict doesn't leave blob
+	// data behind.
+	if blobs {
+		bc.blobMu.Lock()
+		defer bc.blobMu.Unlock()
+
+		bc.mu.RLock()
+		err := bc.checkRequires(b)
+		bc.mu.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+
+		if err := bc.writeBlobs(entries, blobValues); err != nil {
+			return 0, err
+		}
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// Checked again, as a write without blobs can come in while they're
+	// written. Merge reclaims the blob data such a conflict leaves.
+	if err := bc.checkRequires(b); err != nil {
+		return 0, err
+	}
+
+	version := bc.nextTimestamp()
+	records := bc.dropMissingDeletes(entries)
+	for _, entry := range records {
+		entry.Timestamp = version
+	}
+	if err := bc.commitBatch(records); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// dropMissingDeletes returns entries without the deletes of keys that
+// don't exist, counting the writes before them, which need no record.
+// mu must be held.
+func (bc *Bitcask) dropMissingDeletes(entries []*LogEntry) []*LogEntry {
+	exists := make(map[string]bool)
+	var records []*LogEntry
+	for _, entry := range entries {
+		key := string(entry.Key)
+		present, seen := exists[key]
+		if !seen {
+			_, err := bc.lookup(key)
+			present = err == nil
+		}
+		exists[key] = !entry.Tombstone()
+
+		if entry.Tombstone() && !present {
+			continue
+		}
+		records = append(records, entry)
+	}
+	return records
+}
+
+// commitBatch writes records to the active file as one batch, then
+// points their keys at them. Their timestamps must be set. mu must be
+// held.
+func (bc *Bitcask) commitBatch(records []*LogEntry) error {
+	if len(records) == 0 {
+		return nil
+	}
+	for i, entry := range records {
+		entry.Batch = i < len(records)-1
+		entry.BatchEnd = len(records) > 1 && i == len(records)-1
+	}
+
+	if err := bc.prepareActiveFile(); err != nil {
+		return err
+	}
+
+	valuePos := make([]uint64, len(records))
+	for i, entry := range records {
+		pos, err := bc.activeFile.Write(entry)
+		if err != nil {
+			// Start a new file so the next batch's records can't be
+			// mistaken for the rest of this one
+			return errors.Join(fmt.Errorf("failed to write batch: %w", err), bc.rotateActiveFile())
+		}
+		valuePos[i] = pos
+	}
+
+	if err := bc.flushActiveFile(); err != nil {
+		return err
+	}
+
+	// Only now does anyone see the batch
+	for i, entry := range records {
+		key := string(entry.Key)
+		if entry.Tombstone() {
+			delete(bc.keyDir, key)
+			bc.notify(key, true, entry.Timestamp)
+			continue
+		}
+
+		keyDirEntry, err := newKeyDirEntry(bc.activeFile.ID(), valuePos[i], entry)
+		if err != nil {
+			return err
+		}
+		bc.keyDir[key] = keyDirEntry
+		bc.notify(key, false, entry.Timestamp)
+	}
+
+	return nil
+}
+
+// writeBlobs writes each value that isn't nil to a blob file and
+// points the entry at the same index to it. blobMu must be held.
+func (bc *Bitcask) writeBlobs(entries []*LogEntry, values [][]byte) error {
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		if err := bc.prepareActiveBlob(); err != nil {
+			return fmt.Errorf("failed to create blob file: %w", err)
+		}
+		pos, stored, err := bc.activeBlob.WriteBlob(entries[i].Key, bytes.NewReader(value), int64(len(value)))
+		if err != nil {
+			return err
+		}
+
+		ptr := blobPointer{FileID: bc.activeBlob.ID(), Offset: pos, Size: stored}
+		entries[i].Blob = true
+		entries[i].Value = ptr.encode()
+		entries[i].ValueSize = blobPointerSize
+	}
+
+	// The values have to be on disk before the records pointing to them
+	var err error
+	if bc.config.SyncWrites {
+		err = bc.activeBlob.Sync()
+	} else {
+		err = bc.activeBlob.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync blob file: %w", err)
+	}
+	return nil
+}
+
+// checkRequires returns ErrConflict unless every key b requires is at
+// its version. mu must be held.
+func (bc *Bitcask) checkRequires(b *Batch) error {
+	for _, req := range b.requires {
+		if err := bc.checkVersion(req.key, req.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVersion returns ErrConflict unless key is at version. mu must
+// be held.
+func (bc *Bitcask) checkVersion(key string, version int64) error {
+	var current int64
+	keyDirEntry, err := bc.lookup(key)
+	switch {
+	case err == nil:
+		current = keyDirEntry.Timestamp
+	case !errors.Is(err, ErrKeyNotFound):
+		return err
+	}
+
+	if version == AnyVersion && current != 0 || version == current {
+		return nil
+	}
+	return fmt.Errorf("%w: %q is at version %d, not %d", ErrConflict, key, current, version)
+}
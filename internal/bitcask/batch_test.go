@@ -0,0 +1,200 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Put("a", []byte("old")))
+	assert.NoError(t, db.Put("b", []byte("old")))
+
+	big := bytes.Repeat([]byte("x"), 4096)
+	var b Batch
+	b.Put("a", []byte("new"))
+	b.Delete("b")
+	b.Delete("missing") // Not an error
+	b.Put("c", []byte("temp"))
+	b.Delete("c") // Deletes the batch's own write
+	b.Put("blob", big)
+	assert.Equal(t, 6, b.Len())
+
+	version, err := db.Apply(&b)
+	assert.NoError(t, err)
+
+	check := func(db *Bitcask) {
+		got, v, err := db.GetWithVersion("a")
+		assert.NoError(t, err)
+		assert.Equal(t, "new", string(got))
+		assert.Equal(t, version, v)
+
+		got, err = db.Get("blob")
+		assert.NoError(t, err)
+		assert.Equal(t, big, got)
+
+		for _, key := range []string{"b", "c", "missing"} {
+			_, err := db.Get(key)
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+		}
+	}
+	check(db)
+
+	// The batch survives a reopen and a merge
+	assert.NoError(t, db.Close())
+	db, err = Open(dir, blobConfig())
+	assert.NoError(t, err)
+	check(db)
+	assert.NoError(t, db.Merge())
+	check(db)
+	assert.NoError(t, db.Close())
+}
+
+func TestBatchRequire(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("k", []byte("v1")))
+	v1, err := db.Version("k")
+	assert.NoError(t, err)
+
+	apply := func(key string, version int64) error {
+		var b Batch
+		b.Require(key, version)
+		b.Put("k", []byte("changed"))
+		b.Put("other", []byte("changed"))
+		_, err := db.Apply(&b)
+		return err
+	}
+
+	// A failed requirement writes nothing
+	for _, version := range []int64{v1 - 1, 0} {
+		err := apply("k", version)
+		assert.True(t, errors.Is(err, ErrConflict), "got %v", err)
+	}
+	err = apply("missing", AnyVersion)
+	assert.True(t, errors.Is(err, ErrConflict), "got %v", err)
+	_, err = db.Get("other")
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+	assert.NoError(t, apply("missing", 0))
+	assert.NoError(t, apply("k", AnyVersion))
+
+	// Writes move the version on, so the old one conflicts
+	v2, err := db.Version("k")
+	assert.NoError(t, err)
+	assert.True(t, v2 > v1)
+	err = apply("k", v1)
+	assert.True(t, errors.Is(err, ErrConflict), "got %v", err)
+	assert.NoError(t, apply("k", v2))
+}
+
+func TestBatchRequireBlob(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("k", []byte("v1")))
+	assert.NoError(t, db.Put("big", bytes.Repeat([]byte("a"), 4096)))
+	blobSize := func() int64 {
+		var size int64
+		for _, lf := range db.blobFiles {
+			size += lf.Size()
+		}
+		return size
+	}
+	before := blobSize()
+	assert.True(t, before > 0)
+
+	// The conflict is found before the blob is written
+	var b Batch
+	b.Require("k", 0)
+	b.Put("big", bytes.Repeat([]byte("b"), 4096))
+	_, err = db.Apply(&b)
+	assert.True(t, errors.Is(err, ErrConflict), "got %v", err)
+	assert.Equal(t, before, blobSize())
+
+	value, err := db.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 4096), value)
+}
+
+func TestBatchTorn(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Put("before", []byte("v")))
+	var b Batch
+	b.Put("x", []byte("1"))
+	b.Put("y", []byte("2"))
+	b.Put("z", []byte("3"))
+	_, err = db.Apply(&b)
+	assert.NoError(t, err)
+	path := db.activeFile.Path()
+	assert.NoError(t, db.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lf, err := NewLogFile(dir, 1, true, DefaultConfig())
+	assert.NoError(t, err)
+	var starts []int64
+	for pos := lf.DataStart(); pos < lf.Size(); {
+		starts = append(starts, pos)
+		_, pos, err = lf.ReadEntry(pos)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, lf.Close())
+	assert.Equal(t, 4, len(starts))
+
+	// Cut the batch off at every byte, between its records or in the
+	// middle of one, as a crash while writing it would
+	for cut := starts[1]; cut < int64(len(data)); cut++ {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(path)), data[:cut], 0644))
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err, "cut at %d", cut)
+		_, err = db.Get("before")
+		assert.NoError(t, err, "cut at %d", cut)
+		for _, key := range []string{"x", "y", "z"} {
+			_, err := db.Get(key)
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "%s cut at %d: got %v", key, cut, err)
+		}
+
+		// Writes carry on after the cut
+		assert.NoError(t, db.Put("after", []byte("v")))
+		assert.NoError(t, db.Close())
+		db, err = Open(dir, nil)
+		assert.NoError(t, err, "cut at %d", cut)
+		assert.Equal(t, 2, len(db.Keys()), "cut at %d", cut)
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxValueSize = 10
+	db, err := Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var b Batch
+	b.Put("ok", []byte("small"))
+	b.Put("big", bytes.Repeat([]byte("x"), 11))
+	_, err = db.Apply(&b)
+	assert.True(t, errors.Is(err, ErrTooLarge), "got %v", err)
+
+	_, err = db.Get("ok")
+	assert.True(t, errors.Is(err, ErrKeyNotFound))
+}
@@ -1,20 +1,64 @@
 package bitcask
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// KeyDirEntry represents an entry in the in-memory key directory
+// KeyDirEntry represents an entry in the in-memory key directory.
+// FileID stays 32 bits: it counts files rather than bytes, so it allows
+// 4 billion files of up to MaxFileSize each, and every key in memory
+// would pay for a wider one.
 type KeyDirEntry struct {
 	FileID    uint32 // Which log file contains this key
-	ValueSize uint32 // Size of the value
+	ValueSize uint64 // Size of the value as stored
+	Codec     Codec  // How the stored value is compressed
 	ValuePos  uint64 // Position of the value in the file
-	Timestamp uint32 // When this key was written
+	Timestamp int64  // When this key was written, in Unix nanoseconds
+	Expires   int64  // When the key expires in Unix nanoseconds, 0 for never
+	Blob      bool   // Whether FileID and ValuePos point into a blob file
+}
+
+// newKeyDirEntry returns the key directory entry for a record whose
+// value ends up at valuePos in file fileID. A blob pointer's entry
+// points into the blob file instead.
+func newKeyDirEntry(fileID uint32, valuePos uint64, entry *LogEntry) (*KeyDirEntry, error) {
+	if entry.Blob {
+		ptr, err := decodeBlobPointer(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("bad blob pointer for %q: %w", entry.Key, err)
+		}
+		return &KeyDirEntry{
+			FileID:    ptr.FileID,
+			ValueSize: ptr.Size,
+			ValuePos:  ptr.Offset,
+			Timestamp: entry.Timestamp,
+			Expires:   entry.Expires,
+			Blob:      true,
+		}, nil
+	}
+
+	return &KeyDirEntry{
+		FileID:    fileID,
+		ValueSize: entry.ValueSize,
+		Codec:     entry.Codec,
+		ValuePos:  valuePos,
+		Timestamp: entry.Timestamp,
+		Expires:   entry.Expires,
+	}, nil
+}
+
+// expired reports whether the key of e has expired
+func (e *KeyDirEntry) expired() bool {
+	return e.Expires != 0 && e.Expires <= time.Now().UnixNano()
 }
 
 // Bitcask represents the main database instance
@@ -25,6 +69,15 @@ type Bitcask struct {
 	activeFile    *LogFile                // Currently active log file for writes
 	readOnlyFiles map[uint32]*LogFile     // Read-only log files
 	config        *Config                 // Configuration options
+	lastTimestamp int64                   // Timestamp of the newest record
+	readOnly      atomic.Bool             // Whether writes other than ApplyLog fail, see SetReadOnly
+
+	blobMu     sync.Mutex          // Serializes blob writes, taken before mu
+	activeBlob *LogFile            // Blob file being written, nil until the first blob
+	blobFiles  map[uint32]*LogFile // Every blob file, including the active one
+
+	watchMu  sync.Mutex            // Guards watchers, taken after mu
+	watchers map[*Watcher]struct{} // Open watchers, see watch.go
 }
 
 // Open opens a Bitcask database at the given path
@@ -43,6 +96,7 @@ func Open(path string, cfg *Config) (*Bitcask, error) {
 		path:          path,
 		keyDir:        make(map[string]*KeyDirEntry),
 		readOnlyFiles: make(map[uint32]*LogFile),
+		blobFiles:     make(map[uint32]*LogFile),
 		config:        cfg,
 	}
 
@@ -61,14 +115,25 @@ func Open(path string, cfg *Config) (*Bitcask, error) {
 
 // Close closes the database and all open files
 func (bc *Bitcask) Close() error {
+	bc.blobMu.Lock()
+	defer bc.blobMu.Unlock()
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	// Close active file
+	bc.closeWatchers()
+
+	// Close active file. Open creates one every time, so an empty one
+	// is removed to not leave a file behind each time the database is
+	// only read.
 	if bc.activeFile != nil {
 		if err := bc.activeFile.Close(); err != nil {
 			return fmt.Errorf("failed to close active file: %w", err)
 		}
+		if bc.activeFile.Size() == bc.activeFile.DataStart() {
+			if err := os.Remove(bc.activeFile.Path()); err != nil {
+				return fmt.Errorf("failed to remove empty active file: %w", err)
+			}
+		}
 	}
 
 	// Close all read-only files
@@ -78,6 +143,13 @@ func (bc *Bitcask) Close() error {
 		}
 	}
 
+	// Close all blob files
+	for _, file := range bc.blobFiles {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to close blob file: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -87,8 +159,10 @@ func (bc *Bitcask) Keys() []string {
 	defer bc.mu.RUnlock()
 
 	keys := make([]string, 0, len(bc.keyDir))
-	for key := range bc.keyDir {
-		keys = append(keys, key)
+	for key, e := range bc.keyDir {
+		if !e.expired() {
+			keys = append(keys, key)
+		}
 	}
 
 	return keys
@@ -96,31 +170,40 @@ func (bc *Bitcask) Keys() []string {
 
 // loadFiles loads existing log files and rebuilds the key directory
 func (bc *Bitcask) loadFiles() error {
-	files, err := os.ReadDir(bc.path)
+	// Blob files only hold values, records point into them
+	blobIDs, err := listFileIDs(bc.path, blobFileExt)
 	if err != nil {
 		return err
 	}
-
-	// Find all .bitcask files and sort by ID
-	var fileIDs []uint32
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".bitcask") {
-			idStr := strings.TrimSuffix(file.Name(), ".bitcask")
-			id, err := strconv.ParseUint(idStr, 10, 32)
-			if err != nil {
-				continue // Skip invalid files
-			}
-			fileIDs = append(fileIDs, uint32(id))
+	for _, id := range blobIDs {
+		blobFile, err := openLogFile(fileName(bc.path, id, blobFileExt), id, true, bc.config)
+		if err != nil {
+			return err
 		}
+		bc.blobFiles[id] = blobFile
 	}
 
-	sort.Slice(fileIDs, func(i, j int) bool {
-		return fileIDs[i] < fileIDs[j]
-	})
+	fileIDs, err := listFileIDs(bc.path, dataFileExt)
+	if err != nil {
+		return err
+	}
 
-	// Load files and rebuild key directory
-	for _, id := range fileIDs {
-		logFile, err := NewLogFile(bc.path, id, true)
+	// Load files and rebuild key directory. Opening a file validates
+	// its header, so a damaged header or one from a newer version
+	// stops Open instead of being misread as records.
+	for i, id := range fileIDs {
+		// The newest file was being written when the database last
+		// stopped, so a crash can leave it cut off part way
+		newest := i == len(fileIDs)-1
+
+		logFile, err := NewLogFile(bc.path, id, true, bc.config)
+		if newest && errors.Is(err, errTornHeader) {
+			// No record was written after the header, nothing is lost
+			if err := os.Remove(fileName(bc.path, id, dataFileExt)); err != nil {
+				return fmt.Errorf("failed to remove file with torn header: %w", err)
+			}
+			break
+		}
 		if err != nil {
 			return err
 		}
@@ -128,7 +211,7 @@ func (bc *Bitcask) loadFiles() error {
 		bc.readOnlyFiles[id] = logFile
 
 		// Read all entries to rebuild key directory
-		if err := bc.rebuildKeyDir(logFile); err != nil {
+		if err := bc.rebuildKeyDir(logFile, newest); err != nil {
 			return err
 		}
 	}
@@ -136,6 +219,32 @@ func (bc *Bitcask) loadFiles() error {
 	return nil
 }
 
+// listFileIDs returns the IDs of the files in dir with the given
+// extension in ascending order
+func listFileIDs(dir, ext string) ([]uint32, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIDs []uint32
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ext) {
+			idStr := strings.TrimSuffix(file.Name(), ext)
+			id, err := strconv.ParseUint(idStr, 10, 32)
+			if err != nil {
+				continue // Skip invalid files
+			}
+			fileIDs = append(fileIDs, uint32(id))
+		}
+	}
+
+	sort.Slice(fileIDs, func(i, j int) bool {
+		return fileIDs[i] < fileIDs[j]
+	})
+	return fileIDs, nil
+}
+
 // createActiveFile creates a new active file for writing
 func (bc *Bitcask) createActiveFile() error {
 	// Find the next file ID
@@ -149,7 +258,7 @@ func (bc *Bitcask) createActiveFile() error {
 	nextID := maxID + 1
 
 	// Create new active file
-	activeFile, err := NewLogFile(bc.path, nextID, false)
+	activeFile, err := NewLogFile(bc.path, nextID, false, bc.config)
 	if err != nil {
 		return err
 	}
@@ -172,38 +281,92 @@ func (bc *Bitcask) rotateActiveFile() error {
 	return bc.createActiveFile()
 }
 
-// rebuildKeyDir rebuilds the key directory from a log file
-func (bc *Bitcask) rebuildKeyDir(logFile *LogFile) error {
-	var pos int64 = 0
+// rebuildKeyDir rebuilds the key directory from a log file. The
+// records of a batch are held back until its last one, so a batch
+// cut short by a crash is dropped as a whole. In the newest file a
+// record cut off at the end is the end of the log, and it's truncated
+// along with any batch that never ended.
+func (bc *Bitcask) rebuildKeyDir(logFile *LogFile, newest bool) error {
+	type record struct {
+		entry   *LogEntry
+		nextPos int64
+	}
+	var batch []record
+	var batchStart int64
+
+	pos := logFile.DataStart()
 
 	for {
 		entry, nextPos, err := logFile.ReadEntry(pos)
+		if errors.Is(err, io.EOF) {
+			break // End of file
+		}
+		if newest && errors.Is(err, io.ErrUnexpectedEOF) {
+			break // A record torn by a crash, the end of the log
+		}
 		if err != nil {
-			if err.Error() == "EOF" {
-				break // End of file
-			}
 			return err
 		}
+		bc.lastTimestamp = max(bc.lastTimestamp, entry.Timestamp)
 
-		key := string(entry.Key)
+		if entry.Batch {
+			if batch == nil {
+				batchStart = pos
+			}
+			batch = append(batch, record{entry, nextPos})
+			pos = nextPos
+			continue
+		}
+		pos = nextPos
+		if entry.BatchEnd {
+			for _, r := range batch {
+				if err := bc.applyRecord(logFile, r.entry, r.nextPos); err != nil {
+					return err
+				}
+			}
+		}
+		batch = nil // Not ended, so the batch was never committed
 
-		// If value size is 0, this is a tombstone (deletion)
-		if entry.ValueSize == 0 {
-			delete(bc.keyDir, key)
-		} else {
-			// Calculate value position
-			valuePos := pos + 12 + int64(entry.KeySize)
+		if err := bc.applyRecord(logFile, entry, nextPos); err != nil {
+			return err
+		}
+	}
 
-			bc.keyDir[key] = &KeyDirEntry{
-				FileID:    logFile.ID(),
-				ValueSize: entry.ValueSize,
-				ValuePos:  uint64(valuePos),
-				Timestamp: entry.Timestamp,
-			}
+	// Cut off a torn record or a batch that never ended, so new writes
+	// don't follow them and leave them in the middle of the log
+	if batch != nil {
+		pos = batchStart
+	}
+	if newest && pos < logFile.Size() {
+		if err := logFile.truncate(pos); err != nil {
+			return err
 		}
+	}
 
-		pos = nextPos
+	return nil
+}
+
+// applyRecord updates the key directory with a record read from
+// logFile that ends at nextPos
+func (bc *Bitcask) applyRecord(logFile *LogFile, entry *LogEntry, nextPos int64) error {
+	key := string(entry.Key)
+
+	// A tombstone deletes the key. An expired record hides older ones
+	// just the same.
+	if entry.Tombstone() {
+		delete(bc.keyDir, key)
+		return nil
 	}
 
+	// The value is at the end of the record
+	keyDirEntry, err := newKeyDirEntry(logFile.ID(), uint64(nextPos)-entry.ValueSize, entry)
+	if err != nil {
+		return err
+	}
+	if keyDirEntry.expired() {
+		delete(bc.keyDir, key)
+	} else {
+		bc.keyDir[key] = keyDirEntry
+	}
 	return nil
 }
@@ -0,0 +1,552 @@
+package bitcask
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+// jsonValue returns a JSON blob that compresses well
+func jsonValue(i int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for j := 0; j < 50; j++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"user %d","email":"user%d@example.com","active":true},`, i, j, j)
+	}
+	buf.WriteString("{}]")
+	return buf.Bytes()
+}
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.Compression = CodecFlate
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+
+	big := jsonValue(1)
+	assert.NoError(t, db.Put("big", big))
+	assert.NoError(t, db.Put("small", []byte("tiny")))
+
+	// The big value was stored compressed, the small one under the
+	// threshold as is
+	assert.Equal(t, CodecFlate, db.keyDir["big"].Codec)
+	assert.True(t, int(db.keyDir["big"].ValueSize)*5 < len(big), "stored %d of %d bytes", db.keyDir["big"].ValueSize, len(big))
+	assert.Equal(t, CodecNone, db.keyDir["small"].Codec)
+
+	got, err := db.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+	assert.NoError(t, db.Close())
+
+	// Records keep their codec, so they read back whatever the config
+	// says now
+	db, err = Open(dir, DefaultConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	got, err = db.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+	got, err = db.Get("small")
+	assert.NoError(t, err)
+	assert.Equal(t, "tiny", string(got))
+
+	assert.NoError(t, db.Put("raw", big))
+	assert.Equal(t, CodecNone, db.keyDir["raw"].Codec)
+}
+
+func TestCompressionIncompressible(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Compression = CodecFlate
+	cfg.CompressionThreshold = 0
+
+	db, err := Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Short random looking values would only grow
+	value := []byte("x7Q!")
+	assert.NoError(t, db.Put("k", value))
+	assert.Equal(t, CodecNone, db.keyDir["k"].Codec)
+
+	got, err := db.Get("k")
+	assert.NoError(t, err)
+	assert.Equal(t, value, got)
+}
+
+// fileContents returns every data file in dir concatenated
+func fileContents(t *testing.T, dir string) []byte {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.bitcask"))
+	assert.NoError(t, err)
+
+	var all []byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		all = append(all, data...)
+	}
+	return all
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.EncryptionKey = bytes.Repeat([]byte{7}, 32)
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("secret-key", []byte("secret-value")))
+	assert.NoError(t, db.Put("gone", []byte("soon")))
+	assert.NoError(t, db.Delete("gone"))
+	assert.NoError(t, db.Close())
+
+	// Values are encrypted, keys aren't unless asked for
+	data := fileContents(t, dir)
+	assert.False(t, bytes.Contains(data, []byte("secret-value")))
+	assert.True(t, bytes.Contains(data, []byte("secret-key")))
+
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	got, err := db.Get("secret-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-value", string(got))
+	_, err = db.Get("gone")
+	assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+	assert.NoError(t, db.Close())
+
+	// A file can't be opened without its key, or with the wrong one
+	_, err = Open(dir, DefaultConfig())
+	assert.Error(t, err)
+
+	wrong := DefaultConfig()
+	wrong.EncryptionKey = bytes.Repeat([]byte{8}, 32)
+	_, err = Open(dir, wrong)
+	assert.True(t, errors.Is(err, ErrDecrypt), "got %v", err)
+}
+
+func TestEncryptKeys(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.EncryptionKey = bytes.Repeat([]byte{7}, 16)
+	cfg.EncryptKeys = true
+	cfg.Compression = CodecFlate
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	big := jsonValue(1)
+	assert.NoError(t, db.Put("secret-key", big))
+	assert.NoError(t, db.Close())
+
+	data := fileContents(t, dir)
+	assert.False(t, bytes.Contains(data, []byte("secret-key")))
+
+	// Keys are decrypted when the key directory is rebuilt
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"secret-key"}, db.Keys())
+	got, err := db.Get("secret-key")
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+}
+
+func TestKeyRotationMerge(t *testing.T) {
+	dir := t.TempDir()
+	ring := &KeyRing{Current: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}}
+	cfg := DefaultConfig()
+	cfg.KeyProvider = ring
+	cfg.MaxFileSize = 512
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("k%02d", i), []byte(fmt.Sprintf("old%d", i))))
+	}
+	assert.NoError(t, db.Close())
+
+	// Rotate: new files use key 2, old ones are still read with key 1
+	ring.Keys[2] = bytes.Repeat([]byte{2}, 32)
+	ring.Current = 2
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	for i := 0; i < 50; i += 2 {
+		assert.NoError(t, db.Put(fmt.Sprintf("k%02d", i), []byte(fmt.Sprintf("new%d", i))))
+	}
+	assert.NoError(t, db.Delete("k49"))
+
+	check := func(db *Bitcask) {
+		for i := 0; i < 49; i++ {
+			want := fmt.Sprintf("old%d", i)
+			if i%2 == 0 {
+				want = fmt.Sprintf("new%d", i)
+			}
+			got, err := db.Get(fmt.Sprintf("k%02d", i))
+			assert.NoError(t, err)
+			assert.Equal(t, want, string(got))
+		}
+		_, err := db.Get("k49")
+		assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+	}
+	check(db)
+
+	// Merge rewrites everything under key 2 and drops the old files
+	before := len(fileContents(t, dir))
+	assert.NoError(t, db.Merge())
+	check(db)
+	assert.True(t, len(fileContents(t, dir)) < before)
+	for _, lf := range db.readOnlyFiles {
+		assert.NotZero(t, lf.aead)
+	}
+	assert.NoError(t, db.Put("after", []byte("merge")))
+	assert.NoError(t, db.Close())
+
+	// Key 1 is no longer needed
+	delete(ring.Keys, 1)
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+	check(db)
+	got, err := db.Get("after")
+	assert.NoError(t, err)
+	assert.Equal(t, "merge", string(got))
+}
+
+func TestLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// A file written before headers existed. The marker is long and
+	// random, so ciphertext can't contain it by chance.
+	var buf bytes.Buffer
+	writeRecords(&buf, 10)
+	random := make([]byte, 32)
+	rand.Read(random)
+	marker := hex.EncodeToString(random)
+	binary.Write(&buf, binary.LittleEndian, uint32(1700000000))
+	binary.Write(&buf, binary.LittleEndian, uint32(len("marker")))
+	binary.Write(&buf, binary.LittleEndian, uint32(len(marker)))
+	buf.WriteString("marker")
+	buf.WriteString(marker)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0000000001.bitcask"), buf.Bytes(), 0644))
+
+	// It's read as is even with encryption on, and merged into
+	// encrypted files
+	cfg := DefaultConfig()
+	cfg.EncryptionKey = bytes.Repeat([]byte{7}, 32)
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.Get("k3")
+	assert.NoError(t, err)
+	assert.Equal(t, "v3", string(got))
+
+	assert.Zero(t, db.readOnlyFiles[1].Header())
+	assert.NoError(t, db.Merge())
+	assert.True(t, bytes.Contains(buf.Bytes(), []byte(marker)))
+	assert.False(t, bytes.Contains(fileContents(t, dir), []byte(marker)))
+	for _, lf := range db.readOnlyFiles {
+		assert.Equal(t, uint8(fileVersion), lf.Header().Version)
+	}
+	for i := 0; i < 10; i++ {
+		got, err := db.Get(fmt.Sprintf("k%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("v%d", i), string(got))
+	}
+}
+
+// writeRecords appends unencrypted records in the format every
+// version shares
+func writeRecords(buf *bytes.Buffer, n int) {
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)
+		binary.Write(buf, binary.LittleEndian, uint32(1700000000))
+		binary.Write(buf, binary.LittleEndian, uint32(len(key)))
+		binary.Write(buf, binary.LittleEndian, uint32(len(val)))
+		buf.WriteString(key)
+		buf.WriteString(val)
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.Compression = CodecFlate
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("k", []byte("v")))
+	h := db.activeFile.Header()
+	assert.Equal(t, uint8(fileVersion), h.Version)
+	assert.Equal(t, FlagCompressed, h.Flags)
+	assert.True(t, time.Since(h.Created) < time.Minute)
+	assert.NoError(t, db.Close())
+
+	path := filepath.Join(dir, "0000000001.bitcask")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, fileMagic, string(data[:len(fileMagic)]))
+
+	// The header is read back as written
+	db, err = Open(dir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, h.Created.UnixNano(), db.readOnlyFiles[1].Header().Created.UnixNano())
+	assert.NoError(t, db.Close())
+
+	// Damaged headers stop Open instead of being read as records
+	damage := func(off int, b byte) {
+		bad := bytes.Clone(data)
+		bad[off] = b
+		assert.NoError(t, os.WriteFile(path, bad, 0644))
+		_, err := Open(dir, nil)
+		assert.True(t, errors.Is(err, ErrInvalidHeader), "got %v", err)
+	}
+	damage(20, data[20]^1) // Creation time, caught by the checksum
+	damage(8, 99)          // Version
+
+	bad := bytes.Clone(data)
+	bad[9] = 0x80 // A flag from the future
+	binary.LittleEndian.PutUint32(bad[24:], crc32.ChecksumIEEE(bad[:24]))
+	assert.NoError(t, os.WriteFile(path, bad, 0644))
+	_, err = Open(dir, nil)
+	assert.True(t, errors.Is(err, ErrInvalidHeader), "got %v", err)
+
+	// Only the current version is read
+	bad = bytes.Clone(data)
+	bad[8] = fileVersion - 1
+	binary.LittleEndian.PutUint32(bad[24:], crc32.ChecksumIEEE(bad[:24]))
+	assert.NoError(t, os.WriteFile(path, bad, 0644))
+	_, err = Open(dir, nil)
+	assert.True(t, errors.Is(err, ErrInvalidHeader), "got %v", err)
+
+	// So does a header cut off part way, unless it's in the newest
+	// file, where a crash while creating it leaves one
+	assert.NoError(t, os.WriteFile(path, data[:20], 0644))
+	newer := filepath.Join(dir, "0000000002.bitcask")
+	assert.NoError(t, os.WriteFile(newer, data, 0644))
+	_, err = Open(dir, nil)
+	assert.True(t, errors.Is(err, ErrInvalidHeader), "got %v", err)
+
+	assert.NoError(t, os.Remove(newer))
+	db, err = Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "got %v", err)
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("b", []byte("2")))
+	assert.NoError(t, db.Delete("a"))
+	assert.NoError(t, db.Put("c", []byte("3")))
+	path := db.activeFile.Path()
+	assert.NoError(t, db.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lf, err := OpenFile(path, nil)
+	assert.NoError(t, err)
+	var ends []int64
+	for pos := lf.DataStart(); pos < lf.Size(); {
+		_, pos, err = lf.ReadEntry(pos)
+		assert.NoError(t, err)
+		ends = append(ends, pos)
+	}
+	assert.NoError(t, lf.Close())
+	assert.Equal(t, 4, len(ends))
+
+	// What the database holds once each record is written
+	states := []map[string]string{
+		{},
+		{"a": "1"},
+		{"a": "1", "b": "2"},
+		{"b": "2"},
+		{"b": "2", "c": "3"},
+	}
+
+	// A crash can cut the newest file off at any byte. Open drops the
+	// torn record and the log carries on after the last whole one.
+	for cut := 0; cut < len(data); cut++ {
+		dir := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(path)), data[:cut], 0644))
+
+		written := 0
+		for written < len(ends) && ends[written] <= int64(cut) {
+			written++
+		}
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err, "cut at %d", cut)
+		assert.Equal(t, len(states[written]), len(db.Keys()), "cut at %d", cut)
+		for key, value := range states[written] {
+			got, err := db.Get(key)
+			assert.NoError(t, err, "cut at %d", cut)
+			assert.Equal(t, value, string(got), "cut at %d", cut)
+		}
+		assert.NoError(t, db.Put("d", []byte("4")))
+		assert.NoError(t, db.Close())
+
+		// The writes after the cut are read back too
+		db, err = Open(dir, nil)
+		assert.NoError(t, err, "cut at %d", cut)
+		got, err := db.Get("d")
+		assert.NoError(t, err, "cut at %d", cut)
+		assert.Equal(t, "4", string(got))
+		assert.NoError(t, db.Close())
+	}
+
+	// Only the newest file is written to, a torn record in an older
+	// one is damage
+	dir = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0000000001.bitcask"), data[:ends[1]+1], 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0000000002.bitcask"), data, 0644))
+	_, err = Open(dir, nil)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "got %v", err)
+}
+
+func TestSizeLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxKeySize = 8
+	cfg.MaxValueSize = 16
+
+	db, err := Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("12345678", bytes.Repeat([]byte("v"), 16)))
+
+	err = db.Put("123456789", []byte("v"))
+	assert.True(t, errors.Is(err, ErrTooLarge), "got %v", err)
+	var sizeErr *SizeError
+	assert.True(t, errors.As(err, &sizeErr))
+	assert.Equal(t, SizeError{Field: "key", Size: 9, Limit: 8}, *sizeErr)
+
+	err = db.Put("k", bytes.Repeat([]byte("v"), 17))
+	assert.True(t, errors.As(err, &sizeErr))
+	assert.Equal(t, "value", sizeErr.Field)
+	_, err = db.Get("k")
+	assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+}
+
+func TestRecordFormat(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.MaxFileSize = 4096
+
+	// Sizes over a byte's worth of varint, and timestamps that never
+	// go backwards
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	model := make(map[string][]byte)
+	var last int64
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("%s%d", bytes.Repeat([]byte("k"), i*7), i)
+		val := bytes.Repeat([]byte{byte(i)}, i*31+1)
+		assert.NoError(t, db.Put(key, val))
+		model[key] = val
+
+		ts := db.keyDir[key].Timestamp
+		assert.True(t, ts > last)
+		last = ts
+	}
+	assert.NoError(t, db.Close())
+
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+	for key, val := range model {
+		got, err := db.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+	assert.True(t, db.lastTimestamp >= last)
+}
+
+func TestEmptyValue(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprint("encrypted=", encrypted), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := DefaultConfig()
+			if encrypted {
+				cfg.EncryptionKey = bytes.Repeat([]byte{7}, 32)
+			}
+
+			// An empty value is a value, not a delete
+			db, err := Open(dir, cfg)
+			assert.NoError(t, err)
+			assert.NoError(t, db.Put("put", []byte{}))
+			var b Batch
+			b.Put("batch", nil)
+			_, err = db.Apply(&b)
+			assert.NoError(t, err)
+
+			check := func(db *Bitcask) {
+				t.Helper()
+				for _, key := range []string{"put", "batch"} {
+					got, err := db.Get(key)
+					assert.NoError(t, err, key)
+					assert.Equal(t, 0, len(got))
+				}
+			}
+			check(db)
+
+			records, _, err := db.ReadLog(Position{}, 1<<20)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(records))
+			for _, rec := range records {
+				assert.False(t, rec.Delete, rec.Key)
+			}
+			assert.NoError(t, db.Close())
+
+			db, err = Open(dir, cfg)
+			assert.NoError(t, err)
+			check(db)
+			assert.NoError(t, db.Merge())
+			check(db)
+			assert.NoError(t, db.Close())
+
+			db, err = Open(dir, cfg)
+			assert.NoError(t, err)
+			defer db.Close()
+			check(db)
+		})
+	}
+}
+
+func TestLegacyTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	writeRecords(&buf, 10)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "0000000001.bitcask"), buf.Bytes(), 0644))
+
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.Get("k4")
+	assert.NoError(t, err)
+	assert.Equal(t, "v4", string(got))
+
+	// Second timestamps are read as nanoseconds
+	assert.Equal(t, int64(1700000000)*int64(time.Second), db.keyDir["k4"].Timestamp)
+
+	// New writes are newer than anything already there
+	assert.NoError(t, db.Put("k4", []byte("new")))
+	assert.True(t, db.keyDir["k4"].Timestamp > int64(1700000000)*int64(time.Second))
+}
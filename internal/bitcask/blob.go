@@ -0,0 +1,448 @@
+package bitcask
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Values of at least Config.BlobThreshold bytes are kept out of the
+// data files, WiscKey style: the value goes to a blob file and the
+// record holds a pointer to it
+//
+//	[blob_file:4][offset:8][size:8]
+//
+// so merging only copies the pointer. Blob files have the same header
+// as data files and are named %010d.blob. In an encrypted blob file a
+// value is split into blobChunkSize chunks sealed one at a time, so it
+// can be streamed in either direction without holding it in memory.
+const (
+	blobPointerSize = 20
+	blobChunkSize   = 64 * 1024
+)
+
+// blobPointer is where a value lives in a blob file
+type blobPointer struct {
+	FileID uint32 // Blob file ID
+	Offset uint64 // Where the value starts
+	Size   uint64 // Size of the value on disk
+}
+
+func (p blobPointer) encode() []byte {
+	buf := make([]byte, 0, blobPointerSize)
+	buf = binary.LittleEndian.AppendUint32(buf, p.FileID)
+	buf = binary.LittleEndian.AppendUint64(buf, p.Offset)
+	return binary.LittleEndian.AppendUint64(buf, p.Size)
+}
+
+func decodeBlobPointer(data []byte) (blobPointer, error) {
+	if len(data) != blobPointerSize {
+		return blobPointer{}, fmt.Errorf("blob pointer of %d bytes", len(data))
+	}
+	return blobPointer{
+		FileID: binary.LittleEndian.Uint32(data),
+		Offset: binary.LittleEndian.Uint64(data[4:]),
+		Size:   binary.LittleEndian.Uint64(data[12:]),
+	}, nil
+}
+
+// blobPointerOf returns the pointer to a blob value in the key directory
+func blobPointerOf(e *KeyDirEntry) blobPointer {
+	return blobPointer{FileID: e.FileID, Offset: e.ValuePos, Size: e.ValueSize}
+}
+
+// chunkAAD ties a sealed chunk to its key and its place in the value,
+// so chunks can't be reordered or moved to another value
+func chunkAAD(key []byte, index uint64) []byte {
+	return append(binary.LittleEndian.AppendUint64(nil, index), key...)
+}
+
+// WriteBlob appends size bytes read from r to a blob file, encrypting
+// them if the file is encrypted. Returns where the value starts and
+// how many bytes it takes up on disk.
+func (lf *LogFile) WriteBlob(key []byte, r io.Reader, size int64) (uint64, uint64, error) {
+	if lf.readOnly {
+		return 0, 0, fmt.Errorf("cannot write to read-only file")
+	}
+
+	pos := lf.size
+	if lf.aead == nil {
+		n, err := io.CopyN(lf.writer, r, size)
+		lf.size += n
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to write blob: %w", noEOF(err))
+		}
+		return uint64(pos), uint64(size), nil
+	}
+
+	chunk := make([]byte, min(size, blobChunkSize))
+	for index := uint64(0); size > 0; index++ {
+		n := min(size, blobChunkSize)
+		if _, err := io.ReadFull(r, chunk[:n]); err != nil {
+			return 0, 0, fmt.Errorf("failed to read blob: %w", noEOF(err))
+		}
+
+		sealed, err := seal(lf.aead, chunk[:n], chunkAAD(key, index))
+		if err != nil {
+			return 0, 0, err
+		}
+		if _, err := lf.writer.Write(sealed); err != nil {
+			return 0, 0, fmt.Errorf("failed to write blob: %w", err)
+		}
+		lf.size += int64(len(sealed))
+		size -= n
+	}
+	return uint64(pos), uint64(lf.size - pos), nil
+}
+
+// blobValueSize returns the size of a value that takes up stored bytes
+// in the file
+func (lf *LogFile) blobValueSize(stored uint64) uint64 {
+	if lf.aead == nil {
+		return stored
+	}
+	overhead := uint64(lf.aead.NonceSize() + lf.aead.Overhead())
+	chunks := (stored + blobChunkSize + overhead - 1) / (blobChunkSize + overhead)
+	return stored - chunks*overhead
+}
+
+// blobReader returns a reader for the value of key at e. It reads
+// through file, which may be the LogFile's own handle or a separate
+// one that the reader then closes.
+func (lf *LogFile) blobReader(file *os.File, ownFile bool, key string, e *KeyDirEntry) *blobReader {
+	chunkSize := blobChunkSize
+	if lf.aead != nil {
+		chunkSize += lf.aead.NonceSize() + lf.aead.Overhead()
+	}
+
+	return &blobReader{
+		file:    file,
+		ownFile: ownFile,
+		aead:    lf.aead,
+		key:     []byte(key),
+		pos:     int64(e.ValuePos),
+		end:     int64(e.ValuePos + e.ValueSize),
+		chunk:   make([]byte, min(int64(chunkSize), int64(e.ValueSize))),
+	}
+}
+
+// blobReader streams a value out of a blob file a chunk at a time
+type blobReader struct {
+	file    *os.File
+	ownFile bool        // Whether Close closes file
+	aead    cipher.AEAD // Decrypts chunks, nil if the file isn't encrypted
+	key     []byte
+	pos     int64  // Offset of the next chunk
+	end     int64  // Offset just past the value
+	index   uint64 // Index of the next chunk
+	chunk   []byte // Buffer for a chunk as stored
+	buf     []byte // Data from the last chunk not returned yet
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.pos >= r.end {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill reads the next chunk into buf
+func (r *blobReader) fill() error {
+	chunk := r.chunk[:min(int64(len(r.chunk)), r.end-r.pos)]
+	if _, err := r.file.ReadAt(chunk, r.pos); err != nil {
+		return fmt.Errorf("failed to read blob at position %d: %w", r.pos, noEOF(err))
+	}
+	r.pos += int64(len(chunk))
+
+	if r.aead == nil {
+		r.buf = chunk
+		return nil
+	}
+
+	plain, err := open(r.aead, chunk, chunkAAD(r.key, r.index))
+	if err != nil {
+		return err
+	}
+	r.index++
+	r.buf = plain
+	return nil
+}
+
+func (r *blobReader) Close() error {
+	if r.ownFile {
+		return r.file.Close()
+	}
+	return nil
+}
+
+// isBlob reports whether a value of size bytes goes to a blob file
+func (bc *Bitcask) isBlob(size int64) bool {
+	return bc.config.BlobThreshold > 0 && size >= int64(bc.config.BlobThreshold)
+}
+
+// PutReader stores size bytes read from r under key. A value of at
+// least BlobThreshold bytes is streamed to a blob file without being
+// held in memory, a smaller one is read in and stored like Put would.
+func (bc *Bitcask) PutReader(key string, r io.Reader, size int64) error {
+	if bc.readOnly.Load() {
+		return ErrReadOnly
+	}
+	if size < 0 {
+		return fmt.Errorf("negative value size %d", size)
+	}
+	if err := checkSize("key", len(key), bc.config.MaxKeySize); err != nil {
+		return err
+	}
+	if err := checkSize("value", int(size), bc.config.MaxValueSize); err != nil {
+		return err
+	}
+
+	if bc.isBlob(size) {
+		return bc.putBlob(key, r, size, 0)
+	}
+
+	value := make([]byte, size)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return fmt.Errorf("failed to read value: %w", noEOF(err))
+	}
+	return bc.put(key, value, 0)
+}
+
+// GetReader returns a reader for the value of key. A blob value is
+// streamed from its blob file and stays readable even if a merge
+// deletes the file before the reader is closed. The caller must close
+// the reader.
+func (bc *Bitcask) GetReader(key string) (io.ReadCloser, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keyDirEntry.Blob {
+		value, err := bc.readValue(key, keyDirEntry)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(value)), nil
+	}
+
+	blobFile, exists := bc.blobFiles[keyDirEntry.FileID]
+	if !exists {
+		return nil, fmt.Errorf("blob file not found for file ID: %d", keyDirEntry.FileID)
+	}
+	file, err := os.Open(blobFile.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob file: %w", err)
+	}
+	return blobFile.blobReader(file, true, key, keyDirEntry), nil
+}
+
+// putBlob writes a value to the active blob file and a record
+// pointing to it to the active data file. Only blobMu is held while
+// the value is copied, so reads and small writes carry on.
+func (bc *Bitcask) putBlob(key string, r io.Reader, size int64, expires int64) error {
+	bc.blobMu.Lock()
+	defer bc.blobMu.Unlock()
+
+	if err := bc.prepareActiveBlob(); err != nil {
+		return fmt.Errorf("failed to create blob file: %w", err)
+	}
+
+	blobFile := bc.activeBlob
+	pos, stored, err := blobFile.WriteBlob([]byte(key), r, size)
+	if err != nil {
+		return err
+	}
+
+	// The value has to be on disk before the record pointing to it
+	if bc.config.SyncWrites {
+		err = blobFile.Sync()
+	} else {
+		err = blobFile.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync blob file: %w", err)
+	}
+
+	ptr := blobPointer{FileID: blobFile.ID(), Offset: pos, Size: stored}
+	entry := &LogEntry{
+		Expires:   expires,
+		KeySize:   uint64(len(key)),
+		ValueSize: blobPointerSize,
+		Blob:      true,
+		Key:       []byte(key),
+		Value:     ptr.encode(),
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	entry.Timestamp = bc.nextTimestamp()
+	return bc.appendKey(key, entry)
+}
+
+// prepareActiveBlob makes sure there's an active blob file with room
+// left. blobMu must be held.
+func (bc *Bitcask) prepareActiveBlob() error {
+	if bc.activeBlob != nil && bc.activeBlob.Size() < bc.config.MaxFileSize {
+		return nil
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.rotateActiveBlob()
+}
+
+// rotateActiveBlob starts a new active blob file. blobMu and mu must
+// be held.
+func (bc *Bitcask) rotateActiveBlob() error {
+	if bc.activeBlob != nil {
+		if err := bc.activeBlob.Sync(); err != nil {
+			return err
+		}
+	}
+
+	var maxID uint32
+	for id := range bc.blobFiles {
+		maxID = max(maxID, id)
+	}
+
+	blobFile, err := openLogFile(fileName(bc.path, maxID+1, blobFileExt), maxID+1, false, bc.config)
+	if err != nil {
+		return err
+	}
+
+	bc.blobFiles[blobFile.ID()] = blobFile
+	bc.activeBlob = blobFile
+	return nil
+}
+
+// readBlob reads a whole blob value into memory. mu must be held.
+func (bc *Bitcask) readBlob(key string, e *KeyDirEntry) ([]byte, error) {
+	blobFile, exists := bc.blobFiles[e.FileID]
+	if !exists {
+		return nil, fmt.Errorf("blob file not found for file ID: %d", e.FileID)
+	}
+
+	value := make([]byte, blobFile.blobValueSize(e.ValueSize))
+	r := blobFile.blobReader(blobFile.file, false, key, e)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", noEOF(err))
+	}
+	return value, nil
+}
+
+// rewriteBlobs copies the live values out of blob files that are
+// mostly garbage, or encrypted with a key other than the current one,
+// into new blob files. Returns the key directory entries of the moved
+// values. blobMu and mu must be held.
+func (bc *Bitcask) rewriteBlobs() (map[string]*KeyDirEntry, error) {
+	live := make(map[uint32]uint64)
+	for _, e := range bc.keyDir {
+		if e.Blob && !e.expired() {
+			live[e.FileID] += e.ValueSize
+		}
+	}
+
+	var currentKeyID uint32
+	if keys := bc.config.keyProvider(); keys != nil {
+		currentKeyID = keys.CurrentKeyID()
+	}
+
+	rewrite := make(map[uint32]bool)
+	for id, blobFile := range bc.blobFiles {
+		total := uint64(blobFile.Size() - blobFile.DataStart())
+		if live[id] > 0 && (live[id]*2 < total || blobFile.Header().KeyID != currentKeyID) {
+			rewrite[id] = true
+		}
+	}
+
+	moved := make(map[string]*KeyDirEntry)
+	if len(rewrite) == 0 {
+		return moved, nil
+	}
+
+	var keys []string
+	for key, e := range bc.keyDir {
+		if e.Blob && rewrite[e.FileID] && !e.expired() {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	// Copy into a new file, never into one being rewritten
+	if err := bc.rotateActiveBlob(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if bc.activeBlob.Size() >= bc.config.MaxFileSize {
+			if err := bc.rotateActiveBlob(); err != nil {
+				return nil, err
+			}
+		}
+
+		old := bc.keyDir[key]
+		from := bc.blobFiles[old.FileID]
+		r := from.blobReader(from.file, false, key, old)
+		pos, stored, err := bc.activeBlob.WriteBlob([]byte(key), r, int64(from.blobValueSize(old.ValueSize)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy blob of %q: %w", key, err)
+		}
+
+		moved[key] = &KeyDirEntry{
+			FileID:    bc.activeBlob.ID(),
+			ValueSize: stored,
+			ValuePos:  pos,
+			Timestamp: old.Timestamp,
+			Expires:   old.Expires,
+			Blob:      true,
+		}
+	}
+
+	if err := bc.activeBlob.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync blob file: %w", err)
+	}
+	return moved, nil
+}
+
+// removeUnusedBlobs deletes the blob files no key points into any
+// more. blobMu and mu must be held.
+func (bc *Bitcask) removeUnusedBlobs() error {
+	used := make(map[uint32]bool)
+	for _, e := range bc.keyDir {
+		if e.Blob {
+			used[e.FileID] = true
+		}
+	}
+
+	for id, blobFile := range bc.blobFiles {
+		if used[id] || blobFile == bc.activeBlob {
+			continue
+		}
+
+		delete(bc.blobFiles, id)
+		if err := blobFile.Close(); err != nil {
+			return fmt.Errorf("failed to close blob file: %w", err)
+		}
+		if err := os.Remove(blobFile.Path()); err != nil {
+			return fmt.Errorf("failed to remove blob file: %w", err)
+		}
+	}
+	return nil
+}
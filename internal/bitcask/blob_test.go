@@ -0,0 +1,250 @@
+package bitcask
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// blobConfig returns a config that puts values of 1KB or more in blob
+// files
+func blobConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.BlobThreshold = 1024
+	return cfg
+}
+
+// randomValue returns a reader for size bytes of data seeded by seed
+func randomValue(seed int64, size int64) io.Reader {
+	return io.LimitReader(rand.New(rand.NewSource(seed)), size)
+}
+
+// digest returns the SHA-256 of everything r reads
+func digest(t *testing.T, r io.Reader) [32]byte {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	assert.NoError(t, err)
+	return [32]byte(h.Sum(nil))
+}
+
+// blobFileCount returns the number of blob files in dir
+func blobFileCount(t *testing.T, dir string) int {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+blobFileExt))
+	assert.NoError(t, err)
+	return len(paths)
+}
+
+func TestBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+
+	big := bytes.Repeat([]byte("blob"), 1000)
+	assert.NoError(t, db.Put("big", big))
+	assert.NoError(t, db.Put("small", []byte("tiny")))
+	assert.True(t, db.keyDir["big"].Blob)
+	assert.False(t, db.keyDir["small"].Blob)
+
+	// Only the pointer is in the data file
+	assert.Equal(t, 1, blobFileCount(t, dir))
+	assert.True(t, db.activeFile.Size() < 200, "data file is %d bytes", db.activeFile.Size())
+
+	got, err := db.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+	assert.NoError(t, db.Close())
+
+	db, err = Open(dir, blobConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	got, err = db.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, got)
+
+	// Small values can be read as a stream too
+	r, err := db.GetReader("small")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "tiny", string(data))
+	assert.NoError(t, r.Close())
+
+	_, err = db.GetReader("missing")
+	assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+
+	// Deleting a blob value works like any other
+	assert.NoError(t, db.Delete("big"))
+	_, err = db.Get("big")
+	assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+}
+
+func TestPutReader(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := blobConfig()
+			if encrypted {
+				cfg.EncryptionKey = bytes.Repeat([]byte{7}, 32)
+			}
+
+			db, err := Open(dir, cfg)
+			assert.NoError(t, err)
+
+			// Sizes around the chunk size and the threshold
+			sizes := []int64{10, 1023, 1024, blobChunkSize - 1, blobChunkSize, blobChunkSize + 1, 5<<20 + 17}
+			for i, size := range sizes {
+				assert.NoError(t, db.PutReader(fmt.Sprintf("k%d", i), randomValue(int64(i), size), size))
+			}
+
+			check := func(db *Bitcask) {
+				for i, size := range sizes {
+					want := digest(t, randomValue(int64(i), size))
+
+					r, err := db.GetReader(fmt.Sprintf("k%d", i))
+					assert.NoError(t, err)
+					assert.Equal(t, want, digest(t, r))
+					assert.NoError(t, r.Close())
+
+					value, err := db.Get(fmt.Sprintf("k%d", i))
+					assert.NoError(t, err)
+					assert.Equal(t, size, int64(len(value)))
+					assert.Equal(t, want, sha256.Sum256(value))
+				}
+			}
+			check(db)
+			assert.NoError(t, db.Close())
+
+			db, err = Open(dir, cfg)
+			assert.NoError(t, err)
+			defer db.Close()
+			check(db)
+
+			// A reader that ends early doesn't leave a key behind
+			err = db.PutReader("short", randomValue(0, 4096), 8192)
+			assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "got %v", err)
+			_, err = db.Get("short")
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+		})
+	}
+}
+
+func TestPutReaderLimits(t *testing.T) {
+	cfg := blobConfig()
+	cfg.MaxValueSize = 1 << 20
+
+	db, err := Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	err = db.PutReader("k", randomValue(0, 2<<20), 2<<20)
+	var sizeErr *SizeError
+	assert.True(t, errors.As(err, &sizeErr), "got %v", err)
+	assert.Equal(t, "value", sizeErr.Field)
+
+	assert.Error(t, db.PutReader("k", randomValue(0, 16), -1))
+	_, err = db.Get("k")
+	assert.True(t, errors.Is(err, ErrKeyNotFound), "got %v", err)
+}
+
+func TestBlobMerge(t *testing.T) {
+	dir := t.TempDir()
+	cfg := blobConfig()
+	cfg.MaxFileSize = 64 * 1024
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Every key is overwritten a few times, so most of each blob file
+	// is garbage
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 20; i++ {
+			value := bytes.Repeat([]byte{byte(round)}, 4096+i)
+			assert.NoError(t, db.Put(fmt.Sprintf("k%02d", i), value))
+		}
+	}
+	assert.NoError(t, db.Put("keep", bytes.Repeat([]byte("k"), 2048)))
+	before := blobFileCount(t, dir)
+
+	// A reader opened before the merge keeps working after it
+	r, err := db.GetReader("k00")
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Merge())
+	assert.True(t, blobFileCount(t, dir) < before, "%d blob files, %d before", blobFileCount(t, dir), before)
+
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{3}, 4096), data)
+	assert.NoError(t, r.Close())
+
+	check := func(db *Bitcask) {
+		for i := 0; i < 20; i++ {
+			got, err := db.Get(fmt.Sprintf("k%02d", i))
+			assert.NoError(t, err)
+			assert.Equal(t, bytes.Repeat([]byte{3}, 4096+i), got)
+		}
+		got, err := db.Get("keep")
+		assert.NoError(t, err)
+		assert.Equal(t, 2048, len(got))
+	}
+	check(db)
+
+	// Blobs were moved, not the data files' copies of them
+	for _, lf := range db.readOnlyFiles {
+		assert.True(t, lf.Size() < 4096, "data file %d is %d bytes", lf.ID(), lf.Size())
+	}
+
+	assert.NoError(t, db.Close())
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	check(db)
+}
+
+func TestBlobEncryption(t *testing.T) {
+	dir := t.TempDir()
+	ring := &KeyRing{Current: 1, Keys: map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)}}
+	cfg := blobConfig()
+	cfg.KeyProvider = ring
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	secret := bytes.Repeat([]byte("secret "), 1000)
+	assert.NoError(t, db.Put("k", secret))
+	assert.NoError(t, db.Close())
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+blobFileExt))
+	assert.NoError(t, err)
+	data, err := os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("secret")))
+
+	// After a rotation, merging moves the blob to a file under the new
+	// key even though none of it is garbage
+	ring.Keys[2] = bytes.Repeat([]byte{2}, 32)
+	ring.Current = 2
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Merge())
+	for _, blobFile := range db.blobFiles {
+		assert.Equal(t, uint32(2), blobFile.Header().KeyID)
+	}
+	assert.NoError(t, db.Close())
+
+	delete(ring.Keys, 1)
+	db, err = Open(dir, cfg)
+	assert.NoError(t, err)
+	defer db.Close()
+	got, err := db.Get("k")
+	assert.NoError(t, err)
+	assert.Equal(t, secret, got)
+}
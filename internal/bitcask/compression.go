@@ -0,0 +1,84 @@
+package bitcask
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Codec identifies how a record's value is compressed
+type Codec uint8
+
+const (
+	CodecNone  Codec = 0 // Stored as is
+	CodecFlate Codec = 1 // DEFLATE (compress/flate)
+)
+
+// String returns the codec's name
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecFlate:
+		return "flate"
+	default:
+		return fmt.Sprintf("codec(%d)", uint8(c))
+	}
+}
+
+// compressValue compresses value with the configured codec. Values
+// under the threshold, or that don't get smaller, are left as is.
+func (bc *Bitcask) compressValue(value []byte) ([]byte, Codec, error) {
+	codec := bc.config.Compression
+	if codec == CodecNone || len(value) < bc.config.CompressionThreshold {
+		return value, CodecNone, nil
+	}
+
+	compressed, err := compress(codec, value)
+	if err != nil {
+		return nil, CodecNone, fmt.Errorf("failed to compress value: %w", err)
+	}
+	if len(compressed) >= len(value) {
+		return value, CodecNone, nil
+	}
+	return compressed, codec, nil
+}
+
+func compress(codec Codec, value []byte) ([]byte, error) {
+	switch codec {
+	case CodecFlate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
+
+// decompress reverses compress
+func decompress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecFlate:
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		value, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress value: %w", err)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %s", codec)
+	}
+}
@@ -7,6 +7,18 @@ type Config struct {
 	MaxFileSize        int64         // Maximum file size before rotation
 	SyncWrites         bool          // Whether to sync writes to disk immediately
 	CompactionInterval time.Duration // How often to check for compaction
+
+	MaxKeySize   int // Largest key Put accepts in bytes, 0 for no limit
+	MaxValueSize int // Largest value Put accepts in bytes, 0 for no limit
+
+	BlobThreshold int // Values of at least this many bytes go to blob files, 0 to never use them
+
+	Compression          Codec // How to compress values, CodecNone to store them as is
+	CompressionThreshold int   // Values smaller than this are never compressed
+
+	EncryptionKey []byte      // AES key to encrypt new files with, nil to not encrypt
+	KeyProvider   KeyProvider // Supplies keys by ID for rotation, overrides EncryptionKey
+	EncryptKeys   bool        // Whether to encrypt keys too, not just values
 }
 
 // DefaultConfig returns a default configuration
@@ -15,5 +27,13 @@ func DefaultConfig() *Config {
 		MaxFileSize:        1024 * 1024 * 1024, // 1GB
 		SyncWrites:         false,
 		CompactionInterval: time.Minute * 10,
+
+		MaxKeySize:   64 * 1024,          // 64KB, keys are kept in memory
+		MaxValueSize: 1024 * 1024 * 1024, // 1GB
+
+		BlobThreshold: 1024 * 1024, // 1MB
+
+		Compression:          CodecNone,
+		CompressionThreshold: 256,
 	}
 }
@@ -0,0 +1,97 @@
+package bitcask
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrDecrypt is returned when a record can't be decrypted, either
+// because the key is wrong or the data was tampered with
+var ErrDecrypt = errors.New("failed to decrypt record")
+
+// KeyProvider supplies AES keys (16, 24 or 32 bytes) by ID so keys
+// can be rotated: new files are encrypted with the current key and
+// record its ID in their header, older files are read with whatever
+// key they were written with until a merge rewrites them.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key new files use, never 0
+	CurrentKeyID() uint32
+	// Key returns the key with the given ID
+	Key(id uint32) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider holding every key in memory
+type KeyRing struct {
+	Current uint32            // ID of the key new files use
+	Keys    map[uint32][]byte // Every key that may still be in use
+}
+
+// CurrentKeyID returns the ID of the key new files use
+func (r *KeyRing) CurrentKeyID() uint32 {
+	return r.Current
+}
+
+// Key returns the key with the given ID
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	key, ok := r.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("no encryption key with ID %d", id)
+	}
+	return key, nil
+}
+
+// keyProvider returns the configured key provider, nil if data
+// isn't encrypted. A bare EncryptionKey gets ID 1.
+func (cfg *Config) keyProvider() KeyProvider {
+	if cfg.KeyProvider != nil {
+		return cfg.KeyProvider
+	}
+	if cfg.EncryptionKey != nil {
+		return &KeyRing{Current: 1, Keys: map[uint32][]byte{1: cfg.EncryptionKey}}
+	}
+	return nil
+}
+
+// newAEAD returns AES-GCM for the key with the given ID
+func newAEAD(keys KeyProvider, id uint32) (cipher.AEAD, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("file is encrypted with key %d but no encryption key is configured", id)
+	}
+
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key %d: %w", id, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext as [nonce][ciphertext][tag]. aad isn't
+// stored but has to match when opening, it ties a value to its key.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts data sealed by seal
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
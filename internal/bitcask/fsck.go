@@ -0,0 +1,470 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// ProblemKind is what's wrong with part of a file
+type ProblemKind int
+
+const (
+	// ProblemCorrupt is a record that can't be read. Without a way to
+	// find where the next one starts, the rest of the file is lost.
+	ProblemCorrupt ProblemKind = iota
+	// ProblemPartial is a record cut off by the end of the file, such
+	// as by a crash or a full disk in the middle of a write
+	ProblemPartial
+	// ProblemBadValue is a record that reads fine but whose value
+	// doesn't: it won't decompress or decrypt, or it points past the
+	// end of a blob file. Only that record is skipped.
+	ProblemBadValue
+	// ProblemTornBatch is a batch whose last record is missing. Open
+	// ignores it the same way, so it's only reported.
+	ProblemTornBatch
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemCorrupt:
+		return "corrupt record"
+	case ProblemPartial:
+		return "partial record"
+	case ProblemBadValue:
+		return "bad value"
+	case ProblemTornBatch:
+		return "torn batch"
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+// Problem is a damaged part of a file found by Check
+type Problem struct {
+	Kind   ProblemKind
+	FileID uint32
+	Blob   bool  // Whether it's in a blob file rather than a data file
+	Offset int64 // Where the damaged record starts
+	Lost   int64 // Bytes from Offset that can't be used
+	Err    error // What went wrong reading it
+}
+
+func (p Problem) String() string {
+	ext := dataFileExt
+	if p.Blob {
+		ext = blobFileExt
+	}
+	return fmt.Sprintf("%010d%s offset %d: %s, %d bytes lost: %v", p.FileID, ext, p.Offset, p.Kind, p.Lost, p.Err)
+}
+
+// FileCheck is what Check found in one file
+type FileCheck struct {
+	ID         uint32
+	Blob       bool  // Whether it's a blob file rather than a data file
+	Size       int64 // Size of the file in bytes
+	Records    int   // Readable records, 0 for a blob file
+	Tombstones int   // Readable tombstones
+}
+
+// CheckReport is the result of Check and Repair
+type CheckReport struct {
+	Files      []FileCheck
+	Problems   []Problem
+	Keys       int   // Live keys in the readable records
+	Records    int   // Readable records in every data file
+	Tombstones int   // Readable tombstones in every data file
+	TotalBytes int64 // Bytes taken by records and blob values, not file headers
+	LiveBytes  int64 // Bytes taken by the latest records of live keys and their blob values
+	Salvaged   int   // Keys Repair copied
+}
+
+// OK reports whether every record could be read. Torn batches don't
+// count, Open ignores them.
+func (r *CheckReport) OK() bool {
+	for _, p := range r.Problems {
+		if p.Kind != ProblemTornBatch {
+			return false
+		}
+	}
+	return true
+}
+
+// DeadRatio returns the share of TotalBytes that overwritten values,
+// tombstones and expired keys take up, which Merge would reclaim
+func (r *CheckReport) DeadRatio() float64 {
+	if r.TotalBytes == 0 {
+		return 0
+	}
+	return float64(r.TotalBytes-r.LiveBytes) / float64(r.TotalBytes)
+}
+
+// checker reads a database directory without opening it as a
+// database, so nothing in it is changed
+type checker struct {
+	dir    string
+	cfg    *Config
+	report *CheckReport
+
+	dataFiles map[uint32]*LogFile
+	blobFiles map[uint32]*LogFile
+
+	keyDir      map[string]*KeyDirEntry // Latest readable value of every key
+	recordSizes map[string]int64        // Size of the record each keyDir entry came from
+}
+
+// Check reads every record of the database in dir and reports the
+// ones that are damaged, along with how many keys, records and
+// tombstones there are and how much space is dead. dir isn't opened
+// as a database or changed, so Check works on a database Open fails
+// on. cfg must have the keys of an encrypted database.
+func Check(dir string, cfg *Config) (*CheckReport, error) {
+	c, err := newChecker(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.report, nil
+}
+
+// Repair salvages what Check can read of the database in dir into a
+// new database in dst, which must not exist or be empty. Every live
+// key keeps its value, timestamp and expiry, and the new files are
+// written with cfg. dir isn't changed.
+func Repair(dir, dst string, cfg *Config) (*CheckReport, error) {
+	if entries, err := os.ReadDir(dst); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("repair directory %s isn't empty", dst)
+	}
+
+	c, err := newChecker(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	out, err := Open(dst, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.copyTo(out); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return c.report, nil
+}
+
+// newChecker reads every file in dir
+func newChecker(dir string, cfg *Config) (*checker, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	c := &checker{
+		dir:         dir,
+		cfg:         cfg,
+		report:      &CheckReport{},
+		dataFiles:   make(map[uint32]*LogFile),
+		blobFiles:   make(map[uint32]*LogFile),
+		keyDir:      make(map[string]*KeyDirEntry),
+		recordSizes: make(map[string]int64),
+	}
+	if err := c.run(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// close closes every file
+func (c *checker) close() {
+	for _, lf := range c.dataFiles {
+		lf.Close()
+	}
+	for _, lf := range c.blobFiles {
+		lf.Close()
+	}
+}
+
+// run checks every file, then the blob values of the live keys
+func (c *checker) run() error {
+	blobIDs, err := listFileIDs(c.dir, blobFileExt)
+	if err != nil {
+		return err
+	}
+	for _, id := range blobIDs {
+		if lf := c.openFile(id, true); lf != nil {
+			c.blobFiles[id] = lf
+			c.report.TotalBytes += lf.Size() - lf.DataStart()
+		}
+	}
+
+	dataIDs, err := listFileIDs(c.dir, dataFileExt)
+	if err != nil {
+		return err
+	}
+	for _, id := range dataIDs {
+		if lf := c.openFile(id, false); lf != nil {
+			c.dataFiles[id] = lf
+			c.checkFile(lf)
+		}
+	}
+
+	c.checkBlobs()
+
+	for key, e := range c.keyDir {
+		if e.expired() {
+			delete(c.keyDir, key)
+			continue
+		}
+		c.report.LiveBytes += c.recordSizes[key]
+		if e.Blob {
+			c.report.LiveBytes += int64(e.ValueSize)
+		}
+	}
+	c.report.Keys = len(c.keyDir)
+	return nil
+}
+
+// openFile opens a file read-only and adds it to the report. A file
+// whose header can't be read is reported as lost as a whole.
+func (c *checker) openFile(id uint32, blob bool) *LogFile {
+	ext := dataFileExt
+	if blob {
+		ext = blobFileExt
+	}
+	path := fileName(c.dir, id, ext)
+
+	fc := FileCheck{ID: id, Blob: blob}
+	if info, err := os.Stat(path); err == nil {
+		fc.Size = info.Size()
+	}
+
+	lf, err := openLogFile(path, id, true, c.cfg)
+	if err != nil {
+		c.report.Files = append(c.report.Files, fc)
+		c.problem(Problem{Kind: ProblemCorrupt, FileID: id, Blob: blob, Lost: fc.Size, Err: err})
+		return nil
+	}
+	c.report.Files = append(c.report.Files, fc)
+	return lf
+}
+
+// problem adds p to the report
+func (c *checker) problem(p Problem) {
+	c.report.Problems = append(c.report.Problems, p)
+}
+
+// checkFile reads every record of a data file until the end or the
+// first one that can't be read, and applies them to the key directory
+// the way Open would
+func (c *checker) checkFile(lf *LogFile) {
+	fc := &c.report.Files[len(c.report.Files)-1]
+
+	type record struct {
+		entry   *LogEntry
+		pos     int64
+		nextPos int64
+	}
+	var batch []record
+	tornBatch := func() {
+		if len(batch) > 0 {
+			start, end := batch[0].pos, batch[len(batch)-1].nextPos
+			c.problem(Problem{
+				Kind:   ProblemTornBatch,
+				FileID: lf.ID(),
+				Offset: start,
+				Lost:   end - start,
+				Err:    fmt.Errorf("%d records without the last", len(batch)),
+			})
+		}
+		batch = nil
+	}
+
+	pos := lf.DataStart()
+	for {
+		entry, nextPos, err := lf.ReadEntry(pos)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			kind := ProblemCorrupt
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				kind = ProblemPartial
+			}
+			c.problem(Problem{Kind: kind, FileID: lf.ID(), Offset: pos, Lost: lf.Size() - pos, Err: err})
+			break
+		}
+
+		fc.Records++
+		c.report.Records++
+		c.report.TotalBytes += nextPos - pos
+		if entry.Tombstone() {
+			fc.Tombstones++
+			c.report.Tombstones++
+		}
+
+		r := record{entry, pos, nextPos}
+		pos = nextPos
+		if entry.Batch {
+			batch = append(batch, r)
+			continue
+		}
+		if entry.BatchEnd {
+			for _, b := range batch {
+				c.apply(lf, b.entry, b.pos, b.nextPos)
+			}
+			batch = nil
+		} else {
+			tornBatch()
+		}
+		c.apply(lf, entry, r.pos, r.nextPos)
+	}
+	tornBatch()
+}
+
+// apply updates the key directory with a record read from lf at pos,
+// skipping it if its value can't be read
+func (c *checker) apply(lf *LogFile, entry *LogEntry, pos, nextPos int64) {
+	key := string(entry.Key)
+	if entry.Tombstone() {
+		delete(c.keyDir, key)
+		delete(c.recordSizes, key)
+		return
+	}
+
+	bad := func(err error) {
+		c.problem(Problem{Kind: ProblemBadValue, FileID: lf.ID(), Offset: pos, Lost: nextPos - pos, Err: err})
+	}
+
+	e, err := newKeyDirEntry(lf.ID(), uint64(nextPos)-entry.ValueSize, entry)
+	if err != nil {
+		bad(err)
+		return
+	}
+	if e.Blob {
+		blobFile, ok := c.blobFiles[e.FileID]
+		if !ok || e.ValuePos+e.ValueSize > uint64(blobFile.Size()) {
+			bad(fmt.Errorf("blob %d at %d of %d bytes is missing", e.FileID, e.ValuePos, e.ValueSize))
+			return
+		}
+	} else if _, err := decompress(e.Codec, entry.Value); err != nil {
+		bad(err)
+		return
+	}
+
+	c.keyDir[key] = e
+	c.recordSizes[key] = nextPos - pos
+}
+
+// checkBlobs reads the blob value of every live key, which checks
+// the chunks of an encrypted blob, and drops the keys that fail
+func (c *checker) checkBlobs() {
+	for _, key := range c.sortedKeys() {
+		e := c.keyDir[key]
+		if !e.Blob {
+			continue
+		}
+
+		blobFile := c.blobFiles[e.FileID]
+		if _, err := io.Copy(io.Discard, blobFile.blobReader(blobFile.file, false, key, e)); err != nil {
+			c.problem(Problem{
+				Kind:   ProblemBadValue,
+				FileID: e.FileID,
+				Blob:   true,
+				Offset: int64(e.ValuePos),
+				Lost:   int64(e.ValueSize),
+				Err:    fmt.Errorf("blob of %q: %w", key, noEOF(err)),
+			})
+			delete(c.keyDir, key)
+			delete(c.recordSizes, key)
+		}
+	}
+}
+
+// sortedKeys returns the keys of the key directory in order
+func (c *checker) sortedKeys() []string {
+	keys := make([]string, 0, len(c.keyDir))
+	for key := range c.keyDir {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// copyTo writes the value of every live key into out, keeping its
+// timestamp, expiry and codec
+func (c *checker) copyTo(out *Bitcask) error {
+	out.blobMu.Lock()
+	defer out.blobMu.Unlock()
+
+	for _, key := range c.sortedKeys() {
+		e := c.keyDir[key]
+		if e.expired() {
+			continue
+		}
+
+		entry := &LogEntry{
+			Timestamp: e.Timestamp,
+			Expires:   e.Expires,
+			KeySize:   uint64(len(key)),
+			Codec:     e.Codec,
+			Key:       []byte(key),
+		}
+
+		if e.Blob {
+			if err := out.prepareActiveBlob(); err != nil {
+				return fmt.Errorf("failed to create blob file: %w", err)
+			}
+			from := c.blobFiles[e.FileID]
+			r := from.blobReader(from.file, false, key, e)
+			pos, stored, err := out.activeBlob.WriteBlob(entry.Key, r, int64(from.blobValueSize(e.ValueSize)))
+			if err != nil {
+				return fmt.Errorf("failed to copy blob of %q: %w", key, err)
+			}
+			entry.Blob = true
+			entry.Value = blobPointer{FileID: out.activeBlob.ID(), Offset: pos, Size: stored}.encode()
+		} else {
+			stored, err := c.dataFiles[e.FileID].Read(entry.Key, e.ValuePos, e.ValueSize)
+			if err != nil {
+				return fmt.Errorf("failed to read %q: %w", key, err)
+			}
+			entry.Value = stored
+		}
+		entry.ValueSize = uint64(len(entry.Value))
+
+		if err := c.write(out, key, entry); err != nil {
+			return err
+		}
+		c.report.Salvaged++
+	}
+
+	if out.activeBlob != nil {
+		if err := out.activeBlob.Sync(); err != nil {
+			return fmt.Errorf("failed to sync blob file: %w", err)
+		}
+	}
+	return nil
+}
+
+// write appends a salvaged record to out
+func (c *checker) write(out *Bitcask, key string, entry *LogEntry) error {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	out.lastTimestamp = max(out.lastTimestamp, entry.Timestamp)
+	if err := out.appendKey(key, entry); err != nil {
+		return fmt.Errorf("failed to write %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+// recordStarts returns the offset of every record in data file id
+func recordStarts(t *testing.T, dir string, id uint32) []int64 {
+	t.Helper()
+
+	lf, err := NewLogFile(dir, id, true, DefaultConfig())
+	assert.NoError(t, err)
+	defer lf.Close()
+
+	var starts []int64
+	for pos := lf.DataStart(); pos < lf.Size(); {
+		starts = append(starts, pos)
+		_, pos, err = lf.ReadEntry(pos)
+		assert.NoError(t, err)
+	}
+	return starts
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("a", []byte("2")))
+	assert.NoError(t, db.Put("b", []byte("3")))
+	assert.NoError(t, db.Delete("b"))
+	assert.NoError(t, db.PutWithTTL("c", []byte("4"), time.Hour))
+	assert.NoError(t, db.Close())
+
+	report, err := Check(dir, nil)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2, report.Keys)
+	assert.Equal(t, 5, report.Records)
+	assert.Equal(t, 1, report.Tombstones)
+	assert.Equal(t, 1, len(report.Files))
+
+	// Only a's second record and c's are live
+	assert.True(t, report.DeadRatio() > 0.5 && report.DeadRatio() < 0.7, "got %v", report.DeadRatio())
+
+	_, err = Check(filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestCheckDamage(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, db.Put(key, []byte("value of "+key)))
+	}
+	big := bytes.Repeat([]byte("blob"), 1000)
+	assert.NoError(t, db.Put("big", big))
+	assert.NoError(t, db.Close())
+
+	// Another file ends with a partial record, as a full disk leaves
+	// it. Open would cut it off, but Check reports it.
+	db, err = Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("e", []byte("value of e")))
+	assert.NoError(t, db.Put("f", []byte("value of f")))
+	assert.NoError(t, db.Close())
+	starts := recordStarts(t, dir, 2)
+	path2 := fileName(dir, 2, dataFileExt)
+	assert.NoError(t, os.Truncate(path2, starts[1]+5))
+
+	// In the first file, mark c's value as a blob pointer, which only
+	// loses c, and damage d's key size, which loses big after it too
+	starts = recordStarts(t, dir, 1)
+	path1 := fileName(dir, 1, dataFileExt)
+	data, err := os.ReadFile(path1)
+	assert.NoError(t, err)
+	data[starts[2]+8] = recordBlob
+	copy(data[starts[3]+9:], bytes.Repeat([]byte{0xff}, 10))
+	assert.NoError(t, os.WriteFile(path1, data, 0644))
+
+	report, err := Check(dir, nil)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 3, len(report.Problems))
+	assert.Equal(t, ProblemBadValue, report.Problems[0].Kind)
+	assert.Equal(t, starts[2], report.Problems[0].Offset)
+	assert.Equal(t, starts[3]-starts[2], report.Problems[0].Lost)
+	assert.Equal(t, ProblemCorrupt, report.Problems[1].Kind)
+	assert.Equal(t, uint32(1), report.Problems[1].FileID)
+	assert.Equal(t, starts[3], report.Problems[1].Offset)
+	assert.Equal(t, ProblemPartial, report.Problems[2].Kind)
+	assert.Equal(t, uint32(2), report.Problems[2].FileID)
+	assert.Equal(t, int64(5), report.Problems[2].Lost)
+	assert.Equal(t, 3, report.Keys)
+
+	// Repair keeps what could be read, and leaves the original alone
+	dst := filepath.Join(t.TempDir(), "repaired")
+	report, err = Repair(dir, dst, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Salvaged)
+	after, err := os.ReadFile(path1)
+	assert.NoError(t, err)
+	assert.Equal(t, data, after)
+
+	repaired, err := Open(dst, nil)
+	assert.NoError(t, err)
+	defer repaired.Close()
+	for _, key := range []string{"a", "b", "e"} {
+		value, err := repaired.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, "value of "+key, string(value))
+	}
+	for _, key := range []string{"c", "d", "f", "big"} {
+		_, err := repaired.Get(key)
+		assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+	}
+
+	report, err = Check(dst, nil)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 0.0, report.DeadRatio())
+
+	_, err = Repair(dir, dst, nil)
+	assert.Error(t, err)
+}
+
+func TestRepairBlobs(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+	big := bytes.Repeat([]byte("blob"), 1000)
+	assert.NoError(t, db.PutWithTTL("big", big, time.Hour))
+	version, err := db.Version("big")
+	assert.NoError(t, err)
+	var b Batch
+	b.Put("x", []byte("1"))
+	b.Put("y", []byte("2"))
+	_, err = db.Apply(&b)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	// Cut off the end of the batch
+	starts := recordStarts(t, dir, 1)
+	assert.NoError(t, os.Truncate(fileName(dir, 1, dataFileExt), starts[2]))
+
+	report, err := Check(dir, nil)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, len(report.Problems))
+	assert.Equal(t, ProblemTornBatch, report.Problems[0].Kind)
+	assert.Equal(t, 1, report.Keys)
+
+	// The blob value, its version and expiry are copied
+	dst := t.TempDir()
+	_, err = Repair(dir, dst, blobConfig())
+	assert.NoError(t, err)
+	repaired, err := Open(dst, blobConfig())
+	assert.NoError(t, err)
+	defer repaired.Close()
+	value, err := repaired.Get("big")
+	assert.NoError(t, err)
+	assert.Equal(t, big, value)
+	got, err := repaired.Version("big")
+	assert.NoError(t, err)
+	assert.Equal(t, version, got)
+	expiry, err := repaired.Expiry("big")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute)
+
+	// A missing blob file is a bad value
+	assert.NoError(t, os.Remove(fileName(dir, 1, blobFileExt)))
+	report, err = Check(dir, nil)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, ProblemBadValue, report.Problems[0].Kind)
+	assert.Equal(t, 0, report.Keys)
+}
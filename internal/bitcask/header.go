@@ -0,0 +1,102 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrInvalidHeader is returned when a data file's header is damaged
+// or was written by a newer version that this one can't read
+var ErrInvalidHeader = errors.New("invalid file header")
+
+// errTornHeader is a header cut off part way, as a crash while a new
+// file is created leaves it
+var errTornHeader = fmt.Errorf("%w: truncated", ErrInvalidHeader)
+
+// New data files start with a header:
+//
+//	[magic:8][version:1][flags:1][reserved:2][key_id:4][created:8][crc:4]
+//
+// key_id is the encryption key the file's records use, 0 if they
+// aren't encrypted. created is the file's creation time in Unix
+// nanoseconds and crc covers everything before it.
+//
+// Only the current version is read, earlier ones were never released.
+// Files written before headers existed start straight with a record,
+// they're read as unencrypted and Merge rewrites them in the current
+// format.
+const (
+	fileMagic   = "BITCASK\x00"
+	fileVersion = 6
+
+	fileHeaderSize = 28
+)
+
+// Flags in the file header. A file with a flag this version doesn't
+// know is refused rather than misread.
+const (
+	FlagEncryptedKeys uint8 = 1 << 0 // Keys are encrypted as well as values
+	FlagCompressed    uint8 = 1 << 1 // Values may be compressed
+
+	knownFlags = FlagEncryptedKeys | FlagCompressed
+)
+
+// FileHeader is the decoded header of a data file
+type FileHeader struct {
+	Version uint8     // Format version
+	Flags   uint8     // FlagEncryptedKeys, FlagCompressed
+	KeyID   uint32    // Encryption key ID, 0 if not encrypted
+	Created time.Time // When the file was created
+}
+
+// encode returns the header in the current format
+func (h *FileHeader) encode() []byte {
+	buf := make([]byte, 0, fileHeaderSize)
+	buf = append(buf, fileMagic...)
+	buf = append(buf, fileVersion, h.Flags, 0, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, h.KeyID)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.Created.UnixNano()))
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// readFileHeader reads and validates the header at the start of
+// file, nil for a legacy file without one
+func readFileHeader(file *os.File, size int64) (*FileHeader, error) {
+	buf := make([]byte, min(size, fileHeaderSize))
+	if _, err := file.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	// Legacy records start with a timestamp, which never matches the
+	// magic. A file cut off inside the magic is a torn header.
+	n := min(len(buf), len(fileMagic))
+	if string(buf[:n]) != fileMagic[:n] || size == 0 {
+		return nil, nil
+	}
+	if len(buf) < fileHeaderSize {
+		return nil, errTornHeader
+	}
+	if crc32.ChecksumIEEE(buf[:24]) != binary.LittleEndian.Uint32(buf[24:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidHeader)
+	}
+
+	h := &FileHeader{
+		Version: buf[8],
+		Flags:   buf[9],
+		KeyID:   binary.LittleEndian.Uint32(buf[12:]),
+		Created: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[16:]))),
+	}
+	if h.Version != fileVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, h.Version)
+	}
+
+	if h.Flags&^knownFlags != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", ErrInvalidHeader, h.Flags&^knownFlags)
+	}
+	return h, nil
+}
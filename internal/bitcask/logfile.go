@@ -2,35 +2,139 @@ package bitcask
 
 import (
 	"bufio"
+	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Records in current files are laid out as
+//
+//	[timestamp:8][flags:1][expires:8]?[key_size:uvarint][value_size:uvarint][key][value]
+//
+// with the timestamps in Unix nanoseconds. The low bits of flags are
+// the codec, recordTombstone marks a delete, recordBlob a value that's
+// a pointer into a blob file (see blob.go) and recordExpires a record
+// with an expiry time.
+// The records of a batch (see batch.go) carry recordBatch, except the
+// last which carries recordBatchEnd, so a batch a crash cut off
+// between records or inside one is ignored. Legacy files without a header use fixed 32-bit fields
+// and a timestamp in seconds:
+//
+//	[timestamp:4][key_size:4][value_size:4][key][value]
+//
+// where a value size of 0 marks a tombstone.
+const (
+	recordBlob      = 0x80
+	recordExpires   = 0x40
+	recordBatch     = 0x20
+	recordBatchEnd  = 0x10
+	recordTombstone = 0x08
+	recordCodec     = 0x07
+
+	legacyRecordHeaderSize = 12
 )
 
 // LogEntry represents a single entry in the log file
 type LogEntry struct {
-	Timestamp uint32 // Unix timestamp
-	KeySize   uint32 // Size of the key in bytes
-	ValueSize uint32 // Size of the value in bytes (0 for tombstone)
+	Timestamp int64  // Unix nanoseconds
+	Expires   int64  // When the record expires in Unix nanoseconds, 0 for never
+	KeySize   uint64 // Size of the key in bytes as written (after encryption)
+	ValueSize uint64 // Size of the value in bytes as written (0 for tombstone)
+	Codec     Codec  // How the stored value is compressed
+	Blob      bool   // Whether the value is a pointer into a blob file
+	Batch     bool   // Part of a batch that more records follow
+	BatchEnd  bool   // The last record of a batch
+	Delete    bool   // A tombstone, which deletes its key
 	Key       []byte // The key
-	Value     []byte // The value (empty for tombstone)
+	Value     []byte // The stored value (empty for tombstone)
+}
+
+// Tombstone reports whether the record deletes its key
+func (e *LogEntry) Tombstone() bool {
+	return e.Delete
+}
+
+// DecodedValue returns the value of a record read with ReadEntry,
+// decompressed. A blob record's value is only a pointer, see
+// BlobLocation.
+func (e *LogEntry) DecodedValue() ([]byte, error) {
+	if e.Blob {
+		return nil, fmt.Errorf("value of %q is in a blob file", e.Key)
+	}
+	return decompress(e.Codec, e.Value)
+}
+
+// BlobLocation returns the blob file, offset and stored size of the
+// value of a blob record
+func (e *LogEntry) BlobLocation() (fileID uint32, offset, size uint64, err error) {
+	if !e.Blob {
+		return 0, 0, 0, fmt.Errorf("value of %q isn't in a blob file", e.Key)
+	}
+	ptr, err := decodeBlobPointer(e.Value)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return ptr.FileID, ptr.Offset, ptr.Size, nil
+}
+
+// Data files hold records, blob files the large values records point
+// to. Both are named by their ID.
+const (
+	dataFileExt = ".bitcask"
+	blobFileExt = ".blob"
+)
+
+// fileName returns the path of the file with the given ID and extension
+func fileName(dir string, id uint32, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d%s", id, ext))
 }
 
 // LogFile represents a single log file in the Bitcask database
 type LogFile struct {
 	id       uint32        // Unique identifier for this file
+	path     string        // Path of the file
 	file     *os.File      // The underlying file handle
 	writer   *bufio.Writer // Buffered writer for better performance
 	size     int64         // Current size of the file
 	readOnly bool          // Whether this file is read-only
+
+	header      *FileHeader // The file's header, nil for a legacy file
+	dataStart   int64       // Offset of the first record, after the header
+	aead        cipher.AEAD // Encrypts records, nil if the file isn't encrypted
+	encryptKeys bool        // Whether keys are encrypted too
+	legacy      bool        // Whether records use the format from before headers
+}
+
+// NewLogFile opens a log file, creating it if needed. A new file gets
+// a header and is encrypted with cfg's current key, an existing file
+// is read the way its header says.
+func NewLogFile(path string, id uint32, readOnly bool, cfg *Config) (*LogFile, error) {
+	return openLogFile(fileName(path, id, dataFileExt), id, readOnly, cfg)
 }
 
-// NewLogFile creates a new log file
-func NewLogFile(path string, id uint32, readOnly bool) (*LogFile, error) {
-	filename := filepath.Join(path, fmt.Sprintf("%010d.bitcask", id))
+// OpenFile opens the data file at path read-only, for reading its
+// records with ReadEntry outside of a database. Its ID is taken from
+// its name, or 0 if the name isn't one Bitcask gives files.
+func OpenFile(path string, cfg *Config) (*LogFile, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if strings.HasSuffix(path, blobFileExt) {
+		return nil, fmt.Errorf("%s is a blob file, which holds values without records", path)
+	}
+
+	id, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), dataFileExt), 10, 32)
+	return openLogFile(path, uint32(id), true, cfg)
+}
 
+// openLogFile opens the data or blob file at filename
+func openLogFile(filename string, id uint32, readOnly bool, cfg *Config) (*LogFile, error) {
 	var file *os.File
 	var err error
 
@@ -53,11 +157,17 @@ func NewLogFile(path string, id uint32, readOnly bool) (*LogFile, error) {
 
 	logFile := &LogFile{
 		id:       id,
+		path:     filename,
 		file:     file,
 		size:     stat.Size(),
 		readOnly: readOnly,
 	}
 
+	if err := logFile.initHeader(cfg); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to set up log file %s: %w", filename, err)
+	}
+
 	if !readOnly {
 		logFile.writer = bufio.NewWriter(file)
 	}
@@ -65,11 +175,85 @@ func NewLogFile(path string, id uint32, readOnly bool) (*LogFile, error) {
 	return logFile, nil
 }
 
+// initHeader writes the header of a new file or reads an existing one
+func (lf *LogFile) initHeader(cfg *Config) error {
+	keys := cfg.keyProvider()
+
+	if lf.size == 0 && !lf.readOnly {
+		h := &FileHeader{Version: fileVersion, Created: time.Now()}
+		if keys != nil {
+			h.KeyID = keys.CurrentKeyID()
+			if cfg.EncryptKeys {
+				h.Flags |= FlagEncryptedKeys
+			}
+		}
+		if cfg.Compression != CodecNone {
+			h.Flags |= FlagCompressed
+		}
+		if _, err := lf.file.Write(h.encode()); err != nil {
+			return fmt.Errorf("failed to write file header: %w", err)
+		}
+		lf.size = fileHeaderSize
+		return lf.applyHeader(h, keys)
+	}
+
+	h, err := readFileHeader(lf.file, lf.size)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		lf.legacy = true
+		return nil
+	}
+	return lf.applyHeader(h, keys)
+}
+
+// applyHeader sets the file up to read and write records as h says
+func (lf *LogFile) applyHeader(h *FileHeader, keys KeyProvider) error {
+	lf.header = h
+	lf.dataStart = fileHeaderSize
+	if h.KeyID == 0 {
+		return nil
+	}
+
+	aead, err := newAEAD(keys, h.KeyID)
+	if err != nil {
+		return err
+	}
+	lf.aead = aead
+	lf.encryptKeys = h.Flags&FlagEncryptedKeys != 0
+	return nil
+}
+
+// Header returns the file's header, nil for a legacy file without one
+func (lf *LogFile) Header() *FileHeader {
+	return lf.header
+}
+
+// DataStart returns the offset of the first record
+func (lf *LogFile) DataStart() int64 {
+	return lf.dataStart
+}
+
+// truncate cuts the file off at size
+func (lf *LogFile) truncate(size int64) error {
+	if err := os.Truncate(lf.path, size); err != nil {
+		return fmt.Errorf("failed to truncate log file: %w", err)
+	}
+	lf.size = size
+	return nil
+}
+
 // Size returns the current size of the file
 func (lf *LogFile) Size() int64 {
 	return lf.size
 }
 
+// Path returns the path of the file
+func (lf *LogFile) Path() string {
+	return lf.path
+}
+
 // ID returns the file ID
 func (lf *LogFile) ID() uint32 {
 	return lf.id
@@ -107,53 +291,68 @@ func (lf *LogFile) Close() error {
 	return lf.file.Close()
 }
 
-// Write writes a log entry to the file
+// Write writes a log entry to the file, encrypting it if the file is
+// encrypted. KeySize and ValueSize are set to the sizes as written.
 // Returns the valuePos in the file
 func (lf *LogFile) Write(entry *LogEntry) (uint64, error) {
 	if lf.readOnly {
 		return 0, fmt.Errorf("cannot write to read-only file")
 	}
+	if lf.legacy {
+		return 0, fmt.Errorf("cannot write to a file in the old record format")
+	}
 
-	// Calculate total entry size
-	// timestamp + keysize + valuesize + key + value
-	entrySize := 4 + 4 + 4 + len(entry.Key) + len(entry.Value)
-
-	// Write timestamp
-	if err := binary.Write(lf.writer, binary.LittleEndian, entry.Timestamp); err != nil {
-		return 0, err
+	if lf.aead != nil {
+		sealed, err := lf.sealEntry(entry)
+		if err != nil {
+			return 0, err
+		}
+		entry = sealed
 	}
 
-	// Write key size
-	if err := binary.Write(lf.writer, binary.LittleEndian, entry.KeySize); err != nil {
-		return 0, err
+	var header [8 + 1 + 8 + 2*binary.MaxVarintLen64]byte
+	binary.LittleEndian.PutUint64(header[:], uint64(entry.Timestamp))
+	header[8] = byte(entry.Codec)
+	if entry.Blob {
+		header[8] |= recordBlob
 	}
+	if entry.Batch {
+		header[8] |= recordBatch
+	}
+	if entry.BatchEnd {
+		header[8] |= recordBatchEnd
+	}
+	if entry.Delete {
+		header[8] |= recordTombstone
+	}
+	n := 9
+	if entry.Expires != 0 {
+		header[8] |= recordExpires
+		binary.LittleEndian.PutUint64(header[n:], uint64(entry.Expires))
+		n += 8
+	}
+	n += binary.PutUvarint(header[n:], uint64(len(entry.Key)))
+	n += binary.PutUvarint(header[n:], uint64(len(entry.Value)))
 
-	// Write value size
-	if err := binary.Write(lf.writer, binary.LittleEndian, entry.ValueSize); err != nil {
+	if _, err := lf.writer.Write(header[:n]); err != nil {
 		return 0, err
 	}
-
-	// Write key
 	if _, err := lf.writer.Write(entry.Key); err != nil {
 		return 0, err
 	}
-
-	// Write value
 	if _, err := lf.writer.Write(entry.Value); err != nil {
 		return 0, err
 	}
 
 	// Record the position where the value starts
-	// currentFileSize + 12 bytes for timestamp + keysize
-	valuePos := lf.size + 12 + int64(len(entry.Key))
-
-	lf.size += int64(entrySize)
+	valuePos := lf.size + int64(n) + int64(len(entry.Key))
+	lf.size = valuePos + int64(len(entry.Value))
 
 	return uint64(valuePos), nil
 }
 
-// Read reads a value at the specified position
-func (lf *LogFile) Read(valuePos uint64, valueSize uint32) ([]byte, error) {
+// Read reads the value of key at the specified position
+func (lf *LogFile) Read(key []byte, valuePos uint64, valueSize uint64) ([]byte, error) {
 	value := make([]byte, valueSize)
 
 	_, err := lf.file.ReadAt(value, int64(valuePos))
@@ -161,58 +360,185 @@ func (lf *LogFile) Read(valuePos uint64, valueSize uint32) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read value at position %d: %w", valuePos, err)
 	}
 
+	if lf.aead != nil {
+		return open(lf.aead, value, key)
+	}
 	return value, nil
 }
 
+// sealEntry returns a copy of entry with its value (and key, if keys
+// are encrypted) encrypted. The value is tied to the plaintext key
+// so it can't be swapped with another record's. Tombstones keep an
+// empty value, an empty value is sealed like any other.
+func (lf *LogFile) sealEntry(entry *LogEntry) (*LogEntry, error) {
+	sealed := *entry
+
+	if !entry.Delete {
+		value, err := seal(lf.aead, entry.Value, entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Value = value
+	}
+
+	if lf.encryptKeys {
+		key, err := seal(lf.aead, entry.Key, nil)
+		if err != nil {
+			return nil, err
+		}
+		sealed.Key = key
+	}
+
+	sealed.KeySize = uint64(len(sealed.Key))
+	sealed.ValueSize = uint64(len(sealed.Value))
+	entry.KeySize = sealed.KeySize
+	entry.ValueSize = sealed.ValueSize
+	return &sealed, nil
+}
+
+// openEntry decrypts an entry read from an encrypted file in place
+func (lf *LogFile) openEntry(entry *LogEntry) error {
+	if lf.encryptKeys {
+		key, err := open(lf.aead, entry.Key, nil)
+		if err != nil {
+			return err
+		}
+		entry.Key = key
+	}
+
+	if !entry.Delete {
+		value, err := open(lf.aead, entry.Value, entry.Key)
+		if err != nil {
+			return err
+		}
+		entry.Value = value
+	}
+	return nil
+}
+
 // ReadEntry reads a complete log entry starting at the given position
+// and returns it with the position of the next one. The value is the
+// last ValueSize bytes before that.
 func (lf *LogFile) ReadEntry(pos int64) (*LogEntry, int64, error) {
-	// Seek to position
-	if _, err := lf.file.Seek(pos, 0); err != nil {
+	// A section reader leaves the file's offset alone, so records can
+	// be read while others are
+	reader := bufio.NewReader(io.NewSectionReader(lf.file, pos, max(lf.size-pos, 0)))
+
+	var entry *LogEntry
+	var headerSize int64
+	var err error
+	if lf.legacy {
+		entry, err = readLegacyRecordHeader(reader)
+		headerSize = legacyRecordHeaderSize
+	} else {
+		entry, headerSize, err = readRecordHeader(reader)
+	}
+	if err != nil {
 		return nil, 0, err
 	}
 
-	reader := bufio.NewReader(lf.file)
+	// A size past the end of the file is a record cut off, or a damaged
+	// size that shouldn't be allocated
+	remaining := uint64(max(lf.size-pos-headerSize, 0))
+	if entry.KeySize > remaining || entry.ValueSize > remaining-entry.KeySize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-	// Read timestamp
-	var timestamp uint32
-	if err := binary.Read(reader, binary.LittleEndian, &timestamp); err != nil {
+	// Read key
+	entry.Key = make([]byte, entry.KeySize)
+	if _, err := io.ReadFull(reader, entry.Key); err != nil {
 		return nil, 0, err
 	}
 
-	// Read key size
-	var keySize uint32
-	if err := binary.Read(reader, binary.LittleEndian, &keySize); err != nil {
+	// Read value
+	entry.Value = make([]byte, entry.ValueSize)
+	if _, err := io.ReadFull(reader, entry.Value); err != nil {
 		return nil, 0, err
 	}
 
-	// Read value size
-	var valueSize uint32
-	if err := binary.Read(reader, binary.LittleEndian, &valueSize); err != nil {
-		return nil, 0, err
+	// Calculate next position before decrypting changes the sizes
+	nextPos := pos + headerSize + int64(entry.KeySize) + int64(entry.ValueSize)
+
+	if lf.aead != nil {
+		if err := lf.openEntry(entry); err != nil {
+			return nil, 0, err
+		}
 	}
 
-	// Read key
-	key := make([]byte, keySize)
-	if _, err := io.ReadFull(reader, key); err != nil {
+	return entry, nextPos, nil
+}
+
+// readRecordHeader reads the fields before a record's key and
+// returns them with their encoded size
+func readRecordHeader(reader *bufio.Reader) (*LogEntry, int64, error) {
+	var fixed [9]byte
+	if _, err := io.ReadFull(reader, fixed[:]); err != nil {
 		return nil, 0, err
 	}
 
-	// Read value
-	value := make([]byte, valueSize)
-	if _, err := io.ReadFull(reader, value); err != nil {
-		return nil, 0, err
+	flags := fixed[8]
+	if flags&^(recordBlob|recordExpires|recordBatch|recordBatchEnd|recordTombstone|recordCodec) != 0 {
+		return nil, 0, fmt.Errorf("unknown record flags %#x", flags)
 	}
 
 	entry := &LogEntry{
-		Timestamp: timestamp,
-		KeySize:   keySize,
-		ValueSize: valueSize,
-		Key:       key,
-		Value:     value,
+		Timestamp: int64(binary.LittleEndian.Uint64(fixed[:])),
+		Codec:     Codec(flags & recordCodec),
+		Blob:      flags&recordBlob != 0,
+		Batch:     flags&recordBatch != 0,
+		BatchEnd:  flags&recordBatchEnd != 0,
+		Delete:    flags&recordTombstone != 0,
 	}
+	size := int64(len(fixed))
 
-	// Calculate next position
-	nextPos := pos + 12 + int64(keySize) + int64(valueSize)
+	if flags&recordExpires != 0 {
+		var expires [8]byte
+		if _, err := io.ReadFull(reader, expires[:]); err != nil {
+			return nil, 0, noEOF(err)
+		}
+		entry.Expires = int64(binary.LittleEndian.Uint64(expires[:]))
+		size += int64(len(expires))
+	}
 
-	return entry, nextPos, nil
+	for _, field := range []*uint64{&entry.KeySize, &entry.ValueSize} {
+		v, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, 0, noEOF(err)
+		}
+		*field = v
+		size += int64(uvarintLen(v))
+	}
+	return entry, size, nil
+}
+
+// readLegacyRecordHeader reads the fields before a record's key in
+// the format from before headers
+func readLegacyRecordHeader(reader *bufio.Reader) (*LogEntry, error) {
+	var fixed [legacyRecordHeaderSize]byte
+	if _, err := io.ReadFull(reader, fixed[:]); err != nil {
+		return nil, err
+	}
+
+	entry := &LogEntry{
+		Timestamp: int64(binary.LittleEndian.Uint32(fixed[:])) * int64(time.Second),
+		KeySize:   uint64(binary.LittleEndian.Uint32(fixed[4:])),
+		ValueSize: uint64(binary.LittleEndian.Uint32(fixed[8:])),
+	}
+	entry.Delete = entry.ValueSize == 0
+	return entry, nil
+}
+
+// uvarintLen returns the encoded size of v
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for a record cut off
+// after its first field
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
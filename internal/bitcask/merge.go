@@ -0,0 +1,151 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Merge rewrites every live key into new data files and deletes the
+// old ones, reclaiming the space taken by overwritten values and
+// tombstones. The new files use the current settings, so merging is
+// also how data gets re-encrypted with a rotated key (or encrypted
+// for the first time): old files are read with whatever key their
+// header names.
+//
+// Merged files get IDs above every existing file, so if a crash
+// interrupts the merge the newer copies win when the files are loaded
+// again. Old files are deleted oldest first, so a tombstone is never
+// deleted while an older value it hides is still around.
+//
+// Blob values are only copied out of blob files that are mostly
+// garbage or use an old encryption key, other records just keep
+// pointing where they did. Blob files nothing points to are deleted.
+func (bc *Bitcask) Merge() error {
+	bc.blobMu.Lock()
+	defer bc.blobMu.Unlock()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// The active file is merged along with the rest
+	if err := bc.activeFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync active file: %w", err)
+	}
+	bc.readOnlyFiles[bc.activeFile.ID()] = bc.activeFile
+	bc.activeFile = nil
+
+	oldIDs := make([]uint32, 0, len(bc.readOnlyFiles))
+	for id := range bc.readOnlyFiles {
+		oldIDs = append(oldIDs, id)
+	}
+	slices.Sort(oldIDs)
+
+	// Blobs are moved and synced before any record points to them
+	var merged []*LogFile
+	var keyDir map[string]*KeyDirEntry
+	moved, err := bc.rewriteBlobs()
+	if err == nil {
+		merged, keyDir, err = bc.writeMerged(oldIDs[len(oldIDs)-1]+1, moved)
+	}
+	if err != nil {
+		for _, lf := range merged {
+			lf.Close()
+			os.Remove(lf.Path())
+		}
+		if activeErr := bc.createActiveFile(); activeErr != nil {
+			return fmt.Errorf("failed to merge: %w (and to create active file: %v)", err, activeErr)
+		}
+		return fmt.Errorf("failed to merge: %w", err)
+	}
+
+	// Switch over to the merged files, then drop the old ones
+	bc.keyDir = keyDir
+	for _, id := range oldIDs {
+		lf := bc.readOnlyFiles[id]
+		delete(bc.readOnlyFiles, id)
+		if err := lf.Close(); err != nil {
+			return fmt.Errorf("failed to close merged file: %w", err)
+		}
+		if err := os.Remove(lf.Path()); err != nil {
+			return fmt.Errorf("failed to remove merged file: %w", err)
+		}
+	}
+	for _, lf := range merged {
+		bc.readOnlyFiles[lf.ID()] = lf
+	}
+	if err := bc.removeUnusedBlobs(); err != nil {
+		return err
+	}
+
+	if err := syncDir(bc.path); err != nil {
+		return err
+	}
+	return bc.createActiveFile()
+}
+
+// writeMerged copies every live value into new files starting at
+// nextID and returns them with the key directory pointing into them.
+// Blob values are written as pointers, to where moved says if they
+// were moved. Expired keys are dropped.
+func (bc *Bitcask) writeMerged(nextID uint32, moved map[string]*KeyDirEntry) ([]*LogFile, map[string]*KeyDirEntry, error) {
+	var files []*LogFile
+	keyDir := make(map[string]*KeyDirEntry, len(bc.keyDir))
+
+	var out *LogFile
+	keys := make([]string, 0, len(bc.keyDir))
+	for key := range bc.keyDir {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		old := bc.keyDir[key]
+		if m, ok := moved[key]; ok {
+			old = m
+		}
+		if old.expired() {
+			continue
+		}
+
+		// The value keeps its codec, timestamp and expiry, only where
+		// (and how it's encrypted) changes
+		entry, err := bc.record(key, old)
+		if err != nil {
+			return files, nil, fmt.Errorf("failed to read %q: %w", key, err)
+		}
+
+		if out == nil || out.Size() >= bc.config.MaxFileSize {
+			if out, err = NewLogFile(bc.path, nextID, false, bc.config); err != nil {
+				return files, nil, err
+			}
+			files = append(files, out)
+			nextID++
+		}
+
+		valuePos, err := out.Write(entry)
+		if err != nil {
+			return files, nil, fmt.Errorf("failed to write %q: %w", key, err)
+		}
+		if keyDir[key], err = newKeyDirEntry(out.ID(), valuePos, entry); err != nil {
+			return files, nil, err
+		}
+	}
+
+	for _, lf := range files {
+		if err := lf.Sync(); err != nil {
+			return files, nil, fmt.Errorf("failed to sync merged file: %w", err)
+		}
+	}
+	return files, keyDir, nil
+}
+
+// syncDir makes new and removed files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -1,37 +1,155 @@
 package bitcask
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 )
 
-// Put stores a key-value pair
+// ErrKeyNotFound is returned when a key isn't in the database
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrTooLarge is matched by every SizeError
+var ErrTooLarge = errors.New("too large")
+
+// SizeError is returned by Put when a key or value is over the limit
+// set in Config
+type SizeError struct {
+	Field string // "key" or "value"
+	Size  int    // Size of the key or value
+	Limit int    // The configured limit
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%s of %d bytes is over the limit of %d bytes", e.Field, e.Size, e.Limit)
+}
+
+// Unwrap lets errors.Is match ErrTooLarge
+func (e *SizeError) Unwrap() error {
+	return ErrTooLarge
+}
+
+// checkSize returns a SizeError if size is over a non-zero limit
+func checkSize(field string, size, limit int) error {
+	if limit > 0 && size > limit {
+		return &SizeError{Field: field, Size: size, Limit: limit}
+	}
+	return nil
+}
+
+// nextTimestamp returns the timestamp for a new record. Timestamps
+// only go up, even if the clock steps back, so the newest write to a
+// key always has the highest one.
+func (bc *Bitcask) nextTimestamp() int64 {
+	bc.lastTimestamp = max(time.Now().UnixNano(), bc.lastTimestamp+1)
+	return bc.lastTimestamp
+}
+
+// Put stores a key-value pair. A value of at least BlobThreshold
+// bytes goes to a blob file.
 func (bc *Bitcask) Put(key string, value []byte) error {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+	return bc.PutWithTTL(key, value, 0)
+}
 
-	// Check if we need to rotate the active file
-	if bc.activeFile.Size() >= bc.config.MaxFileSize {
-		if err := bc.rotateActiveFile(); err != nil {
-			return fmt.Errorf("failed to rotate active file: %w", err)
-		}
+// PutWithTTL stores a key-value pair that expires after ttl, or never
+// if ttl isn't positive
+func (bc *Bitcask) PutWithTTL(key string, value []byte, ttl time.Duration) error {
+	if bc.readOnly.Load() {
+		return ErrReadOnly
+	}
+	if err := checkSize("key", len(key), bc.config.MaxKeySize); err != nil {
+		return err
+	}
+	if err := checkSize("value", len(value), bc.config.MaxValueSize); err != nil {
+		return err
 	}
 
+	expires := expiresAt(ttl)
+	if bc.isBlob(int64(len(value))) {
+		return bc.putBlob(key, bytes.NewReader(value), int64(len(value)), expires)
+	}
+	return bc.put(key, value, expires)
+}
+
+// put stores a value in the active data file
+func (bc *Bitcask) put(key string, value []byte, expires int64) error {
+	stored, codec, err := bc.compressValue(value)
+	if err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
 	// Create log entry
 	entry := &LogEntry{
-		Timestamp: uint32(time.Now().Unix()),
-		KeySize:   uint32(len(key)),
-		ValueSize: uint32(len(value)),
+		Timestamp: bc.nextTimestamp(),
+		Expires:   expires,
+		KeySize:   uint64(len(key)),
+		ValueSize: uint64(len(stored)),
+		Codec:     codec,
 		Key:       []byte(key),
-		Value:     value,
+		Value:     stored,
+	}
+
+	return bc.appendKey(key, entry)
+}
+
+// appendKey writes entry to the active data file and points key at
+// it. mu must be held.
+func (bc *Bitcask) appendKey(key string, entry *LogEntry) error {
+	valuePos, err := bc.appendRecord(entry)
+	if err != nil {
+		return err
+	}
+
+	// Update key directory
+	keyDirEntry, err := newKeyDirEntry(bc.activeFile.ID(), valuePos, entry)
+	if err != nil {
+		return err
+	}
+	bc.keyDir[key] = keyDirEntry
+	bc.notify(key, false, entry.Timestamp)
+
+	return nil
+}
+
+// appendRecord writes entry to the active data file, rotating it
+// first if it's full. mu must be held.
+func (bc *Bitcask) appendRecord(entry *LogEntry) (uint64, error) {
+	if err := bc.prepareActiveFile(); err != nil {
+		return 0, err
 	}
 
 	// Write to active file
 	valuePos, err := bc.activeFile.Write(entry)
 	if err != nil {
-		return fmt.Errorf("failed to write entry: %w", err)
+		return 0, fmt.Errorf("failed to write entry: %w", err)
+	}
+
+	if err := bc.flushActiveFile(); err != nil {
+		return 0, err
+	}
+
+	return valuePos, nil
+}
+
+// prepareActiveFile rotates the active file if it's full. mu must be
+// held.
+func (bc *Bitcask) prepareActiveFile() error {
+	if bc.activeFile.Size() >= bc.config.MaxFileSize {
+		if err := bc.rotateActiveFile(); err != nil {
+			return fmt.Errorf("failed to rotate active file: %w", err)
+		}
 	}
+	return nil
+}
 
+// flushActiveFile makes the records written to the active file
+// readable, and durable if SyncWrites is set. mu must be held.
+func (bc *Bitcask) flushActiveFile() error {
 	// Sync if configured, otherwise just flush to make data readable
 	if bc.config.SyncWrites {
 		if err := bc.activeFile.Sync(); err != nil {
@@ -43,15 +161,6 @@ func (bc *Bitcask) Put(key string, value []byte) error {
 			return fmt.Errorf("failed to flush: %w", err)
 		}
 	}
-
-	// Update key directory
-	bc.keyDir[key] = &KeyDirEntry{
-		FileID:    bc.activeFile.ID(),
-		ValueSize: entry.ValueSize,
-		ValuePos:  valuePos,
-		Timestamp: entry.Timestamp,
-	}
-
 	return nil
 }
 
@@ -61,69 +170,150 @@ func (bc *Bitcask) Get(key string) ([]byte, error) {
 	defer bc.mu.RUnlock()
 
 	// Look up key in key directory
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.readValue(key, keyDirEntry)
+}
+
+// lookup returns the key directory entry of key, ErrKeyNotFound if
+// it's missing or expired. mu must be held.
+func (bc *Bitcask) lookup(key string) (*KeyDirEntry, error) {
 	keyDirEntry, exists := bc.keyDir[key]
-	if !exists {
-		return nil, fmt.Errorf("key not found: %s", key)
+	if !exists || keyDirEntry.expired() {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
+	return keyDirEntry, nil
+}
 
-	var logFile *LogFile
-	if keyDirEntry.FileID == bc.activeFile.ID() {
-		logFile = bc.activeFile
-	} else {
-		logFile, exists = bc.readOnlyFiles[keyDirEntry.FileID]
-		if !exists {
-			return nil, fmt.Errorf("log file not found for file ID: %d", keyDirEntry.FileID)
-		}
+// readValue reads the value at a key directory entry. mu must be held.
+func (bc *Bitcask) readValue(key string, keyDirEntry *KeyDirEntry) ([]byte, error) {
+	if keyDirEntry.Blob {
+		return bc.readBlob(key, keyDirEntry)
+	}
+
+	stored, err := bc.readStored(key, keyDirEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompress(keyDirEntry.Codec, stored)
+}
+
+// dataFile returns the data file with the given ID, active or not,
+// nil if there's none. mu must be held.
+func (bc *Bitcask) dataFile(id uint32) *LogFile {
+	if bc.activeFile != nil && bc.activeFile.ID() == id {
+		return bc.activeFile
+	}
+	return bc.readOnlyFiles[id]
+}
+
+// readStored reads a value from a data file as stored, still
+// compressed. mu must be held.
+func (bc *Bitcask) readStored(key string, keyDirEntry *KeyDirEntry) ([]byte, error) {
+	logFile := bc.dataFile(keyDirEntry.FileID)
+	if logFile == nil {
+		return nil, fmt.Errorf("log file not found for file ID: %d", keyDirEntry.FileID)
 	}
 
 	// Read value from file
-	value, err := logFile.Read(keyDirEntry.ValuePos, keyDirEntry.ValueSize)
+	stored, err := logFile.Read([]byte(key), keyDirEntry.ValuePos, keyDirEntry.ValueSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read value: %w", err)
 	}
+	return stored, nil
+}
+
+// record returns a record holding the value of a key directory entry
+// as stored, with its timestamp and expiry. mu must be held.
+func (bc *Bitcask) record(key string, keyDirEntry *KeyDirEntry) (*LogEntry, error) {
+	entry := &LogEntry{
+		Timestamp: keyDirEntry.Timestamp,
+		Expires:   keyDirEntry.Expires,
+		KeySize:   uint64(len(key)),
+		Codec:     keyDirEntry.Codec,
+		Blob:      keyDirEntry.Blob,
+		Key:       []byte(key),
+	}
+
+	if keyDirEntry.Blob {
+		entry.Value = blobPointerOf(keyDirEntry).encode()
+	} else {
+		stored, err := bc.readStored(key, keyDirEntry)
+		if err != nil {
+			return nil, err
+		}
+		entry.Value = stored
+	}
 
-	return value, nil
+	entry.ValueSize = uint64(len(entry.Value))
+	return entry, nil
+}
+
+// Scan calls fn for each key in [start, end) in order along with its
+// value, until fn returns false. An empty end means no upper bound.
+// The keys are picked up front, so fn may write to the database.
+func (bc *Bitcask) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	bc.mu.RLock()
+	var keys []string
+	for key := range bc.keyDir {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	bc.mu.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := bc.Get(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue // Deleted since the keys were picked
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
 }
 
 // Delete deletes a key by writing a tombstone
 func (bc *Bitcask) Delete(key string) error {
+	if bc.readOnly.Load() {
+		return ErrReadOnly
+	}
+
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
 	// Check if key exists
-	if _, exists := bc.keyDir[key]; !exists {
-		return fmt.Errorf("key not found: %s", key)
+	if _, err := bc.lookup(key); err != nil {
+		return err
 	}
 
-	// Create tombstone entry (zero value size)
+	// Create tombstone entry
 	entry := &LogEntry{
-		Timestamp: uint32(time.Now().Unix()),
-		KeySize:   uint32(len(key)),
-		ValueSize: 0, // Tombstone
+		Timestamp: bc.nextTimestamp(),
+		KeySize:   uint64(len(key)),
+		Delete:    true,
 		Key:       []byte(key),
-		Value:     nil,
 	}
 
 	// Write tombstone to active file
-	_, err := bc.activeFile.Write(entry)
-	if err != nil {
+	if _, err := bc.appendRecord(entry); err != nil {
 		return fmt.Errorf("failed to write tombstone: %w", err)
 	}
 
-	// Sync if configured, otherwise just flush to make data readable
-	if bc.config.SyncWrites {
-		if err := bc.activeFile.Sync(); err != nil {
-			return fmt.Errorf("failed to sync: %w", err)
-		}
-	} else {
-		// Flush buffer to make data immediately readable
-		if err := bc.activeFile.Flush(); err != nil {
-			return fmt.Errorf("failed to flush: %w", err)
-		}
-	}
-
 	// Remove from key directory
 	delete(bc.keyDir, key)
+	bc.notify(key, true, entry.Timestamp)
 
 	return nil
 }
@@ -0,0 +1,245 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ErrReadOnly is returned by writes to a database made read-only with
+// SetReadOnly
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrPositionGone is returned by ReadLog for a position in a file that
+// a merge has since deleted, or one the database never had. Reading
+// has to start over from the beginning of the log.
+var ErrPositionGone = errors.New("log position no longer exists")
+
+// Position is a place in the log: a data file and the offset of a
+// record in it. The zero Position is the beginning of the log.
+type Position struct {
+	FileID uint32
+	Offset int64
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.FileID, p.Offset)
+}
+
+// LogRecord is a write as ReadLog returns it and ApplyLog takes it
+type LogRecord struct {
+	Key       string
+	Value     []byte // The whole value, decompressed and read from its blob file if it's in one
+	Timestamp int64  // The key's version after the write
+	Expires   int64  // When the key expires in Unix nanoseconds, 0 for never
+	Delete    bool   // Whether the record deletes the key
+	Batch     bool   // Part of a batch that more records follow
+}
+
+// SetReadOnly makes Put, PutReader, Delete, Expire and Apply fail
+// with ErrReadOnly, or lets them write again. ApplyLog and Merge still
+// work, so a follower can apply what its primary writes.
+func (bc *Bitcask) SetReadOnly(readOnly bool) {
+	bc.readOnly.Store(readOnly)
+}
+
+// ReadOnly reports whether the database is read-only
+func (bc *Bitcask) ReadOnly() bool {
+	return bc.readOnly.Load()
+}
+
+// ReadLog returns the records written at or after pos in the order
+// they were written, about maxBytes of keys and values at most, along
+// with the position after them. It returns none once pos is at the end
+// of the active file, where the next write will go.
+//
+// Batches are returned whole, and ones a crash cut short are skipped
+// just as Open skips them. A merge deletes the files it merges, after
+// which reading from a position in them fails with ErrPositionGone.
+func (bc *Bitcask) ReadLog(pos Position, maxBytes int) ([]LogRecord, Position, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if pos.FileID == 0 {
+		first := bc.activeFile.ID()
+		for id := range bc.readOnlyFiles {
+			first = min(first, id)
+		}
+		pos = Position{FileID: first, Offset: bc.dataFile(first).DataStart()}
+	}
+	lf := bc.dataFile(pos.FileID)
+	if lf == nil || pos.Offset < lf.DataStart() || pos.Offset > lf.Size() {
+		return nil, pos, fmt.Errorf("%w: %s", ErrPositionGone, pos)
+	}
+
+	var records, batch []LogRecord
+	done := pos // After the last record returned or skipped
+	size := 0
+	for size < maxBytes || len(batch) > 0 {
+		entry, nextPos, err := lf.ReadEntry(pos.Offset)
+		if errors.Is(err, io.EOF) {
+			if lf == bc.activeFile {
+				break
+			}
+			// A batch never carries on into the next file
+			batch = nil
+			lf = bc.nextDataFile(lf.ID())
+			pos = Position{FileID: lf.ID(), Offset: lf.DataStart()}
+			done = pos
+			continue
+		}
+		if err != nil {
+			return nil, done, fmt.Errorf("failed to read record at %s: %w", pos, err)
+		}
+		pos.Offset = nextPos
+
+		rec, err := bc.logRecord(entry)
+		if err != nil {
+			return nil, done, err
+		}
+		if entry.Batch {
+			batch = append(batch, rec)
+			continue
+		}
+		if !entry.BatchEnd {
+			batch = nil // Not ended, so the batch was never committed
+		}
+		for _, r := range append(batch, rec) {
+			records = append(records, r)
+			size += len(r.Key) + len(r.Value)
+		}
+		batch = nil
+		done = pos
+	}
+
+	return records, done, nil
+}
+
+// nextDataFile returns the data file after the one with the given ID.
+// mu must be held.
+func (bc *Bitcask) nextDataFile(id uint32) *LogFile {
+	next := bc.activeFile
+	for other, lf := range bc.readOnlyFiles {
+		if other > id && other < next.ID() {
+			next = lf
+		}
+	}
+	return next
+}
+
+// logRecord returns the write a record read from the log makes. mu
+// must be held.
+func (bc *Bitcask) logRecord(entry *LogEntry) (LogRecord, error) {
+	rec := LogRecord{
+		Key:       string(entry.Key),
+		Timestamp: entry.Timestamp,
+		Expires:   entry.Expires,
+		Delete:    entry.Tombstone(),
+		Batch:     entry.Batch,
+	}
+	if rec.Delete {
+		return rec, nil
+	}
+
+	var err error
+	if entry.Blob {
+		var e *KeyDirEntry
+		if e, err = newKeyDirEntry(0, 0, entry); err == nil {
+			rec.Value, err = bc.readBlob(rec.Key, e)
+		}
+	} else {
+		rec.Value, err = entry.DecodedValue()
+	}
+	if err != nil {
+		return rec, fmt.Errorf("failed to read value of %q: %w", rec.Key, err)
+	}
+	return rec, nil
+}
+
+// ApplyLog writes records read from another database with ReadLog,
+// keeping their timestamps and expiry, even if this database is
+// read-only. The records of a batch are applied atomically. Values
+// are stored the way this database's config says, compressed or in
+// a blob file, however the other database stored them.
+func (bc *Bitcask) ApplyLog(records []LogRecord) error {
+	for start := 0; start < len(records); {
+		end := start
+		for end < len(records)-1 && records[end].Batch {
+			end++
+		}
+		if err := bc.applyLogBatch(records[start:end+1], false); err != nil {
+			return err
+		}
+		start = end + 1
+	}
+	return nil
+}
+
+// ApplyLogIfNewer is ApplyLog for records that may be older than what
+// this database has, such as keys moved from another server. Each is
+// written only if its timestamp is newer than the key's version, and
+// they're applied together as one batch whatever their Batch flags.
+// Deletes aren't remembered, so a record of a key deleted here is
+// written all the same.
+func (bc *Bitcask) ApplyLogIfNewer(records []LogRecord) error {
+	return bc.applyLogBatch(records, true)
+}
+
+// applyLogBatch writes the records of one batch, or a single record,
+// skipping those no newer than their key's version if newerOnly
+func (bc *Bitcask) applyLogBatch(records []LogRecord, newerOnly bool) error {
+	entries := make([]*LogEntry, len(records))
+	blobValues := make([][]byte, len(records))
+	blobs := false
+	for i, rec := range records {
+		entry := &LogEntry{
+			Timestamp: rec.Timestamp,
+			KeySize:   uint64(len(rec.Key)),
+			Key:       []byte(rec.Key),
+		}
+		entries[i] = entry
+		if rec.Delete {
+			entry.Delete = true
+			continue
+		}
+
+		entry.Expires = rec.Expires
+		if bc.isBlob(int64(len(rec.Value))) {
+			blobValues[i] = rec.Value
+			blobs = true
+			continue
+		}
+
+		stored, codec, err := bc.compressValue(rec.Value)
+		if err != nil {
+			return err
+		}
+		entry.Value = stored
+		entry.ValueSize = uint64(len(stored))
+		entry.Codec = codec
+	}
+
+	if blobs {
+		bc.blobMu.Lock()
+		defer bc.blobMu.Unlock()
+
+		if err := bc.writeBlobs(entries, blobValues); err != nil {
+			return err
+		}
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if newerOnly {
+		entries = slices.DeleteFunc(entries, func(entry *LogEntry) bool {
+			keyDirEntry, err := bc.lookup(string(entry.Key))
+			return err == nil && keyDirEntry.Timestamp >= entry.Timestamp
+		})
+	}
+	for _, entry := range entries {
+		bc.lastTimestamp = max(bc.lastTimestamp, entry.Timestamp)
+	}
+	return bc.commitBatch(bc.dropMissingDeletes(entries))
+}
@@ -0,0 +1,179 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+// copyLog applies everything in from's log after pos to to, reading
+// maxBytes at a time, and returns the position it got to
+func copyLog(t *testing.T, from, to *Bitcask, pos Position, maxBytes int) Position {
+	t.Helper()
+	for {
+		records, next, err := from.ReadLog(pos, maxBytes)
+		assert.NoError(t, err)
+		assert.NoError(t, to.ApplyLog(records))
+		if len(records) == 0 && next == pos {
+			return pos
+		}
+		pos = next
+	}
+}
+
+// sameData checks that a and b hold the same keys, values, versions
+// and expiry
+func sameData(t *testing.T, a, b *Bitcask) {
+	t.Helper()
+	assert.Equal(t, len(a.Keys()), len(b.Keys()))
+	for _, key := range a.Keys() {
+		want, wantVersion, err := a.GetWithVersion(key)
+		assert.NoError(t, err)
+		got, version, err := b.GetWithVersion(key)
+		assert.NoError(t, err, key)
+		assert.Equal(t, want, got, key)
+		assert.Equal(t, wantVersion, version, key)
+
+		wantExpiry, err := a.Expiry(key)
+		assert.NoError(t, err)
+		expiry, err := b.Expiry(key)
+		assert.NoError(t, err)
+		assert.True(t, wantExpiry.Equal(expiry), "%s expires at %v, not %v", key, expiry, wantExpiry)
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	cfg := blobConfig()
+	cfg.MaxFileSize = 4096 // Rotate often
+	cfg.Compression = CodecFlate
+	primary, err := Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	defer primary.Close()
+
+	follower, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer follower.Close()
+	follower.SetReadOnly(true)
+
+	big := bytes.Repeat([]byte("blob"), 1000)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, primary.Put(fmt.Sprintf("key%02d", i), jsonValue(i)))
+	}
+	assert.NoError(t, primary.Put("big", big))
+	assert.NoError(t, primary.PutWithTTL("temp", []byte("v"), time.Hour))
+	assert.NoError(t, primary.Delete("key07"))
+
+	pos := copyLog(t, primary, follower, Position{}, 1000)
+	sameData(t, primary, follower)
+	assert.Equal(t, primary.activeFile.ID(), pos.FileID)
+
+	// Picking up from where it got to copies only what's new
+	var b Batch
+	b.Put("key01", []byte("changed"))
+	b.Delete("key02")
+	b.Put("big", append(big, '!'))
+	_, err = primary.Apply(&b)
+	assert.NoError(t, err)
+	assert.NoError(t, primary.Expire("key03", time.Minute))
+
+	records, _, err := primary.ReadLog(pos, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(records), "a batch is returned whole")
+	assert.True(t, records[0].Batch && records[1].Batch && !records[2].Batch)
+	assert.True(t, records[1].Delete)
+
+	copyLog(t, primary, follower, pos, 1<<20)
+	sameData(t, primary, follower)
+
+	// The follower stored the big value its own way, not in a blob
+	assert.False(t, follower.keyDir["big"].Blob)
+}
+
+func TestReadLogAfterMerge(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("b", []byte("2")))
+	records, pos, err := db.ReadLog(Position{}, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+
+	assert.NoError(t, db.Merge())
+	_, _, err = db.ReadLog(pos, 1<<20)
+	assert.True(t, errors.Is(err, ErrPositionGone), "got %v", err)
+	_, _, err = db.ReadLog(Position{FileID: 1000, Offset: 0}, 1<<20)
+	assert.True(t, errors.Is(err, ErrPositionGone), "got %v", err)
+
+	// Starting over reads the merged files
+	records, _, err = db.ReadLog(Position{}, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+}
+
+func TestReadOnly(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("k", []byte("v")))
+	db.SetReadOnly(true)
+	assert.True(t, db.ReadOnly())
+
+	var b Batch
+	b.Put("k", []byte("w"))
+	_, applyErr := db.Apply(&b)
+	for _, err := range []error{
+		db.Put("k", []byte("w")),
+		db.PutReader("k", bytes.NewReader([]byte("w")), 1),
+		db.Delete("k"),
+		db.Expire("k", time.Minute),
+		applyErr,
+	} {
+		assert.True(t, errors.Is(err, ErrReadOnly), "got %v", err)
+	}
+
+	// Reads still work, and so does applying another database's log
+	value, err := db.Get("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", string(value))
+	assert.NoError(t, db.ApplyLog([]LogRecord{{Key: "k", Value: []byte("w"), Timestamp: 42}}))
+	value, version, err := db.GetWithVersion("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "w", string(value))
+	assert.Equal(t, int64(42), version)
+
+	db.SetReadOnly(false)
+	assert.NoError(t, db.Put("k", []byte("x")))
+}
+
+func TestApplyLogIfNewer(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.ApplyLog([]LogRecord{{Key: "a", Value: []byte("1"), Timestamp: 100}}))
+
+	// Only records newer than the key's version are written
+	assert.NoError(t, db.ApplyLogIfNewer([]LogRecord{
+		{Key: "a", Value: []byte("old"), Timestamp: 50},
+		{Key: "b", Value: []byte("2"), Timestamp: 60},
+	}))
+	value, version, err := db.GetWithVersion("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.Equal(t, int64(100), version)
+	_, version, err = db.GetWithVersion("b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), version)
+
+	assert.NoError(t, db.ApplyLogIfNewer([]LogRecord{{Key: "a", Value: []byte("new"), Timestamp: 150}}))
+	value, err = db.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(value))
+}
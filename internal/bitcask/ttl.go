@@ -0,0 +1,53 @@
+package bitcask
+
+import "time"
+
+// expiresAt returns the expiry time of a key written now with the
+// given TTL, 0 for never
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// Expire sets key to expire after ttl, or never if ttl isn't
+// positive. The value isn't rewritten, only a record with the new
+// expiry that points to the same value (or blob) is appended.
+func (bc *Bitcask) Expire(key string, ttl time.Duration) error {
+	if bc.readOnly.Load() {
+		return ErrReadOnly
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	entry, err := bc.record(key, keyDirEntry)
+	if err != nil {
+		return err
+	}
+	entry.Timestamp = bc.nextTimestamp()
+	entry.Expires = expiresAt(ttl)
+
+	return bc.appendKey(key, entry)
+}
+
+// Expiry returns when key expires, the zero time if it never does
+func (bc *Bitcask) Expiry(key string) (time.Time, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	keyDirEntry, err := bc.lookup(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyDirEntry.Expires == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, keyDirEntry.Expires), nil
+}
@@ -0,0 +1,117 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+func TestTTL(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.PutWithTTL("short", []byte("v"), 50*time.Millisecond))
+	assert.NoError(t, db.PutWithTTL("long", []byte("v"), time.Hour))
+	assert.NoError(t, db.PutWithTTL("blob", bytes.Repeat([]byte("b"), 2048), 50*time.Millisecond))
+	assert.NoError(t, db.Put("forever", []byte("v")))
+
+	expiry, err := db.Expiry("long")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute)
+	expiry, err = db.Expiry("forever")
+	assert.NoError(t, err)
+	assert.True(t, expiry.IsZero())
+
+	// Expire rewrites only the expiry: "forever" gets one and "long"
+	// loses its
+	assert.NoError(t, db.Expire("forever", 50*time.Millisecond))
+	assert.NoError(t, db.Expire("long", 0))
+	expiry, err = db.Expiry("long")
+	assert.NoError(t, err)
+	assert.True(t, expiry.IsZero())
+	got, err := db.Get("forever")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", string(got))
+
+	time.Sleep(100 * time.Millisecond)
+
+	check := func(db *Bitcask) {
+		for _, key := range []string{"short", "blob", "forever"} {
+			_, err := db.Get(key)
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+			_, err = db.GetReader(key)
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+			_, err = db.Expiry(key)
+			assert.True(t, errors.Is(err, ErrKeyNotFound), "%s: got %v", key, err)
+		}
+		assert.Equal(t, []string{"long"}, db.Keys())
+		assert.True(t, errors.Is(db.Delete("short"), ErrKeyNotFound))
+		assert.True(t, errors.Is(db.Expire("short", time.Hour), ErrKeyNotFound))
+	}
+	check(db)
+	assert.NoError(t, db.Close())
+
+	// Expiry times survive a restart, and merging drops expired keys
+	db, err = Open(dir, blobConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	check(db)
+
+	assert.NoError(t, db.Merge())
+	check(db)
+	assert.Equal(t, 1, len(db.keyDir))
+	assert.Equal(t, 0, blobFileCount(t, dir))
+}
+
+func TestTTLOverwrite(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A plain Put clears the expiry of the value it replaces
+	assert.NoError(t, db.PutWithTTL("k", []byte("old"), 50*time.Millisecond))
+	assert.NoError(t, db.Put("k", []byte("new")))
+	time.Sleep(100 * time.Millisecond)
+
+	got, err := db.Get("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(got))
+}
+
+func TestTTLBlobMerge(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, blobConfig())
+	assert.NoError(t, err)
+
+	// Overwriting the other blobs leaves the file mostly garbage, so
+	// Merge moves the one still live to a new file
+	assert.NoError(t, db.PutWithTTL("keep", bytes.Repeat([]byte("k"), 2048), time.Hour))
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, db.Put(key, bytes.Repeat([]byte("v"), 2048)))
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, db.Put(key, []byte("small")))
+	}
+	before, err := db.Expiry("keep")
+	assert.NoError(t, err)
+	fileID := db.keyDir["keep"].FileID
+
+	assert.NoError(t, db.Merge())
+	assert.NotEqual(t, fileID, db.keyDir["keep"].FileID)
+	after, err := db.Expiry("keep")
+	assert.NoError(t, err)
+	assert.Equal(t, before.UnixNano(), after.UnixNano())
+	assert.NoError(t, db.Close())
+
+	// The merged records keep it too
+	db, err = Open(dir, blobConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+	after, err = db.Expiry("keep")
+	assert.NoError(t, err)
+	assert.Equal(t, before.UnixNano(), after.UnixNano())
+}
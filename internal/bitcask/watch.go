@@ -0,0 +1,105 @@
+package bitcask
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrWatchLagged is returned by Watcher.Err when a watcher fell so far
+// behind that it was closed rather than let writes wait for it
+var ErrWatchLagged = errors.New("watcher fell behind")
+
+// watchBuffer is how many events a watcher can fall behind by
+const watchBuffer = 1024
+
+// Event is a change to a key seen by a Watcher. It doesn't carry the
+// value, Get it if it's needed. Keys expiring don't make events.
+type Event struct {
+	Key     string
+	Deleted bool  // Whether the key was deleted rather than written
+	Version int64 // The key's version after a write, the timestamp of a delete
+}
+
+// Watcher receives the events for keys with a prefix, in the order
+// the changes were made
+type Watcher struct {
+	bc     *Bitcask
+	prefix string
+	events chan Event
+	err    error // Why the watcher was closed, guarded by bc.watchMu
+	closed bool  // Guarded by bc.watchMu
+}
+
+// Watch returns a watcher for changes to keys that start with prefix
+// from now on. The caller must close it.
+func (bc *Bitcask) Watch(prefix string) *Watcher {
+	w := &Watcher{bc: bc, prefix: prefix, events: make(chan Event, watchBuffer)}
+
+	bc.watchMu.Lock()
+	defer bc.watchMu.Unlock()
+	if bc.watchers == nil {
+		bc.watchers = make(map[*Watcher]struct{})
+	}
+	bc.watchers[w] = struct{}{}
+	return w
+}
+
+// Events returns the channel of events, which is closed when the
+// watcher or the database is
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns ErrWatchLagged if the watcher was closed because it fell
+// behind, nil otherwise
+func (w *Watcher) Err() error {
+	w.bc.watchMu.Lock()
+	defer w.bc.watchMu.Unlock()
+	return w.err
+}
+
+// Close stops the watcher and closes its channel
+func (w *Watcher) Close() {
+	w.bc.watchMu.Lock()
+	defer w.bc.watchMu.Unlock()
+	w.close(nil)
+}
+
+// close closes the watcher for err. watchMu must be held.
+func (w *Watcher) close(err error) {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.err = err
+	close(w.events)
+	delete(w.bc.watchers, w)
+}
+
+// notify sends an event to the watchers of key. mu must be held, so
+// events go out in the order of the writes.
+func (bc *Bitcask) notify(key string, deleted bool, version int64) {
+	bc.watchMu.Lock()
+	defer bc.watchMu.Unlock()
+
+	for w := range bc.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- Event{Key: key, Deleted: deleted, Version: version}:
+		default:
+			w.close(ErrWatchLagged)
+		}
+	}
+}
+
+// closeWatchers closes every watcher when the database closes
+func (bc *Bitcask) closeWatchers() {
+	bc.watchMu.Lock()
+	defer bc.watchMu.Unlock()
+
+	for w := range bc.watchers {
+		w.close(nil)
+	}
+}
@@ -0,0 +1,84 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+func TestWatch(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	w := db.Watch("user:")
+	defer w.Close()
+
+	assert.NoError(t, db.Put("user:1", []byte("a")))
+	assert.NoError(t, db.Put("order:1", []byte("a"))) // Other prefix
+	assert.NoError(t, db.Expire("user:1", time.Hour))
+	assert.NoError(t, db.Delete("user:1"))
+	var b Batch
+	b.Put("user:2", []byte("b"))
+	b.Delete("user:3") // Missing, so no event
+	version, err := db.Apply(&b)
+	assert.NoError(t, err)
+
+	next := func() Event {
+		select {
+		case e := <-w.Events():
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return Event{}
+		}
+	}
+
+	e := next()
+	assert.Equal(t, "user:1", e.Key)
+	assert.False(t, e.Deleted)
+	v1 := e.Version
+
+	e = next()
+	assert.Equal(t, "user:1", e.Key)
+	assert.True(t, e.Version > v1, "Expire changes the version")
+
+	e = next()
+	assert.Equal(t, Event{Key: "user:1", Deleted: true, Version: e.Version}, e)
+
+	assert.Equal(t, Event{Key: "user:2", Version: version}, next())
+
+	// Closing the database closes the watcher
+	assert.NoError(t, db.Close())
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+	assert.NoError(t, w.Err())
+}
+
+func TestWatchLagged(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	slow := db.Watch("")
+	closed := db.Watch("")
+	closed.Close()
+
+	for i := 0; i <= watchBuffer; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("k%d", i), []byte("v")))
+	}
+
+	// The writes didn't wait for the slow watcher, which was closed
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	assert.Equal(t, watchBuffer, n)
+	assert.True(t, errors.Is(slow.Err(), ErrWatchLagged))
+
+	_, ok := <-closed.Events()
+	assert.False(t, ok)
+	assert.NoError(t, closed.Err())
+}
@@ -0,0 +1,180 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// fileMagic identifies a serialized filter
+	fileMagic = "BLOOMF01"
+
+	// headerSize is magic + k + number of bits
+	headerSize = len(fileMagic) + 1 + 8
+
+	// maxHashes caps k, past this a filter is just slower
+	maxHashes = 30
+)
+
+// ErrCorrupt is returned when a serialized filter fails its checks
+var ErrCorrupt = errors.New("corrupt bloom filter")
+
+// Filter is a bloom filter: a set of keys that can answer "definitely
+// not there" or "maybe there". Each key sets k bits, derived from one
+// 64-bit hash by double hashing.
+type Filter struct {
+	bits  []byte
+	nbits uint64
+	k     int
+}
+
+// New creates a filter sized for n keys with a false positive rate of
+// about fpr once they've all been added
+func New(n int, fpr float64) *Filter {
+	n = max(n, 1)
+	fpr = min(max(fpr, 1e-9), 0.5)
+
+	// m = -n ln(p) / ln(2)^2 bits and k = (m/n) ln(2) hashes
+	// give the lowest false positive rate for n keys
+	m := math.Ceil(-float64(n) * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	nbits := max(uint64(m), 64)
+	nbits = (nbits + 7) / 8 * 8
+	k := int(math.Round(float64(nbits) / float64(n) * math.Ln2))
+
+	return &Filter{
+		bits:  make([]byte, nbits/8),
+		nbits: nbits,
+		k:     min(max(k, 1), maxHashes),
+	}
+}
+
+// Hash hashes key for AddHash, handy for collecting keys before the
+// number of them, and so the filter size, is known
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+// mix is the splitmix64 finalizer, FNV alone leaves similar keys
+// with similar high bits
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// Add adds key to the filter
+func (f *Filter) Add(key string) {
+	f.AddHash(Hash(key))
+}
+
+// AddHash adds a key hashed with Hash to the filter
+func (f *Filter) AddHash(h uint64) {
+	delta := h>>33 | h<<31
+	for i := 0; i < f.k; i++ {
+		bit := h % f.nbits
+		f.bits[bit/8] |= 1 << (bit % 8)
+		h += delta
+	}
+}
+
+// MayContain reports whether key may have been added. False means it
+// definitely wasn't, true is wrong about as often as the filter's fpr.
+func (f *Filter) MayContain(key string) bool {
+	h := Hash(key)
+	delta := h>>33 | h<<31
+	for i := 0; i < f.k; i++ {
+		bit := h % f.nbits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// MarshalBinary serializes the filter as
+// [magic:8][k:1][nbits:8][bits...][crc:4]
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, headerSize+len(f.bits)+4)
+	buf = append(buf, fileMagic...)
+	buf = append(buf, byte(f.k))
+	buf = binary.LittleEndian.AppendUint64(buf, f.nbits)
+	buf = append(buf, f.bits...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// UnmarshalBinary loads a filter serialized by MarshalBinary
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize+4 || !bytes.Equal(data[:len(fileMagic)], []byte(fileMagic)) {
+		return ErrCorrupt
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return ErrCorrupt
+	}
+
+	k := int(body[len(fileMagic)])
+	nbits := binary.LittleEndian.Uint64(body[len(fileMagic)+1:])
+	bits := body[headerSize:]
+	if k < 1 || k > maxHashes || nbits == 0 || nbits != uint64(len(bits))*8 {
+		return ErrCorrupt
+	}
+
+	f.k = k
+	f.nbits = nbits
+	f.bits = append([]byte(nil), bits...)
+	return nil
+}
+
+// WriteFile writes the filter to path and syncs it
+func (f *Filter) WriteFile(path string) error {
+	data, _ := f.MarshalBinary()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create bloom filter %s: %w", path, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write bloom filter: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync bloom filter: %w", err)
+	}
+	return file.Close()
+}
+
+// ReadFile loads a filter written by WriteFile
+func ReadFile(path string) (*Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Filter{}
+	if err := f.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("failed to load bloom filter %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// SidecarPath returns where the filter for the data file at path
+// lives: next to it, with its extension replaced by .bloom
+func SidecarPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".bloom"
+}
@@ -0,0 +1,76 @@
+package bloom
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// measureFPR adds n keys to a filter built for fpr and returns the
+// share of n other keys it claims to contain
+func measureFPR(f *Filter, n int) float64 {
+	for i := 0; i < n; i++ {
+		f.Add(fmt.Sprintf("user:%08d", i))
+	}
+
+	positives := 0
+	for i := n; i < 2*n; i++ {
+		if f.MayContain(fmt.Sprintf("user:%08d", i)) {
+			positives++
+		}
+	}
+	return float64(positives) / float64(n)
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const n = 100000
+	for _, fpr := range []float64{0.1, 0.01, 0.001} {
+		t.Run(fmt.Sprintf("fpr=%g", fpr), func(t *testing.T) {
+			achieved := measureFPR(New(n, fpr), n)
+			t.Logf("target %g, achieved %g", fpr, achieved)
+			assert.True(t, achieved < fpr*1.5, "achieved %g for target %g", achieved, fpr)
+			assert.True(t, achieved > fpr/4, "achieved %g for target %g, filter oversized", achieved, fpr)
+		})
+	}
+}
+
+func TestNoFalseNegatives(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("k%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.MayContain(fmt.Sprintf("k%d", i)))
+	}
+
+	// Adding by hash is the same as adding the key
+	g := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		g.AddHash(Hash(fmt.Sprintf("k%d", i)))
+	}
+	assert.Equal(t, f.bits, g.bits)
+}
+
+func TestSidecarFile(t *testing.T) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "0000000001.sst")
+	assert.Equal(t, filepath.Join(dir, "0000000001.bloom"), SidecarPath(data))
+
+	f := New(500, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add(fmt.Sprintf("k%d", i))
+	}
+	assert.NoError(t, f.WriteFile(SidecarPath(data)))
+
+	loaded, err := ReadFile(SidecarPath(data))
+	assert.NoError(t, err)
+	assert.Equal(t, f, loaded)
+
+	// Any damage is caught
+	raw, _ := f.MarshalBinary()
+	raw[len(raw)/2] ^= 1
+	assert.Error(t, loaded.UnmarshalBinary(raw))
+	assert.Error(t, loaded.UnmarshalBinary(raw[:10]))
+}
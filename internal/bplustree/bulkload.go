@@ -0,0 +1,211 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+)
+
+var (
+	ErrTreeNotEmpty  = errors.New("bulk load needs an empty tree")
+	ErrUnsorted      = errors.New("bulk load input is not sorted")
+	ErrDuplicateKey  = errors.New("bulk load input has a duplicate key")
+	ErrBadFillFactor = errors.New("fill factor must be in (0, 1]")
+)
+
+// BulkLoad builds the tree bottom-up from key/value pairs in
+// ascending key order. With duplicates allowed the pairs must be in
+// (key, value) order and only an exact key/value repeat counts as a
+// duplicate.
+//
+// Leaves are packed left to right with fillFactor*degree keys each
+// (or fillFactor*MaxNodeBytes bytes), then each internal level is
+// built over the one below it the same way. That's far faster than
+// calling Put for every pair and leaves the nodes exactly as full as
+// asked. A fill factor below 1 leaves room for later inserts before
+// nodes start splitting.
+//
+// The tree must be empty. If the input is unsorted or has a
+// duplicate key, an error is returned and the tree is left empty.
+func (t *BPlusTree) BulkLoad(pairs iter.Seq2[string, string], fillFactor float64) error {
+	if fillFactor <= 0 || fillFactor > 1 {
+		return ErrBadFillFactor
+	}
+
+	t.rootLatch.Lock()
+	defer t.rootLatch.Unlock()
+
+	if !t.root.isLeaf || len(t.root.keys) != 0 {
+		return ErrTreeNotEmpty
+	}
+
+	// Nodes (except the root) must keep at least this many keys,
+	// see the splits in splitLeaf and splitInternal
+	minLeafKeys := (t.degree + 1) / 2
+	minChildren := t.degree/2 + 1
+	if t.maxNodeBytes > 0 {
+		// Splitting by bytes makes no promise about key counts
+		minLeafKeys, minChildren = 1, 2
+	}
+
+	perLeaf := clamp(int(math.Ceil(fillFactor*float64(t.degree))), minLeafKeys, t.degree)
+	perNode := clamp(int(math.Ceil(fillFactor*float64(t.degree+1))), minChildren, t.degree+1)
+	targetBytes := int(fillFactor * float64(t.maxNodeBytes))
+
+	// Build the leaf level
+	leaves := []*Node{{isLeaf: true}}
+	leafBytes := 0
+	first := true
+	var prevKey, prevVal string
+	for key, val := range pairs {
+		if !first {
+			c := compareEntries(key, t.entryVal(val), prevKey, t.entryVal(prevVal))
+			if c == 0 {
+				return fmt.Errorf("%w: %q", ErrDuplicateKey, key)
+			}
+			if c < 0 {
+				return fmt.Errorf("%w: %q after %q", ErrUnsorted, key, prevKey)
+			}
+		}
+		first = false
+		prevKey, prevVal = key, val
+		if t.maxNodeBytes > 0 {
+			t.noteEntrySize(key, val)
+		}
+
+		leaf := leaves[len(leaves)-1]
+		entryBytes := entryOverhead + len(key) + len(val)
+		full := len(leaf.keys) == perLeaf ||
+			(t.maxNodeBytes > 0 && len(leaf.keys) > 0 && leafBytes+entryBytes > targetBytes)
+		if full {
+			next := &Node{isLeaf: true}
+			leaf.next = next
+			leaves = append(leaves, next)
+			leaf = next
+			leafBytes = 0
+		}
+		leaf.keys = append(leaf.keys, key)
+		leaf.vals = append(leaf.vals, val)
+		leafBytes += entryBytes
+	}
+	if first {
+		// Nothing to load
+		return nil
+	}
+	leaves = t.balanceLastLeaf(leaves, minLeafKeys)
+
+	// Build internal levels until a single node is left.
+	// sepKeys[i]/sepVals[i] separate node i of a level from node i-1,
+	// the first entry is never used.
+	level := leaves
+	sepKeys := make([]string, len(leaves))
+	sepVals := make([]string, len(leaves))
+	for i := 1; i < len(leaves); i++ {
+		sepKeys[i], sepVals[i] = t.leafSeparator(leaves[i-1], leaves[i])
+	}
+
+	for len(level) > 1 {
+		groups := t.groupSizes(sepKeys, sepVals, perNode, minChildren, targetBytes)
+
+		var parents []*Node
+		var parentSepKeys, parentSepVals []string
+		start := 0
+		for _, size := range groups {
+			parent := &Node{
+				children: level[start : start+size : start+size],
+				keys:     append([]string(nil), sepKeys[start+1:start+size]...),
+			}
+			if t.allowDuplicates {
+				parent.vals = append([]string(nil), sepVals[start+1:start+size]...)
+			}
+			for _, child := range parent.children {
+				child.parent = parent
+			}
+
+			// Whatever separated the parent's first child from its left
+			// neighbour now separates the parent from its left neighbour
+			parents = append(parents, parent)
+			parentSepKeys = append(parentSepKeys, sepKeys[start])
+			parentSepVals = append(parentSepVals, sepVals[start])
+			start += size
+		}
+
+		level = parents
+		sepKeys, sepVals = parentSepKeys, parentSepVals
+	}
+
+	t.root = level[0]
+	return nil
+}
+
+// balanceLastLeaf evens out the last two leaves so the last one
+// isn't left with fewer keys than a split would leave behind
+func (t *BPlusTree) balanceLastLeaf(leaves []*Node, minKeys int) []*Node {
+	if len(leaves) < 2 {
+		return leaves
+	}
+
+	prev := leaves[len(leaves)-2]
+	last := leaves[len(leaves)-1]
+	if len(last.keys) >= minKeys {
+		return leaves
+	}
+
+	keys := append(prev.keys, last.keys...)
+	vals := append(prev.vals, last.vals...)
+
+	merged := &Node{isLeaf: true, keys: keys, vals: vals}
+	if !t.overflows(merged) {
+		// Both fit in one leaf
+		prev.keys = keys
+		prev.vals = vals
+		prev.next = nil
+		return leaves[:len(leaves)-1]
+	}
+
+	half := len(keys) - len(keys)/2
+	prev.keys, last.keys = keys[:half:half], keys[half:]
+	prev.vals, last.vals = vals[:half:half], vals[half:]
+	return leaves
+}
+
+// groupSizes splits a level of nodes into parents of perNode children
+// each (or with MaxNodeBytes, as many as fit in targetBytes worth of
+// separators), evening out the last two so neither has fewer than
+// minChildren
+func (t *BPlusTree) groupSizes(sepKeys, sepVals []string, perNode, minChildren, targetBytes int) []int {
+	var sizes []int
+	size, bytes := 0, 0
+	for i := range sepKeys {
+		// Every child after the first adds its separator to the parent
+		sepBytes := entryOverhead + len(sepKeys[i]) + len(sepVals[i])
+		full := size == perNode ||
+			(t.maxNodeBytes > 0 && size > 1 && bytes+sepBytes > targetBytes)
+		if full {
+			sizes = append(sizes, size)
+			size, bytes = 0, 0
+		}
+		if size > 0 {
+			bytes += sepBytes
+		}
+		size++
+	}
+	sizes = append(sizes, size)
+
+	if len(sizes) >= 2 && sizes[len(sizes)-1] < minChildren {
+		total := sizes[len(sizes)-2] + sizes[len(sizes)-1]
+		sizes = sizes[:len(sizes)-2]
+		if total <= t.degree+1 && (t.maxNodeBytes == 0 || total <= minInternalSplitKeys) {
+			sizes = append(sizes, total)
+		} else {
+			sizes = append(sizes, total-total/2, total/2)
+		}
+	}
+
+	return sizes
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
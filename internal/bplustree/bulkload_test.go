@@ -0,0 +1,149 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// sortedPairs yields n pairs in key order
+func sortedPairs(n int) func(yield func(string, string) bool) {
+	return func(yield func(string, string) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(fmt.Sprintf("key_%05d", i), fmt.Sprintf("v%d", i)) {
+				return
+			}
+		}
+	}
+}
+
+// assertShape checks every leaf sits at the same depth and every
+// node other than the root holds between the minimum and degree keys
+func assertShape(t *testing.T, tree *BPlusTree) {
+	t.Helper()
+
+	leafDepth := -1
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		assert.True(t, len(n.keys) <= tree.degree, "node overflows: %v", n.keys)
+		if n != tree.root {
+			if n.isLeaf {
+				assert.True(t, len(n.keys) >= (tree.degree+1)/2, "leaf underflows: %v", n.keys)
+			} else {
+				assert.True(t, len(n.keys) >= tree.degree/2, "internal node underflows: %v", n.keys)
+			}
+		}
+
+		if n.isLeaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			}
+			assert.Equal(t, leafDepth, depth, "leaves at different depths")
+			return
+		}
+
+		assert.Equal(t, len(n.keys)+1, len(n.children))
+		for _, child := range n.children {
+			assert.True(t, child.parent == n, "bad parent pointer")
+			walk(child, depth+1)
+		}
+	}
+	walk(tree.root, 0)
+}
+
+func TestBulkLoad(t *testing.T) {
+	for _, degree := range []int{3, 4, 8} {
+		for _, fill := range []float64{0.5, 0.7, 1} {
+			for _, n := range []int{0, 1, 2, 5, 100, 1001} {
+				tree := NewBPlusTree(degree)
+				assert.NoError(t, tree.BulkLoad(sortedPairs(n), fill))
+				assertShape(t, tree)
+
+				model := make(map[string]string)
+				for k, v := range sortedPairs(n) {
+					model[k] = v
+				}
+				assertContents(t, tree, model)
+
+				// The tree keeps working as usual afterwards
+				for i := 0; i < n; i += 3 {
+					tree.Put(fmt.Sprintf("key_%05d_x", i), "new")
+					model[fmt.Sprintf("key_%05d_x", i)] = "new"
+				}
+				assertShape(t, tree)
+				assertContents(t, tree, model)
+			}
+		}
+	}
+}
+
+func TestBulkLoadFillFactor(t *testing.T) {
+	tree := NewBPlusTree(10)
+	assert.NoError(t, tree.BulkLoad(sortedPairs(1000), 0.7))
+
+	// Every leaf but the last two gets exactly 7 keys
+	var sizes []int
+	for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		sizes = append(sizes, len(leaf.keys))
+	}
+	for _, size := range sizes[:len(sizes)-2] {
+		assert.Equal(t, 7, size)
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	unsorted := func(yield func(string, string) bool) {
+		for _, k := range []string{"a", "c", "b"} {
+			if !yield(k, "v") {
+				return
+			}
+		}
+	}
+	tree := NewBPlusTree(4)
+	err := tree.BulkLoad(unsorted, 1)
+	assert.True(t, errors.Is(err, ErrUnsorted), "got %v", err)
+
+	// A failed load leaves the tree empty and usable
+	_, ok := tree.Get("a")
+	assert.False(t, ok)
+	tree.Put("x", "y")
+
+	pairs := map[string]string{"a": "1", "b": "2"}
+	duplicate := func(yield func(string, string) bool) {
+		for _, k := range []string{"a", "b", "b"} {
+			if !yield(k, pairs[k]) {
+				return
+			}
+		}
+	}
+	err = NewBPlusTree(4).BulkLoad(duplicate, 1)
+	assert.True(t, errors.Is(err, ErrDuplicateKey), "got %v", err)
+
+	err = tree.BulkLoad(sortedPairs(10), 1)
+	assert.True(t, errors.Is(err, ErrTreeNotEmpty), "got %v", err)
+
+	err = NewBPlusTree(4).BulkLoad(sortedPairs(10), 0)
+	assert.True(t, errors.Is(err, ErrBadFillFactor), "got %v", err)
+	err = NewBPlusTree(4).BulkLoad(sortedPairs(10), 1.5)
+	assert.True(t, errors.Is(err, ErrBadFillFactor), "got %v", err)
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree := NewBPlusTree(64)
+		if err := tree.BulkLoad(sortedPairs(100000), 0.9); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPutSorted(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree := NewBPlusTree(64)
+		for k, v := range sortedPairs(100000) {
+			tree.Put(k, v)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package bplustree
+
+// Config holds configuration options for a BPlusTree
+type Config struct {
+	Degree          int  // Maximum number of keys in a node
+	AllowDuplicates bool // Whether a key can map to several values
+	MaxNodeBytes    int  // If set, also split nodes whose keys and values outgrow this
+	SyncWrites      bool // Whether a DurableTree syncs the WAL on every write
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Degree:          64,
+		AllowDuplicates: false,
+		MaxNodeBytes:    0,
+		SyncWrites:      true,
+	}
+}
@@ -0,0 +1,279 @@
+package bplustree
+
+import (
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// cowNode is an immutable node of a copy-on-write tree.
+// Once a node is reachable from a Snapshot it's never modified,
+// a change copies the path from the leaf up to the root instead.
+// That's also why there are no parent or next pointers: a new leaf
+// would need its neighbours (and theirs) copied to point at it.
+type cowNode struct {
+	keys     []string
+	vals     []string   // Leaf values
+	children []*cowNode // Nil for leaves
+}
+
+func (n *cowNode) isLeaf() bool {
+	return n.children == nil
+}
+
+// childIndex returns the index of the child whose range holds key,
+// children[i] holds keys[i-1] <= xxx < keys[i]
+func (n *cowNode) childIndex(key string) int {
+	i, found := slices.BinarySearch(n.keys, key)
+	if found {
+		return i + 1
+	}
+	return i
+}
+
+// Snapshot is a read-only version of a COWTree. It can only be read,
+// writes go through the COWTree, which builds each new version from
+// the last one and shares every node off the modified path with it.
+// Any number of goroutines can read a Snapshot without locking while
+// newer versions are being written.
+type Snapshot struct {
+	root    *cowNode
+	degree  int
+	version uint64 // Number of writes that went into this version
+	size    int
+}
+
+// Version returns the version number of the snapshot
+func (s *Snapshot) Version() uint64 {
+	return s.version
+}
+
+// Len returns the number of keys in the snapshot
+func (s *Snapshot) Len() int {
+	return s.size
+}
+
+// Get retrieves the value for key
+func (s *Snapshot) Get(key string) (string, bool) {
+	n := s.root
+	for !n.isLeaf() {
+		n = n.children[n.childIndex(key)]
+	}
+
+	i, found := slices.BinarySearch(n.keys, key)
+	if !found {
+		return "", false
+	}
+	return n.vals[i], true
+}
+
+// Scan iterates over the keys in [start, end) in order, an empty
+// end means no upper bound
+func (s *Snapshot) Scan(start, end string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		s.scan(s.root, start, end, yield)
+	}
+}
+
+// All iterates over every key in order
+func (s *Snapshot) All() iter.Seq2[string, string] {
+	return s.Scan("", "")
+}
+
+// scan walks the subtree under n, returns false once yield asks to stop
+func (s *Snapshot) scan(n *cowNode, start, end string, yield func(string, string) bool) bool {
+	if n.isLeaf() {
+		i, _ := slices.BinarySearch(n.keys, start)
+		for ; i < len(n.keys); i++ {
+			if end != "" && n.keys[i] >= end {
+				return false
+			}
+			if !yield(n.keys[i], n.vals[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := n.childIndex(start); i < len(n.children); i++ {
+		if i > 0 && end != "" && n.keys[i-1] >= end {
+			return false
+		}
+		if !s.scan(n.children[i], start, end, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+// withPut returns a new snapshot with key set to val
+func (s *Snapshot) withPut(key, val string) *Snapshot {
+	left, right, sep, added := s.put(s.root, key, val)
+
+	root := left
+	if right != nil {
+		// The root split, grow a level
+		root = &cowNode{
+			keys:     []string{sep},
+			children: []*cowNode{left, right},
+		}
+	}
+
+	next := &Snapshot{root: root, degree: s.degree, version: s.version + 1, size: s.size}
+	if added {
+		next.size++
+	}
+	return next
+}
+
+// put inserts into a copy of the subtree under n. If the copy had
+// to be split it returns both halves and the separator between them.
+func (s *Snapshot) put(n *cowNode, key, val string) (left, right *cowNode, sep string, added bool) {
+	if n.isLeaf() {
+		i, found := slices.BinarySearch(n.keys, key)
+		leaf := &cowNode{
+			keys: slices.Clone(n.keys),
+			vals: slices.Clone(n.vals),
+		}
+		if found {
+			leaf.vals[i] = val
+			return leaf, nil, "", false
+		}
+
+		leaf.keys = slices.Insert(leaf.keys, i, key)
+		leaf.vals = slices.Insert(leaf.vals, i, val)
+		if len(leaf.keys) <= s.degree {
+			return leaf, nil, "", true
+		}
+
+		mid := len(leaf.keys) / 2
+		right := &cowNode{
+			keys: slices.Clone(leaf.keys[mid:]),
+			vals: slices.Clone(leaf.vals[mid:]),
+		}
+		leaf.keys = leaf.keys[:mid:mid]
+		leaf.vals = leaf.vals[:mid:mid]
+		return leaf, right, right.keys[0], true
+	}
+
+	i := n.childIndex(key)
+	childLeft, childRight, childSep, added := s.put(n.children[i], key, val)
+
+	node := &cowNode{
+		keys:     slices.Clone(n.keys),
+		children: slices.Clone(n.children),
+	}
+	node.children[i] = childLeft
+	if childRight == nil {
+		return node, nil, "", added
+	}
+
+	node.keys = slices.Insert(node.keys, i, childSep)
+	node.children = slices.Insert(node.children, i+1, childRight)
+	if len(node.keys) <= s.degree {
+		return node, nil, "", added
+	}
+
+	// Same split as splitInternal, the middle key moves up
+	mid := len(node.keys) / 2
+	sep = node.keys[mid]
+	right = &cowNode{
+		keys:     slices.Clone(node.keys[mid+1:]),
+		children: slices.Clone(node.children[mid+1:]),
+	}
+	node.keys = node.keys[:mid:mid]
+	node.children = node.children[: mid+1 : mid+1]
+	return node, right, sep, added
+}
+
+// withDelete returns a new snapshot without key, or the same snapshot
+// if key isn't there. Like BPlusTree.Delete leaves aren't merged.
+func (s *Snapshot) withDelete(key string) (*Snapshot, bool) {
+	if _, ok := s.Get(key); !ok {
+		return s, false
+	}
+
+	return &Snapshot{
+		root:    s.delete(s.root, key),
+		degree:  s.degree,
+		version: s.version + 1,
+		size:    s.size - 1,
+	}, true
+}
+
+// delete removes key from a copy of the subtree under n
+func (s *Snapshot) delete(n *cowNode, key string) *cowNode {
+	if n.isLeaf() {
+		i, _ := slices.BinarySearch(n.keys, key)
+		return &cowNode{
+			keys: slices.Delete(slices.Clone(n.keys), i, i+1),
+			vals: slices.Delete(slices.Clone(n.vals), i, i+1),
+		}
+	}
+
+	i := n.childIndex(key)
+	node := &cowNode{
+		keys:     n.keys, // Separators don't change, share them
+		children: slices.Clone(n.children),
+	}
+	node.children[i] = s.delete(n.children[i], key)
+	return node
+}
+
+// COWTree is a copy-on-write B+ tree with MVCC versions.
+//
+// Every Put/Delete path-copies from the leaf to the root and
+// publishes the new root as the current version, old versions stay
+// readable for as long as someone holds their Snapshot. Readers
+// never take a lock, writers are serialized with each other.
+//
+// It's a separate tree rather than a mode of BPlusTree, see the
+// package docs, so it has none of Config's options: no duplicates, no
+// MaxNodeBytes and no prefix compression.
+type COWTree struct {
+	mu      sync.Mutex // Serializes writers
+	current atomic.Pointer[Snapshot]
+}
+
+// NewCOWTree creates an empty copy-on-write tree
+func NewCOWTree(degree int) *COWTree {
+	t := &COWTree{}
+	t.current.Store(&Snapshot{
+		root:   &cowNode{keys: []string{}, vals: []string{}},
+		degree: degree,
+	})
+	return t
+}
+
+// Snapshot returns the current version, which never changes no
+// matter what is written to the tree afterwards
+func (t *COWTree) Snapshot() *Snapshot {
+	return t.current.Load()
+}
+
+// Get retrieves the value for key from the current version
+func (t *COWTree) Get(key string) (string, bool) {
+	return t.Snapshot().Get(key)
+}
+
+// Put stores a key/value pair and returns the version it created
+func (t *COWTree) Put(key, val string) *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	next := t.current.Load().withPut(key, val)
+	t.current.Store(next)
+	return next
+}
+
+// Delete removes key and returns the resulting version and whether
+// the key was there
+func (t *COWTree) Delete(key string) (*Snapshot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	next, ok := t.current.Load().withDelete(key)
+	t.current.Store(next)
+	return next, ok
+}
@@ -0,0 +1,174 @@
+package bplustree
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// collect gathers a scan into a map
+func collect(seq iter.Seq2[string, string]) map[string]string {
+	out := make(map[string]string)
+	for k, v := range seq {
+		out[k] = v
+	}
+	return out
+}
+
+func TestCOWSnapshots(t *testing.T) {
+	tree := NewCOWTree(3)
+	rng := rand.New(rand.NewSource(1))
+
+	// Keep every version along with what it should contain
+	oracle := make(map[string]string)
+	var versions []*Snapshot
+	var expected []map[string]string
+
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("k%03d", rng.Intn(300))
+		var snap *Snapshot
+		if rng.Intn(4) == 0 {
+			var ok bool
+			snap, ok = tree.Delete(key)
+			_, exists := oracle[key]
+			assert.Equal(t, exists, ok)
+			delete(oracle, key)
+		} else {
+			snap = tree.Put(key, fmt.Sprintf("v%d", i))
+			oracle[key] = fmt.Sprintf("v%d", i)
+		}
+
+		if i%100 == 0 {
+			versions = append(versions, snap)
+			expected = append(expected, maps.Clone(oracle))
+		}
+	}
+
+	// Every old version still reads exactly as it did
+	for i, snap := range versions {
+		assert.Equal(t, expected[i], collect(snap.All()), "version %d", snap.Version())
+		assert.Equal(t, len(expected[i]), snap.Len())
+		for k, v := range expected[i] {
+			got, ok := snap.Get(k)
+			assert.True(t, ok)
+			assert.Equal(t, v, got)
+		}
+	}
+
+	// Writes after the last saved version made newer ones
+	assert.True(t, versions[len(versions)-1].Version() < tree.Snapshot().Version())
+	assert.Equal(t, oracle, collect(tree.Snapshot().All()))
+}
+
+func TestCOWPathCopy(t *testing.T) {
+	tree := NewCOWTree(4)
+	for i := 0; i < 200; i++ {
+		tree.Put(fmt.Sprintf("k%03d", i), "v")
+	}
+
+	before := tree.Snapshot()
+	after := tree.Put("k000", "changed")
+
+	// Only the leftmost path was copied, its siblings are shared
+	assert.True(t, before.root != after.root)
+	assert.True(t, before.root.children[0] != after.root.children[0])
+	for i := 1; i < len(before.root.children); i++ {
+		assert.True(t, before.root.children[i] == after.root.children[i], "child %d was copied", i)
+	}
+
+	v, _ := before.Get("k000")
+	assert.Equal(t, "v", v)
+	v, _ = after.Get("k000")
+	assert.Equal(t, "changed", v)
+
+	// Deleting a missing key doesn't create a version
+	same, ok := tree.Delete("missing")
+	assert.False(t, ok)
+	assert.True(t, same == after)
+}
+
+func TestCOWScan(t *testing.T) {
+	tree := NewCOWTree(3)
+	for i := 0; i < 100; i++ {
+		tree.Put(fmt.Sprintf("k%03d", i), fmt.Sprintf("v%d", i))
+	}
+	snap := tree.Snapshot()
+
+	var keys []string
+	for k := range snap.Scan("k010", "k020") {
+		keys = append(keys, k)
+	}
+	assert.Equal(t, 10, len(keys))
+	assert.Equal(t, "k010", keys[0])
+	assert.Equal(t, "k019", keys[9])
+
+	// Stop part way through
+	keys = nil
+	for k := range snap.All() {
+		keys = append(keys, k)
+		if len(keys) == 5 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"k000", "k001", "k002", "k003", "k004"}, keys)
+
+	// A key between existing ones as the start
+	keys = nil
+	for k := range snap.Scan("k0955", "") {
+		keys = append(keys, k)
+	}
+	assert.Equal(t, []string{"k096", "k097", "k098", "k099"}, keys)
+}
+
+// TestCOWConcurrentReaders scans snapshots while a writer keeps
+// rewriting every key, readers must always see one consistent version
+func TestCOWConcurrentReaders(t *testing.T) {
+	tree := NewCOWTree(8)
+	for i := 0; i < 500; i++ {
+		tree.Put(fmt.Sprintf("k%03d", i), "round0")
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// Each round is written in key order, so any version
+				// holds round n for a prefix of keys and n-1 after it
+				var vals []string
+				for _, v := range tree.Snapshot().All() {
+					vals = append(vals, v)
+				}
+				assert.Equal(t, 500, len(vals))
+				boundary := slices.IndexFunc(vals, func(v string) bool { return v != vals[0] })
+				if boundary >= 0 {
+					for _, v := range vals[boundary:] {
+						assert.Equal(t, vals[boundary], v)
+					}
+				}
+			}
+		}()
+	}
+
+	for round := 1; round <= 20; round++ {
+		for i := 0; i < 500; i++ {
+			tree.Put(fmt.Sprintf("k%03d", i), fmt.Sprintf("round%d", round))
+		}
+	}
+	close(done)
+	wg.Wait()
+}
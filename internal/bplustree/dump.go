@@ -0,0 +1,105 @@
+package bplustree
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dump writes the tree to w, one node per line and indented by
+// depth. Internal nodes list their separators, leaves their entries:
+//
+//	internal [d]
+//	  leaf [a=1 b=2 c=3]
+//	  leaf [d=4 e=5]
+func (t *BPlusTree) Dump(w io.Writer) error {
+	root, unlock := t.readLockAll()
+	defer unlock()
+
+	var walk func(n *Node, depth int) error
+	walk = func(n *Node, depth int) error {
+		kind := "internal"
+		if n.isLeaf {
+			kind = "leaf"
+		}
+		if _, err := fmt.Fprintf(w, "%s%s [%s]\n", strings.Repeat("  ", depth), kind, t.nodeLabel(n, " ")); err != nil {
+			return err
+		}
+
+		for _, child := range n.children {
+			if err := walk(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(root, 0)
+}
+
+// WriteDOT writes the tree to w in Graphviz DOT format, with edges
+// from parents to children and dashed edges along the leaf chain.
+// Render it with `dot -Tsvg tree.dot -o tree.svg`.
+func (t *BPlusTree) WriteDOT(w io.Writer) error {
+	root, unlock := t.readLockAll()
+	defer unlock()
+
+	ids := make(map[*Node]int)
+	var b strings.Builder
+	b.WriteString("digraph bplustree {\n")
+	b.WriteString("  node [shape=record, fontname=monospace];\n")
+
+	var leaves []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		id := len(ids)
+		ids[n] = id
+
+		label := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).
+			Replace(t.nodeLabel(n, "|"))
+		fmt.Fprintf(&b, "  n%d [label=\"%s\"];\n", id, label)
+
+		if n.isLeaf {
+			leaves = append(leaves, n)
+			return
+		}
+		for _, child := range n.children {
+			walk(child)
+			fmt.Fprintf(&b, "  n%d -> n%d;\n", id, ids[child])
+		}
+	}
+	walk(root)
+
+	// Keep the leaves on one row, linked left to right
+	b.WriteString("  { rank=same;")
+	for _, leaf := range leaves {
+		fmt.Fprintf(&b, " n%d;", ids[leaf])
+	}
+	b.WriteString(" }\n")
+	for _, leaf := range leaves {
+		if leaf.next != nil {
+			fmt.Fprintf(&b, "  n%d -> n%d [style=dashed, constraint=false];\n", ids[leaf], ids[leaf.next])
+		}
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// nodeLabel lists a node's separators or entries joined by sep
+func (t *BPlusTree) nodeLabel(n *Node, sep string) string {
+	parts := make([]string, len(n.keys))
+	for i, k := range n.keys {
+		switch {
+		case n.isLeaf:
+			parts[i] = k + "=" + n.vals[i]
+		case t.allowDuplicates:
+			parts[i] = k + "/" + n.vals[i]
+		default:
+			parts[i] = k
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,168 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+func newDuplicatesTree(degree int) *BPlusTree {
+	return NewBPlusTreeWithConfig(&Config{Degree: degree, AllowDuplicates: true})
+}
+
+func TestDuplicates(t *testing.T) {
+	tree := newDuplicatesTree(3)
+	rng := rand.New(rand.NewSource(7))
+
+	// Interleave a long run of one key with keys around it
+	var want []string
+	for _, i := range rng.Perm(50) {
+		val := fmt.Sprintf("row%02d", i)
+		tree.Put("dup", val)
+		want = append(want, val)
+		tree.Put(fmt.Sprintf("a%02d", i), "x")
+		tree.Put(fmt.Sprintf("z%02d", i), "x")
+	}
+	slices.Sort(want)
+
+	assert.Equal(t, want, tree.GetAll("dup"))
+	v, ok := tree.Get("dup")
+	assert.True(t, ok)
+	assert.Equal(t, "row00", v)
+
+	// The run is spread over several leaves
+	leaves := 0
+	for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		if slices.Contains(leaf.keys, "dup") {
+			leaves++
+		}
+	}
+	assert.True(t, leaves > 1, "run fits in %d leaf", leaves)
+
+	// Putting an existing pair again changes nothing
+	tree.Put("dup", "row10")
+	assert.Equal(t, want, tree.GetAll("dup"))
+
+	// Remove single values from the start, middle and end of the run
+	for _, val := range []string{"row00", "row25", "row49"} {
+		assert.True(t, tree.DeleteValue("dup", val))
+		assert.False(t, tree.DeleteValue("dup", val))
+		want = slices.DeleteFunc(want, func(s string) bool { return s == val })
+	}
+	assert.Equal(t, want, tree.GetAll("dup"))
+	v, _ = tree.Get("dup")
+	assert.Equal(t, "row01", v)
+
+	// Neighbours are untouched
+	assert.Equal(t, []string{"x"}, tree.GetAll("a10"))
+	assert.Equal(t, []string{"x"}, tree.GetAll("z10"))
+
+	// Delete drops the whole run, across leaves
+	assert.True(t, tree.Delete("dup"))
+	assert.Equal(t, 0, len(tree.GetAll("dup")))
+	_, ok = tree.Get("dup")
+	assert.False(t, ok)
+	assert.False(t, tree.Delete("dup"))
+
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, []string{"x"}, tree.GetAll(fmt.Sprintf("a%02d", i)))
+		assert.Equal(t, []string{"x"}, tree.GetAll(fmt.Sprintf("z%02d", i)))
+	}
+}
+
+func TestDuplicatesBulkLoad(t *testing.T) {
+	pairs := func(yield func(string, string) bool) {
+		for k := 0; k < 20; k++ {
+			for v := 0; v < k; v++ {
+				if !yield(fmt.Sprintf("k%02d", k), fmt.Sprintf("v%02d", v)) {
+					return
+				}
+			}
+		}
+	}
+
+	tree := newDuplicatesTree(4)
+	assert.NoError(t, tree.BulkLoad(pairs, 0.8))
+	assertShape(t, tree)
+
+	for k := 0; k < 20; k++ {
+		vals := tree.GetAll(fmt.Sprintf("k%02d", k))
+		assert.Equal(t, k, len(vals))
+
+		// Inserting into the middle of a run lands in the right leaf
+		tree.Put(fmt.Sprintf("k%02d", k), "v00x")
+	}
+	for k := 1; k < 20; k++ {
+		vals := tree.GetAll(fmt.Sprintf("k%02d", k))
+		assert.Equal(t, k+1, len(vals))
+		assert.True(t, slices.IsSorted(vals))
+	}
+
+	// The same key is fine, the same pair isn't
+	repeat := func(yield func(string, string) bool) {
+		_ = yield("a", "1") && yield("a", "2") && yield("a", "2")
+	}
+	assert.Error(t, newDuplicatesTree(4).BulkLoad(repeat, 1))
+}
+
+func TestUniqueGetAll(t *testing.T) {
+	tree := NewBPlusTree(3)
+	tree.Put("a", "1")
+	tree.Put("a", "2")
+
+	assert.Equal(t, []string{"2"}, tree.GetAll("a"))
+	assert.Equal(t, 0, len(tree.GetAll("b")))
+
+	// DeleteValue only deletes a matching value
+	assert.False(t, tree.DeleteValue("a", "1"))
+	assert.True(t, tree.DeleteValue("a", "2"))
+	_, ok := tree.Get("a")
+	assert.False(t, ok)
+}
+
+func TestDuplicatesEmptiedLeaf(t *testing.T) {
+	// Empty each leaf that holds nothing but part of the run in turn, so
+	// the run carries on past an empty leaf
+	build := func() (*BPlusTree, [][]string) {
+		tree := newDuplicatesTree(3)
+		tree.Put("a", "x")
+		for i := 0; i < 8; i++ {
+			tree.Put("m", fmt.Sprintf("v%d", i))
+		}
+		tree.Put("z", "x")
+
+		var only [][]string
+		for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+			if len(leaf.keys) > 0 && !slices.ContainsFunc(leaf.keys, func(k string) bool { return k != "m" }) {
+				only = append(only, slices.Clone(leaf.vals))
+			}
+		}
+		return tree, only
+	}
+
+	_, leaves := build()
+	assert.True(t, len(leaves) > 1, "only %d leaves hold just the run", len(leaves))
+	for i := range leaves {
+		tree, leaves := build()
+		want := []string{"v0", "v1", "v2", "v3", "v4", "v5", "v6", "v7"}
+		for _, val := range leaves[i] {
+			assert.True(t, tree.DeleteValue("m", val))
+			want = slices.DeleteFunc(want, func(s string) bool { return s == val })
+		}
+
+		assert.Equal(t, want, tree.GetAll("m"))
+		v, ok := tree.Get("m")
+		assert.True(t, ok, "leaf %d emptied", i)
+		assert.Equal(t, want[0], v)
+
+		assert.True(t, tree.Delete("m"))
+		assert.Equal(t, 0, len(tree.GetAll("m")), "leaf %d emptied", i)
+		_, ok = tree.Get("m")
+		assert.False(t, ok)
+		assert.Equal(t, []string{"x"}, tree.GetAll("a"))
+		assert.Equal(t, []string{"x"}, tree.GetAll("z"))
+	}
+}
@@ -0,0 +1,424 @@
+package bplustree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"iter"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	walFileName        = "tree.wal"
+	checkpointFileName = "tree.checkpoint"
+
+	// checkpointMagic identifies a checkpoint file of prefix-compressed
+	// pages
+	checkpointMagic = "BPTCKPT2"
+
+	// defaultCheckpointSize is how big the WAL may grow before
+	// Put takes a checkpoint and starts a fresh log
+	defaultCheckpointSize = 16 * 1024 * 1024
+
+	// checkpointFillFactor leaves some room in the leaves rebuilt
+	// from a checkpoint so the first writes after Open don't all split
+	checkpointFillFactor = 0.75
+)
+
+var errCorruptCheckpoint = errors.New("corrupt checkpoint")
+
+// DurableTree is a BPlusTree whose changes survive a crash.
+//
+// Every Put/Delete is appended to a logical write-ahead log and
+// synced before it touches the in-memory tree. Checkpoint writes the
+// whole tree to a checkpoint file tagged with the LSN it covers, after
+// which the log can be emptied. Open rebuilds the tree from the last
+// checkpoint and then runs the Puts and Deletes logged after it again.
+type DurableTree struct {
+	// mu serializes writers so records are applied in LSN order.
+	// Readers go straight to the tree, which latches on its own.
+	mu sync.Mutex
+
+	tree *BPlusTree
+	dir  string
+	wal  *WAL
+
+	lsn            uint64 // Last LSN handed out
+	checkpointLSN  uint64 // LSN covered by the checkpoint on disk
+	checkpointSize int64  // WAL size that triggers a checkpoint
+	syncWrites     bool   // Sync the WAL before acknowledging a write
+
+	// hook is called at each crash point with its name, a non-nil
+	// error aborts the operation there. Only set by tests.
+	hook func(point string) error
+}
+
+// Open opens (or creates) a durable tree stored in dir
+func Open(dir string, degree int) (*DurableTree, error) {
+	cfg := DefaultConfig()
+	cfg.Degree = degree
+	return OpenWithConfig(dir, cfg)
+}
+
+// OpenWithConfig opens (or creates) a durable tree stored in dir
+// with the given options. Without SyncWrites a write is in the WAL
+// once it returns, but only reaches the disk on Sync or a checkpoint.
+func OpenWithConfig(dir string, cfg *Config) (*DurableTree, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	d := &DurableTree{
+		tree:           NewBPlusTreeWithConfig(cfg),
+		dir:            dir,
+		checkpointSize: defaultCheckpointSize,
+		syncWrites:     cfg.SyncWrites,
+	}
+
+	if err := d.loadCheckpoint(); err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	wal, err := OpenWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		return nil, err
+	}
+	d.wal = wal
+
+	if err := d.recover(); err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("failed to recover from wal: %w", err)
+	}
+
+	return d, nil
+}
+
+// Get retrieves the value for key
+func (d *DurableTree) Get(key string) (string, bool) {
+	return d.tree.Get(key)
+}
+
+// Scan iterates over the keys in [start, end) in order, an empty
+// end means no upper bound
+func (d *DurableTree) Scan(start, end string) iter.Seq2[string, string] {
+	return d.tree.Scan(start, end)
+}
+
+// Put logs and then stores a key/value pair
+func (d *DurableTree) Put(key string, val string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec := &WALRecord{LSN: d.lsn + 1, Type: WALPut, Key: key, Val: val}
+	if err := d.log(rec); err != nil {
+		return err
+	}
+
+	d.apply(rec)
+
+	return d.maybeCheckpoint()
+}
+
+// Delete logs and then removes key, returns false if it wasn't present
+func (d *DurableTree) Delete(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.tree.Get(key); !ok {
+		return false, nil
+	}
+
+	rec := &WALRecord{LSN: d.lsn + 1, Type: WALDelete, Key: key}
+	if err := d.log(rec); err != nil {
+		return false, err
+	}
+
+	d.apply(rec)
+
+	return true, d.maybeCheckpoint()
+}
+
+// Tree returns the in-memory tree, it must not be modified directly
+func (d *DurableTree) Tree() *BPlusTree {
+	return d.tree
+}
+
+// Sync forces the WAL to disk
+func (d *DurableTree) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.wal.Sync()
+}
+
+// Close syncs and closes the WAL
+func (d *DurableTree) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.wal.Sync(); err != nil {
+		d.wal.Close()
+		return err
+	}
+
+	return d.wal.Close()
+}
+
+// log makes a record durable before it's applied
+func (d *DurableTree) log(rec *WALRecord) error {
+	if err := d.crashPoint("wal.append"); err != nil {
+		return err
+	}
+	if err := d.wal.Append(rec); err != nil {
+		return err
+	}
+
+	if d.syncWrites {
+		if err := d.crashPoint("wal.sync"); err != nil {
+			return err
+		}
+		if err := d.wal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	d.lsn = rec.LSN
+	return nil
+}
+
+// apply makes a logged change to the in-memory tree
+func (d *DurableTree) apply(rec *WALRecord) {
+	switch rec.Type {
+	case WALPut:
+		d.tree.Put(rec.Key, rec.Val)
+	case WALDelete:
+		d.tree.Delete(rec.Key)
+	}
+}
+
+// recover redoes the records in the WAL that are newer than the
+// checkpoint. Older ones are left over from a crash between writing a
+// checkpoint and emptying the log.
+func (d *DurableTree) recover() error {
+	d.lsn = d.checkpointLSN
+
+	return d.wal.Replay(func(rec *WALRecord) error {
+		if rec.LSN <= d.checkpointLSN {
+			return nil
+		}
+		d.apply(rec)
+		d.lsn = max(d.lsn, rec.LSN)
+		return nil
+	})
+}
+
+// maybeCheckpoint takes a checkpoint once the WAL has grown too big
+func (d *DurableTree) maybeCheckpoint() error {
+	if d.wal.Size() < d.checkpointSize {
+		return nil
+	}
+
+	return d.checkpoint()
+}
+
+// Checkpoint writes the whole tree to disk and empties the WAL.
+//
+// The checkpoint is written to a temp file and renamed into place,
+// so a crash leaves either the old or the new checkpoint. A crash
+// after the rename but before the WAL is emptied is harmless since
+// every record left in the WAL is at or below the checkpoint LSN.
+func (d *DurableTree) Checkpoint() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.checkpoint()
+}
+
+// checkpoint does the work of Checkpoint, d.mu must be held
+func (d *DurableTree) checkpoint() error {
+	data := d.encodeCheckpoint()
+
+	tmpPath := filepath.Join(d.dir, checkpointFileName+".tmp")
+	path := filepath.Join(d.dir, checkpointFileName)
+
+	if err := d.crashPoint("checkpoint.write"); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	if err := d.crashPoint("checkpoint.sync"); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := d.crashPoint("checkpoint.rename"); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to install checkpoint: %w", err)
+	}
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+	d.checkpointLSN = d.lsn
+
+	if err := d.crashPoint("wal.reset"); err != nil {
+		return err
+	}
+	return d.wal.Reset()
+}
+
+// encodeCheckpoint serializes every key/value in order as
+// [magic:8][lsn:8][count:8] ([page_size:4][page])... [crc:4]
+// where each page holds about pageSize bytes of front-coded entries
+func (d *DurableTree) encodeCheckpoint() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(checkpointMagic)
+	binary.Write(&buf, binary.LittleEndian, d.lsn)
+
+	// Count is patched in once the leaves have been walked
+	countPos := buf.Len()
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+
+	writePage := func(page []byte) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(page)))
+		buf.Write(page)
+	}
+
+	var pages pageBuilder
+	var count uint64 = 0
+	for leaf := d.tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for i, key := range leaf.keys {
+			pages.add(key, leaf.vals[i])
+			if pages.full() {
+				writePage(pages.finish())
+			}
+			count++
+		}
+	}
+	if !pages.empty() {
+		writePage(pages.finish())
+	}
+
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint64(data[countPos:], count)
+
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// loadCheckpoint rebuilds the tree from the checkpoint file if there is one
+func (d *DurableTree) loadCheckpoint() error {
+	data, err := os.ReadFile(filepath.Join(d.dir, checkpointFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	headerSize := len(checkpointMagic) + 8 + 8
+	if len(data) < headerSize+4 {
+		return errCorruptCheckpoint
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return errCorruptCheckpoint
+	}
+
+	lsn := binary.LittleEndian.Uint64(body[8:16])
+	count := binary.LittleEndian.Uint64(body[16:24])
+
+	keys := make([]string, 0, count)
+	vals := make([]string, 0, count)
+	add := func(key, val string) {
+		keys = append(keys, key)
+		vals = append(vals, val)
+	}
+
+	if string(body[:len(checkpointMagic)]) != checkpointMagic {
+		return errCorruptCheckpoint
+	}
+	if err := decodeCheckpointPages(body[headerSize:], add); err != nil {
+		return err
+	}
+	if uint64(len(keys)) != count {
+		return errCorruptCheckpoint
+	}
+
+	// The checkpoint is written in key order so the tree can be
+	// bulk loaded instead of replaying a Put per key
+	pairs := func(yield func(string, string) bool) {
+		for i := range keys {
+			if !yield(keys[i], vals[i]) {
+				return
+			}
+		}
+	}
+	if err := d.tree.BulkLoad(pairs, checkpointFillFactor); err != nil {
+		return fmt.Errorf("%w: %w", errCorruptCheckpoint, err)
+	}
+	d.checkpointLSN = lsn
+
+	return nil
+}
+
+// decodeCheckpointPages decodes the pages of a checkpoint
+func decodeCheckpointPages(body []byte, add func(key, val string)) error {
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return errCorruptCheckpoint
+		}
+		size := int(binary.LittleEndian.Uint32(body))
+		body = body[4:]
+		if size > len(body) {
+			return errCorruptCheckpoint
+		}
+		if err := decodePage(body[:size], add); err != nil {
+			return fmt.Errorf("%w: %w", errCorruptCheckpoint, err)
+		}
+		body = body[size:]
+	}
+	return nil
+}
+
+// crashPoint runs the test hook for the named point
+func (d *DurableTree) crashPoint(point string) error {
+	if d.hook == nil {
+		return nil
+	}
+	return d.hook(point)
+}
+
+// syncDir fsyncs a directory so a rename in it is durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
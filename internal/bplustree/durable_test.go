@@ -0,0 +1,270 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// op is one step of a test workload
+type op struct {
+	del bool
+	key string
+	val string
+}
+
+// splitWorkload returns puts in shuffled order with a few overwrites
+// and deletes mixed in, enough to cascade splits with a small degree
+func splitWorkload(n int) []op {
+	rng := rand.New(rand.NewSource(42))
+
+	var ops []op
+	for _, i := range rng.Perm(n) {
+		ops = append(ops, op{key: fmt.Sprintf("k%03d", i), val: fmt.Sprintf("v%d", i)})
+	}
+	for i := 0; i < n/4; i++ {
+		k := rng.Intn(n)
+		if i%2 == 0 {
+			ops = append(ops, op{key: fmt.Sprintf("k%03d", k), val: fmt.Sprintf("w%d", k)})
+		} else {
+			ops = append(ops, op{del: true, key: fmt.Sprintf("k%03d", k)})
+		}
+	}
+
+	return ops
+}
+
+// modelAfter returns the expected contents after the first n ops
+func modelAfter(ops []op, n int) map[string]string {
+	model := make(map[string]string)
+	for _, o := range ops[:n] {
+		if o.del {
+			delete(model, o.key)
+		} else {
+			model[o.key] = o.val
+		}
+	}
+	return model
+}
+
+// runOps applies ops until one fails, returning how many succeeded
+func runOps(d *DurableTree, ops []op, checkpointEvery int) (int, error) {
+	for i, o := range ops {
+		var err error
+		if o.del {
+			_, err = d.Delete(o.key)
+		} else {
+			err = d.Put(o.key, o.val)
+		}
+		if err != nil {
+			return i, err
+		}
+
+		if checkpointEvery > 0 && (i+1)%checkpointEvery == 0 {
+			if err := d.Checkpoint(); err != nil {
+				// The op itself went through
+				return i + 1, err
+			}
+		}
+	}
+	return len(ops), nil
+}
+
+// crash drops the tree without syncing or checkpointing
+func crash(d *DurableTree) {
+	d.wal.file.Close()
+}
+
+// assertContents checks the tree holds exactly model, both through
+// Get and by walking the leaf chain
+func assertContents(t *testing.T, tree *BPlusTree, model map[string]string) {
+	t.Helper()
+
+	assert.NoError(t, tree.Validate())
+
+	for k, v := range model {
+		got, ok := tree.Get(k)
+		assert.True(t, ok, "missing key %s", k)
+		assert.Equal(t, v, got)
+	}
+
+	var chain []string
+	for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		chain = append(chain, leaf.keys...)
+	}
+	assert.True(t, slices.IsSorted(chain), "leaf chain out of order: %v", chain)
+	assert.Equal(t, len(model), len(chain), "leaf chain: %v", chain)
+}
+
+// limitWriter writes at most n bytes and then fails, which leaves a
+// torn record at the end of the log just like a crash mid-write
+type limitWriter struct {
+	w io.Writer
+	n int
+}
+
+var errInjected = errors.New("injected fault")
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if len(p) <= l.n {
+		l.n -= len(p)
+		return l.w.Write(p)
+	}
+
+	n, _ := l.w.Write(p[:l.n])
+	l.n = 0
+	return n, errInjected
+}
+
+func TestDurableReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := Open(dir, 3)
+	assert.NoError(t, err)
+
+	ops := splitWorkload(50)
+	_, err = runOps(d, ops[:30], 0)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Close())
+
+	// Everything comes back from the WAL alone
+	d, err = Open(dir, 3)
+	assert.NoError(t, err)
+	assertContents(t, d.Tree(), modelAfter(ops, 30))
+
+	// And from a checkpoint plus the WAL written after it
+	assert.NoError(t, d.Checkpoint())
+	_, err = runOps(d, ops[30:], 0)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Close())
+
+	d, err = Open(dir, 3)
+	assert.NoError(t, err)
+	assertContents(t, d.Tree(), modelAfter(ops, len(ops)))
+
+	// New writes continue after the recovered LSN
+	assert.NoError(t, d.Put("zzz", "last"))
+	assert.NoError(t, d.Close())
+
+	d, err = Open(dir, 3)
+	assert.NoError(t, err)
+	v, ok := d.Get("zzz")
+	assert.True(t, ok)
+	assert.Equal(t, "last", v)
+	assert.NoError(t, d.Close())
+}
+
+func TestDurableTornWrites(t *testing.T) {
+	ops := splitWorkload(24)
+
+	// Find out how long the full log is
+	d, err := Open(t.TempDir(), 3)
+	assert.NoError(t, err)
+	_, err = runOps(d, ops, 0)
+	assert.NoError(t, err)
+	total := int(d.wal.Size())
+	assert.NoError(t, d.Close())
+
+	// Cut the log at every byte, which tears each record at every
+	// offset in turn
+	for cut := 0; cut <= total; cut++ {
+		dir := t.TempDir()
+
+		d, err := Open(dir, 3)
+		assert.NoError(t, err)
+		d.wal.w = &limitWriter{w: d.wal.file, n: cut}
+
+		acked, _ := runOps(d, ops, 0)
+		crash(d)
+
+		d, err = Open(dir, 3)
+		assert.NoError(t, err, "cut at %d", cut)
+		assertContents(t, d.Tree(), modelAfter(ops, acked))
+
+		// The torn tail is gone, so the log keeps working
+		assert.NoError(t, d.Put("after", "crash"))
+		assert.NoError(t, d.Close())
+	}
+}
+
+func TestDurableGarbageTail(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, 3)
+	assert.NoError(t, err)
+	ops := splitWorkload(10)
+	_, err = runOps(d, ops, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, d.Close())
+
+	// A tail whose header claims sizes far past the end of the file is
+	// cut off without allocating them
+	f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	garbage := make([]byte, walHeaderSize)
+	for i := range garbage {
+		garbage[i] = 0xff
+	}
+	_, err = f.Write(garbage)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	d, err = Open(dir, 3)
+	assert.NoError(t, err)
+	assertContents(t, d.Tree(), modelAfter(ops, len(ops)))
+	assert.NoError(t, d.Close())
+}
+
+func TestDurableCrashPoints(t *testing.T) {
+	ops := splitWorkload(40)
+	points := []string{
+		"wal.append", "wal.sync",
+		"checkpoint.write", "checkpoint.sync", "checkpoint.rename", "wal.reset",
+	}
+
+	for _, point := range points {
+		for hit := 1; ; hit++ {
+			dir := t.TempDir()
+
+			d, err := Open(dir, 3)
+			assert.NoError(t, err)
+
+			seen := 0
+			d.hook = func(p string) error {
+				if p != point {
+					return nil
+				}
+				seen++
+				if seen == hit {
+					return errInjected
+				}
+				return nil
+			}
+
+			acked, err := runOps(d, ops, 7)
+			crash(d)
+			if err == nil {
+				// Ran out of places to crash at this point
+				break
+			}
+
+			d, err = Open(dir, 3)
+			assert.NoError(t, err, "%s #%d", point, hit)
+
+			// A record that reached the log before the crash is
+			// redone even though its Put never returned
+			expected := modelAfter(ops, acked)
+			if point == "wal.sync" {
+				expected = modelAfter(ops, acked+1)
+			}
+			assertContents(t, d.Tree(), expected)
+			assert.NoError(t, d.Close())
+		}
+	}
+}
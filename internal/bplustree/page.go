@@ -0,0 +1,105 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// pageSize is the size leaf pages are packed to on disk. A page
+// may end up larger if a single entry doesn't fit.
+const pageSize = 4096
+
+var errCorruptPage = errors.New("corrupt page")
+
+// A leaf page stores sorted entries front coded: each key only keeps
+// the suffix it doesn't share with the key before it.
+//
+//	[count:uvarint] ([shared:uvarint][unshared:uvarint][val_size:uvarint][suffix][val])...
+//
+// Keys that share long prefixes (tenant/123/user/...) shrink to a few
+// bytes each, and the varint sizes cost one byte for short entries.
+
+// pageBuilder packs sorted entries into prefix-compressed pages
+type pageBuilder struct {
+	buf     []byte // Entries of the current page
+	count   int
+	prevKey string
+}
+
+// add appends an entry to the current page
+func (b *pageBuilder) add(key, val string) {
+	shared := commonPrefix(b.prevKey, key)
+	b.buf = binary.AppendUvarint(b.buf, uint64(shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(val)))
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, val...)
+	b.count++
+	b.prevKey = key
+}
+
+// full reports whether the current page has reached pageSize
+func (b *pageBuilder) full() bool {
+	return len(b.buf) >= pageSize
+}
+
+// empty reports whether the current page has no entries
+func (b *pageBuilder) empty() bool {
+	return b.count == 0
+}
+
+// finish returns the encoded page and starts a new one.
+// Every page starts with a full key so it decodes on its own.
+func (b *pageBuilder) finish() []byte {
+	page := binary.AppendUvarint(nil, uint64(b.count))
+	page = append(page, b.buf...)
+
+	b.buf = b.buf[:0]
+	b.count = 0
+	b.prevKey = ""
+	return page
+}
+
+// decodePage calls fn with each entry of a page in order
+func decodePage(page []byte, fn func(key, val string)) error {
+	count, n := binary.Uvarint(page)
+	if n <= 0 {
+		return errCorruptPage
+	}
+	page = page[n:]
+
+	var key []byte
+	for i := uint64(0); i < count; i++ {
+		var sizes [3]uint64
+		for j := range sizes {
+			sizes[j], n = binary.Uvarint(page)
+			if n <= 0 {
+				return errCorruptPage
+			}
+			page = page[n:]
+		}
+
+		shared, unshared, valSize := sizes[0], sizes[1], sizes[2]
+		if shared > uint64(len(key)) || unshared+valSize > uint64(len(page)) {
+			return errCorruptPage
+		}
+
+		key = append(key[:shared], page[:unshared]...)
+		fn(string(key), string(page[unshared:unshared+valSize]))
+		page = page[unshared+valSize:]
+	}
+
+	if len(page) != 0 {
+		return errCorruptPage
+	}
+	return nil
+}
+
+// commonPrefix returns the length of the prefix a and b share
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
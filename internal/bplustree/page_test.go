@@ -0,0 +1,149 @@
+package bplustree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// tenantKey returns a key with a long prefix shared by its neighbours
+func tenantKey(tenant, user int) string {
+	return fmt.Sprintf("tenant/%04d/user/%08d/profile", tenant, user)
+}
+
+func TestShortestSeparator(t *testing.T) {
+	assert.Equal(t, "b", shortestSeparator("apple", "banana"))
+	assert.Equal(t, "abd", shortestSeparator("abc", "abdef"))
+	assert.Equal(t, "abc", shortestSeparator("ab", "abc"))
+	assert.Equal(t, "tenant/0001/user/0000001",
+		shortestSeparator("tenant/0001/user/00000009/profile", "tenant/0001/user/00000010/profile"))
+
+	// Suffix truncated separators keep internal nodes small
+	tree := NewBPlusTree(4)
+	for i := 0; i < 200; i++ {
+		tree.Put(tenantKey(1, i*10), "v")
+	}
+	assert.NoError(t, tree.Validate())
+	for _, key := range tree.root.keys {
+		assert.True(t, len(key) < len(tenantKey(1, 0)), "separator %q wasn't truncated", key)
+	}
+}
+
+func TestPageRoundTrip(t *testing.T) {
+	var keys, vals []string
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, tenantKey(i/100, i))
+		vals = append(vals, strings.Repeat("x", i%7))
+	}
+
+	var b pageBuilder
+	var pages [][]byte
+	for i := range keys {
+		b.add(keys[i], vals[i])
+		if b.full() {
+			pages = append(pages, b.finish())
+		}
+	}
+	pages = append(pages, b.finish())
+	assert.True(t, len(pages) > 1)
+
+	var gotKeys, gotVals []string
+	raw, compressed := 0, 0
+	for _, page := range pages {
+		compressed += len(page)
+		assert.NoError(t, decodePage(page, func(key, val string) {
+			gotKeys = append(gotKeys, key)
+			gotVals = append(gotVals, val)
+		}))
+	}
+	for i := range keys {
+		raw += len(keys[i]) + len(vals[i])
+	}
+	assert.Equal(t, keys, gotKeys)
+	assert.Equal(t, vals, gotVals)
+
+	// Keys share all but the last few bytes, so pages should be well
+	// under half the size of the raw entries
+	assert.True(t, compressed*2 < raw, "compressed %d of %d bytes", compressed, raw)
+
+	// Truncated or trailing bytes are caught
+	assert.Error(t, decodePage(pages[0][:len(pages[0])-1], func(string, string) {}))
+	assert.Error(t, decodePage(append(pages[0], 0), func(string, string) {}))
+}
+
+// TestRandomOpsMaxNodeBytes runs random puts and deletes with keys
+// and values of very different sizes in a tree split by bytes
+func TestRandomOpsMaxNodeBytes(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	tree := NewBPlusTreeWithConfig(&Config{Degree: 64, MaxNodeBytes: 256})
+	oracle := make(map[string]string)
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("%s/%d", strings.Repeat("k", rng.Intn(40)), rng.Intn(500))
+		switch rng.Intn(10) {
+		case 0, 1:
+			_, exists := oracle[key]
+			assert.Equal(t, exists, tree.Delete(key))
+			delete(oracle, key)
+		default:
+			val := strings.Repeat("v", rng.Intn(60))
+			tree.Put(key, val)
+			oracle[key] = val
+		}
+
+		if i%250 == 0 {
+			assert.NoError(t, tree.Validate(), "after %d ops", i)
+		}
+	}
+
+	assertContents(t, tree, oracle)
+
+	// Leaves are limited by bytes long before they reach the degree
+	stats := tree.Stats()
+	assert.True(t, stats.Entries/stats.Leaves < 32, "%s", stats)
+}
+
+func TestBulkLoadMaxNodeBytes(t *testing.T) {
+	tree := NewBPlusTreeWithConfig(&Config{Degree: 64, MaxNodeBytes: 512})
+	pairs := func(yield func(string, string) bool) {
+		for i := 0; i < 2000; i++ {
+			if !yield(tenantKey(i/50, i), strings.Repeat("v", i%20)) {
+				return
+			}
+		}
+	}
+	assert.NoError(t, tree.BulkLoad(pairs, 0.9))
+	assert.NoError(t, tree.Validate())
+	assert.Equal(t, 2000, tree.Stats().Entries)
+
+	// Later inserts keep the limits
+	for i := 0; i < 500; i++ {
+		tree.Put(tenantKey(1000, i), strings.Repeat("w", 50))
+	}
+	assert.NoError(t, tree.Validate())
+}
+
+func TestCheckpointMagic(t *testing.T) {
+	dir := t.TempDir()
+
+	// A checkpoint with a valid checksum but a magic this code doesn't
+	// write is refused rather than misread
+	var buf bytes.Buffer
+	buf.WriteString("BPTCKPT1")
+	binary.Write(&buf, binary.LittleEndian, uint64(7))
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+	data := binary.LittleEndian.AppendUint32(buf.Bytes(), crc32.ChecksumIEEE(buf.Bytes()))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, checkpointFileName), data, 0644))
+
+	_, err := Open(dir, 4)
+	assert.True(t, errors.Is(err, errCorruptCheckpoint), "got %v", err)
+}
@@ -0,0 +1,137 @@
+package bplustree
+
+// entryOverhead approximates the bytes an entry costs on top of its
+// key and value, the two length prefixes of a page entry
+const entryOverhead = 4
+
+// Splitting by bytes needs a few entries per node so that both
+// halves of a split end up non-empty
+const (
+	minLeafSplitKeys     = 2
+	minInternalSplitKeys = 3
+)
+
+// nodeBytes returns the size of a node's keys and values in bytes
+func nodeBytes(n *Node) int {
+	size := 0
+	for i, k := range n.keys {
+		size += entryOverhead + len(k)
+		if i < len(n.vals) {
+			size += len(n.vals[i])
+		}
+	}
+	return size
+}
+
+// overflows reports whether n has to be split. Without MaxNodeBytes
+// that's when it holds more than degree keys, with it also when its
+// keys and values take up more than MaxNodeBytes.
+func (t *BPlusTree) overflows(n *Node) bool {
+	if len(n.keys) > t.degree {
+		return true
+	}
+	if t.maxNodeBytes == 0 || len(n.keys) < t.minSplitKeys(n) {
+		return false
+	}
+	return nodeBytes(n) > t.maxNodeBytes
+}
+
+func (t *BPlusTree) minSplitKeys(n *Node) int {
+	if n.isLeaf {
+		return minLeafSplitKeys
+	}
+	return minInternalSplitKeys
+}
+
+// isSafe reports whether inserting one key into n can't split it.
+// With MaxNodeBytes the inserted entry (or a separator promoted from
+// below, which is never longer than the longest entry) has to fit.
+func (t *BPlusTree) isSafe(n *Node) bool {
+	if len(n.keys) >= t.degree {
+		return false
+	}
+	if t.maxNodeBytes == 0 || len(n.keys)+1 < t.minSplitKeys(n) {
+		return true
+	}
+	return nodeBytes(n)+entryOverhead+int(t.maxEntryBytes.Load()) <= t.maxNodeBytes
+}
+
+// noteEntrySize records the size of an entry about to be inserted so
+// isSafe knows how big a separator might get
+func (t *BPlusTree) noteEntrySize(key, val string) {
+	size := int64(len(key) + len(val))
+	for {
+		cur := t.maxEntryBytes.Load()
+		if size <= cur || t.maxEntryBytes.CompareAndSwap(cur, size) {
+			return
+		}
+	}
+}
+
+// leafSplitPoint returns the index of the first entry that moves to
+// the right half when a leaf splits: the middle entry, or with
+// MaxNodeBytes the entry where the left half reaches half the bytes
+func (t *BPlusTree) leafSplitPoint(n *Node) int {
+	if t.maxNodeBytes == 0 {
+		return len(n.keys) / 2
+	}
+	return bytesMidpoint(n, 1, len(n.keys)-1)
+}
+
+// internalSplitPoint returns the index of the separator that moves
+// up when an internal node splits, keeping a key on each side
+func (t *BPlusTree) internalSplitPoint(n *Node) int {
+	if t.maxNodeBytes == 0 {
+		return len(n.keys) / 2
+	}
+	return bytesMidpoint(n, 1, len(n.keys)-2)
+}
+
+// bytesMidpoint returns the index in [lo, hi] that splits the node
+// with the fewest bytes in its larger half. Neither half then holds
+// more than half the node plus one entry, so halves fit as long as
+// entries are at most MaxNodeBytes/2.
+func bytesMidpoint(n *Node, lo, hi int) int {
+	total := nodeBytes(n)
+	best, bestSize := hi, total
+	size := 0
+	for i, k := range n.keys {
+		if i >= lo && i <= hi {
+			if larger := max(size, total-size); larger < bestSize {
+				best, bestSize = i, larger
+			}
+		}
+		size += entryOverhead + len(k)
+		if i < len(n.vals) {
+			size += len(n.vals[i])
+		}
+	}
+	return best
+}
+
+// leafSeparator returns the separator to promote between two
+// adjacent leaves: the shortest key that sorts after everything in
+// left and no later than the first key in right (suffix truncation).
+// Short separators keep internal nodes small, so fanout stays high
+// even when keys share long prefixes.
+func (t *BPlusTree) leafSeparator(left, right *Node) (string, string) {
+	first := right.keys[0]
+	if len(left.keys) == 0 {
+		return t.separator(first, right.vals[0])
+	}
+
+	last := left.keys[len(left.keys)-1]
+	if last == first {
+		// A run of duplicates spans the split, only the value tells
+		// the two sides apart
+		return t.separator(first, right.vals[0])
+	}
+
+	return shortestSeparator(last, first), ""
+}
+
+// shortestSeparator returns the shortest prefix of b that sorts after a,
+// a must sort before b
+func shortestSeparator(a, b string) string {
+	return b[:commonPrefix(a, b)+1]
+}
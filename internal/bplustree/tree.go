@@ -1,8 +1,31 @@
+// Package bplustree implements an in-memory B+ tree, with a
+// write-ahead log and checkpoints to make it durable (DurableTree) and
+// a separate copy-on-write tree for snapshots (COWTree).
+//
+// COWTree doesn't share BPlusTree's Node. A Node is changed in place
+// under latches, and has parent and leaf-chain pointers that a copied
+// node would need its neighbours copied to keep up to date. A
+// copy-on-write tree needs nodes that never change once published and
+// no pointers sideways or up, so it has its own. BPlusTree's options
+// (duplicates, MaxNodeBytes and prefix-compressed pages) aren't
+// implemented for it.
 package bplustree
 
-import "slices"
+import (
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 type Node struct {
+	// latch guards keys, vals, children and next.
+	// Operations crab down the tree: a child is latched before its
+	// parent is released, and ancestors are only held on to while
+	// the child below them might still split.
+	latch sync.RWMutex
+
 	keys []string
 
 	// For Internal Nodes
@@ -18,6 +41,8 @@ type Node struct {
 	parent   *Node
 
 	// For Leaf Nodes
+	// (internal nodes of a tree with duplicates use vals too, as
+	// tiebreakers for separator keys, see BPlusTree.separator)
 	isLeaf bool
 	vals   []string
 	next   *Node
@@ -60,52 +85,87 @@ func (n *Node) Get(key string) (string, bool) {
 	return "", false
 }
 
-func (n *Node) findLeaf(key string) *Node {
+func (n *Node) findLeaf(key, val string) *Node {
 	// Found the leaf
 	if n.isLeaf {
 		return n
 	}
 
 	// Find the correct child by traversing
-	// try searching in its children
-	for i, childNode := range n.children {
-		lessKey := ""
-		if i != 0 {
-			lessKey = n.keys[i-1]
-		}
-		greaterKey := ""
-		if i != len(n.children)-1 {
-			greaterKey = n.keys[i]
-		}
+	return n.child(key, val).findLeaf(key, val)
+}
 
-		if i == len(n.children)-1 {
-			// Last Child so no upperbound
-			if lessKey <= key {
-				return childNode.findLeaf(key)
-			}
-		} else {
-			// Both LowerBound and UpperBound is present
-			if lessKey <= key && key < greaterKey {
-				return childNode.findLeaf(key)
-			}
+// child returns the child of an internal node whose range holds
+// the entry key/val
+func (n *Node) child(key, val string) *Node {
+	// children[i] holds keys[i-1] <= xxx < keys[i],
+	// so the first separator greater than the entry picks the child
+	for i, k := range n.keys {
+		if compareEntries(key, val, k, n.separatorVal(i)) < 0 {
+			return n.children[i]
 		}
 	}
 
-	return nil
+	// Last Child so no upperbound
+	return n.children[len(n.children)-1]
+}
+
+// separatorVal returns the tiebreaker of the i'th separator of an
+// internal node, always "" in a tree without duplicates
+func (n *Node) separatorVal(i int) string {
+	if n.vals == nil {
+		return ""
+	}
+	return n.vals[i]
+}
+
+// compareEntries orders entries by key and then by value.
+// A separator with an empty value sorts before every entry with the
+// same key, so in a tree without duplicates (where separators never
+// carry a value) this is the same as comparing keys.
+func compareEntries(k1, v1, k2, v2 string) int {
+	if c := strings.Compare(k1, k2); c != 0 {
+		return c
+	}
+	return strings.Compare(v1, v2)
 }
 
 type BPlusTree struct {
 	// All the leaf nodes lies at the same level
 	root *Node
 
+	// rootLatch guards the root pointer itself.
+	// Writers hold it until they know the root won't split.
+	rootLatch sync.RWMutex
+
 	// Degree is the maximum number of keys
 	// so each node (except root) should have
 	// no of keys in range of [ceil(d/2), d]
 	// For Internal Nodes, they will have len(keys)+1 children
 	degree int
+
+	// With allowDuplicates a key maps to a set of values. Entries
+	// are ordered by (key, value) and separators carry the value as
+	// a tiebreaker, so a run of one key can span several leaves.
+	allowDuplicates bool
+
+	// With maxNodeBytes set nodes also split once their keys and
+	// values outgrow it, maxEntryBytes tracks the largest entry put
+	// so far to tell whether a node has room for one more
+	maxNodeBytes  int
+	maxEntryBytes atomic.Int64
 }
 
 func NewBPlusTree(degree int) *BPlusTree {
+	return NewBPlusTreeWithConfig(&Config{Degree: degree})
+}
+
+// NewBPlusTreeWithConfig creates an empty tree with the given options
+func NewBPlusTreeWithConfig(cfg *Config) *BPlusTree {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
 	root := &Node{
 		keys:   []string{},
 		vals:   []string{},
@@ -113,45 +173,327 @@ func NewBPlusTree(degree int) *BPlusTree {
 	}
 
 	return &BPlusTree{
-		root:   root,
-		degree: degree,
+		root:            root,
+		degree:          cfg.Degree,
+		allowDuplicates: cfg.AllowDuplicates,
+		maxNodeBytes:    cfg.MaxNodeBytes,
 	}
 }
 
+// Get returns the value for key, with duplicates the smallest one
 func (t *BPlusTree) Get(key string) (string, bool) {
-	return t.root.Get(key)
+	leaf := t.readLeaf(key, "")
+
+	// With duplicates the run may start further along the leaf
+	// chain, past leaves emptied by deletes
+	if t.allowDuplicates {
+		vals := t.scanRun(leaf, key, 1)
+		if len(vals) == 0 {
+			return "", false
+		}
+		return vals[0], true
+	}
+
+	defer leaf.latch.RUnlock()
+	for i, k := range leaf.keys {
+		if k == key {
+			return leaf.vals[i], true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns every value stored under key in ascending order.
+// Without duplicates that's at most one value.
+func (t *BPlusTree) GetAll(key string) []string {
+	return t.scanRun(t.readLeaf(key, ""), key, -1)
+}
+
+// Scan iterates over the entries with keys in [start, end) in order,
+// an empty end means no upper bound. A leaf's entries are copied out
+// under its read latch and yielded with no latches held, so the loop
+// body may write to the tree. Writes made during the scan to keys it
+// hasn't reached yet may or may not be seen.
+func (t *BPlusTree) Scan(start, end string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		var keys, vals []string
+		resumed := false
+		var lastKey, lastVal string
+
+		leaf := t.readLeaf(start, "")
+		for {
+			// Copy entries out of the first leaf that has any left,
+			// crabbing past leaves emptied by deletes
+			keys, vals = keys[:0], vals[:0]
+			done := false
+			for {
+				for i, k := range leaf.keys {
+					if k < start {
+						continue
+					}
+					if resumed && compareEntries(k, t.entryVal(leaf.vals[i]), lastKey, t.entryVal(lastVal)) <= 0 {
+						continue
+					}
+					if end != "" && k >= end {
+						done = true
+						break
+					}
+					keys = append(keys, k)
+					vals = append(vals, leaf.vals[i])
+				}
+
+				next := leaf.next
+				if done || len(keys) > 0 || next == nil {
+					done = done || next == nil
+					leaf.latch.RUnlock()
+					break
+				}
+				next.latch.RLock()
+				leaf.latch.RUnlock()
+				leaf = next
+			}
+
+			for i := range keys {
+				if !yield(keys[i], vals[i]) {
+					return
+				}
+			}
+			if done {
+				return
+			}
+
+			// The tree may have changed while unlatched, so go back
+			// down to wherever the last entry is now
+			lastKey, lastVal = keys[len(keys)-1], vals[len(vals)-1]
+			resumed = true
+			leaf = t.readLeaf(lastKey, t.entryVal(lastVal))
+		}
+	}
 }
 
+// scanRun collects up to limit values of key (all of them if limit
+// is negative) starting at the read latched leaf, which it unlatches
+func (t *BPlusTree) scanRun(leaf *Node, key string, limit int) []string {
+	var vals []string
+	for {
+		for i, k := range leaf.keys {
+			if k == key {
+				vals = append(vals, leaf.vals[i])
+			}
+			if k > key || len(vals) == limit {
+				leaf.latch.RUnlock()
+				return vals
+			}
+		}
+
+		// The run may carry on in the next leaf, crab over to it
+		next := leaf.next
+		if next == nil {
+			leaf.latch.RUnlock()
+			return vals
+		}
+		next.latch.RLock()
+		leaf.latch.RUnlock()
+		leaf = next
+	}
+}
+
+// readLeaf crabs down to the leaf for key/val with read latches and
+// returns it still read latched
+func (t *BPlusTree) readLeaf(key, val string) *Node {
+	t.rootLatch.RLock()
+	n := t.root
+	n.latch.RLock()
+	t.rootLatch.RUnlock()
+
+	for !n.isLeaf {
+		child := n.child(key, val)
+		child.latch.RLock()
+		n.latch.RUnlock()
+		n = child
+	}
+
+	return n
+}
+
+// lockLeaf crabs down to the leaf for key/val and returns it write
+// latched. Leaves are never merged so a delete only ever changes
+// leaves, read latches are enough on the way down.
+func (t *BPlusTree) lockLeaf(key, val string) *Node {
+	t.rootLatch.RLock()
+	n := t.root
+	if n.isLeaf {
+		n.latch.Lock()
+	} else {
+		n.latch.RLock()
+	}
+	t.rootLatch.RUnlock()
+
+	for !n.isLeaf {
+		child := n.child(key, val)
+		if child.isLeaf {
+			child.latch.Lock()
+		} else {
+			child.latch.RLock()
+		}
+		n.latch.RUnlock()
+		n = child
+	}
+
+	return n
+}
+
+// Delete removes key, with duplicates every value stored under it
 func (t *BPlusTree) Delete(key string) bool {
-	leaf := t.root.findLeaf(key)
+	leaf := t.lockLeaf(key, "")
 
-	keyIndex := -1
-	for i, k := range leaf.keys {
-		if k == key {
-			keyIndex = i
+	deleted := false
+	for {
+		// Keep everything but key
+		n := 0
+		for i, k := range leaf.keys {
+			if k == key {
+				deleted = true
+				continue
+			}
+			leaf.keys[n] = k
+			leaf.vals[n] = leaf.vals[i]
+			n++
+		}
+		clear(leaf.vals[n:])
+		leaf.keys = leaf.keys[:n]
+		leaf.vals = leaf.vals[:n]
+
+		// Only a run of duplicates can carry on past this leaf
+		next := leaf.next
+		if !t.allowDuplicates || next == nil {
+			break
+		}
+		next.latch.Lock()
+		if len(next.keys) > 0 && next.keys[0] > key {
+			next.latch.Unlock()
 			break
 		}
+		leaf.latch.Unlock()
+		leaf = next
 	}
+	leaf.latch.Unlock()
 
-	if keyIndex == -1 {
-		return false
+	return deleted
+}
+
+// DeleteValue removes a single key/val entry and reports whether it
+// was there. Without duplicates it only deletes key if it holds val.
+func (t *BPlusTree) DeleteValue(key, val string) bool {
+	leaf := t.lockLeaf(key, t.entryVal(val))
+	defer leaf.latch.Unlock()
+
+	for i, k := range leaf.keys {
+		if k == key && leaf.vals[i] == val {
+			leaf.keys = slices.Delete(leaf.keys, i, i+1)
+			leaf.vals = slices.Delete(leaf.vals, i, i+1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// entryVal is the value an entry is ordered by, which is its value
+// with duplicates and nothing otherwise
+func (t *BPlusTree) entryVal(val string) string {
+	if t.allowDuplicates {
+		return val
 	}
+	return ""
+}
 
-	leaf.keys = append(leaf.keys[:keyIndex], leaf.keys[keyIndex+1:]...)
-	leaf.vals = append(leaf.vals[:keyIndex], leaf.vals[keyIndex+1:]...)
+// separator returns the separator to promote for a node whose
+// smallest entry is key/val
+func (t *BPlusTree) separator(key, val string) (string, string) {
+	return key, t.entryVal(val)
+}
 
-	return true
+// writePath holds the write latches taken on the way down for a Put
+type writePath struct {
+	tree     *BPlusTree
+	rootHeld bool    // Whether rootLatch is still held
+	held     []*Node // Latched nodes, top down
+}
+
+// releaseAncestors unlatches everything above the last node in the path
+func (p *writePath) releaseAncestors() {
+	if p.rootHeld {
+		p.tree.rootLatch.Unlock()
+		p.rootHeld = false
+	}
+	for _, n := range p.held[:len(p.held)-1] {
+		n.latch.Unlock()
+	}
+	p.held = p.held[len(p.held)-1:]
+}
+
+// releaseAll unlatches the whole path
+func (p *writePath) releaseAll() {
+	if p.rootHeld {
+		p.tree.rootLatch.Unlock()
+		p.rootHeld = false
+	}
+	for _, n := range p.held {
+		n.latch.Unlock()
+	}
+	p.held = nil
 }
 
+// writeLeaf crabs down to the leaf for key/val with write latches.
+// A node with room for one more key can't split, so once such a
+// node is reached nothing above it can change and its ancestors
+// are released. Whatever is still held is returned in the path.
+func (t *BPlusTree) writeLeaf(key, val string) *writePath {
+	t.rootLatch.Lock()
+	n := t.root
+	n.latch.Lock()
+
+	path := &writePath{tree: t, rootHeld: true, held: []*Node{n}}
+	if t.isSafe(n) {
+		path.releaseAncestors()
+	}
+
+	for !n.isLeaf {
+		child := n.child(key, val)
+		child.latch.Lock()
+		path.held = append(path.held, child)
+		if t.isSafe(child) {
+			path.releaseAncestors()
+		}
+		n = child
+	}
+
+	return path
+}
+
+// Put stores val under key. Without duplicates it replaces the
+// current value, with duplicates it adds val to the key's values.
 func (t *BPlusTree) Put(key string, val string) {
+	if t.maxNodeBytes > 0 {
+		t.noteEntrySize(key, val)
+	}
+
 	// Find the correct leaf node and insert the key/val
-	leaf := t.root.findLeaf(key)
+	path := t.writeLeaf(key, t.entryVal(val))
+	defer path.releaseAll()
+	leaf := path.held[len(path.held)-1]
 
 	// Loop through existing keys to insert the keys
 	for i, k := range leaf.keys {
-		if k == key {
-			// Key already exists!
+		c := compareEntries(key, t.entryVal(val), k, t.entryVal(leaf.vals[i]))
+		if c == 0 {
+			// Key already exists! (with duplicates, so does the value)
 			leaf.vals[i] = val
+			if t.overflows(leaf) {
+				// A longer value can outgrow MaxNodeBytes
+				t.splitLeaf(leaf)
+			}
 			return
 		}
 
@@ -159,10 +501,10 @@ func (t *BPlusTree) Put(key string, val string) {
 		//  Keys     =  ["pA", "pB", "pD"]
 		//  newKey   =  pC (at index 2)
 		//  New Keys = ["pA", "pB", "pC", "pD"]
-		if key < k {
+		if c < 0 {
 			leaf.keys = slices.Insert(leaf.keys, i, key)
 			leaf.vals = slices.Insert(leaf.vals, i, val)
-			if len(leaf.keys) > t.degree {
+			if t.overflows(leaf) {
 				t.splitLeaf(leaf)
 			}
 			return
@@ -173,53 +515,60 @@ func (t *BPlusTree) Put(key string, val string) {
 	// so insert at the end
 	leaf.keys = append(leaf.keys, key)
 	leaf.vals = append(leaf.vals, val)
-	if len(leaf.keys) > t.degree {
+	if t.overflows(leaf) {
 		t.splitLeaf(leaf)
 	}
 	return
 }
 
 func (t *BPlusTree) splitLeaf(leaf *Node) {
-	midpoint := len(leaf.keys) / 2
+	midpoint := t.leafSplitPoint(leaf)
 
-	leftNode := &Node{
-		keys:   leaf.keys[:midpoint],
-		vals:   leaf.vals[:midpoint],
-		isLeaf: true,
-	}
+	// The leaf keeps the left half in place so the previous leaf's
+	// next pointer stays valid, only the right half is a new node.
+	// The right half is copied so that later inserts into the left
+	// half can't overwrite it through the shared backing array
 	rightNode := &Node{
-		keys:   leaf.keys[midpoint:],
-		vals:   leaf.vals[midpoint:],
+		keys:   slices.Clone(leaf.keys[midpoint:]),
+		vals:   slices.Clone(leaf.vals[midpoint:]),
 		isLeaf: true,
+		next:   leaf.next,
 	}
-	leftNode.next = rightNode
-	promoteKey := rightNode.keys[0]
+	leaf.keys = leaf.keys[:midpoint]
+	leaf.vals = leaf.vals[:midpoint]
+	leaf.next = rightNode
+	promoteKey, promoteVal := t.leafSeparator(leaf, rightNode)
 
 	if leaf.parent == nil {
 		// This is root node
-		newRoot := &Node{
-			keys:     []string{promoteKey},
-			children: []*Node{leftNode, rightNode},
-			isLeaf:   false, // It's an internal node now!
-		}
-		leftNode.parent = newRoot
-		rightNode.parent = newRoot
-		t.root = newRoot
+		t.newRoot(leaf, rightNode, promoteKey, promoteVal)
 	} else {
 		// Leaf has parent
-		parent := leaf.parent
-		leftNode.parent = parent
-		rightNode.parent = parent
-
-		t.insertIntoInternal(parent, promoteKey, leftNode, rightNode)
+		rightNode.parent = leaf.parent
+		t.insertIntoInternal(leaf.parent, promoteKey, promoteVal, rightNode)
 	}
 
 }
 
-func (t *BPlusTree) insertIntoInternal(parent *Node, key string, leftChild, rightChild *Node) {
+// newRoot grows the tree by one level after the root was split
+func (t *BPlusTree) newRoot(left, right *Node, key, val string) {
+	newRoot := &Node{
+		keys:     []string{key},
+		children: []*Node{left, right},
+		isLeaf:   false, // It's an internal node now!
+	}
+	if t.allowDuplicates {
+		newRoot.vals = []string{val}
+	}
+	left.parent = newRoot
+	right.parent = newRoot
+	t.root = newRoot
+}
+
+func (t *BPlusTree) insertIntoInternal(parent *Node, key, val string, rightChild *Node) {
 	// Got
 	// promote key -> "c"
-	// leftChild = (b) <= xxx < c
+	// leftChild = (b) <= xxx < c  (the node that was split, kept in place)
 	// rightChild = c <= xxx < (z)
 
 	// Before:
@@ -247,22 +596,21 @@ func (t *BPlusTree) insertIntoInternal(parent *Node, key string, leftChild, righ
 	// Find insertion position for the key
 	insertPos := 0
 	for i, k := range parent.keys {
-		if key < k {
+		if compareEntries(key, val, k, parent.separatorVal(i)) < 0 {
 			break
 		}
 		insertPos = i + 1
 	}
-	// Insert the key
+	// Insert the key, the left child already sits at insertPos
+	// so the right child goes right after it
 	parent.keys = slices.Insert(parent.keys, insertPos, key)
-
-	// Remove old child at insertPos
-	// Insert leftChild & rightChild
-	parent.children = slices.Delete(parent.children, insertPos, insertPos+1)
-	parent.children = slices.Insert(parent.children, insertPos, leftChild)
+	if t.allowDuplicates {
+		parent.vals = slices.Insert(parent.vals, insertPos, val)
+	}
 	parent.children = slices.Insert(parent.children, insertPos+1, rightChild)
 
 	// Check for overflow
-	if len(parent.keys) > t.degree {
+	if t.overflows(parent) {
 		t.splitInternal(parent)
 	}
 }
@@ -295,43 +643,43 @@ func (t *BPlusTree) splitInternal(internal *Node) {
 	//   c4 = h <= xxx < j
 	//   c5 = j <= xxx
 
-	midpoint := len(internal.keys) / 2
+	midpoint := t.internalSplitPoint(internal)
 
 	promoteKey := internal.keys[midpoint]
+	promoteVal := internal.separatorVal(midpoint)
 
-	leftNode := &Node{
-		keys:     internal.keys[:midpoint],
-		children: internal.children[:midpoint+1],
-		isLeaf:   false,
-	}
+	// Same as leaves, the internal node keeps the left half in place
 	rightNode := &Node{
-		keys:     internal.keys[midpoint+1:],
-		children: internal.children[midpoint+1:],
+		keys:     slices.Clone(internal.keys[midpoint+1:]),
+		children: slices.Clone(internal.children[midpoint+1:]),
 		isLeaf:   false,
 	}
-
-	for _, child := range leftNode.children {
-		child.parent = leftNode
+	internal.keys = internal.keys[:midpoint]
+	internal.children = internal.children[:midpoint+1]
+	if t.allowDuplicates {
+		rightNode.vals = slices.Clone(internal.vals[midpoint+1:])
+		internal.vals = internal.vals[:midpoint]
 	}
+
 	for _, child := range rightNode.children {
 		child.parent = rightNode
 	}
 
 	if internal.parent == nil {
 		// Create new root
-		newRoot := &Node{
-			keys:     []string{promoteKey},
-			children: []*Node{leftNode, rightNode},
-			isLeaf:   false,
-		}
-		leftNode.parent = newRoot
-		rightNode.parent = newRoot
-		t.root = newRoot
+		t.newRoot(internal, rightNode, promoteKey, promoteVal)
 	} else {
 		// Insert into parent
-		parent := internal.parent
-		leftNode.parent = parent
-		rightNode.parent = parent
-		t.insertIntoInternal(parent, promoteKey, leftNode, rightNode)
+		rightNode.parent = internal.parent
+		t.insertIntoInternal(internal.parent, promoteKey, promoteVal, rightNode)
+	}
+}
+
+// firstLeaf returns the leftmost leaf, the start of the leaf chain
+func (t *BPlusTree) firstLeaf() *Node {
+	n := t.root
+	for !n.isLeaf {
+		n = n.children[0]
 	}
+	return n
 }
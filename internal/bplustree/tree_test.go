@@ -2,6 +2,9 @@ package bplustree
 
 import (
 	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/alecthomas/assert"
@@ -76,3 +79,132 @@ func TestSplitting(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("v%d", i+1), val)
 	}
 }
+
+func TestScan(t *testing.T) {
+	tree := NewBPlusTree(3)
+	for i := 0; i < 100; i++ {
+		tree.Put(fmt.Sprintf("k%03d", i), fmt.Sprintf("v%d", i))
+	}
+	// Empty out a few leaves in the middle
+	for i := 40; i < 60; i++ {
+		tree.Delete(fmt.Sprintf("k%03d", i))
+	}
+
+	var keys []string
+	for k, v := range tree.Scan("k035", "k065") {
+		assert.Equal(t, "v"+strings.TrimLeft(k[1:], "0"), v)
+		keys = append(keys, k)
+	}
+	assert.Equal(t, 10, len(keys))
+	assert.Equal(t, "k035", keys[0])
+	assert.Equal(t, "k064", keys[9])
+
+	// Writing from inside the loop doesn't deadlock
+	count := 0
+	for k := range tree.Scan("", "") {
+		tree.Put(k, "changed")
+		count++
+	}
+	assert.Equal(t, 80, count)
+	v, _ := tree.Get("k099")
+	assert.Equal(t, "changed", v)
+
+	// Duplicates come back in (key, value) order
+	dups := newDuplicatesTree(3)
+	for i := 0; i < 20; i++ {
+		dups.Put("a", fmt.Sprintf("v%02d", i))
+		dups.Put("b", fmt.Sprintf("v%02d", i))
+	}
+	count = 0
+	for k, v := range dups.Scan("a", "b") {
+		assert.Equal(t, "a", k)
+		assert.Equal(t, fmt.Sprintf("v%02d", count), v)
+		count++
+	}
+	assert.Equal(t, 20, count)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	tree := NewBPlusTree(4)
+
+	// Keys readers check while everything else is going on
+	const stable = 500
+	for i := 0; i < stable; i++ {
+		tree.Put(fmt.Sprintf("stable_%04d", i), fmt.Sprintf("v%d", i))
+	}
+
+	// Keys the deleters remove
+	const doomed = 500
+	for i := 0; i < doomed; i++ {
+		tree.Put(fmt.Sprintf("doomed_%04d", i), "x")
+	}
+
+	const writers, deleters, readers = 8, 4, 8
+	const perWriter = 1000
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for _, i := range rng.Perm(perWriter) {
+				tree.Put(fmt.Sprintf("w%d_%04d", w, i), fmt.Sprintf("v%d", i))
+			}
+		}(w)
+	}
+
+	for d := 0; d < deleters; d++ {
+		wg.Add(1)
+		go func(d int) {
+			defer wg.Done()
+			for i := d; i < doomed; i += deleters {
+				assert.True(t, tree.Delete(fmt.Sprintf("doomed_%04d", i)))
+			}
+		}(d)
+	}
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(100 + r)))
+			for n := 0; n < 5000; n++ {
+				i := rng.Intn(stable)
+				v, ok := tree.Get(fmt.Sprintf("stable_%04d", i))
+				assert.True(t, ok)
+				assert.Equal(t, fmt.Sprintf("v%d", i), v)
+
+				// Keys being written right now are either there or not
+				tree.Get(fmt.Sprintf("w%d_%04d", rng.Intn(writers), rng.Intn(perWriter)))
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	assert.NoError(t, tree.Validate())
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < perWriter; i++ {
+			v, ok := tree.Get(fmt.Sprintf("w%d_%04d", w, i))
+			assert.True(t, ok)
+			assert.Equal(t, fmt.Sprintf("v%d", i), v)
+		}
+	}
+	for i := 0; i < doomed; i++ {
+		_, ok := tree.Get(fmt.Sprintf("doomed_%04d", i))
+		assert.False(t, ok)
+	}
+
+	// The leaf chain still links every key in order
+	var prev string
+	count := 0
+	for leaf := tree.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, k := range leaf.keys {
+			assert.True(t, prev < k, "%q then %q", prev, k)
+			prev = k
+			count++
+		}
+	}
+	assert.Equal(t, stable+writers*perWriter, count)
+}
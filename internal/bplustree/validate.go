@@ -0,0 +1,208 @@
+package bplustree
+
+import "fmt"
+
+// Stats describes the shape of a tree
+type Stats struct {
+	Height        int     // Number of levels, 1 for a lone leaf
+	Nodes         int     // Internal nodes plus leaves
+	InternalNodes int     // Nodes with children
+	Leaves        int     // Nodes holding entries
+	Entries       int     // Key/value pairs in the leaves
+	FillFactor    float64 // Entries / (Leaves * degree)
+}
+
+// readLockAll read latches every node top down, giving a view of
+// the whole tree that no writer can change. Writers only ever wait
+// on nodes below the ones they hold, and this takes latches in the
+// same top-down, left-to-right order, so it can't deadlock with them.
+func (t *BPlusTree) readLockAll() (root *Node, unlock func()) {
+	var held []*Node
+
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		n.latch.RLock()
+		held = append(held, n)
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+
+	t.rootLatch.RLock()
+	root = t.root
+	walk(root)
+
+	return root, func() {
+		for _, n := range held {
+			n.latch.RUnlock()
+		}
+		t.rootLatch.RUnlock()
+	}
+}
+
+// Validate checks the structural invariants of the tree and returns
+// an error describing the first violation it finds:
+//   - entries in every node are strictly ascending
+//   - every entry falls within the bounds set by the separators above it
+//   - every child's parent pointer points back at its parent
+//   - every leaf is at the same depth
+//   - the leaf chain visits every leaf in order and nothing else
+//   - no node holds more than degree keys (or MaxNodeBytes bytes), and
+//     internal nodes other than the root hold at least degree/2 when
+//     splitting by count (leaves may hold fewer, since Delete doesn't
+//     rebalance)
+func (t *BPlusTree) Validate() error {
+	root, unlock := t.readLockAll()
+	defer unlock()
+
+	if root.parent != nil {
+		return fmt.Errorf("root has a parent")
+	}
+
+	v := &validator{tree: t, leafDepth: -1}
+	if err := v.check(root, 0, nil, nil); err != nil {
+		return err
+	}
+
+	// The leaf chain has to match the leaves in tree order
+	leaf := v.leaves[0]
+	for i, want := range v.leaves {
+		if leaf != want {
+			return fmt.Errorf("leaf chain: leaf %d is not linked in order", i)
+		}
+		leaf = leaf.next
+	}
+	if leaf != nil {
+		return fmt.Errorf("leaf chain: last leaf links to another node")
+	}
+
+	return nil
+}
+
+// bound is an inclusive lower or exclusive upper limit on entries
+type bound struct {
+	key, val string
+}
+
+type validator struct {
+	tree      *BPlusTree
+	leafDepth int
+	leaves    []*Node
+}
+
+func (v *validator) check(n *Node, depth int, lo, hi *bound) error {
+	t := v.tree
+	where := fmt.Sprintf("node %v at depth %d", n.keys, depth)
+
+	if len(n.keys) > t.degree {
+		return fmt.Errorf("%s: %d keys overflows degree %d", where, len(n.keys), t.degree)
+	}
+	if t.overflows(n) {
+		return fmt.Errorf("%s: %d bytes overflows MaxNodeBytes %d", where, nodeBytes(n), t.maxNodeBytes)
+	}
+
+	// Entry i as it's ordered
+	entry := func(i int) bound {
+		if n.isLeaf {
+			return bound{n.keys[i], t.entryVal(n.vals[i])}
+		}
+		return bound{n.keys[i], n.separatorVal(i)}
+	}
+
+	for i := range n.keys {
+		e := entry(i)
+		if i > 0 {
+			prev := entry(i - 1)
+			if compareEntries(prev.key, prev.val, e.key, e.val) >= 0 {
+				return fmt.Errorf("%s: key %q is not after %q", where, e.key, prev.key)
+			}
+		}
+		if lo != nil && compareEntries(e.key, e.val, lo.key, lo.val) < 0 {
+			return fmt.Errorf("%s: key %q is below separator %q", where, e.key, lo.key)
+		}
+		if hi != nil && compareEntries(e.key, e.val, hi.key, hi.val) >= 0 {
+			return fmt.Errorf("%s: key %q is not below separator %q", where, e.key, hi.key)
+		}
+	}
+
+	if n.isLeaf {
+		if len(n.vals) != len(n.keys) {
+			return fmt.Errorf("%s: %d keys but %d values", where, len(n.keys), len(n.vals))
+		}
+		if v.leafDepth == -1 {
+			v.leafDepth = depth
+		}
+		if depth != v.leafDepth {
+			return fmt.Errorf("%s: leaf at depth %d, others at %d", where, depth, v.leafDepth)
+		}
+		v.leaves = append(v.leaves, n)
+		return nil
+	}
+
+	if len(n.children) != len(n.keys)+1 {
+		return fmt.Errorf("%s: %d keys but %d children", where, len(n.keys), len(n.children))
+	}
+	if t.allowDuplicates && len(n.vals) != len(n.keys) {
+		return fmt.Errorf("%s: %d keys but %d separator values", where, len(n.keys), len(n.vals))
+	}
+	if !t.allowDuplicates && n.vals != nil {
+		return fmt.Errorf("%s: separator values in a tree without duplicates", where)
+	}
+	if n.parent != nil && t.maxNodeBytes == 0 && len(n.keys) < t.degree/2 {
+		return fmt.Errorf("%s: %d keys underflows minimum %d", where, len(n.keys), t.degree/2)
+	}
+
+	for i, child := range n.children {
+		if child.parent != n {
+			return fmt.Errorf("%s: child %d has the wrong parent pointer", where, i)
+		}
+
+		childLo, childHi := lo, hi
+		if i > 0 {
+			b := entry(i - 1)
+			childLo = &b
+		}
+		if i < len(n.keys) {
+			b := entry(i)
+			childHi = &b
+		}
+		if err := v.check(child, depth+1, childLo, childHi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the height, node counts and fill factor of the tree
+func (t *BPlusTree) Stats() Stats {
+	root, unlock := t.readLockAll()
+	defer unlock()
+
+	var stats Stats
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		stats.Nodes++
+		stats.Height = max(stats.Height, depth+1)
+		if n.isLeaf {
+			stats.Leaves++
+			stats.Entries += len(n.keys)
+			return
+		}
+
+		stats.InternalNodes++
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(root, 0)
+
+	stats.FillFactor = float64(stats.Entries) / float64(stats.Leaves*t.degree)
+	return stats
+}
+
+// String formats stats on one line
+func (s Stats) String() string {
+	return fmt.Sprintf("height=%d nodes=%d (internal=%d leaves=%d) entries=%d fill=%.2f",
+		s.Height, s.Nodes, s.InternalNodes, s.Leaves, s.Entries, s.FillFactor)
+}
@@ -0,0 +1,161 @@
+package bplustree
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// TestRandomOps runs random puts and deletes against a map and checks
+// the tree agrees with it and stays valid the whole way through
+func TestRandomOps(t *testing.T) {
+	for _, degree := range []int{3, 4, 5, 8, 32} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(degree)))
+			tree := NewBPlusTree(degree)
+			oracle := make(map[string]string)
+
+			for i := 0; i < 5000; i++ {
+				key := fmt.Sprintf("k%d", rng.Intn(1000))
+				switch rng.Intn(10) {
+				case 0, 1:
+					_, exists := oracle[key]
+					assert.Equal(t, exists, tree.Delete(key))
+					delete(oracle, key)
+				case 2:
+					v, ok := tree.Get(key)
+					want, exists := oracle[key]
+					assert.Equal(t, exists, ok)
+					assert.Equal(t, want, v)
+				default:
+					val := fmt.Sprintf("v%d", i)
+					tree.Put(key, val)
+					oracle[key] = val
+				}
+
+				if i%250 == 0 {
+					assert.NoError(t, tree.Validate(), "after %d ops", i)
+				}
+			}
+
+			assertContents(t, tree, oracle)
+			assert.Equal(t, len(oracle), tree.Stats().Entries)
+		})
+	}
+}
+
+// TestRandomOpsDuplicates does the same with a key mapping to a set of values
+func TestRandomOpsDuplicates(t *testing.T) {
+	for _, degree := range []int{3, 4, 7} {
+		t.Run(fmt.Sprintf("degree=%d", degree), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(int64(degree)))
+			tree := newDuplicatesTree(degree)
+			oracle := make(map[string][]string)
+
+			for i := 0; i < 5000; i++ {
+				// Few keys and many values, so runs span leaves
+				key := fmt.Sprintf("k%d", rng.Intn(20))
+				val := fmt.Sprintf("v%d", rng.Intn(100))
+				switch rng.Intn(10) {
+				case 0:
+					assert.Equal(t, len(oracle[key]) > 0, tree.Delete(key))
+					delete(oracle, key)
+				case 1, 2:
+					idx := slices.Index(oracle[key], val)
+					assert.Equal(t, idx >= 0, tree.DeleteValue(key, val))
+					if idx >= 0 {
+						oracle[key] = slices.Delete(oracle[key], idx, idx+1)
+					}
+				default:
+					tree.Put(key, val)
+					if !slices.Contains(oracle[key], val) {
+						oracle[key] = append(oracle[key], val)
+						slices.Sort(oracle[key])
+					}
+				}
+
+				if i%250 == 0 {
+					assert.NoError(t, tree.Validate(), "after %d ops", i)
+				}
+			}
+
+			assert.NoError(t, tree.Validate())
+			for k := 0; k < 20; k++ {
+				key := fmt.Sprintf("k%d", k)
+				assert.Equal(t, len(oracle[key]), len(tree.GetAll(key)))
+				if len(oracle[key]) > 0 {
+					assert.Equal(t, oracle[key], tree.GetAll(key))
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCatchesCorruption(t *testing.T) {
+	build := func() *BPlusTree {
+		tree := NewBPlusTree(3)
+		for i := 0; i < 30; i++ {
+			tree.Put(fmt.Sprintf("k%02d", i), "v")
+		}
+		assert.NoError(t, tree.Validate())
+		return tree
+	}
+
+	// Out of order keys in a leaf
+	tree := build()
+	leaf := tree.firstLeaf()
+	leaf.keys[0], leaf.keys[1] = leaf.keys[1], leaf.keys[0]
+	assert.Error(t, tree.Validate())
+
+	// A key outside the separator bounds
+	tree = build()
+	tree.firstLeaf().next.keys[0] = "k00"
+	assert.Error(t, tree.Validate())
+
+	// A broken parent pointer
+	tree = build()
+	tree.root.children[0].parent = nil
+	assert.Error(t, tree.Validate())
+
+	// A broken leaf chain
+	tree = build()
+	tree.firstLeaf().next = tree.firstLeaf().next.next
+	assert.Error(t, tree.Validate())
+
+	// An overflowing node
+	tree = build()
+	leaf = tree.firstLeaf()
+	leaf.keys = append(leaf.keys, "k01a", "k01b")
+	leaf.vals = append(leaf.vals, "v", "v")
+	assert.Error(t, tree.Validate())
+}
+
+func TestStatsAndDump(t *testing.T) {
+	tree := NewBPlusTree(4)
+	assert.NoError(t, tree.BulkLoad(sortedPairs(100), 1))
+
+	stats := tree.Stats()
+	assert.Equal(t, 100, stats.Entries)
+	assert.Equal(t, 25, stats.Leaves)
+	assert.Equal(t, 3, stats.Height)
+	assert.Equal(t, stats.Leaves+stats.InternalNodes, stats.Nodes)
+	assert.Equal(t, 1.0, stats.FillFactor)
+
+	var dump strings.Builder
+	assert.NoError(t, tree.Dump(&dump))
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	assert.Equal(t, stats.Nodes, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "internal ["))
+	assert.Equal(t, "    leaf [key_00000=v0 key_00001=v1 key_00002=v2 key_00003=v3]", lines[2])
+
+	var dot strings.Builder
+	assert.NoError(t, tree.WriteDOT(&dot))
+	assert.True(t, strings.HasPrefix(dot.String(), "digraph bplustree {"))
+	// One edge per child plus the leaf chain
+	assert.Equal(t, stats.Nodes-1+stats.Leaves-1, strings.Count(dot.String(), " -> "))
+	assert.Equal(t, stats.Leaves-1, strings.Count(dot.String(), "dashed"))
+}
@@ -0,0 +1,228 @@
+package bplustree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Record types written to the WAL
+const (
+	WALPut    byte = 1
+	WALDelete byte = 2
+)
+
+// walHeaderSize is crc + lsn + type + keysize + valsize
+const walHeaderSize = 4 + 8 + 1 + 4 + 4
+
+// errCorruptRecord is returned when a WAL record fails its checksum
+// or is cut short, which is what a torn write at a crash looks like
+var errCorruptRecord = errors.New("corrupt wal record")
+
+// WALRecord is a single logical change to the tree, a Put or Delete
+// to run again on recovery rather than a page image. The tree only
+// lives in memory between checkpoints, so there are no pages on disk
+// for a record to repair.
+type WALRecord struct {
+	LSN  uint64 // Log sequence number, strictly increasing
+	Type byte   // WALPut or WALDelete
+	Key  string
+	Val  string // Empty for deletes
+}
+
+// encodeWALRecord encodes a record as
+// [crc:4][lsn:8][type:1][key_size:4][val_size:4][key][val]
+// where crc covers everything after itself
+func encodeWALRecord(rec *WALRecord) []byte {
+	buf := make([]byte, walHeaderSize+len(rec.Key)+len(rec.Val))
+
+	binary.LittleEndian.PutUint64(buf[4:12], rec.LSN)
+	buf[12] = rec.Type
+	binary.LittleEndian.PutUint32(buf[13:17], uint32(len(rec.Key)))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(len(rec.Val)))
+	copy(buf[walHeaderSize:], rec.Key)
+	copy(buf[walHeaderSize+len(rec.Key):], rec.Val)
+
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// readWALRecord reads the next record from r, which has left bytes
+// before the end of the log.
+// Returns io.EOF at a clean end of the log and errCorruptRecord
+// for a partial or damaged record.
+func readWALRecord(r io.Reader, left int64) (*WALRecord, int64, error) {
+	header := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF && n > 0 {
+			return nil, 0, errCorruptRecord
+		}
+		return nil, 0, err
+	}
+
+	keySize := binary.LittleEndian.Uint32(header[13:17])
+	valSize := binary.LittleEndian.Uint32(header[17:21])
+
+	// The sizes aren't checked by the crc yet, so a garbage tail
+	// mustn't get to allocate more than the log holds
+	bodySize := int64(keySize) + int64(valSize)
+	if bodySize > left-walHeaderSize {
+		return nil, 0, errCorruptRecord
+	}
+
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, errCorruptRecord
+		}
+		return nil, 0, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[0:4]) {
+		return nil, 0, errCorruptRecord
+	}
+
+	rec := &WALRecord{
+		LSN:  binary.LittleEndian.Uint64(header[4:12]),
+		Type: header[12],
+		Key:  string(body[:keySize]),
+		Val:  string(body[keySize:]),
+	}
+	if rec.Type != WALPut && rec.Type != WALDelete {
+		return nil, 0, errCorruptRecord
+	}
+
+	return rec, int64(walHeaderSize) + int64(len(body)), nil
+}
+
+// WAL is an append-only write-ahead log of key/value changes, used
+// by the durable tree and by the LSM memtable
+type WAL struct {
+	file *os.File
+	// w is where records are written, normally file itself.
+	// Tests swap it for a writer that fails part way through.
+	w    io.Writer
+	size int64 // Offset of the end of the last complete record
+	err  error // Sticky error from a failed append
+}
+
+// OpenWAL opens the log at path, creating it if needed
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal %s: %w", path, err)
+	}
+
+	return &WAL{file: file, w: file}, nil
+}
+
+// Replay calls fn for every complete record in the log in order.
+// A torn or corrupt tail is cut off so that new records are
+// appended right after the last good one.
+func (w *WAL) Replay(fn func(rec *WALRecord) error) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var pos int64 = 0
+	reader := bufio.NewReader(w.file)
+	for {
+		rec, n, err := readWALRecord(reader, info.Size()-pos)
+		if err != nil {
+			if err == io.EOF || err == errCorruptRecord {
+				break
+			}
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+		pos += n
+	}
+
+	// Drop anything after the last complete record
+	if err := w.file.Truncate(pos); err != nil {
+		return fmt.Errorf("failed to truncate wal: %w", err)
+	}
+	if _, err := w.file.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = pos
+
+	return nil
+}
+
+// Append writes a record to the end of the log.
+// The record is not durable until Sync is called.
+func (w *WAL) Append(rec *WALRecord) error {
+	// Once an append has failed the tail of the log may hold part
+	// of a record, so refuse further writes until recovery has run
+	if w.err != nil {
+		return w.err
+	}
+
+	buf := encodeWALRecord(rec)
+
+	n, err := w.w.Write(buf)
+	if err != nil {
+		w.err = fmt.Errorf("failed to append wal record: %w", err)
+		return w.err
+	}
+
+	w.size += int64(n)
+	return nil
+}
+
+// Sync flushes the log to disk
+func (w *WAL) Sync() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.file.Sync(); err != nil {
+		w.err = fmt.Errorf("failed to sync wal: %w", err)
+		return w.err
+	}
+
+	return nil
+}
+
+// Reset empties the log, used once a checkpoint covers every record in it
+func (w *WAL) Reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = 0
+
+	return w.file.Sync()
+}
+
+// Size returns the size of the log in bytes
+func (w *WAL) Size() int64 {
+	return w.size
+}
+
+// Close closes the log file
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
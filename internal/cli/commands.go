@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *Shell) cmdGet(args []string) error {
+	value, err := s.db.Get(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, s.format.Encode(value))
+	return nil
+}
+
+func (s *Shell) cmdPut(args []string) error {
+	value, err := s.format.Decode(args[1])
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if len(args) == 3 {
+		ttl, err = time.ParseDuration(args[2])
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("bad ttl %q, want a duration such as 30s", args[2])
+		}
+	}
+
+	if err := s.db.PutWithTTL(args[0], value, ttl); err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+func (s *Shell) cmdDel(args []string) error {
+	if err := s.db.Delete(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, "OK")
+	return nil
+}
+
+func (s *Shell) cmdKeys(args []string) error {
+	var prefix string
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	keys := s.db.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			fmt.Fprintln(s.out, displayKey(key))
+		}
+	}
+	return nil
+}
+
+func (s *Shell) cmdScan(args []string) error {
+	var start, end string
+	if len(args) > 0 {
+		start = args[0]
+	}
+	if len(args) > 1 {
+		end = args[1]
+	}
+
+	return s.db.Scan(start, end, func(key string, value []byte) bool {
+		fmt.Fprintf(s.out, "%s = %s\n", displayKey(key), s.format.Encode(value))
+		return true
+	})
+}
+
+func (s *Shell) cmdStats(args []string) error {
+	stats := s.db.Stats()
+	fmt.Fprintf(s.out, "directory    %s\n", s.dir)
+	fmt.Fprintf(s.out, "keys         %d\n", stats.Keys)
+	fmt.Fprintf(s.out, "data files   %d (%s)\n", stats.DataFiles, formatBytes(stats.DataSize))
+	fmt.Fprintf(s.out, "blob files   %d (%s)\n", stats.BlobFiles, formatBytes(stats.BlobSize))
+	fmt.Fprintf(s.out, "active file  %d\n", stats.ActiveFileID)
+	return nil
+}
+
+func (s *Shell) cmdMerge(args []string) error {
+	before := s.db.Stats()
+	if err := s.db.Merge(); err != nil {
+		return err
+	}
+	after := s.db.Stats()
+	fmt.Fprintf(s.out, "OK, %s -> %s\n",
+		formatBytes(before.DataSize+before.BlobSize), formatBytes(after.DataSize+after.BlobSize))
+	return nil
+}
+
+func (s *Shell) cmdBackup(args []string) error {
+	if err := s.db.Backup(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "OK, backed up to %s\n", args[0])
+	return nil
+}
+
+// formatBytes returns n bytes in the largest unit that keeps it above 1
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/glob"
+)
+
+// timeLayout is how dump shows times, fixed width so columns line up
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Dump prints every record of the data file named in args:
+//
+//	kvdb dump [-values none|hex|utf8|json] [-key pattern] [-since t] [-until t] [-jsonl] <file>
+func Dump(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	fs.SetOutput(out)
+	values := fs.String("values", "none", "show values as `none`, hex, utf8 or json (indented, utf8 if it isn't JSON)")
+	pattern := fs.String("key", "", "only records whose key matches this glob `pattern`, such as user:*")
+	since := fs.String("since", "", "only records written at or after this `time`, RFC 3339 or a date")
+	until := fs.String("until", "", "only records written before this `time`")
+	jsonl := fs.Bool("jsonl", false, "print a JSON object per line")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: kvdb dump [-values none|hex|utf8|json] [-key pattern] [-since t] [-until t] [-jsonl] <file>")
+	}
+
+	switch *values {
+	case "none", "hex", "utf8", "json":
+	default:
+		return fmt.Errorf("unknown value format %q, want none, hex, utf8 or json", *values)
+	}
+	var from, to time.Time
+	if *since != "" {
+		if from, err = parseTime(*since); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if to, err = parseTime(*until); err != nil {
+			return err
+		}
+	}
+
+	lf, err := bitcask.OpenFile(args[0], nil)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	d := &dumper{out: out, values: *values, jsonl: *jsonl}
+	if !d.jsonl {
+		fmt.Fprintf(out, "%-10s %-30s %-5s %10s  %s\n", "OFFSET", "TIMESTAMP", "FLAGS", "SIZE", "KEY")
+	}
+
+	for pos := lf.DataStart(); ; {
+		entry, next, err := lf.ReadEntry(pos)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read record at offset %d: %w", pos, err)
+		}
+
+		ts := time.Unix(0, entry.Timestamp)
+		if (*pattern == "" || glob.Match(*pattern, string(entry.Key))) &&
+			(from.IsZero() || !ts.Before(from)) && (to.IsZero() || ts.Before(to)) {
+			if err := d.record(pos, entry); err != nil {
+				return err
+			}
+		}
+		pos = next
+	}
+}
+
+// parseTime reads a time given as RFC 3339 or a date in UTC
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want RFC 3339 such as 2006-01-02T15:04:05Z or a date", s)
+}
+
+// dumper prints records
+type dumper struct {
+	out    io.Writer
+	values string // none, hex, utf8 or json
+	jsonl  bool
+}
+
+// dumpRecord is a record as a line of JSON
+type dumpRecord struct {
+	Offset      int64           `json:"offset"`
+	Timestamp   string          `json:"timestamp"`
+	Key         *string         `json:"key,omitempty"`
+	KeyBase64   string          `json:"key_base64,omitempty"`
+	ValueSize   uint64          `json:"value_size"`
+	Tombstone   bool            `json:"tombstone"`
+	Expires     string          `json:"expires,omitempty"`
+	Codec       string          `json:"codec,omitempty"`
+	Blob        *dumpBlob       `json:"blob,omitempty"`
+	Batch       bool            `json:"batch,omitempty"`
+	BatchEnd    bool            `json:"batch_end,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	ValueBase64 string          `json:"value_base64,omitempty"`
+	ValueError  string          `json:"value_error,omitempty"`
+}
+
+// dumpBlob is where a blob record's value is
+type dumpBlob struct {
+	File   uint32 `json:"file"`
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+}
+
+// record prints the record at pos
+func (d *dumper) record(pos int64, entry *bitcask.LogEntry) error {
+	if d.jsonl {
+		return d.jsonRecord(pos, entry)
+	}
+
+	var flags string
+	for _, f := range []struct {
+		set  bool
+		flag byte
+	}{
+		{entry.Tombstone(), 'D'},
+		{entry.Expires != 0, 'X'},
+		{entry.Blob, 'B'},
+		{entry.Codec != bitcask.CodecNone, 'C'},
+		{entry.Batch, 'b'},
+		{entry.BatchEnd, 'e'},
+	} {
+		if f.set {
+			flags += string(f.flag)
+		}
+	}
+	if flags == "" {
+		flags = "-"
+	}
+
+	line := fmt.Sprintf("%-10d %-30s %-5s %10d  %s", pos, time.Unix(0, entry.Timestamp).UTC().Format(timeLayout),
+		flags, entry.ValueSize, displayKey(string(entry.Key)))
+	if entry.Expires != 0 {
+		line += "  expires " + time.Unix(0, entry.Expires).UTC().Format(timeLayout)
+	}
+	if entry.Blob {
+		if file, offset, size, err := entry.BlobLocation(); err == nil {
+			line += fmt.Sprintf("  blob %d at %d, %d bytes", file, offset, size)
+		}
+	}
+	fmt.Fprintln(d.out, line)
+
+	if d.values == "none" || entry.Tombstone() || entry.Blob {
+		return nil
+	}
+	value, err := entry.DecodedValue()
+	if err != nil {
+		fmt.Fprintf(d.out, "    (%v)\n", err)
+		return nil
+	}
+	text := d.textValue(value)
+	fmt.Fprintln(d.out, "    "+strings.ReplaceAll(text, "\n", "\n    "))
+	return nil
+}
+
+// textValue returns value as text in the chosen format
+func (d *dumper) textValue(value []byte) string {
+	switch d.values {
+	case "hex":
+		return hex.EncodeToString(value)
+	case "json":
+		var buf bytes.Buffer
+		if json.Indent(&buf, value, "", "  ") == nil {
+			return buf.String()
+		}
+	}
+	if utf8.Valid(value) {
+		return string(value)
+	}
+	return strconv.Quote(string(value))
+}
+
+// jsonRecord prints the record at pos as a line of JSON. A key or value
+// that isn't UTF-8 is given in base64 instead.
+func (d *dumper) jsonRecord(pos int64, entry *bitcask.LogEntry) error {
+	r := dumpRecord{
+		Offset:    pos,
+		Timestamp: time.Unix(0, entry.Timestamp).UTC().Format(time.RFC3339Nano),
+		ValueSize: entry.ValueSize,
+		Tombstone: entry.Tombstone(),
+		Batch:     entry.Batch,
+		BatchEnd:  entry.BatchEnd,
+	}
+	if utf8.Valid(entry.Key) {
+		key := string(entry.Key)
+		r.Key = &key
+	} else {
+		r.KeyBase64 = base64.StdEncoding.EncodeToString(entry.Key)
+	}
+	if entry.Expires != 0 {
+		r.Expires = time.Unix(0, entry.Expires).UTC().Format(time.RFC3339Nano)
+	}
+	if entry.Codec != bitcask.CodecNone {
+		r.Codec = entry.Codec.String()
+	}
+	if entry.Blob {
+		file, offset, size, err := entry.BlobLocation()
+		if err != nil {
+			r.ValueError = err.Error()
+		} else {
+			r.Blob = &dumpBlob{File: file, Offset: offset, Size: size}
+		}
+	}
+
+	if d.values != "none" && !entry.Tombstone() && !entry.Blob {
+		value, err := entry.DecodedValue()
+		switch {
+		case err != nil:
+			r.ValueError = err.Error()
+		case d.values == "hex":
+			r.Value, _ = json.Marshal(hex.EncodeToString(value))
+		case d.values == "json" && json.Valid(value):
+			r.Value = value
+		case utf8.Valid(value):
+			r.Value, _ = json.Marshal(string(value))
+		default:
+			r.ValueBase64 = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(d.out, "%s\n", line)
+	return err
+}
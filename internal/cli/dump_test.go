@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// dumpFile writes a few records of every kind and returns the data
+// file they're in
+func dumpFile(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := bitcask.Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("user:1", []byte(`{"name":"Alice"}`)))
+	assert.NoError(t, db.PutWithTTL("session", []byte("s3cr3t"), time.Hour))
+	assert.NoError(t, db.Put("bin", []byte{0xff, 0x00}))
+	assert.NoError(t, db.Delete("session"))
+	var b bitcask.Batch
+	b.Put("user:2", []byte("Bob"))
+	b.Put("user:3", []byte("Carol"))
+	_, err = db.Apply(&b)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+	return filepath.Join(dir, "0000000001.bitcask")
+}
+
+func TestDump(t *testing.T) {
+	file := dumpFile(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Dump(&out, []string{file}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 7, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "OFFSET "))
+	assert.True(t, strings.HasPrefix(lines[1], "28 "), "got %q", lines[1])
+	assert.True(t, strings.HasSuffix(lines[1], " user:1"), "got %q", lines[1])
+	assert.Contains(t, lines[2], " X ")
+	assert.Contains(t, lines[2], "session  expires ")
+	assert.Contains(t, lines[4], " D ")
+	assert.Contains(t, lines[5], " b ")
+	assert.Contains(t, lines[6], " e ")
+
+	out.Reset()
+	assert.NoError(t, Dump(&out, []string{"-key", "user:*", "-values", "json", file}))
+	assert.Contains(t, out.String(), "user:1\n    {\n      \"name\": \"Alice\"\n    }\n")
+	assert.Contains(t, out.String(), "user:2\n    Bob\n")
+	assert.False(t, strings.Contains(out.String(), "session"))
+
+	out.Reset()
+	assert.NoError(t, Dump(&out, []string{"-key", "bin", "-values", "hex", file}))
+	assert.Contains(t, out.String(), "bin\n    ff00\n")
+
+	// Nothing was written in the future
+	out.Reset()
+	assert.NoError(t, Dump(&out, []string{"-since", time.Now().Add(time.Hour).Format(time.RFC3339), file}))
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"))
+
+	assert.Error(t, Dump(&out, []string{"-values", "xml", file}))
+	assert.Error(t, Dump(&out, []string{"-since", "yesterday", file}))
+}
+
+func TestDumpJSONL(t *testing.T) {
+	file := dumpFile(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Dump(&out, []string{file, "-jsonl", "-values", "json", "-until", time.Now().Add(time.Minute).Format(time.RFC3339Nano)}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 6, len(lines))
+
+	var records []map[string]any
+	for _, line := range lines {
+		var r map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+		records = append(records, r)
+	}
+
+	assert.Equal(t, float64(28), records[0]["offset"])
+	assert.Equal(t, "user:1", records[0]["key"])
+	assert.Equal(t, map[string]any{"name": "Alice"}, records[0]["value"])
+	assert.Equal(t, false, records[0]["tombstone"])
+	assert.NotZero(t, records[1]["expires"])
+	assert.Equal(t, "s3cr3t", records[1]["value"])
+	assert.Equal(t, "/wA=", records[2]["value_base64"])
+	assert.Equal(t, true, records[3]["tombstone"])
+	assert.Equal(t, nil, records[3]["value"])
+	assert.Equal(t, true, records[4]["batch"])
+	assert.Equal(t, true, records[5]["batch_end"])
+	_, err := time.Parse(time.RFC3339Nano, records[0]["timestamp"].(string))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// formatFor returns the format named by flag, or else the one the
+// extension of path suggests
+func formatFor(flag, path string) string {
+	if flag != "" {
+		return flag
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "jsonl"
+}
+
+// Export writes every key of the database named in args with its
+// value, timestamp and expiry to a file, or to out:
+//
+//	kvdb export [-format jsonl|csv] [-prefix p] [-o file] <dir>
+func Export(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	format := fs.String("format", "", "jsonl or csv, by default csv if the output ends in .csv and jsonl otherwise")
+	prefix := fs.String("prefix", "", "only export keys starting with this `prefix`")
+	output := fs.String("o", "", "write to this `file` instead of stdout")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: kvdb export [-format jsonl|csv] [-prefix p] [-o file] <dir>")
+	}
+	if _, err := os.Stat(args[0]); err != nil {
+		return err
+	}
+
+	dst := out
+	var f *os.File
+	if *output != "" {
+		if f, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer f.Close()
+		dst = f
+	}
+	w, err := newRecordWriter(formatFor(*format, *output), dst)
+	if err != nil {
+		return err
+	}
+
+	db, err := bitcask.Open(args[0], nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys := db.Keys()
+	sort.Strings(keys)
+	now := time.Now()
+	n := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, *prefix) {
+			continue
+		}
+
+		value, version, err := db.GetWithVersion(key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue // Expired since
+		}
+		if err != nil {
+			return err
+		}
+		expires, err := db.Expiry(key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		r := &kvRecord{Key: key, Value: value, Timestamp: time.Unix(0, version), Expires: expires}
+		if err := w.Write(r, now); err != nil {
+			return fmt.Errorf("failed to write %q: %w", key, err)
+		}
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "exported %d keys to %s\n", n, *output)
+	}
+	return nil
+}
+
+// Import loads a file written by Export, or another in the same
+// format, into the database named in args:
+//
+//	kvdb import [-format jsonl|csv] [-batch n] [-checkpoint file] [-dry-run] <dir> <file>
+//
+// Records are written in atomic batches. After each one the last key
+// in it is saved to a checkpoint file, so an import that fails part way
+// skips what it already wrote when run again. Keys get new versions,
+// the timestamps in the file aren't kept, and keys that have expired
+// are skipped.
+func Import(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	format := fs.String("format", "", "jsonl or csv, by default csv if the file ends in .csv and jsonl otherwise")
+	batchSize := fs.Int("batch", 1000, "write this many `records` at a time")
+	checkpoint := fs.String("checkpoint", "", "resume from and save progress to this `file`, by default the input file with .checkpoint added")
+	dryRun := fs.Bool("dry-run", false, "read and check the file without writing anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return errors.New("usage: kvdb import [-format jsonl|csv] [-batch n] [-checkpoint file] [-dry-run] <dir> <file>")
+	}
+	if *batchSize < 1 {
+		return errors.New("batch size must be at least 1")
+	}
+	dir, file := args[0], args[1]
+
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+		if *checkpoint == "" {
+			*checkpoint = file + ".checkpoint"
+		}
+	}
+	r, err := newRecordReader(formatFor(*format, file), in)
+	if err != nil {
+		return err
+	}
+
+	imp := &importer{batchSize: *batchSize, checkpoint: *checkpoint}
+	if *checkpoint != "" {
+		data, err := os.ReadFile(*checkpoint)
+		if err == nil {
+			imp.resumeAfter, imp.resuming = string(data), true
+			fmt.Fprintf(out, "resuming after %s\n", displayKey(imp.resumeAfter))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+	}
+
+	if !*dryRun {
+		imp.db, err = bitcask.Open(dir, nil)
+		if err != nil {
+			return err
+		}
+		defer imp.db.Close()
+	}
+
+	if err := imp.run(r); err != nil {
+		if imp.imported > 0 {
+			fmt.Fprintf(out, "imported %d keys before failing, run again to resume\n", imp.imported)
+		}
+		return err
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(out, "%s %d keys, skipped %d expired and %d imported before\n", verb, imp.imported, imp.expired, imp.skipped)
+	return nil
+}
+
+// importer writes records to a database in batches
+type importer struct {
+	db         *bitcask.Bitcask // nil for a dry run
+	batchSize  int
+	checkpoint string // File progress is saved to, none if empty
+
+	resumeAfter string // Key of the last record written before
+	resuming    bool   // Whether records are skipped until resumeAfter
+
+	batch    bitcask.Batch
+	lastKey  string // Key of the last record in batch
+	imported int
+	expired  int
+	skipped  int
+}
+
+// run imports every record r reads. Records read before an error are
+// still written.
+func (imp *importer) run(r recordReader) error {
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if flushErr := imp.flush(); flushErr != nil {
+				return flushErr
+			}
+			return err
+		}
+
+		if imp.resuming {
+			imp.skipped++
+			imp.resuming = rec.Key != imp.resumeAfter
+			continue
+		}
+
+		var ttl time.Duration
+		if !rec.Expires.IsZero() {
+			if ttl = time.Until(rec.Expires); ttl <= 0 {
+				imp.expired++
+				continue
+			}
+		}
+		imp.batch.PutWithTTL(rec.Key, rec.Value, ttl)
+		imp.lastKey = rec.Key
+		if imp.batch.Len() >= imp.batchSize {
+			if err := imp.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if imp.resuming {
+		return fmt.Errorf("checkpoint key %s isn't in the file, remove %s to start over", displayKey(imp.resumeAfter), imp.checkpoint)
+	}
+	if err := imp.flush(); err != nil {
+		return err
+	}
+	if imp.checkpoint != "" && imp.db != nil {
+		if err := os.Remove(imp.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// flush writes the pending batch, makes it durable and then saves the
+// checkpoint
+func (imp *importer) flush() error {
+	n := imp.batch.Len()
+	if n == 0 {
+		return nil
+	}
+	defer func() { imp.batch = bitcask.Batch{} }()
+
+	if imp.db == nil {
+		imp.imported += n
+		return nil
+	}
+
+	if _, err := imp.db.Apply(&imp.batch); err != nil {
+		return fmt.Errorf("failed to write batch ending at %s: %w", displayKey(imp.lastKey), err)
+	}
+	if err := imp.db.Sync(); err != nil {
+		return err
+	}
+	imp.imported += n
+
+	if imp.checkpoint == "" {
+		return nil
+	}
+	tmp := imp.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, []byte(imp.lastKey), 0644); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, imp.checkpoint); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
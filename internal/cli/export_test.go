@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// exportDB returns the directory of a database with text, binary,
+// empty and expiring values
+func exportDB(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := bitcask.Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("user:1", []byte("Alice, \"Al\"\nSmith")))
+	assert.NoError(t, db.Put("user:2", []byte("Bob")))
+	assert.NoError(t, db.Put("bin\xff", []byte{0, 1, 2}))
+	assert.NoError(t, db.Put("empty", nil))
+	assert.NoError(t, db.PutWithTTL("session", []byte("s"), time.Hour))
+	assert.NoError(t, db.Close())
+	return dir
+}
+
+// checkImported checks that dir holds what exportDB wrote
+func checkImported(t *testing.T, dir string) {
+	t.Helper()
+
+	db, err := bitcask.Open(dir, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for key, want := range map[string]string{
+		"user:1":  "Alice, \"Al\"\nSmith",
+		"user:2":  "Bob",
+		"bin\xff": "\x00\x01\x02",
+		"empty":   "",
+		"session": "s",
+	} {
+		value, err := db.Get(key)
+		assert.NoError(t, err, "%q", key)
+		assert.Equal(t, want, string(value))
+	}
+	expiry, err := db.Expiry("session")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute && time.Until(expiry) <= time.Hour)
+}
+
+func TestExportImport(t *testing.T) {
+	src := exportDB(t)
+
+	for _, ext := range []string{".jsonl", ".csv"} {
+		t.Run(ext, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "export"+ext)
+			var out bytes.Buffer
+			assert.NoError(t, Export(&out, []string{"-o", file, src}))
+			assert.Equal(t, "exported 5 keys to "+file+"\n", out.String())
+
+			dst := t.TempDir()
+			out.Reset()
+			assert.NoError(t, Import(&out, []string{"-batch", "3", dst, file}))
+			assert.Equal(t, "imported 5 keys, skipped 0 expired and 0 imported before\n", out.String())
+			checkImported(t, dst)
+
+			_, err := os.Stat(file + ".checkpoint")
+			assert.True(t, errors.Is(err, os.ErrNotExist))
+		})
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	src := exportDB(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Export(&out, []string{"-prefix", "user:", src}))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	var r map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &r))
+	assert.Equal(t, "user:2", r["key"])
+	assert.Equal(t, "Bob", r["value"])
+	_, err := time.Parse(time.RFC3339Nano, r["timestamp"].(string))
+	assert.NoError(t, err)
+
+	out.Reset()
+	assert.NoError(t, Export(&out, []string{"-format", "csv", src}))
+	lines = strings.Split(out.String(), "\n")
+	assert.Equal(t, "key,value,encoding,timestamp,expires,ttl_ms", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "Ymlu/w==,AAEC,base64,"), "got %q", lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "empty,,,"), "got %q", lines[2])
+	assert.True(t, strings.HasPrefix(lines[3], "session,s,,"), "got %q", lines[3])
+	assert.True(t, strings.HasSuffix(lines[3], ",3600000") || strings.HasSuffix(lines[3], ",3599999"), "got %q", lines[3])
+}
+
+func TestImportResume(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "in.jsonl")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"key":"a","value":"1"}
+{"key":"b","value":"2"}
+{"key":"c","value":"3","expires":"2000-01-01T00:00:00Z"}
+{"key":"d","value":"4","ttl_ms":60000}
+{"key":"e","value_base64":"not base64!"}
+{"key":"f","value":"6"}
+`), 0644))
+	db := filepath.Join(dir, "db")
+
+	// A dry run checks the whole file and writes nothing
+	var out bytes.Buffer
+	err := Import(&out, []string{"-dry-run", db, file})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "record 5: bad value_base64")
+	_, err = os.Stat(db)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// The records before the bad one are written and checkpointed
+	out.Reset()
+	err = Import(&out, []string{"-batch", "2", db, file})
+	assert.Error(t, err)
+	assert.Equal(t, "imported 3 keys before failing, run again to resume\n", out.String())
+	checkpoint, err := os.ReadFile(file + ".checkpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, "d", string(checkpoint))
+
+	// Fixed, the import picks up after d
+	assert.NoError(t, os.WriteFile(file, []byte(`{"key":"a","value":"1"}
+{"key":"b","value":"2"}
+{"key":"c","value":"3","expires":"2000-01-01T00:00:00Z"}
+{"key":"d","value":"4","ttl_ms":60000}
+{"key":"e","value_base64":"NQ=="}
+{"key":"f","value":"6"}
+`), 0644))
+	out.Reset()
+	assert.NoError(t, Import(&out, []string{db, file}))
+	assert.Equal(t, "resuming after d\nimported 2 keys, skipped 0 expired and 4 imported before\n", out.String())
+
+	bc, err := bitcask.Open(db, nil)
+	assert.NoError(t, err)
+	defer bc.Close()
+	keys := bc.Keys()
+	assert.Equal(t, 5, len(keys))
+	value, err := bc.Get("e")
+	assert.NoError(t, err)
+	assert.Equal(t, "5", string(value))
+	_, err = bc.Get("c")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound))
+	expiry, err := bc.Expiry("d")
+	assert.NoError(t, err)
+	assert.False(t, expiry.IsZero())
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Format is how values are shown, and how values given to put are read
+type Format int
+
+const (
+	Raw    Format = iota // Bytes as they are
+	Hex                  // Lowercase hex
+	Base64               // Standard base64
+)
+
+// ParseFormat returns the format called name
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "raw":
+		return Raw, nil
+	case "hex":
+		return Hex, nil
+	case "base64":
+		return Base64, nil
+	}
+	return 0, fmt.Errorf("unknown format %q, want raw, hex or base64", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case Hex:
+		return "hex"
+	case Base64:
+		return "base64"
+	}
+	return "raw"
+}
+
+// Encode returns value as text in format f
+func (f Format) Encode(value []byte) string {
+	switch f {
+	case Hex:
+		return hex.EncodeToString(value)
+	case Base64:
+		return base64.StdEncoding.EncodeToString(value)
+	}
+	return string(value)
+}
+
+// Decode returns the value s holds in format f
+func (f Format) Decode(s string) ([]byte, error) {
+	switch f {
+	case Hex:
+		value, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad hex value: %w", err)
+		}
+		return value, nil
+	case Base64:
+		value, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 value: %w", err)
+		}
+		return value, nil
+	}
+	return []byte(s), nil
+}
+
+// displayKey returns key as is if it's printable, quoted with escapes
+// otherwise so binary keys don't garble the terminal
+func displayKey(key string) string {
+	if key == "" || !utf8.ValidString(key) {
+		return strconv.Quote(key)
+	}
+	for _, r := range key {
+		if !unicode.IsPrint(r) || r == '"' {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
+
+// splitArgs splits a command line into its arguments on spaces. An
+// argument in double quotes or backquotes may hold spaces, and double
+// quoted ones Go escapes such as \x00.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] == '"' || line[0] == '`' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted argument: %s", line)
+			}
+			arg, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted argument: %s", quoted)
+			}
+			args = append(args, arg)
+			line = line[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		}
+		args = append(args, line[:end])
+		line = line[end:]
+	}
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// ErrDamaged is returned by Fsck when a database has damaged records
+var ErrDamaged = errors.New("database is damaged")
+
+// Fsck checks the database directory named in args without opening
+// it, and with -repair <dir> also salvages what it can read into a new
+// database there:
+//
+//	kvdb fsck [-repair <dir>] [-v] <dir>
+func Fsck(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.SetOutput(out)
+	repair := fs.String("repair", "", "copy every readable live key into a new database in this `directory`")
+	verbose := fs.Bool("v", false, "list every file")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: kvdb fsck [-repair <dir>] [-v] <dir>")
+	}
+	dir := args[0]
+
+	var report *bitcask.CheckReport
+	if *repair != "" {
+		report, err = bitcask.Repair(dir, *repair, nil)
+	} else {
+		report, err = bitcask.Check(dir, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *verbose {
+		for _, f := range report.Files {
+			if f.Blob {
+				fmt.Fprintf(out, "%010d.blob      %s\n", f.ID, formatBytes(f.Size))
+			} else {
+				fmt.Fprintf(out, "%010d.bitcask   %s, %d records, %d tombstones\n", f.ID, formatBytes(f.Size), f.Records, f.Tombstones)
+			}
+		}
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintln(out, p)
+	}
+
+	fmt.Fprintf(out, "files        %d\n", len(report.Files))
+	fmt.Fprintf(out, "keys         %d\n", report.Keys)
+	fmt.Fprintf(out, "records      %d\n", report.Records)
+	fmt.Fprintf(out, "tombstones   %d\n", report.Tombstones)
+	fmt.Fprintf(out, "dead bytes   %s of %s (%.1f%%)\n",
+		formatBytes(report.TotalBytes-report.LiveBytes), formatBytes(report.TotalBytes), report.DeadRatio()*100)
+	if *repair != "" {
+		fmt.Fprintf(out, "salvaged     %d keys into %s\n", report.Salvaged, *repair)
+	}
+
+	if !report.OK() {
+		return ErrDamaged
+	}
+	return nil
+}
+
+// parseArgs parses the flags in args, which may come before or after
+// the other arguments, and returns the other arguments. Everything
+// after -- is an argument.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		parsed := len(args) - fs.NArg()
+		if fs.NArg() == 0 || (parsed > 0 && args[parsed-1] == "--") {
+			return append(rest, fs.Args()...), nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
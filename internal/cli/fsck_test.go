@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+func TestFsck(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bitcask.Open(dir, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("b", []byte("2")))
+	assert.NoError(t, db.Delete("a"))
+	assert.NoError(t, db.Close())
+
+	var out bytes.Buffer
+	assert.NoError(t, Fsck(&out, []string{dir}))
+	assert.Contains(t, out.String(), "keys         1\n")
+	assert.Contains(t, out.String(), "tombstones   1\n")
+
+	// Cut the tombstone short
+	path := filepath.Join(dir, "0000000001.bitcask")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(path, info.Size()-1))
+
+	out.Reset()
+	err = Fsck(&out, []string{dir})
+	assert.True(t, errors.Is(err, ErrDamaged), "got %v", err)
+	assert.Contains(t, out.String(), "0000000001.bitcask offset ")
+	assert.Contains(t, out.String(), "partial record")
+
+	// Flags may come after the directory
+	repaired := filepath.Join(t.TempDir(), "repaired")
+	out.Reset()
+	err = Fsck(&out, []string{dir, "-repair", repaired, "-v"})
+	assert.True(t, errors.Is(err, ErrDamaged), "got %v", err)
+	assert.Contains(t, out.String(), "salvaged     2 keys into "+repaired)
+
+	out.Reset()
+	assert.NoError(t, Fsck(&out, []string{repaired}))
+	assert.Contains(t, out.String(), "dead bytes   0 B")
+
+	assert.Error(t, Fsck(&out, nil))
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadHistory returns the last size lines of the history file at path.
+// A missing or unreadable file is an empty history.
+func loadHistory(path string, size int) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > size {
+		lines = lines[len(lines)-size:]
+	}
+	return lines
+}
+
+// addHistory adds line to the history, and appends it to the history
+// file if there is one. The file isn't trimmed, only what's loaded.
+func (s *Shell) addHistory(line string) {
+	s.history = append(s.history, line)
+	if len(s.history) > s.cfg.HistorySize {
+		s.history = s.history[len(s.history)-s.cfg.HistorySize:]
+	}
+
+	if s.cfg.HistoryFile == "" {
+		return
+	}
+	f, err := os.OpenFile(s.cfg.HistoryFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return // History is a convenience, not worth failing commands over
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// expandHistory returns the history line that line refers to if it's
+// !n or !!, and line itself otherwise
+func (s *Shell) expandHistory(line string) (string, error) {
+	if !strings.HasPrefix(line, "!") {
+		return line, nil
+	}
+	if len(s.history) == 0 {
+		return "", fmt.Errorf("history is empty")
+	}
+
+	if line == "!!" {
+		return s.history[len(s.history)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(s.history) {
+		return "", fmt.Errorf("no history line %s", line[1:])
+	}
+	return s.history[n-1], nil
+}
+
+func (s *Shell) cmdHistory(args []string) error {
+	for i, line := range s.history {
+		fmt.Fprintf(s.out, "%4d  %s\n", i+1, line)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// kvRecord is a key and its value as export writes and import reads
+// them
+type kvRecord struct {
+	Key       string
+	Value     []byte
+	Timestamp time.Time // When the value was written, zero if unknown
+	Expires   time.Time // When the key expires, zero for never
+}
+
+// jsonRecord is a line of an export in JSONL. Keys and values that
+// aren't UTF-8 are given in base64 instead.
+type jsonRecord struct {
+	Key         *string `json:"key,omitempty"`
+	KeyBase64   string  `json:"key_base64,omitempty"`
+	Value       *string `json:"value,omitempty"`
+	ValueBase64 string  `json:"value_base64,omitempty"`
+	Timestamp   string  `json:"timestamp,omitempty"`
+	Expires     string  `json:"expires,omitempty"`
+	TTLMillis   int64   `json:"ttl_ms,omitempty"`
+}
+
+// csvHeader names the columns of an export in CSV. encoding is base64
+// if both key and value are in base64, as they are if either isn't
+// UTF-8, and empty otherwise.
+var csvHeader = []string{"key", "value", "encoding", "timestamp", "expires", "ttl_ms"}
+
+// recordWriter writes records in one of the export formats
+type recordWriter interface {
+	Write(r *kvRecord, now time.Time) error
+	Flush() error
+}
+
+// recordReader reads records in one of the export formats, returning
+// io.EOF after the last
+type recordReader interface {
+	Read() (*kvRecord, error)
+}
+
+// newRecordWriter returns a writer of format jsonl or csv
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want jsonl or csv", format)
+}
+
+// newRecordReader returns a reader of format jsonl or csv
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case "csv":
+		return &csvReader{r: csv.NewReader(r)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want jsonl or csv", format)
+}
+
+// formatTime returns t in RFC 3339 in UTC, empty if it's zero
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseRecordTime reads a time written by formatTime
+func parseRecordTime(field, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad %s %q: %w", field, s, err)
+	}
+	return t, nil
+}
+
+// expiresFrom returns when a record expires from its expires field,
+// or failing that its TTL in milliseconds relative to now
+func expiresFrom(expires string, ttlMillis int64, now time.Time) (time.Time, error) {
+	t, err := parseRecordTime("expires", expires)
+	if err != nil || !t.IsZero() || ttlMillis <= 0 {
+		return t, err
+	}
+	return now.Add(time.Duration(ttlMillis) * time.Millisecond), nil
+}
+
+// ttlMillis returns the TTL of a record in milliseconds at now, 0 for
+// none
+func ttlMillis(r *kvRecord, now time.Time) int64 {
+	if r.Expires.IsZero() {
+		return 0
+	}
+	return max(r.Expires.Sub(now).Milliseconds(), 1)
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(r *kvRecord, now time.Time) error {
+	out := jsonRecord{
+		Timestamp: formatTime(r.Timestamp),
+		Expires:   formatTime(r.Expires),
+		TTLMillis: ttlMillis(r, now),
+	}
+	if utf8.ValidString(r.Key) {
+		out.Key = &r.Key
+	} else {
+		out.KeyBase64 = base64.StdEncoding.EncodeToString([]byte(r.Key))
+	}
+	if utf8.Valid(r.Value) {
+		value := string(r.Value)
+		out.Value = &value
+	} else {
+		out.ValueBase64 = base64.StdEncoding.EncodeToString(r.Value)
+	}
+	return w.enc.Encode(out)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return nil
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+	n   int
+}
+
+func (r *jsonlReader) Read() (*kvRecord, error) {
+	var in jsonRecord
+	if err := r.dec.Decode(&in); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("record %d: %w", r.n+1, err)
+	}
+	r.n++
+
+	rec, err := in.record()
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+	return rec, nil
+}
+
+// record returns the record a line of JSONL holds
+func (in *jsonRecord) record() (*kvRecord, error) {
+	var rec kvRecord
+	switch {
+	case in.Key != nil:
+		rec.Key = *in.Key
+	case in.KeyBase64 != "":
+		key, err := base64.StdEncoding.DecodeString(in.KeyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("bad key_base64: %w", err)
+		}
+		rec.Key = string(key)
+	default:
+		return nil, errors.New("no key")
+	}
+
+	switch {
+	case in.Value != nil:
+		rec.Value = []byte(*in.Value)
+	case in.ValueBase64 != "":
+		value, err := base64.StdEncoding.DecodeString(in.ValueBase64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value_base64: %w", err)
+		}
+		rec.Value = value
+	default:
+		return nil, errors.New("no value")
+	}
+
+	var err error
+	if rec.Timestamp, err = parseRecordTime("timestamp", in.Timestamp); err != nil {
+		return nil, err
+	}
+	if rec.Expires, err = expiresFrom(in.Expires, in.TTLMillis, time.Now()); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (w *csvWriter) Write(r *kvRecord, now time.Time) error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+
+	key, value, encoding := r.Key, string(r.Value), ""
+	if !utf8.ValidString(key) || !utf8.ValidString(value) {
+		key = base64.StdEncoding.EncodeToString([]byte(key))
+		value = base64.StdEncoding.EncodeToString(r.Value)
+		encoding = "base64"
+	}
+
+	var ttl string
+	if ms := ttlMillis(r, now); ms > 0 {
+		ttl = strconv.FormatInt(ms, 10)
+	}
+	return w.w.Write([]string{key, value, encoding, formatTime(r.Timestamp), formatTime(r.Expires), ttl})
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int // Index of each column by name
+	n       int
+}
+
+func (r *csvReader) Read() (*kvRecord, error) {
+	if r.columns == nil {
+		header, err := r.r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+		r.columns = make(map[string]int)
+		for i, name := range header {
+			r.columns[name] = i
+		}
+		for _, name := range []string{"key", "value"} {
+			if _, ok := r.columns[name]; !ok {
+				return nil, fmt.Errorf("header has no %s column", name)
+			}
+		}
+	}
+
+	row, err := r.r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	r.n++
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	rec, err := csvRecord(field)
+	if err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.n, err)
+	}
+	return rec, nil
+}
+
+// csvRecord returns the record in a CSV row, whose columns field
+// returns by name
+func csvRecord(field func(name string) string) (*kvRecord, error) {
+	rec := kvRecord{Key: field("key"), Value: []byte(field("value"))}
+	switch field("encoding") {
+	case "":
+	case "base64":
+		key, err := base64.StdEncoding.DecodeString(rec.Key)
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 key: %w", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(string(rec.Value))
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 value: %w", err)
+		}
+		rec.Key, rec.Value = string(key), value
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", field("encoding"))
+	}
+
+	var ttl int64
+	if s := field("ttl_ms"); s != "" {
+		var err error
+		if ttl, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("bad ttl_ms %q", s)
+		}
+	}
+	var err error
+	if rec.Timestamp, err = parseRecordTime("timestamp", field("timestamp")); err != nil {
+		return nil, err
+	}
+	if rec.Expires, err = expiresFrom(field("expires"), ttl, time.Now()); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/sharding"
+)
+
+const shardUsage = `usage: kvdb shard -nodes a,b,c [-vnodes n] get <key>
+       kvdb shard -nodes a,b,c [-vnodes n] put <key> <value> [ttl]
+       kvdb shard -nodes a,b,c [-vnodes n] del <key>
+       kvdb shard -nodes a,b,c [-vnodes n] locate <key>
+       kvdb shard -nodes a,b,c [-vnodes n] rebalance <new nodes>`
+
+// splitNodes returns the comma-separated nodes in list
+func splitNodes(list string) []string {
+	var nodes []string
+	for _, node := range strings.Split(list, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Shard runs a command against kvdb servers sharded on a consistent
+// hash ring of their gRPC addresses, routing each key to its owner:
+//
+//	kvdb shard -nodes a,b,c [-vnodes n] <get|put|del|locate|rebalance> [args]
+//
+// rebalance moves the keys whose owner changes on a ring of the new
+// nodes. Every client has to be given the new nodes afterwards.
+func Shard(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("shard", flag.ContinueOnError)
+	fs.SetOutput(out)
+	nodeList := fs.String("nodes", "", "the servers' gRPC `addresses`, separated by commas")
+	vnodes := fs.Int("vnodes", sharding.DefaultVirtualNodes, "points on the ring per server, the same for every client")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	nodes := splitNodes(*nodeList)
+	if len(args) == 0 || len(nodes) == 0 {
+		return errors.New(shardUsage)
+	}
+
+	ring := sharding.NewRing(*vnodes, nodes...)
+	cmd, args := args[0], args[1:]
+	if cmd == "locate" {
+		if len(args) != 1 {
+			return errors.New(shardUsage)
+		}
+		fmt.Fprintf(out, "%s (hash %#016x)\n", ring.Owner(args[0]), sharding.Hash(args[0]))
+		return nil
+	}
+
+	c, err := sharding.NewClient(ring)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	switch {
+	case cmd == "get" && len(args) == 1:
+		value, err := c.Get(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", value)
+		return nil
+
+	case cmd == "put" && (len(args) == 2 || len(args) == 3):
+		var ttl time.Duration
+		if len(args) == 3 {
+			ttl, err = time.ParseDuration(args[2])
+			if err != nil || ttl <= 0 {
+				return fmt.Errorf("bad ttl %q, want a duration such as 30s", args[2])
+			}
+		}
+		return c.PutWithTTL(ctx, args[0], []byte(args[1]), ttl)
+
+	case cmd == "del" && len(args) == 1:
+		return c.Delete(ctx, args[0])
+
+	case cmd == "rebalance" && len(args) == 1:
+		newNodes := splitNodes(args[0])
+		if len(newNodes) == 0 {
+			return errors.New("rebalance needs at least one node")
+		}
+		to := sharding.NewRing(*vnodes, newNodes...)
+		stats, err := c.Rebalance(ctx, ring, to)
+		fmt.Fprintf(out, "%d ranges changed owner, %d keys moved\n", stats.Ranges, stats.Moved)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "route by -nodes %s from now on\n", strings.Join(to.Nodes(), ","))
+		return nil
+	}
+	return errors.New(shardUsage)
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"google.golang.org/grpc"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi"
+	"github.com/yashagw/kvdb/internal/sharding"
+)
+
+// startShard serves a new database over gRPC on a loopback port
+func startShard(t *testing.T) (*bitcask.Bitcask, string) {
+	t.Helper()
+
+	db, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	gs := grpc.NewServer()
+	grpcapi.New(db).Register(gs)
+	sharding.NewServer(db).Register(gs)
+	go gs.Serve(ln)
+	t.Cleanup(func() {
+		gs.Stop()
+		db.Close()
+	})
+	return db, ln.Addr().String()
+}
+
+func TestShard(t *testing.T) {
+	a, addrA := startShard(t)
+	b, addrB := startShard(t)
+	shard := func(nodes string, args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		assert.NoError(t, Shard(&out, append([]string{"-nodes", nodes, "-vnodes", "16"}, args...)))
+		return out.String()
+	}
+
+	for i := 0; i < 50; i++ {
+		shard(addrA, "put", fmt.Sprint("key", i), fmt.Sprint(i))
+	}
+	shard(addrA, "put", "temp", "t", "1h")
+	assert.Equal(t, "7\n", shard(addrA, "get", "key7"))
+	shard(addrA, "del", "key7")
+	assert.Equal(t, 50, len(a.Keys()))
+
+	// The second server takes its share
+	both := addrA + "," + addrB
+	out := shard(addrA, "rebalance", both)
+	assert.Contains(t, out, "route by -nodes "+strings.Join(sharding.NewRing(16, addrA, addrB).Nodes(), ","))
+	assert.True(t, len(b.Keys()) > 0 && len(a.Keys())+len(b.Keys()) == 50, "%d and %d keys", len(a.Keys()), len(b.Keys()))
+	for i := 0; i < 50; i++ {
+		if i != 7 {
+			assert.Equal(t, fmt.Sprint(i, "\n"), shard(both, "get", fmt.Sprint("key", i)))
+		}
+	}
+
+	owner := sharding.NewRing(16, addrA, addrB).Owner("key1")
+	assert.True(t, strings.HasPrefix(shard(both, "locate", "key1"), owner+" (hash "))
+
+	var buf bytes.Buffer
+	assert.Error(t, Shard(&buf, []string{"get", "key1"}))
+	assert.Error(t, Shard(&buf, []string{"-nodes", both, "get", "key7"}))
+}
@@ -0,0 +1,249 @@
+// Package cli is the kvdb command line shell. A Shell runs commands on
+// one open Bitcask database at a time, either read line by line from a
+// terminal or one at a time from command line arguments.
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// errExit is returned by the exit command to end Run
+var errExit = errors.New("exit")
+
+// Config holds configuration options for a Shell
+type Config struct {
+	Format      Format          // How values are shown and read
+	Prompt      bool            // Whether Run prompts for each line
+	HistoryFile string          // File lines are kept in across runs, none if empty
+	HistorySize int             // Most lines kept in history
+	DB          *bitcask.Config // Config databases are opened with, defaults if nil
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Format:      Raw,
+		Prompt:      true,
+		HistorySize: 1000,
+	}
+}
+
+// Shell runs commands on a database
+type Shell struct {
+	out    io.Writer
+	cfg    *Config
+	format Format
+
+	db  *bitcask.Bitcask // Open database, nil if none
+	dir string           // Directory of db
+
+	history []string
+}
+
+// New returns a shell that writes command output to out
+func New(out io.Writer, cfg *Config) *Shell {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	s := &Shell{out: out, cfg: cfg, format: cfg.Format}
+	if cfg.HistoryFile != "" {
+		s.history = loadHistory(cfg.HistoryFile, cfg.HistorySize)
+	}
+	return s
+}
+
+// Open opens the database in dir, closing the one open before
+func (s *Shell) Open(dir string) error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	db, err := bitcask.Open(dir, s.cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	s.db, s.dir = db, dir
+	return nil
+}
+
+// Close closes the open database, if any
+func (s *Shell) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	err := s.db.Close()
+	s.db, s.dir = nil, ""
+	if err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return nil
+}
+
+// Run reads commands from in line by line and runs them until in ends
+// or a command exits. Failed commands print their error and Run goes
+// on. Lines are added to history, and !n runs line n of it again and
+// !! the last one.
+func (s *Shell) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 64*1024*1024) // Values can be large
+
+	for {
+		if s.cfg.Prompt {
+			fmt.Fprint(s.out, s.prompt())
+		}
+		if !scanner.Scan() {
+			if s.cfg.Prompt {
+				fmt.Fprintln(s.out)
+			}
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		line, err := s.expandHistory(line)
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+			continue
+		}
+		s.addHistory(line)
+
+		err = s.Exec(line)
+		if errors.Is(err, errExit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+		}
+	}
+}
+
+// prompt returns the prompt, which names the open database
+func (s *Shell) prompt() string {
+	if s.db == nil {
+		return "kvdb> "
+	}
+	return fmt.Sprintf("kvdb %s> ", s.dir)
+}
+
+// Exec runs one command line
+func (s *Shell) Exec(line string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return s.ExecArgs(args)
+}
+
+// ExecArgs runs the command args[0] with the arguments after it
+func (s *Shell) ExecArgs(args []string) error {
+	name, args := strings.ToLower(args[0]), args[1:]
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		return fmt.Errorf("usage: %s", cmd.usage)
+	}
+	if cmd.needsDB && s.db == nil {
+		return errors.New("no database open, use open <dir>")
+	}
+	return cmd.run(s, args)
+}
+
+// command is a shell command
+type command struct {
+	usage   string
+	help    string
+	minArgs int
+	maxArgs int  // -1 for any number
+	needsDB bool // Whether it fails without an open database
+	run     func(s *Shell, args []string) error
+}
+
+// commands holds every command by name. It's filled in by init as
+// help refers back to it.
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"open":    {usage: "open <dir>", help: "open the database in dir, creating it if needed", minArgs: 1, maxArgs: 1, run: (*Shell).cmdOpen},
+		"close":   {usage: "close", help: "close the open database", run: (*Shell).cmdClose},
+		"get":     {usage: "get <key>", help: "show the value of key", minArgs: 1, maxArgs: 1, needsDB: true, run: (*Shell).cmdGet},
+		"put":     {usage: "put <key> <value> [ttl]", help: "store value under key, expiring after ttl (such as 30s) if given", minArgs: 2, maxArgs: 3, needsDB: true, run: (*Shell).cmdPut},
+		"del":     {usage: "del <key>", help: "delete key", minArgs: 1, maxArgs: 1, needsDB: true, run: (*Shell).cmdDel},
+		"keys":    {usage: "keys [prefix]", help: "list keys in order, only those starting with prefix if given", maxArgs: 1, needsDB: true, run: (*Shell).cmdKeys},
+		"scan":    {usage: "scan [start [end]]", help: "show keys in [start, end) in order along with their values", maxArgs: 2, needsDB: true, run: (*Shell).cmdScan},
+		"stats":   {usage: "stats", help: "show the number of keys and the size of the files", needsDB: true, run: (*Shell).cmdStats},
+		"merge":   {usage: "merge", help: "compact the database, dropping old values and deleted keys", needsDB: true, run: (*Shell).cmdMerge},
+		"backup":  {usage: "backup <dir>", help: "copy the database to a new directory", minArgs: 1, maxArgs: 1, needsDB: true, run: (*Shell).cmdBackup},
+		"format":  {usage: "format [raw|hex|base64]", help: "show or set how values are shown and read", maxArgs: 1, run: (*Shell).cmdFormat},
+		"history": {usage: "history", help: "list previous lines, run one again with !n or the last with !!", run: (*Shell).cmdHistory},
+		"help":    {usage: "help [command]", help: "list commands or describe one", maxArgs: 1, run: (*Shell).cmdHelp},
+		"exit":    {usage: "exit", help: "leave the shell", run: (*Shell).cmdExit},
+	}
+	commands["quit"] = commands["exit"]
+}
+
+func (s *Shell) cmdOpen(args []string) error {
+	return s.Open(args[0])
+}
+
+func (s *Shell) cmdClose(args []string) error {
+	return s.Close()
+}
+
+func (s *Shell) cmdExit(args []string) error {
+	return errExit
+}
+
+func (s *Shell) cmdHelp(args []string) error {
+	if len(args) == 1 {
+		cmd, ok := commands[strings.ToLower(args[0])]
+		if !ok {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		fmt.Fprintf(s.out, "%s\n    %s\n", cmd.usage, cmd.help)
+		return nil
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != "quit" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%-24s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintln(s.out, `Quote arguments with spaces or escapes: put "my key" "a\x00b"`)
+	return nil
+}
+
+func (s *Shell) cmdFormat(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(s.out, s.format)
+		return nil
+	}
+
+	format, err := ParseFormat(args[0])
+	if err != nil {
+		return err
+	}
+	s.format = format
+	return nil
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// newShell returns a shell without a prompt with the database in a new
+// directory open, and the buffer its output goes to
+func newShell(t *testing.T) (*Shell, *bytes.Buffer) {
+	t.Helper()
+
+	var out bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.Prompt = false
+	s := New(&out, cfg)
+	assert.NoError(t, s.Open(t.TempDir()))
+	t.Cleanup(func() { assert.NoError(t, s.Close()) })
+	return s, &out
+}
+
+// run runs a script of commands and returns their output
+func run(t *testing.T, s *Shell, out *bytes.Buffer, script string) string {
+	t.Helper()
+
+	out.Reset()
+	assert.NoError(t, s.Run(strings.NewReader(script)))
+	return out.String()
+}
+
+func TestCommands(t *testing.T) {
+	s, out := newShell(t)
+
+	assert.Equal(t, "OK\nOK\nOK\nAlice\n", run(t, s, out, `
+put name Alice
+put "user:1" "Bob Smith"
+put user:2 Carol 1h
+get name
+`))
+
+	assert.Equal(t, "user:1\nuser:2\n", run(t, s, out, "keys user:"))
+	assert.Equal(t, "name = Alice\nuser:1 = Bob Smith\n", run(t, s, out, "scan a user:2"))
+
+	assert.Equal(t, "OK\nerror: key not found: name\nerror: key not found: name\n", run(t, s, out, `
+del name
+get name
+del name
+`))
+
+	got := run(t, s, out, "stats")
+	assert.Contains(t, got, "keys         2\n")
+	assert.Contains(t, got, "data files   1")
+
+	assert.Equal(t, "error: usage: get <key>\nerror: unknown command \"frob\", try help\n", run(t, s, out, "get\nfrob"))
+}
+
+func TestFormats(t *testing.T) {
+	s, out := newShell(t)
+
+	assert.Equal(t, "OK\n", run(t, s, out, `put bin "\x00\xffhi"`))
+	assert.Equal(t, "00ff6869\nAP9oaQ==\n", run(t, s, out, "format hex\nget bin\nformat base64\nget bin"))
+
+	// Values given to put are read in the format too
+	assert.Equal(t, "OK\n", run(t, s, out, "put b64 aGVsbG8="))
+	assert.Equal(t, "hello\n", run(t, s, out, "format raw\nget b64"))
+	assert.Equal(t, "error: bad hex value: encoding/hex: invalid byte: U+007A 'z'\n", run(t, s, out, "format hex\nput x zz"))
+
+	// Binary keys are quoted
+	assert.Equal(t, "OK\n\"\\x01key\"\nb64\nbin\n", run(t, s, out, "format raw\nput \"\\x01key\" v\nkeys"))
+}
+
+func TestMergeAndBackup(t *testing.T) {
+	s, out := newShell(t)
+
+	for i := 0; i < 10; i++ {
+		run(t, s, out, "put k v")
+	}
+	assert.Contains(t, run(t, s, out, "merge"), "OK, ")
+
+	backup := filepath.Join(t.TempDir(), "backup")
+	assert.Equal(t, "OK, backed up to "+backup+"\n", run(t, s, out, "backup "+backup))
+	assert.Equal(t, "v\n", run(t, s, out, "open "+backup+"\nget k"))
+}
+
+func TestNoDatabase(t *testing.T) {
+	var out bytes.Buffer
+	s := New(&out, &Config{Prompt: true, HistorySize: 10})
+	assert.NoError(t, s.Run(strings.NewReader("get k\nexit\nget k\n")))
+	assert.Equal(t, "kvdb> error: no database open, use open <dir>\nkvdb> ", out.String())
+
+	// The directory stays as it was after closing
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0644))
+	assert.NoError(t, s.Open(dir))
+	assert.NoError(t, s.Close())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	var out bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.Prompt = false
+	cfg.HistoryFile = file
+	s := New(&out, cfg)
+	defer s.Close()
+
+	assert.NoError(t, s.Run(strings.NewReader("format hex\nformat\n!1\n!!\n!9\nhistory\n")))
+	assert.Equal(t, "hex\nerror: no history line 9\n"+
+		"   1  format hex\n   2  format\n   3  format hex\n   4  format hex\n   5  history\n", out.String())
+
+	// A new shell picks up where the last one left off
+	out.Reset()
+	s = New(&out, cfg)
+	assert.NoError(t, s.Run(strings.NewReader("!1\n!2\n")))
+	assert.Equal(t, "hex\n", out.String())
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`put  "a key" ` + "`raw\\n`" + ` "\x00" plain`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"put", "a key", `raw\n`, "\x00", "plain"}, args)
+
+	_, err = splitArgs(`put "unterminated`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,293 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/raft"
+)
+
+// testCluster is a cluster of nodes on an in-memory network, each with
+// its own directory
+type testCluster struct {
+	t      *testing.T
+	net    *raft.Network
+	dir    string
+	ids    []string
+	config *Config
+	nodes  map[string]*Node
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	cfg := DefaultConfig()
+	cfg.Raft.ElectionTimeout = 50 * time.Millisecond
+	cfg.Raft.HeartbeatInterval = 10 * time.Millisecond
+	cfg.Raft.SnapshotThreshold = snapshotThreshold
+
+	c := &testCluster{t: t, net: raft.NewNetwork(), dir: t.TempDir(), config: cfg, nodes: make(map[string]*Node)}
+	for i := 1; i <= size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Close()
+		}
+	})
+	return c
+}
+
+// start opens the node id from its directory
+func (c *testCluster) start(id string) {
+	c.t.Helper()
+	n, err := Open(filepath.Join(c.dir, id), id, c.ids, c.net.Transport(id), c.config)
+	assert.NoError(c.t, err)
+	c.nodes[id] = n
+	c.net.Register(id, n.Raft())
+}
+
+// leader waits for one of ids to lead in the latest term
+func (c *testCluster) leader(ids ...string) *Node {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		var leaders []*Node
+		var term, leaderTerm uint64
+		for _, id := range ids {
+			status := c.nodes[id].Raft().Status()
+			term = max(term, status.Term)
+			if status.State == raft.Leader {
+				leaders = append(leaders, c.nodes[id])
+				leaderTerm = status.Term
+			}
+		}
+		if len(leaders) == 1 && leaderTerm == term {
+			return leaders[0]
+		}
+	}
+	c.t.Fatalf("no leader elected among %v", ids)
+	return nil
+}
+
+// others returns the IDs other than id
+func (c *testCluster) others(id string) []string {
+	return slices.DeleteFunc(slices.Clone(c.ids), func(other string) bool { return other == id })
+}
+
+// put writes key on the leader among ids, retrying if leadership
+// changes
+func (c *testCluster) put(key, value string, ids ...string) {
+	c.t.Helper()
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = c.leader(ids...).Put(ctx, key, []byte(value))
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+	c.t.Fatalf("failed to put %s: %v", key, err)
+}
+
+// waitInSync waits until every one of ids has the same keys, values
+// and versions as from
+func (c *testCluster) waitInSync(from *Node, ids ...string) {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	for _, id := range ids {
+		var diff string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if diff = compare(from, c.nodes[id]); diff == "" {
+				break
+			}
+		}
+		assert.Equal(c.t, "", diff, "node %s", id)
+	}
+}
+
+// compare returns how b's data differs from a's, empty if it doesn't
+func compare(a, b *Node) string {
+	keys := a.Keys()
+	if len(keys) != len(b.Keys()) {
+		return fmt.Sprintf("%d keys, not %d", len(b.Keys()), len(keys))
+	}
+	for _, key := range keys {
+		want, wantVersion, err := a.GetWithVersion(key)
+		if err != nil {
+			return err.Error()
+		}
+		got, version, err := b.GetWithVersion(key)
+		if err != nil {
+			return err.Error()
+		}
+		if string(got) != string(want) || version != wantVersion {
+			return fmt.Sprintf("%s is %q at %d, not %q at %d", key, got, version, want, wantVersion)
+		}
+	}
+	return ""
+}
+
+func TestCluster(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	leader := c.leader()
+	ctx := context.Background()
+
+	version, err := leader.Put(ctx, "a", []byte("1"))
+	assert.NoError(t, err)
+	_, got, err := leader.GetWithVersion("a")
+	assert.NoError(t, err)
+	assert.Equal(t, version, got)
+
+	_, err = leader.PutWithTTL(ctx, "temp", []byte("v"), time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, leader.Delete(ctx, "temp"))
+	err = leader.Delete(ctx, "missing")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+
+	// Batches apply atomically, and only at the versions they require
+	var b Batch
+	b.Require("a", version)
+	b.Require("b", 0)
+	b.Put("b", []byte("2"))
+	b.Put("c", []byte("3"))
+	_, err = leader.Apply(ctx, &b)
+	assert.NoError(t, err)
+	_, err = leader.Apply(ctx, &b)
+	assert.True(t, errors.Is(err, bitcask.ErrConflict), "got %v", err)
+
+	c.waitInSync(leader)
+	assert.Equal(t, 3, len(c.nodes[c.others(leader.ID())[0]].Keys()))
+
+	// Writes only go through the leader
+	follower := c.nodes[c.others(leader.ID())[0]]
+	_, err = follower.Put(ctx, "x", []byte("y"))
+	assert.True(t, errors.Is(err, raft.ErrNotLeader), "got %v", err)
+	_, err = leader.Put(ctx, appliedKey, []byte("y"))
+	assert.True(t, errors.Is(err, ErrReservedKey), "got %v", err)
+}
+
+func TestFailover(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	c.put("before", "1")
+	oldLeader := c.leader()
+	c.waitInSync(oldLeader)
+
+	// The leader is cut off, and the others elect a new one that has
+	// every acknowledged write
+	c.net.Partition([]string{oldLeader.ID()})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err := oldLeader.Put(ctx, "lost", []byte("x"))
+	cancel()
+	assert.Error(t, err)
+
+	majority := c.others(oldLeader.ID())
+	c.put("after", "2", majority...)
+	newLeader := c.leader(majority...)
+	value, err := newLeader.Get("before")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	// The old leader rejoins as a follower and catches up
+	c.net.Heal()
+	c.put("healed", "3")
+	c.waitInSync(c.leader())
+	_, err = oldLeader.Get("lost")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	c := newTestCluster(t, 3, 20)
+	leader := c.leader()
+
+	// A follower misses enough writes that the leader has compacted
+	// them into a snapshot of its database
+	lagging := c.others(leader.ID())[0]
+	c.net.Partition([]string{lagging})
+	for i := 0; i < 100; i++ {
+		c.put(fmt.Sprintf("key%03d", i), fmt.Sprint(i), c.others(lagging)...)
+	}
+	leader = c.leader(c.others(lagging)...)
+	assert.True(t, leader.Raft().Status().SnapshotIndex > 0)
+
+	// It catches up from the snapshot, restored from the backup
+	c.net.Heal()
+	c.waitInSync(leader)
+	assert.True(t, c.nodes[lagging].Raft().Status().SnapshotIndex > 0)
+
+	// Every node picks up where it left off after a restart
+	for _, id := range c.ids {
+		assert.NoError(t, c.nodes[id].Close())
+		c.start(id)
+	}
+	c.put("restarted", "1")
+	c.waitInSync(c.leader())
+	assert.Equal(t, 101, len(c.leader().Keys()))
+}
+
+func TestStateMachineVersions(t *testing.T) {
+	dir := t.TempDir()
+	sm, err := openStateMachine(dir, bitcask.DefaultConfig())
+	assert.NoError(t, err)
+
+	index := uint64(0)
+	apply := func(sm *stateMachine, timestamp int64, b *Batch) any {
+		t.Helper()
+		c := b.command(time.Unix(0, timestamp))
+		data, err := c.encode()
+		assert.NoError(t, err)
+		index++
+		result, err := sm.Apply(raft.Entry{Index: index, Data: data})
+		assert.NoError(t, err)
+		return result
+	}
+	put := func(key string) *Batch {
+		var b Batch
+		b.Put(key, []byte("v"))
+		return &b
+	}
+
+	// The leader's clock when it's ahead, one more than the last version
+	// when it steps back
+	assert.Equal(t, int64(1000), apply(sm, 1000, put("k")))
+	var del Batch
+	del.Delete("k")
+	assert.Equal(t, int64(1001), apply(sm, 500, &del))
+	assert.Equal(t, int64(1002), apply(sm, 1000, put("k")))
+
+	// So a version a key had before doesn't match again
+	var stale Batch
+	stale.Require("k", 1000)
+	stale.Put("k", []byte("stale"))
+	assert.True(t, errors.Is(apply(sm, 1000, &stale).(error), bitcask.ErrConflict))
+
+	// The last version survives a restart
+	assert.NoError(t, sm.close())
+	sm, err = openStateMachine(dir, bitcask.DefaultConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1004), apply(sm, 1, put("k")))
+
+	// A batch that can't be written is an error for raft, and isn't
+	// counted as applied
+	assert.NoError(t, sm.close())
+	data, err := put("k").command(time.Unix(0, 1)).encode()
+	assert.NoError(t, err)
+	_, err = sm.Apply(raft.Entry{Index: index + 1, Data: data})
+	assert.Error(t, err)
+	assert.Equal(t, index, sm.applied)
+	assert.Equal(t, int64(1004), sm.version)
+}
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+)
+
+// command is a write replicated through the Raft log. The leader sets
+// its timestamp and expiry times, and the state machine turns the
+// timestamp into a version, so every node writes the same ones.
+type command struct {
+	Timestamp int64 // The leader's clock, the lowest version its keys can get
+	Ops       []op
+	Requires  []require
+}
+
+// op is one write of a command
+type op struct {
+	Key     string
+	Value   []byte
+	Expires int64 // Unix nanoseconds, 0 for never
+	Delete  bool
+}
+
+// require is a version a key must be at for a command to apply
+type require struct {
+	Key     string
+	Version int64
+}
+
+func (c *command) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCommand(data []byte) (*command, error) {
+	var c command
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Batch collects writes for Node.Apply to make atomically on every
+// node, like bitcask.Batch
+type Batch struct {
+	ops      []batchOp
+	requires []require
+}
+
+// batchOp is one write in a batch, with a TTL the leader turns into an
+// expiry time
+type batchOp struct {
+	key    string
+	value  []byte
+	ttl    time.Duration
+	delete bool
+}
+
+// Put adds a write of a key-value pair to the batch
+func (b *Batch) Put(key string, value []byte) {
+	b.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL adds a write of a key-value pair that expires after
+// ttl, or never if ttl isn't positive
+func (b *Batch) PutWithTTL(key string, value []byte, ttl time.Duration) {
+	b.ops = append(b.ops, batchOp{key: key, value: value, ttl: ttl})
+}
+
+// Delete adds a delete of key to the batch. It isn't an error if the
+// key doesn't exist.
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Require makes Apply fail with bitcask.ErrConflict unless key is at
+// version when the batch is applied. Version 0 requires the key not to
+// exist and bitcask.AnyVersion only that it does.
+func (b *Batch) Require(key string, version int64) {
+	b.requires = append(b.requires, require{Key: key, Version: version})
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// command returns the command for the batch as of now
+func (b *Batch) command(now time.Time) *command {
+	c := &command{Timestamp: now.UnixNano(), Requires: b.requires}
+	for _, o := range b.ops {
+		op := op{Key: o.key, Value: o.value, Delete: o.delete}
+		if !o.delete && o.ttl > 0 {
+			op.Expires = now.Add(o.ttl).UnixNano()
+		}
+		c.Ops = append(c.Ops, op)
+	}
+	return c
+}
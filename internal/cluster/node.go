@@ -0,0 +1,174 @@
+// Package cluster replicates a kvdb database across a cluster of nodes
+// with Raft. Every node keeps its own Bitcask database, which only
+// changes by applying commands committed to the Raft log, so when the
+// leader fails another node takes over with all its acknowledged
+// writes.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/raft"
+)
+
+// ErrReservedKey is returned for writes to keys the cluster keeps for
+// itself
+var ErrReservedKey = errors.New("key is reserved")
+
+// Config holds configuration options for a node
+type Config struct {
+	Raft *raft.Config
+	DB   *bitcask.Config
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{Raft: raft.DefaultConfig(), DB: bitcask.DefaultConfig()}
+}
+
+// Node is a member of a kvdb cluster. Writes go through the leader and
+// fail with raft.ErrNotLeader elsewhere. Reads are served from the
+// node's own database, which on a follower may be a little behind.
+type Node struct {
+	raft    *raft.Node
+	sm      *stateMachine
+	storage *raft.BitcaskStorage
+}
+
+// Open starts the node id of a cluster of members, keeping its Raft
+// log in dir/raft and its database in dir/data. Requests from the
+// other nodes need to be delivered to Raft().
+func Open(dir, id string, members []string, transport raft.Transport, cfg *Config) (*Node, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	storage, err := raft.OpenStorage(filepath.Join(dir, "raft"))
+	if err != nil {
+		return nil, err
+	}
+	sm, err := openStateMachine(dir, cfg.DB)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+	rn, err := raft.New(id, members, sm, storage, transport, cfg.Raft)
+	if err != nil {
+		sm.close()
+		storage.Close()
+		return nil, err
+	}
+	return &Node{raft: rn, sm: sm, storage: storage}, nil
+}
+
+// Close stops the node and closes its database and log
+func (n *Node) Close() error {
+	n.raft.Stop()
+	return errors.Join(n.sm.close(), n.storage.Close())
+}
+
+// ID returns the node's ID
+func (n *Node) ID() string {
+	return n.raft.ID()
+}
+
+// Raft returns the node's Raft node
+func (n *Node) Raft() *raft.Node {
+	return n.raft
+}
+
+// Get returns the value of key
+func (n *Node) Get(key string) ([]byte, error) {
+	value, _, err := n.GetWithVersion(key)
+	return value, err
+}
+
+// GetWithVersion returns the value of key along with its version,
+// which is the same on every node
+func (n *Node) GetWithVersion(key string) ([]byte, int64, error) {
+	if strings.HasPrefix(key, reservedPrefix) {
+		return nil, 0, bitcask.ErrKeyNotFound
+	}
+	var value []byte
+	var version int64
+	err := n.sm.get(func(db *bitcask.Bitcask) (err error) {
+		value, version, err = db.GetWithVersion(key)
+		return err
+	})
+	return value, version, err
+}
+
+// Keys returns all the keys
+func (n *Node) Keys() []string {
+	var keys []string
+	n.sm.get(func(db *bitcask.Bitcask) error {
+		for _, key := range db.Keys() {
+			if !strings.HasPrefix(key, reservedPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	return keys
+}
+
+// Put stores a key-value pair and returns its version
+func (n *Node) Put(ctx context.Context, key string, value []byte) (int64, error) {
+	return n.PutWithTTL(ctx, key, value, 0)
+}
+
+// PutWithTTL stores a key-value pair that expires after ttl, or never
+// if ttl isn't positive, and returns its version
+func (n *Node) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) (int64, error) {
+	var b Batch
+	b.PutWithTTL(key, value, ttl)
+	return n.Apply(ctx, &b)
+}
+
+// Delete removes key, returning bitcask.ErrKeyNotFound if it doesn't
+// exist
+func (n *Node) Delete(ctx context.Context, key string) error {
+	var b Batch
+	b.Require(key, bitcask.AnyVersion)
+	b.Delete(key)
+	_, err := n.Apply(ctx, &b)
+	if errors.Is(err, bitcask.ErrConflict) {
+		return bitcask.ErrKeyNotFound
+	}
+	return err
+}
+
+// Apply commits the writes of b on the cluster and waits for this node
+// to apply them, returning the version they were written at
+func (n *Node) Apply(ctx context.Context, b *Batch) (int64, error) {
+	for _, o := range b.ops {
+		if o.key == "" {
+			return 0, errors.New("key cannot be empty")
+		}
+		if strings.HasPrefix(o.key, reservedPrefix) {
+			return 0, fmt.Errorf("%w: %q", ErrReservedKey, o.key)
+		}
+	}
+
+	data, err := b.command(time.Now()).encode()
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode command: %w", err)
+	}
+	result, err := n.raft.Propose(ctx, data)
+	if err != nil {
+		return 0, err
+	}
+	switch result := result.(type) {
+	case int64:
+		return result, nil
+	case error:
+		return 0, result
+	}
+	return 0, fmt.Errorf("unexpected result %v", result)
+}
@@ -0,0 +1,292 @@
+package cluster
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/raft"
+)
+
+// appliedKey holds the index of the last entry applied, written in the
+// same batch as the entry's writes and at the same version, so its
+// version is the last one given out. Keys starting with reservedPrefix
+// are kept from clients.
+const (
+	reservedPrefix = "\x00raft:"
+	appliedKey     = reservedPrefix + "applied"
+)
+
+// stateMachine applies committed commands to a Bitcask database in
+// dir/data. Snapshots are backups of the database packed into a tar.
+type stateMachine struct {
+	dir    string
+	config *bitcask.Config
+
+	mu sync.RWMutex // Guards db, which Restore replaces
+	db *bitcask.Bitcask
+
+	// The last entry applied. Entries up to it are skipped when the log
+	// is replayed after a restart, as applying a conditional batch again
+	// could go differently. Only touched by raft's applier.
+	applied uint64
+
+	// The version of the last entry applied. Each entry gets a higher
+	// one, so a version is never reused even if the leader's clock steps
+	// back. Only touched by raft's applier.
+	version int64
+}
+
+// Directories in the node's directory the state machine uses
+func (sm *stateMachine) dataDir() string     { return filepath.Join(sm.dir, "data") }
+func (sm *stateMachine) restoreDir() string  { return filepath.Join(sm.dir, "data.new") }
+func (sm *stateMachine) oldDir() string      { return filepath.Join(sm.dir, "data.old") }
+func (sm *stateMachine) snapshotDir() string { return filepath.Join(sm.dir, "snapshot.tmp") }
+
+// openStateMachine opens the database in dir, finishing off a restore
+// a crash interrupted
+func openStateMachine(dir string, cfg *bitcask.Config) (*stateMachine, error) {
+	sm := &stateMachine{dir: dir, config: cfg}
+
+	if _, err := os.Stat(sm.dataDir()); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(sm.restoreDir()); err == nil {
+			if err := os.Rename(sm.restoreDir(), sm.dataDir()); err != nil {
+				return nil, fmt.Errorf("failed to finish restore: %w", err)
+			}
+		}
+	}
+	for _, leftover := range []string{sm.restoreDir(), sm.oldDir(), sm.snapshotDir()} {
+		if err := os.RemoveAll(leftover); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sm.open(); err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// open opens the database and reads the last entry applied to it.
+// mu must be held.
+func (sm *stateMachine) open() error {
+	db, err := bitcask.Open(sm.dataDir(), sm.config)
+	if err != nil {
+		return err
+	}
+	db.SetReadOnly(true)
+
+	sm.applied, sm.version = 0, 0
+	value, version, err := db.GetWithVersion(appliedKey)
+	switch {
+	case err == nil && len(value) == 8:
+		sm.applied, sm.version = binary.BigEndian.Uint64(value), version
+	case err == nil:
+		err = fmt.Errorf("applied index is %d bytes", len(value))
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		err = nil
+	}
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to read applied index: %w", err)
+	}
+	sm.db = db
+	return nil
+}
+
+// get runs fn with the current database
+func (sm *stateMachine) get(fn func(db *bitcask.Bitcask) error) error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return fn(sm.db)
+}
+
+func (sm *stateMachine) close() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.Close()
+}
+
+// Apply writes a command's records and the entry's index in one batch.
+// The command's version is the leader's timestamp, or one more than the
+// last entry's if that's higher, so it's the same on every node. It
+// returns the version, or the reason the command was refused. An error
+// writing the batch stops raft applying entries.
+func (sm *stateMachine) Apply(e raft.Entry) (any, error) {
+	if e.Index <= sm.applied {
+		return nil, nil
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	var records []bitcask.LogRecord
+	var result any
+	version := sm.version + 1
+	c, err := decodeCommand(e.Data)
+	if err == nil {
+		version = max(c.Timestamp, version)
+		err = sm.check(c.Requires)
+	}
+	if err != nil {
+		result = err
+	} else {
+		for _, o := range c.Ops {
+			records = append(records, bitcask.LogRecord{
+				Key:       o.Key,
+				Value:     o.Value,
+				Timestamp: version,
+				Expires:   o.Expires,
+				Delete:    o.Delete,
+			})
+		}
+		result = version
+	}
+
+	// Even a command that fails moves the applied index on
+	records = append(records, bitcask.LogRecord{
+		Key:       appliedKey,
+		Value:     binary.BigEndian.AppendUint64(nil, e.Index),
+		Timestamp: version,
+	})
+	for i := range records[:len(records)-1] {
+		records[i].Batch = true
+	}
+	if err := sm.db.ApplyLog(records); err != nil {
+		return nil, fmt.Errorf("failed to apply entry %d: %w", e.Index, err)
+	}
+	sm.applied, sm.version = e.Index, version
+	return result, nil
+}
+
+// check returns bitcask.ErrConflict unless every key is at the version
+// required. RLock must be held.
+func (sm *stateMachine) check(requires []require) error {
+	for _, r := range requires {
+		current, err := sm.db.Version(r.Key)
+		if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+			return err
+		}
+		if r.Version == bitcask.AnyVersion && current != 0 || r.Version == current {
+			continue
+		}
+		return fmt.Errorf("%w: %q is at version %d, not %d", bitcask.ErrConflict, r.Key, current, r.Version)
+	}
+	return nil
+}
+
+// Snapshot backs the database up and writes the backup's files as a
+// tar
+func (sm *stateMachine) Snapshot(w io.Writer) error {
+	if err := os.RemoveAll(sm.snapshotDir()); err != nil {
+		return err
+	}
+	defer os.RemoveAll(sm.snapshotDir())
+
+	if err := sm.get(func(db *bitcask.Bitcask) error { return db.Backup(sm.snapshotDir()) }); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(sm.snapshotDir())
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if err := addFile(tw, filepath.Join(sm.snapshotDir(), f.Name())); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// addFile writes the file at path to tw
+func addFile(tw *tar.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: info.Name(), Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// Restore unpacks a snapshot next to the database, then swaps it in.
+// Reads wait while the databases are swapped.
+func (sm *stateMachine) Restore(r io.Reader) error {
+	if err := os.RemoveAll(sm.restoreDir()); err != nil {
+		return err
+	}
+	if err := unpack(r, sm.restoreDir()); err != nil {
+		return fmt.Errorf("failed to unpack snapshot: %w", err)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if err := sm.db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(sm.dataDir(), sm.oldDir()); err != nil {
+		return err
+	}
+	if err := os.Rename(sm.restoreDir(), sm.dataDir()); err != nil {
+		return err
+	}
+	if err := sm.open(); err != nil {
+		return err
+	}
+	return os.RemoveAll(sm.oldDir())
+}
+
+// unpack writes the files of a tar to dir
+func unpack(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || header.Name != filepath.Base(header.Name) || strings.HasPrefix(header.Name, ".") {
+			return fmt.Errorf("unexpected entry %q", header.Name)
+		}
+
+		file, err := os.OpenFile(filepath.Join(dir, header.Name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
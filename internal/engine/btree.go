@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/yashagw/kvdb/internal/bplustree"
+)
+
+// BTree is an Engine backed by a durable B+ tree. Unlike Bitcask it
+// keeps keys sorted, so scans don't have to sort the whole keyspace.
+type BTree struct {
+	tree *bplustree.DurableTree
+}
+
+// OpenBTree opens (or creates) a B+ tree engine in path. A nil cfg
+// uses bplustree.DefaultConfig without SyncWrites, matching Bitcask.
+func OpenBTree(path string, cfg *bplustree.Config) (*BTree, error) {
+	if cfg == nil {
+		cfg = bplustree.DefaultConfig()
+		cfg.SyncWrites = false
+	}
+	if cfg.AllowDuplicates {
+		return nil, fmt.Errorf("engine keys can't have duplicates")
+	}
+
+	tree, err := bplustree.OpenWithConfig(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &BTree{tree: tree}, nil
+}
+
+// Get retrieves the value for key
+func (b *BTree) Get(key string) ([]byte, error) {
+	val, ok := b.tree.Get(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return []byte(val), nil
+}
+
+// Put stores a key-value pair
+func (b *BTree) Put(key string, value []byte) error {
+	if err := b.tree.Put(key, string(value)); err != nil {
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes key
+func (b *BTree) Delete(key string) error {
+	ok, err := b.tree.Delete(key)
+	if err != nil {
+		return fmt.Errorf("failed to write delete: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return nil
+}
+
+// Scan calls fn for each key in [start, end) in order
+func (b *BTree) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	for key, val := range b.tree.Scan(start, end) {
+		if !fn(key, []byte(val)) {
+			break
+		}
+	}
+	return nil
+}
+
+// Sync forces the WAL to disk
+func (b *BTree) Sync() error {
+	return b.tree.Sync()
+}
+
+// Close syncs and closes the tree
+func (b *BTree) Close() error {
+	return b.tree.Close()
+}
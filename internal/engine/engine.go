@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// ErrKeyNotFound is returned by Get and Delete when the key isn't
+// there. Engines wrap it, so check with errors.Is.
+var ErrKeyNotFound = bitcask.ErrKeyNotFound
+
+// Engine is a persistent key-value store
+type Engine interface {
+	// Get retrieves the value for key
+	Get(key string) ([]byte, error)
+
+	// Put stores a key-value pair
+	Put(key string, value []byte) error
+
+	// Delete removes key
+	Delete(key string) error
+
+	// Scan calls fn for each key in [start, end) in order along with
+	// its value, until fn returns false. An empty end means no upper
+	// bound. fn may write to the engine.
+	Scan(start, end string, fn func(key string, value []byte) bool) error
+
+	// Sync forces pending writes to disk
+	Sync() error
+
+	// Close syncs and releases the engine
+	Close() error
+}
+
+// Every engine is usable wherever an Engine is
+var (
+	_ Engine = (*bitcask.Bitcask)(nil)
+	_ Engine = (*BTree)(nil)
+	_ Engine = (*LSM)(nil)
+)
+
+// openers maps engine names to how to open them with default options
+var openers = map[string]func(path string) (Engine, error){
+	"bitcask": func(path string) (Engine, error) {
+		return bitcask.Open(path, bitcask.DefaultConfig())
+	},
+	"bplustree": func(path string) (Engine, error) {
+		return OpenBTree(path, nil)
+	},
+	"lsm": func(path string) (Engine, error) {
+		return OpenLSM(path, nil)
+	},
+}
+
+// Names returns the names Open accepts in sorted order
+func Names() []string {
+	names := make([]string, 0, len(openers))
+	for name := range openers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open opens the engine called name with its default options,
+// storing its files in path
+func Open(name, path string) (Engine, error) {
+	open, ok := openers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+
+	e, err := open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s engine: %w", name, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchKeys is how many keys the read benchmarks preload
+const benchKeys = 10000
+
+// setupBenchEngine opens the named engine in a temporary directory
+func setupBenchEngine(b *testing.B, name string, preload int) Engine {
+	b.Helper()
+
+	e, err := Open(name, b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { e.Close() })
+
+	value := make([]byte, 100)
+	for i := 0; i < preload; i++ {
+		if err := e.Put(fmt.Sprintf("bench_key_%08d", i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return e
+}
+
+// BenchmarkEngines runs the same workloads against every engine,
+// compare with go test -bench Engines ./internal/engine
+func BenchmarkEngines(b *testing.B) {
+	value := make([]byte, 100)
+
+	for _, name := range Names() {
+		b.Run(name+"/Put", func(b *testing.B) {
+			e := setupBenchEngine(b, name, 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := e.Put(fmt.Sprintf("bench_key_%08d", i), value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(name+"/Get", func(b *testing.B) {
+			e := setupBenchEngine(b, name, benchKeys)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := e.Get(fmt.Sprintf("bench_key_%08d", i%benchKeys)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(name+"/Scan100", func(b *testing.B) {
+			e := setupBenchEngine(b, name, benchKeys)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				start := fmt.Sprintf("bench_key_%08d", i%(benchKeys-100))
+				count := 0
+				err := e.Scan(start, "", func(string, []byte) bool {
+					count++
+					return count < 100
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
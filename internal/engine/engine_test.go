@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// scanKeys collects the keys a scan visits
+func scanKeys(t *testing.T, e Engine, start, end string) []string {
+	t.Helper()
+	var keys []string
+	err := e.Scan(start, end, func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+// TestEngines runs the same checks against every engine
+func TestEngines(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e, err := Open(name, dir)
+			assert.NoError(t, err)
+
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, e.Put(fmt.Sprintf("k%03d", i), []byte(fmt.Sprintf("v%d", i))))
+			}
+			assert.NoError(t, e.Put("k005", []byte("changed")))
+			assert.NoError(t, e.Delete("k006"))
+
+			val, err := e.Get("k005")
+			assert.NoError(t, err)
+			assert.Equal(t, "changed", string(val))
+
+			_, err = e.Get("k006")
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			assert.True(t, errors.Is(e.Delete("missing"), ErrKeyNotFound))
+
+			assert.Equal(t, []string{"k004", "k005", "k007"}, scanKeys(t, e, "k004", "k008"))
+			assert.Equal(t, 99, len(scanKeys(t, e, "", "")))
+
+			// Stop part way through
+			count := 0
+			assert.NoError(t, e.Scan("", "", func(string, []byte) bool {
+				count++
+				return count < 3
+			}))
+			assert.Equal(t, 3, count)
+
+			// Everything survives a reopen
+			assert.NoError(t, e.Sync())
+			assert.NoError(t, e.Close())
+			e, err = Open(name, dir)
+			assert.NoError(t, err)
+			defer e.Close()
+
+			val, err = e.Get("k005")
+			assert.NoError(t, err)
+			assert.Equal(t, "changed", string(val))
+			_, err = e.Get("k006")
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			assert.Equal(t, 99, len(scanKeys(t, e, "", "")))
+		})
+	}
+}
+
+func TestOpenUnknown(t *testing.T) {
+	_, err := Open("nope", t.TempDir())
+	assert.Error(t, err)
+}
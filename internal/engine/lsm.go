@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/yashagw/kvdb/internal/lsm"
+)
+
+// LSM is an Engine backed by an LSM tree. Only block indexes and
+// bloom filters are kept in memory, so the keys don't have to fit in RAM.
+type LSM struct {
+	db *lsm.DB
+}
+
+// OpenLSM opens (or creates) an LSM tree engine in path
+func OpenLSM(path string, cfg *lsm.Config) (*LSM, error) {
+	db, err := lsm.Open(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &LSM{db: db}, nil
+}
+
+// Get retrieves the value for key
+func (l *LSM) Get(key string) ([]byte, error) {
+	val, ok, err := l.db.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return val, nil
+}
+
+// Put stores a key-value pair
+func (l *LSM) Put(key string, value []byte) error {
+	return l.db.Put(key, value)
+}
+
+// Delete removes key
+func (l *LSM) Delete(key string) error {
+	ok, err := l.db.Delete(key)
+	if err != nil {
+		return fmt.Errorf("failed to write delete: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return nil
+}
+
+// Scan calls fn for each key in [start, end) in order
+func (l *LSM) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	return l.db.Scan(start, end, fn)
+}
+
+// Sync forces the WAL to disk
+func (l *LSM) Sync() error {
+	return l.db.Sync()
+}
+
+// Close closes the tree
+func (l *LSM) Close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,94 @@
+// Package glob matches keys against Redis style glob patterns, as used
+// by KEYS and SCAN MATCH
+package glob
+
+// Match reports whether s matches a Redis style glob pattern:
+// * matches any run of bytes, ? any one byte, [abc], [^abc] and [a-z]
+// a set of bytes, and \ escapes the next byte. Unlike path.Match, *
+// also matches /.
+func Match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest, ok := matchSet(pattern[1:], s[0])
+			if !ok {
+				// No closing ], match [ literally
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			if !matched {
+				return false
+			}
+			pattern, s = rest, s[1:]
+			continue
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchSet matches c against the set at the start of pattern, just
+// after its [. Returns the pattern after the closing ], and false for
+// ok if there isn't one.
+func matchSet(pattern string, c byte) (matched bool, rest string, ok bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']':
+			return matched != negate, pattern[i+1:], true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	return false, "", false
+}
@@ -0,0 +1,35 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"[abc", "[abc", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.pattern, tt.s), "%q ~ %q", tt.pattern, tt.s)
+	}
+}
@@ -0,0 +1,228 @@
+// Package client is a Go client for the kvdb gRPC service. Its methods
+// mirror Bitcask's, and the errors they return match the same bitcask
+// errors (ErrKeyNotFound, ErrConflict, ErrTooLarge, ErrReadOnly) with
+// errors.Is.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// Client talks to a kvdb gRPC server
+type Client struct {
+	conn *grpc.ClientConn // Set if Dial opened it
+	kv   kvdbpb.KVClient
+}
+
+// Dial returns a client for the server at target. Without options it
+// connects in plaintext.
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, kv: kvdbpb.NewKVClient(conn)}, nil
+}
+
+// New returns a client that uses conn, which the caller closes
+func New(conn grpc.ClientConnInterface) *Client {
+	return &Client{kv: kvdbpb.NewKVClient(conn)}
+}
+
+// Close closes the connection Dial opened
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// rpcError is a failed call. It matches the bitcask error its code
+// stands for as well as the gRPC status.
+type rpcError struct {
+	err    error
+	target error
+}
+
+func (e *rpcError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap lets errors.Is match the bitcask error and status.FromError
+// the status
+func (e *rpcError) Unwrap() []error {
+	return []error{e.err, e.target}
+}
+
+// fromStatus returns the error for a failed call
+func fromStatus(err error) error {
+	var target error
+	switch status.Code(err) {
+	case codes.NotFound:
+		target = bitcask.ErrKeyNotFound
+	case codes.FailedPrecondition:
+		target = bitcask.ErrConflict
+	case codes.ResourceExhausted:
+		target = bitcask.ErrTooLarge
+	case codes.PermissionDenied:
+		target = bitcask.ErrReadOnly
+	default:
+		return err
+	}
+	return &rpcError{err: err, target: target}
+}
+
+// Get retrieves a value by key
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := c.GetWithVersion(ctx, key)
+	return value, err
+}
+
+// GetWithVersion retrieves a value by key along with its version
+func (c *Client) GetWithVersion(ctx context.Context, key string) ([]byte, int64, error) {
+	resp, err := c.kv.Get(ctx, &kvdbpb.GetRequest{Key: []byte(key)})
+	if err != nil {
+		return nil, 0, fromStatus(err)
+	}
+	return resp.Value, resp.Version, nil
+}
+
+// Put stores a key-value pair
+func (c *Client) Put(ctx context.Context, key string, value []byte) error {
+	return c.PutWithTTL(ctx, key, value, 0)
+}
+
+// PutWithTTL stores a key-value pair that expires after ttl, or never
+// if ttl isn't positive
+func (c *Client) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.kv.Put(ctx, &kvdbpb.PutRequest{Key: []byte(key), Value: value, TtlMs: ttl.Milliseconds()})
+	return fromStatus(err)
+}
+
+// Delete deletes a key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.kv.Delete(ctx, &kvdbpb.DeleteRequest{Key: []byte(key)})
+	return fromStatus(err)
+}
+
+// Batch collects writes for Apply, like bitcask.Batch
+type Batch struct {
+	req kvdbpb.BatchWriteRequest
+}
+
+// Put adds a write of a key-value pair to the batch
+func (b *Batch) Put(key string, value []byte) {
+	b.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL adds a write of a key-value pair that expires after ttl
+func (b *Batch) PutWithTTL(key string, value []byte, ttl time.Duration) {
+	put := &kvdbpb.PutRequest{Key: []byte(key), Value: value, TtlMs: max(ttl.Milliseconds(), 0)}
+	b.req.Ops = append(b.req.Ops, &kvdbpb.BatchOp{Op: &kvdbpb.BatchOp_Put{Put: put}})
+}
+
+// Delete adds a delete of key to the batch. It isn't an error if the
+// key doesn't exist.
+func (b *Batch) Delete(key string) {
+	del := &kvdbpb.DeleteRequest{Key: []byte(key)}
+	b.req.Ops = append(b.req.Ops, &kvdbpb.BatchOp{Op: &kvdbpb.BatchOp_Delete{Delete: del}})
+}
+
+// Require makes Apply fail with ErrConflict unless key is at version.
+// Version 0 requires the key not to exist and bitcask.AnyVersion only
+// that it does.
+func (b *Batch) Require(key string, version int64) {
+	b.req.Conditions = append(b.req.Conditions, &kvdbpb.Condition{Key: []byte(key), Version: version})
+}
+
+// Apply writes a batch atomically and returns the version every key
+// it puts is now at
+func (c *Client) Apply(ctx context.Context, b *Batch) (int64, error) {
+	resp, err := c.kv.BatchWrite(ctx, &b.req)
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	return resp.Version, nil
+}
+
+// Scan calls fn for each key in [start, end) in order along with its
+// value, until fn returns false. An empty end means no upper bound.
+func (c *Client) Scan(ctx context.Context, start, end string, fn func(key string, value []byte) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Ends the stream if fn stops early
+
+	stream, err := c.kv.Scan(ctx, &kvdbpb.ScanRequest{Start: []byte(start), End: []byte(end)})
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	for {
+		kv, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		if !fn(string(kv.Key), kv.Value) {
+			return nil
+		}
+	}
+}
+
+// Event is a change to a watched key
+type Event struct {
+	Key     string
+	Deleted bool   // Whether the key was deleted rather than written
+	Version int64  // The key's version after a write, the timestamp of a delete
+	Value   []byte // The value when the event was sent, if the watch asked for values
+}
+
+// Watch receives the changes to keys with a prefix
+type Watch struct {
+	stream grpc.ServerStreamingClient[kvdbpb.WatchEvent]
+}
+
+// Watch starts watching keys that start with prefix, and with values
+// set also sends their values. It returns once the server is watching,
+// so every change made after is seen. Cancel ctx to stop.
+func (c *Client) Watch(ctx context.Context, prefix string, values bool) (*Watch, error) {
+	stream, err := c.kv.Watch(ctx, &kvdbpb.WatchRequest{Prefix: []byte(prefix), Values: values})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	if _, err := stream.Header(); err != nil {
+		return nil, fromStatus(err)
+	}
+	return &Watch{stream: stream}, nil
+}
+
+// Recv returns the next change. A watch that fell behind fails with
+// an error matching codes.ResourceExhausted.
+func (w *Watch) Recv() (Event, error) {
+	e, err := w.stream.Recv()
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Key:     string(e.Key),
+		Deleted: e.Type == kvdbpb.WatchEvent_DELETE,
+		Version: e.Version,
+		Value:   e.Value,
+	}, nil
+}
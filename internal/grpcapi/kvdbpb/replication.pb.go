@@ -0,0 +1,314 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: kvdbpb/replication.proto
+
+package kvdbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type FollowRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        uint32                 `protobuf:"varint,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FollowRequest) Reset() {
+	*x = FollowRequest{}
+	mi := &file_kvdbpb_replication_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FollowRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FollowRequest) ProtoMessage() {}
+
+func (x *FollowRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_replication_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FollowRequest.ProtoReflect.Descriptor instead.
+func (*FollowRequest) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_replication_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FollowRequest) GetFileId() uint32 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+func (x *FollowRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type LogRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`          // The whole value, empty for a delete
+	Timestamp     int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // The key's version after the write
+	Expires       int64                  `protobuf:"varint,4,opt,name=expires,proto3" json:"expires,omitempty"`     // Unix nanoseconds, 0 for never
+	Delete        bool                   `protobuf:"varint,5,opt,name=delete,proto3" json:"delete,omitempty"`
+	Batch         bool                   `protobuf:"varint,6,opt,name=batch,proto3" json:"batch,omitempty"` // Part of a batch that more records follow
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogRecord) Reset() {
+	*x = LogRecord{}
+	mi := &file_kvdbpb_replication_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogRecord) ProtoMessage() {}
+
+func (x *LogRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_replication_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogRecord.ProtoReflect.Descriptor instead.
+func (*LogRecord) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_replication_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LogRecord) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *LogRecord) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *LogRecord) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *LogRecord) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+func (x *LogRecord) GetDelete() bool {
+	if x != nil {
+		return x.Delete
+	}
+	return false
+}
+
+func (x *LogRecord) GetBatch() bool {
+	if x != nil {
+		return x.Batch
+	}
+	return false
+}
+
+// LogChunk is the next records in the log. Batches are never split
+// across chunks.
+type LogChunk struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Records []*LogRecord           `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	// The position after the records, to follow from next time
+	FileId        uint32 `protobuf:"varint,2,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Offset        int64  `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	Restarted     bool   `protobuf:"varint,4,opt,name=restarted,proto3" json:"restarted,omitempty"`               // The stream started over from the beginning of the log
+	CaughtUp      bool   `protobuf:"varint,5,opt,name=caught_up,json=caughtUp,proto3" json:"caught_up,omitempty"` // Nothing was left to send after these records
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogChunk) Reset() {
+	*x = LogChunk{}
+	mi := &file_kvdbpb_replication_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogChunk) ProtoMessage() {}
+
+func (x *LogChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_replication_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogChunk.ProtoReflect.Descriptor instead.
+func (*LogChunk) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_replication_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *LogChunk) GetRecords() []*LogRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *LogChunk) GetFileId() uint32 {
+	if x != nil {
+		return x.FileId
+	}
+	return 0
+}
+
+func (x *LogChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *LogChunk) GetRestarted() bool {
+	if x != nil {
+		return x.Restarted
+	}
+	return false
+}
+
+func (x *LogChunk) GetCaughtUp() bool {
+	if x != nil {
+		return x.CaughtUp
+	}
+	return false
+}
+
+var File_kvdbpb_replication_proto protoreflect.FileDescriptor
+
+const file_kvdbpb_replication_proto_rawDesc = "" +
+	"\n" +
+	"\x18kvdbpb/replication.proto\x12\akvdb.v1\"@\n" +
+	"\rFollowRequest\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\rR\x06fileId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\"\x99\x01\n" +
+	"\tLogRecord\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x1c\n" +
+	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x12\x18\n" +
+	"\aexpires\x18\x04 \x01(\x03R\aexpires\x12\x16\n" +
+	"\x06delete\x18\x05 \x01(\bR\x06delete\x12\x14\n" +
+	"\x05batch\x18\x06 \x01(\bR\x05batch\"\xa4\x01\n" +
+	"\bLogChunk\x12,\n" +
+	"\arecords\x18\x01 \x03(\v2\x12.kvdb.v1.LogRecordR\arecords\x12\x17\n" +
+	"\afile_id\x18\x02 \x01(\rR\x06fileId\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x03R\x06offset\x12\x1c\n" +
+	"\trestarted\x18\x04 \x01(\bR\trestarted\x12\x1b\n" +
+	"\tcaught_up\x18\x05 \x01(\bR\bcaughtUp2D\n" +
+	"\vReplication\x125\n" +
+	"\x06Follow\x12\x16.kvdb.v1.FollowRequest\x1a\x11.kvdb.v1.LogChunk0\x01B1Z/github.com/yashagw/kvdb/internal/grpcapi/kvdbpbb\x06proto3"
+
+var (
+	file_kvdbpb_replication_proto_rawDescOnce sync.Once
+	file_kvdbpb_replication_proto_rawDescData []byte
+)
+
+func file_kvdbpb_replication_proto_rawDescGZIP() []byte {
+	file_kvdbpb_replication_proto_rawDescOnce.Do(func() {
+		file_kvdbpb_replication_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_kvdbpb_replication_proto_rawDesc), len(file_kvdbpb_replication_proto_rawDesc)))
+	})
+	return file_kvdbpb_replication_proto_rawDescData
+}
+
+var file_kvdbpb_replication_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_kvdbpb_replication_proto_goTypes = []any{
+	(*FollowRequest)(nil), // 0: kvdb.v1.FollowRequest
+	(*LogRecord)(nil),     // 1: kvdb.v1.LogRecord
+	(*LogChunk)(nil),      // 2: kvdb.v1.LogChunk
+}
+var file_kvdbpb_replication_proto_depIdxs = []int32{
+	1, // 0: kvdb.v1.LogChunk.records:type_name -> kvdb.v1.LogRecord
+	0, // 1: kvdb.v1.Replication.Follow:input_type -> kvdb.v1.FollowRequest
+	2, // 2: kvdb.v1.Replication.Follow:output_type -> kvdb.v1.LogChunk
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_kvdbpb_replication_proto_init() }
+func file_kvdbpb_replication_proto_init() {
+	if File_kvdbpb_replication_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_kvdbpb_replication_proto_rawDesc), len(file_kvdbpb_replication_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_kvdbpb_replication_proto_goTypes,
+		DependencyIndexes: file_kvdbpb_replication_proto_depIdxs,
+		MessageInfos:      file_kvdbpb_replication_proto_msgTypes,
+	}.Build()
+	File_kvdbpb_replication_proto = out.File
+	file_kvdbpb_replication_proto_goTypes = nil
+	file_kvdbpb_replication_proto_depIdxs = nil
+}
@@ -0,0 +1,348 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: kvdbpb/sharding.proto
+
+package kvdbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// HashRange is the hashes after start up to and including end. If start
+// isn't below end the range wraps around past the largest hash.
+type HashRange struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Start         uint64                 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End           uint64                 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HashRange) Reset() {
+	*x = HashRange{}
+	mi := &file_kvdbpb_sharding_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HashRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HashRange) ProtoMessage() {}
+
+func (x *HashRange) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_sharding_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HashRange.ProtoReflect.Descriptor instead.
+func (*HashRange) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_sharding_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HashRange) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *HashRange) GetEnd() uint64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+type StreamRangesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ranges        []*HashRange           `protobuf:"bytes,1,rep,name=ranges,proto3" json:"ranges,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRangesRequest) Reset() {
+	*x = StreamRangesRequest{}
+	mi := &file_kvdbpb_sharding_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRangesRequest) ProtoMessage() {}
+
+func (x *StreamRangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_sharding_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRangesRequest.ProtoReflect.Descriptor instead.
+func (*StreamRangesRequest) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_sharding_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StreamRangesRequest) GetRanges() []*HashRange {
+	if x != nil {
+		return x.Ranges
+	}
+	return nil
+}
+
+type ShardRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           []byte                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value         []byte                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Version       int64                  `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	Expires       int64                  `protobuf:"varint,4,opt,name=expires,proto3" json:"expires,omitempty"` // Unix nanoseconds, 0 for never
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardRecord) Reset() {
+	*x = ShardRecord{}
+	mi := &file_kvdbpb_sharding_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardRecord) ProtoMessage() {}
+
+func (x *ShardRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_sharding_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardRecord.ProtoReflect.Descriptor instead.
+func (*ShardRecord) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_sharding_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ShardRecord) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *ShardRecord) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *ShardRecord) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ShardRecord) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+type IngestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*ShardRecord         `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestRequest) Reset() {
+	*x = IngestRequest{}
+	mi := &file_kvdbpb_sharding_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestRequest) ProtoMessage() {}
+
+func (x *IngestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_sharding_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestRequest.ProtoReflect.Descriptor instead.
+func (*IngestRequest) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_sharding_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IngestRequest) GetRecords() []*ShardRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type IngestResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestResponse) Reset() {
+	*x = IngestResponse{}
+	mi := &file_kvdbpb_sharding_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResponse) ProtoMessage() {}
+
+func (x *IngestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvdbpb_sharding_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResponse.ProtoReflect.Descriptor instead.
+func (*IngestResponse) Descriptor() ([]byte, []int) {
+	return file_kvdbpb_sharding_proto_rawDescGZIP(), []int{4}
+}
+
+var File_kvdbpb_sharding_proto protoreflect.FileDescriptor
+
+const file_kvdbpb_sharding_proto_rawDesc = "" +
+	"\n" +
+	"\x15kvdbpb/sharding.proto\x12\akvdb.v1\"3\n" +
+	"\tHashRange\x12\x14\n" +
+	"\x05start\x18\x01 \x01(\x04R\x05start\x12\x10\n" +
+	"\x03end\x18\x02 \x01(\x04R\x03end\"A\n" +
+	"\x13StreamRangesRequest\x12*\n" +
+	"\x06ranges\x18\x01 \x03(\v2\x12.kvdb.v1.HashRangeR\x06ranges\"i\n" +
+	"\vShardRecord\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\fR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\x03R\aversion\x12\x18\n" +
+	"\aexpires\x18\x04 \x01(\x03R\aexpires\"?\n" +
+	"\rIngestRequest\x12.\n" +
+	"\arecords\x18\x01 \x03(\v2\x14.kvdb.v1.ShardRecordR\arecords\"\x10\n" +
+	"\x0eIngestResponse2\x8b\x01\n" +
+	"\bSharding\x12D\n" +
+	"\fStreamRanges\x12\x1c.kvdb.v1.StreamRangesRequest\x1a\x14.kvdb.v1.ShardRecord0\x01\x129\n" +
+	"\x06Ingest\x12\x16.kvdb.v1.IngestRequest\x1a\x17.kvdb.v1.IngestResponseB1Z/github.com/yashagw/kvdb/internal/grpcapi/kvdbpbb\x06proto3"
+
+var (
+	file_kvdbpb_sharding_proto_rawDescOnce sync.Once
+	file_kvdbpb_sharding_proto_rawDescData []byte
+)
+
+func file_kvdbpb_sharding_proto_rawDescGZIP() []byte {
+	file_kvdbpb_sharding_proto_rawDescOnce.Do(func() {
+		file_kvdbpb_sharding_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_kvdbpb_sharding_proto_rawDesc), len(file_kvdbpb_sharding_proto_rawDesc)))
+	})
+	return file_kvdbpb_sharding_proto_rawDescData
+}
+
+var file_kvdbpb_sharding_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_kvdbpb_sharding_proto_goTypes = []any{
+	(*HashRange)(nil),           // 0: kvdb.v1.HashRange
+	(*StreamRangesRequest)(nil), // 1: kvdb.v1.StreamRangesRequest
+	(*ShardRecord)(nil),         // 2: kvdb.v1.ShardRecord
+	(*IngestRequest)(nil),       // 3: kvdb.v1.IngestRequest
+	(*IngestResponse)(nil),      // 4: kvdb.v1.IngestResponse
+}
+var file_kvdbpb_sharding_proto_depIdxs = []int32{
+	0, // 0: kvdb.v1.StreamRangesRequest.ranges:type_name -> kvdb.v1.HashRange
+	2, // 1: kvdb.v1.IngestRequest.records:type_name -> kvdb.v1.ShardRecord
+	1, // 2: kvdb.v1.Sharding.StreamRanges:input_type -> kvdb.v1.StreamRangesRequest
+	3, // 3: kvdb.v1.Sharding.Ingest:input_type -> kvdb.v1.IngestRequest
+	2, // 4: kvdb.v1.Sharding.StreamRanges:output_type -> kvdb.v1.ShardRecord
+	4, // 5: kvdb.v1.Sharding.Ingest:output_type -> kvdb.v1.IngestResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_kvdbpb_sharding_proto_init() }
+func file_kvdbpb_sharding_proto_init() {
+	if File_kvdbpb_sharding_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_kvdbpb_sharding_proto_rawDesc), len(file_kvdbpb_sharding_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_kvdbpb_sharding_proto_goTypes,
+		DependencyIndexes: file_kvdbpb_sharding_proto_depIdxs,
+		MessageInfos:      file_kvdbpb_sharding_proto_msgTypes,
+	}.Build()
+	File_kvdbpb_sharding_proto = out.File
+	file_kvdbpb_sharding_proto_goTypes = nil
+	file_kvdbpb_sharding_proto_depIdxs = nil
+}
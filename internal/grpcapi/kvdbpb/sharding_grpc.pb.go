@@ -0,0 +1,177 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: kvdbpb/sharding.proto
+
+package kvdbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Sharding_StreamRanges_FullMethodName = "/kvdb.v1.Sharding/StreamRanges"
+	Sharding_Ingest_FullMethodName       = "/kvdb.v1.Sharding/Ingest"
+)
+
+// ShardingClient is the client API for Sharding service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Sharding lets a rebalance stream the keys a server holds for parts
+// of a consistent hash ring, and write them to their new owner.
+type ShardingClient interface {
+	// StreamRanges sends every key whose hash is in one of the ranges,
+	// with its value, version and expiry
+	StreamRanges(ctx context.Context, in *StreamRangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ShardRecord], error)
+	// Ingest writes records streamed from another server, keeping their
+	// versions and expiry, except those older than what's already there
+	Ingest(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResponse, error)
+}
+
+type shardingClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShardingClient(cc grpc.ClientConnInterface) ShardingClient {
+	return &shardingClient{cc}
+}
+
+func (c *shardingClient) StreamRanges(ctx context.Context, in *StreamRangesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ShardRecord], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sharding_ServiceDesc.Streams[0], Sharding_StreamRanges_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamRangesRequest, ShardRecord]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sharding_StreamRangesClient = grpc.ServerStreamingClient[ShardRecord]
+
+func (c *shardingClient) Ingest(ctx context.Context, in *IngestRequest, opts ...grpc.CallOption) (*IngestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestResponse)
+	err := c.cc.Invoke(ctx, Sharding_Ingest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShardingServer is the server API for Sharding service.
+// All implementations must embed UnimplementedShardingServer
+// for forward compatibility.
+//
+// Sharding lets a rebalance stream the keys a server holds for parts
+// of a consistent hash ring, and write them to their new owner.
+type ShardingServer interface {
+	// StreamRanges sends every key whose hash is in one of the ranges,
+	// with its value, version and expiry
+	StreamRanges(*StreamRangesRequest, grpc.ServerStreamingServer[ShardRecord]) error
+	// Ingest writes records streamed from another server, keeping their
+	// versions and expiry, except those older than what's already there
+	Ingest(context.Context, *IngestRequest) (*IngestResponse, error)
+	mustEmbedUnimplementedShardingServer()
+}
+
+// UnimplementedShardingServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedShardingServer struct{}
+
+func (UnimplementedShardingServer) StreamRanges(*StreamRangesRequest, grpc.ServerStreamingServer[ShardRecord]) error {
+	return status.Error(codes.Unimplemented, "method StreamRanges not implemented")
+}
+func (UnimplementedShardingServer) Ingest(context.Context, *IngestRequest) (*IngestResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Ingest not implemented")
+}
+func (UnimplementedShardingServer) mustEmbedUnimplementedShardingServer() {}
+func (UnimplementedShardingServer) testEmbeddedByValue()                  {}
+
+// UnsafeShardingServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShardingServer will
+// result in compilation errors.
+type UnsafeShardingServer interface {
+	mustEmbedUnimplementedShardingServer()
+}
+
+func RegisterShardingServer(s grpc.ServiceRegistrar, srv ShardingServer) {
+	// If the following call panics, it indicates UnimplementedShardingServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Sharding_ServiceDesc, srv)
+}
+
+func _Sharding_StreamRanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShardingServer).StreamRanges(m, &grpc.GenericServerStream[StreamRangesRequest, ShardRecord]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sharding_StreamRangesServer = grpc.ServerStreamingServer[ShardRecord]
+
+func _Sharding_Ingest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IngestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardingServer).Ingest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sharding_Ingest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardingServer).Ingest(ctx, req.(*IngestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Sharding_ServiceDesc is the grpc.ServiceDesc for Sharding service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sharding_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kvdb.v1.Sharding",
+	HandlerType: (*ShardingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ingest",
+			Handler:    _Sharding_Ingest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRanges",
+			Handler:       _Sharding_StreamRanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kvdbpb/sharding.proto",
+}
@@ -0,0 +1,263 @@
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kvdbpb/kvdb.proto kvdbpb/replication.proto kvdbpb/sharding.proto
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// messageOverhead is room in a message for the key and the fields
+// besides the value
+const messageOverhead = 1 << 20
+
+// MaxMessageSize returns the message size limit that lets through
+// values of up to maxValueSize bytes, 0 meaning no limit. gRPC's own
+// default of 4MB is far under Bitcask's default MaxValueSize, and it
+// can't send a message over 2GB at all.
+func MaxMessageSize(maxValueSize int) int {
+	if maxValueSize <= 0 || maxValueSize > math.MaxInt32-messageOverhead {
+		return math.MaxInt32
+	}
+	return maxValueSize + messageOverhead
+}
+
+// Server implements the KV service (see kvdbpb/kvdb.proto) over a
+// Bitcask database
+type Server struct {
+	kvdbpb.UnimplementedKVServer
+	db *bitcask.Bitcask
+}
+
+// New returns a server for db. The caller still owns db and closes it
+// after the gRPC server.
+func New(db *bitcask.Bitcask) *Server {
+	return &Server{db: db}
+}
+
+// Register registers the KV service on gs
+func (s *Server) Register(gs *grpc.Server) {
+	kvdbpb.RegisterKVServer(gs, s)
+}
+
+// toStatus returns err as a gRPC status with the code that matches it
+func toStatus(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		code = codes.NotFound
+	case errors.Is(err, bitcask.ErrConflict):
+		code = codes.FailedPrecondition
+	case errors.Is(err, bitcask.ErrTooLarge):
+		code = codes.ResourceExhausted
+	case errors.Is(err, bitcask.ErrReadOnly):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, err.Error())
+}
+
+// checkKey rejects an empty key
+func checkKey(key []byte) error {
+	if len(key) == 0 {
+		return status.Error(codes.InvalidArgument, "empty key")
+	}
+	return nil
+}
+
+// Get returns the value and version of a key
+func (s *Server) Get(ctx context.Context, req *kvdbpb.GetRequest) (*kvdbpb.GetResponse, error) {
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+
+	value, version, err := s.db.GetWithVersion(string(req.Key))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &kvdbpb.GetResponse{Value: value, Version: version}, nil
+}
+
+// Put stores a key-value pair
+func (s *Server) Put(ctx context.Context, req *kvdbpb.PutRequest) (*kvdbpb.PutResponse, error) {
+	var b bitcask.Batch
+	if err := addPut(&b, req); err != nil {
+		return nil, err
+	}
+
+	version, err := s.db.Apply(&b)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &kvdbpb.PutResponse{Version: version}, nil
+}
+
+// Delete deletes a key
+func (s *Server) Delete(ctx context.Context, req *kvdbpb.DeleteRequest) (*kvdbpb.DeleteResponse, error) {
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if req.IfVersion == nil {
+		err = s.db.Delete(string(req.Key))
+	} else {
+		var b bitcask.Batch
+		addDelete(&b, req)
+		_, err = s.db.Apply(&b)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &kvdbpb.DeleteResponse{}, nil
+}
+
+// BatchWrite applies a batch of puts and deletes atomically
+func (s *Server) BatchWrite(ctx context.Context, req *kvdbpb.BatchWriteRequest) (*kvdbpb.BatchWriteResponse, error) {
+	if len(req.Ops) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no ops")
+	}
+
+	var b bitcask.Batch
+	for _, cond := range req.Conditions {
+		if err := checkKey(cond.Key); err != nil {
+			return nil, err
+		}
+		b.Require(string(cond.Key), cond.Version)
+	}
+	for _, op := range req.Ops {
+		switch op := op.Op.(type) {
+		case *kvdbpb.BatchOp_Put:
+			if err := addPut(&b, op.Put); err != nil {
+				return nil, err
+			}
+		case *kvdbpb.BatchOp_Delete:
+			if err := checkKey(op.Delete.Key); err != nil {
+				return nil, err
+			}
+			addDelete(&b, op.Delete)
+		default:
+			return nil, status.Error(codes.InvalidArgument, "empty op")
+		}
+	}
+
+	version, err := s.db.Apply(&b)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &kvdbpb.BatchWriteResponse{Version: version}, nil
+}
+
+// addPut adds a put and its condition to b
+func addPut(b *bitcask.Batch, req *kvdbpb.PutRequest) error {
+	if err := checkKey(req.Key); err != nil {
+		return err
+	}
+	if req.TtlMs < 0 {
+		return status.Error(codes.InvalidArgument, "negative ttl")
+	}
+
+	key := string(req.Key)
+	if req.IfVersion != nil {
+		b.Require(key, *req.IfVersion)
+	}
+	b.PutWithTTL(key, req.Value, time.Duration(req.TtlMs)*time.Millisecond)
+	return nil
+}
+
+// addDelete adds a delete and its condition to b
+func addDelete(b *bitcask.Batch, req *kvdbpb.DeleteRequest) {
+	key := string(req.Key)
+	if req.IfVersion != nil {
+		b.Require(key, *req.IfVersion)
+	}
+	b.Delete(key)
+}
+
+// Scan streams the keys in a range in order
+func (s *Server) Scan(req *kvdbpb.ScanRequest, stream grpc.ServerStreamingServer[kvdbpb.KeyValue]) error {
+	start, end := string(req.Start), string(req.End)
+
+	var keys []string
+	for _, key := range s.db.Keys() {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	sent := int64(0)
+	for _, key := range keys {
+		if req.Limit > 0 && sent == req.Limit {
+			break
+		}
+
+		value, version, err := s.db.GetWithVersion(key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue // Deleted since the keys were picked
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+
+		if err := stream.Send(&kvdbpb.KeyValue{Key: []byte(key), Value: value, Version: version}); err != nil {
+			return err
+		}
+		sent++
+	}
+	return nil
+}
+
+// Watch streams changes to keys with a prefix. The headers are sent
+// once the watch is in place, so a client that waits for them sees
+// every change made after.
+func (s *Server) Watch(req *kvdbpb.WatchRequest, stream grpc.ServerStreamingServer[kvdbpb.WatchEvent]) error {
+	w := s.db.Watch(string(req.Prefix))
+	defer w.Close()
+
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+
+		case e, ok := <-w.Events():
+			if !ok {
+				if err := w.Err(); err != nil {
+					return status.Error(codes.ResourceExhausted, err.Error())
+				}
+				return status.Error(codes.Unavailable, "database closed")
+			}
+
+			event := &kvdbpb.WatchEvent{Key: []byte(e.Key), Version: e.Version}
+			if e.Deleted {
+				event.Type = kvdbpb.WatchEvent_DELETE
+			} else if req.Values {
+				// The key may have changed again since, its own event
+				// follows
+				value, _, err := s.db.GetWithVersion(e.Key)
+				if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+					return toStatus(err)
+				}
+				event.Value = value
+			}
+
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
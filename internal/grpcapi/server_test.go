@@ -0,0 +1,208 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/client"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// startServer serves a fresh database over an in-process listener and
+// returns a client for it along with its connection
+func startServer(t *testing.T) (*client.Client, *grpc.ClientConn, *bitcask.Bitcask) {
+	t.Helper()
+
+	db, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	ln := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	New(db).Register(gs)
+	go gs.Serve(ln)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		gs.Stop()
+		assert.NoError(t, db.Close())
+	})
+	return client.New(conn), conn, db
+}
+
+func TestGetPutDelete(t *testing.T) {
+	c, _, db := startServer(t)
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, "name")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	assert.NoError(t, c.Put(ctx, "name", []byte("Alice")))
+	value, version, err := c.GetWithVersion(ctx, "name")
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", string(value))
+	want, err := db.Version("name")
+	assert.NoError(t, err)
+	assert.Equal(t, want, version)
+
+	// Binary keys and values make it through
+	assert.NoError(t, c.Put(ctx, "\xff\x00", []byte{0, 1, 2}))
+	value, err = c.Get(ctx, "\xff\x00")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2}, value)
+
+	// An empty value is stored, not taken for a delete
+	assert.NoError(t, c.Put(ctx, "empty", nil))
+	value, err = c.Get(ctx, "empty")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(value))
+
+	assert.NoError(t, c.PutWithTTL(ctx, "temp", []byte("v"), time.Hour))
+	expiry, err := db.Expiry("temp")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute)
+
+	assert.NoError(t, c.Delete(ctx, "name"))
+	err = c.Delete(ctx, "name")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+
+	err = c.Put(ctx, "", []byte("v"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestBatchWrite(t *testing.T) {
+	c, _, db := startServer(t)
+	ctx := context.Background()
+
+	assert.NoError(t, db.Put("gone", []byte("v")))
+	assert.NoError(t, db.Put("guarded", []byte("v1")))
+	guarded, err := db.Version("guarded")
+	assert.NoError(t, err)
+
+	var b client.Batch
+	b.Put("a", []byte("1"))
+	b.PutWithTTL("b", []byte("2"), time.Hour)
+	b.Put("empty", []byte{})
+	b.Delete("gone")
+	b.Delete("missing")
+	b.Require("guarded", guarded)
+	version, err := c.Apply(ctx, &b)
+	assert.NoError(t, err)
+
+	_, v, err := c.GetWithVersion(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, version, v)
+	value, err := c.Get(ctx, "empty")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(value))
+	_, err = c.Get(ctx, "gone")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound))
+
+	// A stale version fails the whole batch
+	assert.NoError(t, db.Put("guarded", []byte("v2")))
+	b = client.Batch{}
+	b.Put("c", []byte("3"))
+	b.Require("guarded", guarded)
+	_, err = c.Apply(ctx, &b)
+	assert.True(t, errors.Is(err, bitcask.ErrConflict), "got %v", err)
+	_, err = c.Get(ctx, "c")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound))
+
+	_, err = c.Apply(ctx, &client.Batch{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestConditionalWrites(t *testing.T) {
+	_, conn, db := startServer(t)
+	ctx := context.Background()
+
+	// The raw service takes if_version on single writes too
+	kv := kvdbpb.NewKVClient(conn)
+	zero, stale := int64(0), int64(1)
+
+	resp, err := kv.Put(ctx, &kvdbpb.PutRequest{Key: []byte("k"), Value: []byte("v1"), IfVersion: &zero})
+	assert.NoError(t, err)
+	_, err = kv.Put(ctx, &kvdbpb.PutRequest{Key: []byte("k"), Value: []byte("v2"), IfVersion: &zero})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = kv.Put(ctx, &kvdbpb.PutRequest{Key: []byte("k"), Value: []byte("v2"), IfVersion: &resp.Version})
+	assert.NoError(t, err)
+
+	_, err = kv.Delete(ctx, &kvdbpb.DeleteRequest{Key: []byte("k"), IfVersion: &stale})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	value, err := db.Get("k")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(value))
+}
+
+func TestScan(t *testing.T) {
+	c, _, db := startServer(t)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("k%02d", i), []byte(fmt.Sprintf("v%d", i))))
+	}
+
+	var keys []string
+	assert.NoError(t, c.Scan(ctx, "k05", "k10", func(key string, value []byte) bool {
+		keys = append(keys, key+"="+string(value))
+		return true
+	}))
+	assert.Equal(t, []string{"k05=v5", "k06=v6", "k07=v7", "k08=v8", "k09=v9"}, keys)
+
+	// Stopping early ends the stream
+	n := 0
+	assert.NoError(t, c.Scan(ctx, "", "", func(key string, value []byte) bool {
+		n++
+		return n < 3
+	}))
+	assert.Equal(t, 3, n)
+}
+
+func TestWatch(t *testing.T) {
+	c, _, db := startServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w, err := c.Watch(ctx, "user:", true)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Put(ctx, "user:1", []byte("Alice")))
+	assert.NoError(t, c.Put(ctx, "order:1", []byte("ignored")))
+	assert.NoError(t, db.Delete("user:1"))
+
+	e, err := w.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "user:1", e.Key)
+	assert.False(t, e.Deleted)
+	assert.Equal(t, "Alice", string(e.Value))
+
+	e, err = w.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "user:1", e.Key)
+	assert.True(t, e.Deleted)
+
+	// Cancelling ends the watch
+	cancel()
+	_, err = w.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
@@ -0,0 +1,114 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// batchRequest is the body of POST /v1/batch:
+//
+//	{"ops": [
+//	  {"op": "put", "key": "a", "value": "1", "ttl": "1h"},
+//	  {"op": "put", "key": "b", "value_base64": "AAE=", "if_match": "\"1718000000000000000\""},
+//	  {"op": "delete", "key": "c"}
+//	]}
+//
+// Either every op is applied or none are. Deleting a missing key
+// isn't an error.
+type batchRequest struct {
+	Ops []batchOp `json:"ops"`
+}
+
+// batchOp is one write in a batch request
+type batchOp struct {
+	Op          string  `json:"op"` // "put" or "delete"
+	Key         string  `json:"key"`
+	Value       *string `json:"value,omitempty"`         // Value as text
+	ValueBase64 []byte  `json:"value_base64,omitempty"`  // Value as base64, for binary values
+	TTL         string  `json:"ttl,omitempty"`           // Go duration the key expires after
+	IfMatch     string  `json:"if_match,omitempty"`      // Like the If-Match header, for this key
+	IfNoneMatch string  `json:"if_none_match,omitempty"` // Like the If-None-Match header, for this key
+}
+
+// batchResponse is the body of a successful POST /v1/batch
+type batchResponse struct {
+	ETag string `json:"etag"` // The ETag of every key the batch put
+}
+
+// handleBatch applies the ops of POST /v1/batch atomically
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.config.MaxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("%w: invalid JSON: %w", errBadRequest, err))
+		return
+	}
+	if len(req.Ops) == 0 {
+		writeError(w, r, fmt.Errorf("%w: no ops", errBadRequest))
+		return
+	}
+
+	var b bitcask.Batch
+	for i, op := range req.Ops {
+		if err := s.addOp(&b, op); err != nil {
+			writeError(w, r, fmt.Errorf("op %d: %w", i, err))
+			return
+		}
+	}
+
+	version, err := s.db.Apply(&b)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, batchResponse{ETag: formatETag(version)})
+}
+
+// addOp adds one op of a batch request, and its conditions, to b
+func (s *Server) addOp(b *bitcask.Batch, op batchOp) error {
+	if op.Key == "" {
+		return fmt.Errorf("%w: empty key", errBadRequest)
+	}
+
+	switch op.Op {
+	case "put":
+		if (op.Value == nil) == (op.ValueBase64 == nil) {
+			return fmt.Errorf("%w: put needs one of value and value_base64", errBadRequest)
+		}
+		value := op.ValueBase64
+		if op.Value != nil {
+			value = []byte(*op.Value)
+		}
+
+		var ttl time.Duration
+		if op.TTL != "" {
+			var err error
+			if ttl, err = parseTTL(op.TTL); err != nil {
+				return err
+			}
+		}
+
+		if err := s.require(b, op.Key, op.IfMatch, op.IfNoneMatch); err != nil {
+			return err
+		}
+		b.PutWithTTL(op.Key, value, ttl)
+
+	case "delete":
+		if op.Value != nil || op.ValueBase64 != nil || op.TTL != "" {
+			return fmt.Errorf("%w: delete takes no value or ttl", errBadRequest)
+		}
+		if err := s.require(b, op.Key, op.IfMatch, op.IfNoneMatch); err != nil {
+			return err
+		}
+		b.Delete(op.Key)
+
+	default:
+		return fmt.Errorf("%w: unknown op %q", errBadRequest, op.Op)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// A key's ETag is its version (the timestamp of its last write) in
+// quotes. Versions survive merges, so ETags stay valid across them.
+
+// formatETag returns the ETag of a key at version
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETags returns the versions in an If-Match or If-None-Match
+// header that isn't "*". Weak ETags only count if weak is set, as
+// If-Match compares strongly. ETags that aren't ours never match.
+func parseETags(header string, weak bool) []int64 {
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if rest, ok := strings.CutPrefix(tag, "W/"); ok {
+			if !weak {
+				continue
+			}
+			tag = rest
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		if v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// require adds the conditions of If-Match and If-None-Match headers
+// on key to b, so Apply checks them atomically with the writes
+func (s *Server) require(b *bitcask.Batch, key, ifMatch, ifNoneMatch string) error {
+	if ifMatch == "*" {
+		b.Require(key, bitcask.AnyVersion)
+		ifMatch = ""
+	}
+	if ifNoneMatch == "*" {
+		b.Require(key, 0)
+		ifNoneMatch = ""
+	}
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+
+	// A list of ETags is checked against the current version now,
+	// and Apply makes sure it hasn't changed since
+	current, err := s.db.Version(key)
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return err
+	}
+	if ifMatch != "" && (current == 0 || !slices.Contains(parseETags(ifMatch, false), current)) {
+		return conflict(key, current)
+	}
+	if ifNoneMatch != "" && current != 0 && slices.Contains(parseETags(ifNoneMatch, true), current) {
+		return conflict(key, current)
+	}
+	b.Require(key, current)
+	return nil
+}
+
+// conflict returns the error for a precondition on key failing
+func conflict(key string, current int64) error {
+	if current == 0 {
+		return fmt.Errorf("%w: %q doesn't exist", bitcask.ErrConflict, key)
+	}
+	return fmt.Errorf("%w: %q is at %s", bitcask.ErrConflict, key, formatETag(current))
+}
@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// pathKey returns the key a /v1/keys/{key} request is for
+func pathKey(r *http.Request) (string, error) {
+	key := r.PathValue("key")
+	if key == "" {
+		return "", fmt.Errorf("%w: empty key", errBadRequest)
+	}
+	return key, nil
+}
+
+// handleGet answers GET /v1/keys/{key} with the raw value
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	key, err := pathKey(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	value, version, err := s.db.GetWithVersion(key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	etag := formatETag(version)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm == "*" || slices.Contains(parseETags(inm, true), version) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+// handlePut stores the body of PUT /v1/keys/{key}
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	key, err := pathKey(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		if ttl, err = parseTTL(v); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxBodySize))
+	if err != nil {
+		writeError(w, r, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+
+	var b bitcask.Batch
+	if err := s.require(&b, key, r.Header.Get("If-Match"), r.Header.Get("If-None-Match")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	b.PutWithTTL(key, value, ttl)
+
+	version, err := s.db.Apply(&b)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(version))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete deletes the key of DELETE /v1/keys/{key}
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key, err := pathKey(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		err = s.db.Delete(key)
+	} else {
+		// A batch doesn't mind deleting a missing key, so check for
+		// it first to answer 404 like a plain DELETE
+		if _, err = s.db.Version(key); err == nil {
+			var b bitcask.Batch
+			if err = s.require(&b, key, ifMatch, ifNoneMatch); err == nil {
+				b.Delete(key)
+				_, err = s.db.Apply(&b)
+			}
+		}
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTTL parses a TTL given as a Go duration such as 1h30m
+func parseTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("%w: invalid ttl %q", errBadRequest, s)
+	}
+	return ttl, nil
+}
+
+// listResponse is the body of GET /v1/keys
+type listResponse struct {
+	Keys   []string `json:"keys"`
+	Cursor string   `json:"cursor,omitempty"` // Pass back to get the next page, empty on the last one
+}
+
+// handleList answers GET /v1/keys with keys in order. The cursor is
+// the last key of the page, base64 encoded, so keys written between
+// requests don't shift the pages.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	limit := s.config.DefaultLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > s.config.MaxLimit {
+			writeError(w, r, fmt.Errorf("%w: limit must be from 1 to %d", errBadRequest, s.config.MaxLimit))
+			return
+		}
+		limit = n
+	}
+
+	var after string
+	if v := query.Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("%w: invalid cursor", errBadRequest))
+			return
+		}
+		after = string(b)
+	}
+
+	var keys []string
+	for _, key := range s.db.Keys() {
+		if strings.HasPrefix(key, prefix) && (after == "" || key > after) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	resp := listResponse{Keys: keys}
+	if len(keys) > limit {
+		resp.Keys = keys[:limit]
+		resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(keys[limit-1]))
+	}
+	if resp.Keys == nil {
+		resp.Keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
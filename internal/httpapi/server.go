@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// Config holds configuration options for the HTTP API
+type Config struct {
+	MaxBodySize  int64 // Largest request body accepted in bytes
+	DefaultLimit int   // Keys listed when a request doesn't set a limit
+	MaxLimit     int   // Most keys listed in one response
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		MaxBodySize:  64 * 1024 * 1024, // 64MB
+		DefaultLimit: 100,
+		MaxLimit:     1000,
+	}
+}
+
+// errBadRequest is wrapped by errors in what a client sent
+var errBadRequest = errors.New("bad request")
+
+// Server serves a Bitcask database over HTTP with JSON responses:
+//
+//	GET    /v1/keys/{key}   the raw value, with its version as the ETag
+//	PUT    /v1/keys/{key}   store the request body, ?ttl=30s to expire it
+//	DELETE /v1/keys/{key}
+//	GET    /v1/keys         list keys, ?prefix=&limit=&cursor=
+//	POST   /v1/batch        apply puts and deletes atomically
+//
+// PUT and DELETE honour If-Match and If-None-Match, and GET
+// If-None-Match.
+type Server struct {
+	db     *bitcask.Bitcask
+	config *Config
+	mux    *http.ServeMux
+}
+
+// New returns a handler for db. The caller still owns db and closes it
+// after the HTTP server.
+func New(db *bitcask.Bitcask, cfg *Config) *Server {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	s := &Server{db: db, config: cfg, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /v1/keys/{key...}", s.handleGet)
+	s.mux.HandleFunc("PUT /v1/keys/{key...}", s.handlePut)
+	s.mux.HandleFunc("DELETE /v1/keys/{key...}", s.handleDelete)
+	s.mux.HandleFunc("GET /v1/keys", s.handleList)
+	s.mux.HandleFunc("POST /v1/batch", s.handleBatch)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// writeJSON writes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse is the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError answers with the status that matches err
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytes *http.MaxBytesError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, bitcask.ErrConflict):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, bitcask.ErrTooLarge), errors.As(err, &maxBytes):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, bitcask.ErrReadOnly):
+		status = http.StatusForbidden
+	default:
+		log.Printf("httpapi: %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,257 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// startServer serves a fresh database over HTTP on loopback
+func startServer(t *testing.T) (*httptest.Server, *bitcask.Bitcask) {
+	t.Helper()
+
+	db, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	cfg := DefaultConfig()
+	cfg.MaxBodySize = 1024
+	srv := httptest.NewServer(New(db, cfg))
+	t.Cleanup(func() {
+		srv.Close()
+		assert.NoError(t, db.Close())
+	})
+	return srv, db
+}
+
+// do sends a request and returns the response with its body read
+func do(t *testing.T, srv *httptest.Server, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	for i := 0; i < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := srv.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestKeys(t *testing.T) {
+	srv, _ := startServer(t)
+
+	resp, body := do(t, srv, "GET", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, `{"error":"key not found: name"}`+"\n", body)
+
+	resp, _ = do(t, srv, "PUT", "/v1/keys/name", "Alice")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	resp, body = do(t, srv, "GET", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Alice", body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+
+	// Keys can have slashes and escaped bytes
+	resp, _ = do(t, srv, "PUT", "/v1/keys/users/1%2F2%20x", "v")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, body = do(t, srv, "GET", "/v1/keys/users/1%2F2%20x", "")
+	assert.Equal(t, "v", body)
+
+	// An empty body stores an empty value, it doesn't delete
+	resp, _ = do(t, srv, "PUT", "/v1/keys/empty", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, body = do(t, srv, "GET", "/v1/keys/empty", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", body)
+	resp, _ = do(t, srv, "PUT", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(t, srv, "GET", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = do(t, srv, "PUT", "/v1/keys/name", "Alice")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, _ = do(t, srv, "DELETE", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(t, srv, "DELETE", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(t, srv, "GET", "/v1/keys/", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = do(t, srv, "POST", "/v1/keys/name", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestPutLimitsAndTTL(t *testing.T) {
+	srv, db := startServer(t)
+
+	resp, _ := do(t, srv, "PUT", "/v1/keys/big", strings.Repeat("x", 1025))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, _ = do(t, srv, "PUT", "/v1/keys/k?ttl=soon", "v")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = do(t, srv, "PUT", "/v1/keys/k?ttl=1h", "v")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	expiry, err := db.Expiry("k")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	srv, _ := startServer(t)
+
+	// If-None-Match: * only creates
+	resp, _ := do(t, srv, "PUT", "/v1/keys/k", "v1", "If-None-Match", "*")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	v1 := resp.Header.Get("ETag")
+	resp, _ = do(t, srv, "PUT", "/v1/keys/k", "v2", "If-None-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = do(t, srv, "GET", "/v1/keys/k", "", "If-None-Match", v1)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// Compare and swap on the ETag
+	resp, _ = do(t, srv, "PUT", "/v1/keys/k", "v2", "If-Match", v1)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	v2 := resp.Header.Get("ETag")
+	assert.NotEqual(t, v1, v2)
+
+	resp, body := do(t, srv, "PUT", "/v1/keys/k", "v3", "If-Match", v1)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Contains(t, body, "version conflict")
+	resp, _ = do(t, srv, "PUT", "/v1/keys/k", "v3", "If-Match", `"1", `+v2)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(t, srv, "PUT", "/v1/keys/missing", "v", "If-Match", "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = do(t, srv, "GET", "/v1/keys/k", "", "If-None-Match", "W/"+v2)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = do(t, srv, "DELETE", "/v1/keys/k", "", "If-Match", v2)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	_, body = do(t, srv, "GET", "/v1/keys/k", "")
+	assert.Equal(t, "v3", body)
+
+	resp, _ = do(t, srv, "GET", "/v1/keys/k", "")
+	resp, _ = do(t, srv, "DELETE", "/v1/keys/k", "", "If-Match", resp.Header.Get("ETag"))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestList(t *testing.T) {
+	srv, db := startServer(t)
+
+	for _, key := range []string{"user:3", "user:1", "user:2", "user:10", "order:1"} {
+		assert.NoError(t, db.Put(key, []byte("v")))
+	}
+
+	list := func(query string) (int, listResponse) {
+		resp, body := do(t, srv, "GET", "/v1/keys"+query, "")
+		var lr listResponse
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(body), &lr))
+		}
+		return resp.StatusCode, lr
+	}
+
+	status, lr := list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, listResponse{Keys: []string{"order:1", "user:1", "user:10", "user:2", "user:3"}}, lr)
+
+	// Page through a prefix two keys at a time
+	var keys []string
+	query := url.Values{"prefix": {"user:"}, "limit": {"2"}}
+	for pages := 1; ; pages++ {
+		status, lr := list("?" + query.Encode())
+		assert.Equal(t, http.StatusOK, status)
+		keys = append(keys, lr.Keys...)
+		if lr.Cursor == "" {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		query.Set("cursor", lr.Cursor)
+
+		// A key written behind the cursor doesn't shift the pages
+		assert.NoError(t, db.Put("user:0", []byte("v")))
+	}
+	assert.Equal(t, []string{"user:1", "user:10", "user:2", "user:3"}, keys)
+
+	status, lr = list("?prefix=none")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, listResponse{Keys: []string{}}, lr)
+
+	for _, query := range []string{"?limit=0", "?limit=1001", "?limit=x", "?cursor=!"} {
+		status, _ := list(query)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv, db := startServer(t)
+
+	assert.NoError(t, db.Put("gone", []byte("v")))
+	assert.NoError(t, db.Put("guarded", []byte("v")))
+
+	resp, body := do(t, srv, "POST", "/v1/batch", `{"ops": [
+		{"op": "put", "key": "text", "value": "hello", "ttl": "1h"},
+		{"op": "put", "key": "binary", "value_base64": "AAEC"},
+		{"op": "put", "key": "empty", "value": ""},
+		{"op": "delete", "key": "gone"},
+		{"op": "delete", "key": "never-existed"}
+	]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, body)
+	var br batchResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &br))
+
+	resp, body = do(t, srv, "GET", "/v1/keys/text", "")
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, br.ETag, resp.Header.Get("ETag"))
+	_, body = do(t, srv, "GET", "/v1/keys/binary", "")
+	assert.Equal(t, "\x00\x01\x02", body)
+	resp, body = do(t, srv, "GET", "/v1/keys/empty", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "", body)
+	resp, _ = do(t, srv, "GET", "/v1/keys/gone", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// One failed condition and nothing is written
+	resp, _ = do(t, srv, "POST", "/v1/batch", `{"ops": [
+		{"op": "put", "key": "new", "value": "v"},
+		{"op": "delete", "key": "guarded", "if_match": "\"1\""}
+	]}`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = do(t, srv, "GET", "/v1/keys/new", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = do(t, srv, "GET", "/v1/keys/guarded", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, bad := range []string{
+		`not json`,
+		`{"ops": []}`,
+		`{"ops": [{"op": "get", "key": "k"}]}`,
+		`{"ops": [{"op": "put", "key": "", "value": "v"}]}`,
+		`{"ops": [{"op": "put", "key": "k"}]}`,
+		`{"ops": [{"op": "put", "key": "k", "value": "v", "value_base64": "AA=="}]}`,
+		`{"ops": [{"op": "put", "key": "k", "value": "v", "ttl": "-1s"}]}`,
+		`{"ops": [{"op": "delete", "key": "k", "value": "v"}]}`,
+		`{"ops": [], "extra": 1}`,
+	} {
+		resp, _ := do(t, srv, "POST", "/v1/batch", bad)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, bad)
+	}
+}
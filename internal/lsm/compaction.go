@@ -0,0 +1,141 @@
+package lsm
+
+import (
+	"slices"
+	"strings"
+)
+
+// compact runs compactions until every level is within its limits.
+//
+// Compaction is leveled: level 0 is merged into level 1 once it has
+// L0CompactionTrigger tables, and a level below that is compacted
+// once it holds more than its size limit by merging one of its tables
+// (round robin through the key space) with the tables it overlaps in
+// the next level.
+func (db *DB) compact() error {
+	for {
+		level, ok := db.pickLevel()
+		if !ok {
+			return nil
+		}
+		if err := db.compactLevel(level); err != nil {
+			return err
+		}
+	}
+}
+
+// pickLevel returns the level that needs compacting, if any
+func (db *DB) pickLevel() (int, bool) {
+	if len(db.levels[0]) >= db.config.L0CompactionTrigger {
+		return 0, true
+	}
+	for level := 1; level < numLevels-1; level++ {
+		if levelBytes(db.levels[level]) > db.maxLevelBytes(level) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// maxLevelBytes returns the size limit of a level below 0
+func (db *DB) maxLevelBytes(level int) int64 {
+	size := db.config.BaseLevelSize
+	for i := 1; i < level; i++ {
+		size *= int64(db.config.LevelSizeMultiplier)
+	}
+	return size
+}
+
+func levelBytes(tables []*table) int64 {
+	var size int64
+	for _, t := range tables {
+		size += t.size
+	}
+	return size
+}
+
+// pickTable returns the table of level to compact next, the first
+// one after where the last compaction of the level ended
+func (db *DB) pickTable(level int) *table {
+	for _, t := range db.levels[level] {
+		if t.smallest > db.compactPointer[level] {
+			return t
+		}
+	}
+	return db.levels[level][0]
+}
+
+// compactLevel merges tables of level into the next level
+func (db *DB) compactLevel(level int) error {
+	var inputs []*table
+	if level == 0 {
+		// Level 0 tables may overlap each other, so they all go at once
+		inputs = slices.Clone(db.levels[0])
+	} else {
+		inputs = []*table{db.pickTable(level)}
+	}
+
+	smallest, largest := inputs[0].smallest, inputs[0].largest
+	for _, t := range inputs[1:] {
+		smallest = min(smallest, t.smallest)
+		largest = max(largest, t.largest)
+	}
+
+	var overlapping, kept []*table
+	for _, t := range db.levels[level+1] {
+		if t.overlaps(smallest, largest) {
+			overlapping = append(overlapping, t)
+		} else {
+			kept = append(kept, t)
+		}
+	}
+
+	// A tombstone only has to be kept while an older value of its key
+	// might still be in a level further down
+	dropTombstones := true
+	for below := level + 2; below < numLevels; below++ {
+		for _, t := range db.levels[below] {
+			if t.overlaps(smallest, largest) {
+				dropTombstones = false
+			}
+		}
+	}
+
+	// Newest first: the inputs (level 0 is already newest first), then
+	// the next level
+	var iters []iterator
+	for _, t := range inputs {
+		iters = append(iters, t.iterator("", ""))
+	}
+	for _, t := range overlapping {
+		iters = append(iters, t.iterator("", ""))
+	}
+	it := newMergeIterator(iters)
+	outputs, err := db.writeTables(it, dropTombstones, db.config.TargetFileSize)
+	it.Close()
+	if err != nil {
+		return err
+	}
+
+	// Install the outputs in place of what they were merged from
+	next := append(kept, outputs...)
+	slices.SortFunc(next, func(a, b *table) int {
+		return strings.Compare(a.smallest, b.smallest)
+	})
+	db.levels[level+1] = next
+	db.levels[level] = slices.DeleteFunc(db.levels[level], func(t *table) bool {
+		return slices.Contains(inputs, t)
+	})
+	db.compactPointer[level] = largest
+
+	if err := db.saveManifest(); err != nil {
+		return err
+	}
+
+	// The old tables go once the scans still reading them are done
+	for _, t := range append(inputs, overlapping...) {
+		t.obsolete.Store(true)
+		t.unref()
+	}
+	return nil
+}
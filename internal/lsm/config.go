@@ -0,0 +1,27 @@
+package lsm
+
+// Config holds configuration options for an LSM tree
+type Config struct {
+	MemtableSize        int64   // Flush the memtable to an SSTable once it holds this many bytes
+	BlockSize           int     // Target size of an SSTable data block
+	BloomFPR            float64 // False positive rate of each SSTable's bloom filter, 0 disables filters
+	TargetFileSize      int64   // Compaction splits its output into SSTables of about this size
+	L0CompactionTrigger int     // Compact level 0 into level 1 once it holds this many SSTables
+	BaseLevelSize       int64   // Maximum bytes in level 1 before it's compacted into level 2
+	LevelSizeMultiplier int     // How many times bigger each level below 1 may get than the one above
+	SyncWrites          bool    // Whether to sync the WAL on every write
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		MemtableSize:        4 * 1024 * 1024, // 4MB
+		BlockSize:           4 * 1024,        // 4KB
+		BloomFPR:            0.01,
+		TargetFileSize:      2 * 1024 * 1024, // 2MB
+		L0CompactionTrigger: 4,
+		BaseLevelSize:       10 * 1024 * 1024, // 10MB
+		LevelSizeMultiplier: 10,
+		SyncWrites:          false,
+	}
+}
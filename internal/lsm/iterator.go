@@ -0,0 +1,97 @@
+package lsm
+
+import "container/heap"
+
+// iterator walks entries in key order
+type iterator interface {
+	// Next moves to the next entry, false at the end or on an error
+	Next() bool
+	// Entry returns the current entry
+	Entry() entry
+	// Err returns the error that stopped the iterator, if any
+	Err() error
+	// Close releases the iterator
+	Close()
+}
+
+// mergeIterator merges several iterators into one, ordered by key.
+// Iterators are given newest first: when several hold the same key
+// only the entry from the newest one comes out.
+type mergeIterator struct {
+	iters []iterator
+	heap  mergeHeap
+	cur   entry
+	err   error
+}
+
+func newMergeIterator(iters []iterator) *mergeIterator {
+	m := &mergeIterator{iters: iters}
+	for i := range iters {
+		m.advance(i)
+	}
+	return m
+}
+
+// advance moves iterator i forward and puts it back on the heap
+func (m *mergeIterator) advance(i int) {
+	it := m.iters[i]
+	if it.Next() {
+		heap.Push(&m.heap, mergeItem{key: it.Entry().key, index: i})
+		return
+	}
+	if err := it.Err(); err != nil && m.err == nil {
+		m.err = err
+	}
+}
+
+func (m *mergeIterator) Next() bool {
+	if m.err != nil || m.heap.Len() == 0 {
+		return false
+	}
+
+	top := heap.Pop(&m.heap).(mergeItem)
+	m.cur = m.iters[top.index].Entry()
+	m.advance(top.index)
+
+	// Older versions of the same key are shadowed
+	for m.heap.Len() > 0 && m.heap[0].key == m.cur.key {
+		item := heap.Pop(&m.heap).(mergeItem)
+		m.advance(item.index)
+	}
+
+	return m.err == nil
+}
+
+func (m *mergeIterator) Entry() entry { return m.cur }
+func (m *mergeIterator) Err() error   { return m.err }
+
+func (m *mergeIterator) Close() {
+	for _, it := range m.iters {
+		it.Close()
+	}
+}
+
+// mergeItem is an iterator on the heap and the key it's at
+type mergeItem struct {
+	key   string
+	index int // Position in mergeIterator.iters, lower is newer
+}
+
+// mergeHeap orders iterators by key, then newest first
+type mergeHeap []mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
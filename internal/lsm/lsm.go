@@ -0,0 +1,457 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yashagw/kvdb/internal/bplustree"
+)
+
+const (
+	walFileName = "lsm.wal"
+
+	// numLevels is the number of levels SSTables can live in
+	numLevels = 7
+)
+
+// DB is a log-structured merge tree.
+//
+// Writes go to a WAL and then a memtable. Once the memtable is big
+// enough it's flushed to an SSTable in level 0, and compaction merges
+// SSTables down the levels so reads only have to look at a few files.
+// Unlike Bitcask only the SSTables' block indexes and bloom filters
+// are kept in memory, not every key. The filters let a Get of a
+// missing key skip almost every SSTable without reading from it.
+//
+// Level 0 holds flushed memtables, newest first, whose key ranges may
+// overlap. Every other level is a sorted run of SSTables with disjoint
+// key ranges. A key in a lower level is always newer than the same
+// key in a higher one.
+type DB struct {
+	mu     sync.RWMutex
+	dir    string
+	config *Config
+
+	wal        *bplustree.WAL
+	lsn        uint64 // Last LSN written to the WAL
+	flushedLSN uint64 // Last LSN flushed to an SSTable
+	mem        *memtable
+
+	levels         [numLevels][]*table
+	nextFileID     uint64
+	compactPointer [numLevels]string // Where the next compaction of each level starts
+}
+
+// Open opens (or creates) an LSM tree in dir
+func Open(dir string, cfg *Config) (*DB, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	db := &DB{
+		dir:        dir,
+		config:     cfg,
+		mem:        newMemtable(),
+		nextFileID: 1,
+	}
+
+	if err := db.loadTables(); err != nil {
+		db.closeTables()
+		return nil, fmt.Errorf("failed to load sstables: %w", err)
+	}
+
+	wal, err := bplustree.OpenWAL(filepath.Join(dir, walFileName))
+	if err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	db.wal = wal
+
+	// Rebuild the memtable from whatever wasn't flushed
+	err = wal.Replay(func(rec *bplustree.WALRecord) error {
+		db.lsn = max(db.lsn, rec.LSN)
+		if rec.LSN > db.flushedLSN {
+			db.applyRecord(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to replay wal: %w", err)
+	}
+	db.lsn = max(db.lsn, db.flushedLSN)
+
+	return db, nil
+}
+
+// loadTables opens the SSTables listed in the manifest and removes
+// any left behind by a flush or compaction that didn't finish
+func (db *DB) loadTables() error {
+	m, err := readManifest(db.dir)
+	if err != nil {
+		return err
+	}
+
+	live := make(map[uint64]bool)
+	if m != nil {
+		db.flushedLSN = m.flushedLSN
+		db.nextFileID = m.nextFileID
+		for _, mt := range m.tables {
+			t, err := openTable(db.tablePath(mt.id), mt.id)
+			if err != nil {
+				return err
+			}
+			db.levels[mt.level] = append(db.levels[mt.level], t)
+			live[mt.id] = true
+		}
+	}
+
+	files, err := os.ReadDir(db.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		idStr, ok := strings.CutSuffix(file.Name(), ".sst")
+		if !ok {
+			idStr, ok = strings.CutSuffix(file.Name(), ".bloom")
+		}
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil || live[id] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(db.dir, file.Name())); err != nil {
+			return fmt.Errorf("failed to remove orphaned file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) tablePath(id uint64) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%010d.sst", id))
+}
+
+// Get retrieves the value for key
+func (db *DB) Get(key string) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	e, found, err := db.get(key)
+	if err != nil || !found || e.deleted {
+		return nil, false, err
+	}
+	return e.value, true, nil
+}
+
+// get finds the newest entry for key, looking at the memtable, then
+// level 0 newest first, then one table in each level below that
+func (db *DB) get(key string) (entry, bool, error) {
+	if e, ok := db.mem.get(key); ok {
+		return e, true, nil
+	}
+
+	for _, t := range db.levels[0] {
+		if e, ok, err := t.get(key); err != nil || ok {
+			return e, ok, err
+		}
+	}
+
+	for level := 1; level < numLevels; level++ {
+		tables := db.levels[level]
+		i := sort.Search(len(tables), func(i int) bool {
+			return tables[i].largest >= key
+		})
+		if i == len(tables) {
+			continue
+		}
+		if e, ok, err := tables[i].get(key); err != nil || ok {
+			return e, ok, err
+		}
+	}
+
+	return entry{}, false, nil
+}
+
+// Put stores a key-value pair
+func (db *DB) Put(key string, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.write(&bplustree.WALRecord{LSN: db.lsn + 1, Type: bplustree.WALPut, Key: key, Val: string(value)})
+}
+
+// Delete removes key, returns false if it wasn't present
+func (db *DB) Delete(key string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	e, found, err := db.get(key)
+	if err != nil {
+		return false, err
+	}
+	if !found || e.deleted {
+		return false, nil
+	}
+
+	return true, db.write(&bplustree.WALRecord{LSN: db.lsn + 1, Type: bplustree.WALDelete, Key: key})
+}
+
+// write logs a record, applies it to the memtable and flushes the
+// memtable if it's full
+func (db *DB) write(rec *bplustree.WALRecord) error {
+	if err := db.wal.Append(rec); err != nil {
+		return err
+	}
+	if db.config.SyncWrites {
+		if err := db.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	db.lsn = rec.LSN
+	db.applyRecord(rec)
+
+	if db.mem.size < db.config.MemtableSize {
+		return nil
+	}
+	if err := db.flush(); err != nil {
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+	if err := db.compact(); err != nil {
+		return fmt.Errorf("failed to compact: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) applyRecord(rec *bplustree.WALRecord) {
+	if rec.Type == bplustree.WALDelete {
+		db.mem.delete(rec.Key)
+	} else {
+		db.mem.put(rec.Key, []byte(rec.Val))
+	}
+}
+
+// Scan calls fn for each key in [start, end) in order along with its
+// value, until fn returns false. An empty end means no upper bound.
+// fn may write to the tree: the scan works on the SSTables that were
+// live when it started, which stay around until it's done.
+func (db *DB) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	db.mu.RLock()
+	iters := []iterator{db.mem.iterator(start, end)}
+	var tables []*table
+	for level := range db.levels {
+		for _, t := range db.levels[level] {
+			if t.overlaps(start, end) {
+				t.ref()
+				tables = append(tables, t)
+				iters = append(iters, t.iterator(start, end))
+			}
+		}
+	}
+	db.mu.RUnlock()
+
+	defer func() {
+		for _, t := range tables {
+			t.unref()
+		}
+	}()
+
+	it := newMergeIterator(iters)
+	defer it.Close()
+
+	for it.Next() {
+		e := it.Entry()
+		if e.deleted {
+			continue
+		}
+		if !fn(e.key, e.value) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+// Flush writes the memtable out to an SSTable and compacts if needed
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.flush(); err != nil {
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+	return db.compact()
+}
+
+// flush writes the memtable to a new level 0 SSTable, records it in
+// the manifest and then empties the WAL
+func (db *DB) flush() error {
+	if db.mem.empty() {
+		return nil
+	}
+
+	it := db.mem.iterator("", "")
+	tables, err := db.writeTables(it, false, 0)
+	it.Close()
+	if err != nil {
+		return err
+	}
+
+	// Newest first
+	db.levels[0] = append(tables, db.levels[0]...)
+	db.flushedLSN = db.lsn
+	if err := db.saveManifest(); err != nil {
+		return err
+	}
+
+	db.mem = newMemtable()
+	return db.wal.Reset()
+}
+
+// writeTables writes everything it yields to new SSTables, starting
+// a new one once a table reaches maxSize (0 for no limit). Tombstones
+// are left out if dropTombstones is set.
+func (db *DB) writeTables(it iterator, dropTombstones bool, maxSize int64) ([]*table, error) {
+	var tables []*table
+	var w *tableWriter
+	var id uint64
+
+	fail := func(err error) ([]*table, error) {
+		if w != nil {
+			w.abort()
+		}
+		for _, t := range tables {
+			t.obsolete.Store(true)
+			t.unref()
+		}
+		return nil, err
+	}
+
+	finish := func() error {
+		if err := w.finish(); err != nil {
+			return err
+		}
+		w = nil
+		t, err := openTable(db.tablePath(id), id)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, t)
+		return nil
+	}
+
+	for it.Next() {
+		e := it.Entry()
+		if e.deleted && dropTombstones {
+			continue
+		}
+
+		if w == nil {
+			id = db.nextFileID
+			db.nextFileID++
+			var err error
+			if w, err = newTableWriter(db.tablePath(id), db.config); err != nil {
+				return fail(err)
+			}
+		}
+		if err := w.add(e); err != nil {
+			return fail(err)
+		}
+		if maxSize > 0 && w.size() >= maxSize {
+			if err := finish(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fail(err)
+	}
+	if w != nil {
+		if err := finish(); err != nil {
+			return fail(err)
+		}
+	}
+
+	return tables, nil
+}
+
+// saveManifest records the current levels in the manifest
+func (db *DB) saveManifest() error {
+	m := &manifest{flushedLSN: db.flushedLSN, nextFileID: db.nextFileID}
+	for level := range db.levels {
+		for _, t := range db.levels[level] {
+			m.tables = append(m.tables, manifestTable{level: level, id: t.id})
+		}
+	}
+	return writeManifest(db.dir, m)
+}
+
+// Sync forces the WAL to disk
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.wal.Sync()
+}
+
+// Close syncs the WAL and closes every file. The memtable isn't
+// flushed, the next Open rebuilds it from the WAL.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.wal.Sync(); err != nil {
+		db.wal.Close()
+		db.closeTables()
+		return err
+	}
+	if err := db.wal.Close(); err != nil {
+		db.closeTables()
+		return err
+	}
+	return db.closeTables()
+}
+
+func (db *DB) closeTables() error {
+	var firstErr error
+	for level := range db.levels {
+		for _, t := range db.levels[level] {
+			if err := t.unref(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		db.levels[level] = nil
+	}
+	return firstErr
+}
+
+// LevelStats describes one level of the tree
+type LevelStats struct {
+	Tables int
+	Bytes  int64
+}
+
+// Levels returns the number of SSTables and bytes in each level
+func (db *DB) Levels() []LevelStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := make([]LevelStats, numLevels)
+	for level, tables := range db.levels {
+		for _, t := range tables {
+			stats[level].Tables++
+			stats[level].Bytes += t.size
+		}
+	}
+	return stats
+}
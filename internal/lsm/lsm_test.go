@@ -0,0 +1,204 @@
+package lsm
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/assert"
+)
+
+// smallConfig makes every part of the tree kick in after a few KB
+func smallConfig() *Config {
+	return &Config{
+		MemtableSize:        4 * 1024,
+		BlockSize:           256,
+		BloomFPR:            0.01,
+		TargetFileSize:      8 * 1024,
+		L0CompactionTrigger: 2,
+		BaseLevelSize:       16 * 1024,
+		LevelSizeMultiplier: 4,
+	}
+}
+
+// assertDB checks db holds exactly model, through Get and Scan
+func assertDB(t *testing.T, db *DB, model map[string]string) {
+	t.Helper()
+
+	for k, v := range model {
+		got, ok, err := db.Get(k)
+		assert.NoError(t, err)
+		assert.True(t, ok, "missing %q", k)
+		assert.Equal(t, v, string(got))
+	}
+
+	var keys []string
+	assert.NoError(t, db.Scan("", "", func(key string, value []byte) bool {
+		assert.Equal(t, model[key], string(value))
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, slices.Sorted(maps.Keys(model)), keys)
+}
+
+func TestLSMBasic(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, smallConfig())
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Put("a", []byte("1")))
+	assert.NoError(t, db.Put("b", []byte("2")))
+	assert.NoError(t, db.Flush())
+	assert.NoError(t, db.Put("a", []byte("changed")))
+
+	ok, err := db.Delete("b")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = db.Delete("b")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// The newer memtable entries hide the flushed ones
+	assertDB(t, db, map[string]string{"a": "changed"})
+
+	// Unflushed writes come back from the WAL
+	assert.NoError(t, db.Close())
+	db, err = Open(dir, smallConfig())
+	assert.NoError(t, err)
+	assertDB(t, db, map[string]string{"a": "changed"})
+	assert.NoError(t, db.Close())
+}
+
+// TestLSMRandomOps runs random writes against a map with a config
+// small enough that data moves through several levels
+func TestLSMRandomOps(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, smallConfig())
+	assert.NoError(t, err)
+
+	rng := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key%05d", rng.Intn(3000))
+		if rng.Intn(5) == 0 {
+			_, exists := model[key]
+			ok, err := db.Delete(key)
+			assert.NoError(t, err)
+			assert.Equal(t, exists, ok)
+			delete(model, key)
+		} else {
+			val := fmt.Sprintf("val%d", i)
+			assert.NoError(t, db.Put(key, []byte(val)))
+			model[key] = val
+		}
+	}
+
+	// Compaction kept level 0 short and pushed data below level 1
+	levels := db.Levels()
+	assert.True(t, levels[0].Tables < smallConfig().L0CompactionTrigger)
+	assert.True(t, levels[2].Tables > 0, "%v", levels)
+	for level := 1; level < numLevels; level++ {
+		tables := db.levels[level]
+		for i := 1; i < len(tables); i++ {
+			assert.True(t, tables[i-1].largest < tables[i].smallest, "level %d overlaps", level)
+		}
+	}
+
+	assertDB(t, db, model)
+
+	// A range in the middle
+	var keys []string
+	assert.NoError(t, db.Scan("key01000", "key01100", func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	var want []string
+	for k := range model {
+		if k >= "key01000" && k < "key01100" {
+			want = append(want, k)
+		}
+	}
+	slices.Sort(want)
+	assert.Equal(t, want, keys)
+
+	assert.NoError(t, db.Close())
+	db, err = Open(dir, smallConfig())
+	assert.NoError(t, err)
+	assertDB(t, db, model)
+	assert.NoError(t, db.Close())
+}
+
+// TestLSMScanWhileWriting writes enough from inside a scan to flush
+// and compact away the tables it's reading
+func TestLSMScanWhileWriting(t *testing.T) {
+	db, err := Open(t.TempDir(), smallConfig())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	model := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		assert.NoError(t, db.Put(key, []byte("old")))
+		model[key] = "old"
+	}
+	assert.NoError(t, db.Flush())
+
+	count := 0
+	assert.NoError(t, db.Scan("", "", func(key string, value []byte) bool {
+		count++
+		assert.NoError(t, db.Put(key, []byte("new")))
+		model[key] = "new"
+		return true
+	}))
+	assert.Equal(t, 1000, count)
+	assertDB(t, db, model)
+
+	// Tables replaced during the scan were deleted once it finished,
+	// along with their filters
+	files, err := filepath.Glob(filepath.Join(db.dir, "*.sst"))
+	assert.NoError(t, err)
+	filters, err := filepath.Glob(filepath.Join(db.dir, "*.bloom"))
+	assert.NoError(t, err)
+	live := 0
+	for _, level := range db.Levels() {
+		live += level.Tables
+	}
+	assert.Equal(t, live, len(files))
+	assert.Equal(t, live, len(filters))
+}
+
+// TestLSMCrash reopens a tree that was never closed and one with a
+// table that was written but never made it into the manifest
+func TestLSMCrash(t *testing.T) {
+	dir := t.TempDir()
+	cfg := smallConfig()
+	cfg.SyncWrites = true
+
+	db, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	model := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		assert.NoError(t, db.Put(key, []byte(key)))
+		model[key] = key
+	}
+
+	// A half finished flush
+	orphan := filepath.Join(dir, fmt.Sprintf("%010d.sst", 999))
+	assert.NoError(t, os.WriteFile(orphan, []byte("partial"), 0644))
+	orphanFilter := filepath.Join(dir, fmt.Sprintf("%010d.bloom", 999))
+	assert.NoError(t, os.WriteFile(orphanFilter, []byte("partial"), 0644))
+
+	reopened, err := Open(dir, cfg)
+	assert.NoError(t, err)
+	assertDB(t, reopened, model)
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(orphanFilter)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, reopened.Close())
+}
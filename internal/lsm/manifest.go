@@ -0,0 +1,135 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+const (
+	manifestFileName = "MANIFEST"
+	manifestMagic    = "LSMMANI1"
+)
+
+var errCorruptManifest = errors.New("corrupt manifest")
+
+// manifest records which SSTables make up each level. It's rewritten
+// (temp file + rename) after every flush and compaction, so tables
+// only become part of the tree once they're completely written.
+type manifest struct {
+	flushedLSN uint64 // WAL records up to here are in the SSTables
+	nextFileID uint64
+	tables     []manifestTable
+}
+
+// manifestTable is one SSTable and the level it's in. Tables are
+// listed in level order, level 0 newest first.
+type manifestTable struct {
+	level int
+	id    uint64
+}
+
+// encode serializes the manifest as
+// [magic:8][flushed_lsn:8][next_file_id:8][count:4] ([level:1][file_id:8])... [crc:4]
+func (m *manifest) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(manifestMagic)
+	binary.Write(&buf, binary.LittleEndian, m.flushedLSN)
+	binary.Write(&buf, binary.LittleEndian, m.nextFileID)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(m.tables)))
+	for _, t := range m.tables {
+		buf.WriteByte(byte(t.level))
+		binary.Write(&buf, binary.LittleEndian, t.id)
+	}
+
+	data := buf.Bytes()
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+func decodeManifest(data []byte) (*manifest, error) {
+	headerSize := len(manifestMagic) + 8 + 8 + 4
+	if len(data) < headerSize+4 || string(data[:len(manifestMagic)]) != manifestMagic {
+		return nil, errCorruptManifest
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, errCorruptManifest
+	}
+
+	m := &manifest{
+		flushedLSN: binary.LittleEndian.Uint64(body[8:]),
+		nextFileID: binary.LittleEndian.Uint64(body[16:]),
+	}
+	count := int(binary.LittleEndian.Uint32(body[24:]))
+	if len(body) != headerSize+count*9 {
+		return nil, errCorruptManifest
+	}
+
+	for pos := headerSize; pos < len(body); pos += 9 {
+		level := int(body[pos])
+		if level >= numLevels {
+			return nil, errCorruptManifest
+		}
+		m.tables = append(m.tables, manifestTable{
+			level: level,
+			id:    binary.LittleEndian.Uint64(body[pos+1:]),
+		})
+	}
+
+	return m, nil
+}
+
+// readManifest loads the manifest in dir, nil if there isn't one yet
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeManifest(data)
+}
+
+// writeManifest atomically replaces the manifest in dir
+func writeManifest(dir string, m *manifest) error {
+	tmpPath := filepath.Join(dir, manifestFileName+".tmp")
+	path := filepath.Join(dir, manifestFileName)
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	if _, err := file.Write(m.encode()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync manifest: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to install manifest: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes renames and new files in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,94 @@
+package lsm
+
+import (
+	"iter"
+
+	"github.com/yashagw/kvdb/internal/bplustree"
+)
+
+// memtableDegree is the degree of the memtable's B+ tree
+const memtableDegree = 32
+
+// Values in the memtable start with a byte telling puts from deletes
+const (
+	kindDelete byte = 0
+	kindPut    byte = 1
+)
+
+// entry is a put or a delete (tombstone) of a key
+type entry struct {
+	key     string
+	value   []byte
+	deleted bool
+}
+
+// memtable holds the most recent writes in memory, sorted by key.
+// Deletes are kept as tombstones so they hide older values in the
+// SSTables once flushed.
+type memtable struct {
+	tree *bplustree.BPlusTree
+	size int64 // Rough number of bytes written to it
+}
+
+func newMemtable() *memtable {
+	return &memtable{tree: bplustree.NewBPlusTree(memtableDegree)}
+}
+
+// put records a write of key
+func (m *memtable) put(key string, value []byte) {
+	m.tree.Put(key, string(kindPut)+string(value))
+	m.size += int64(len(key) + len(value) + 1)
+}
+
+// delete records a tombstone for key
+func (m *memtable) delete(key string) {
+	m.tree.Put(key, string(kindDelete))
+	m.size += int64(len(key) + 1)
+}
+
+// get returns the latest entry for key, which may be a tombstone
+func (m *memtable) get(key string) (entry, bool) {
+	val, ok := m.tree.Get(key)
+	if !ok {
+		return entry{}, false
+	}
+	return decodeMemValue(key, val), true
+}
+
+// empty reports whether nothing was written to the memtable
+func (m *memtable) empty() bool {
+	return m.size == 0
+}
+
+// iterator walks the entries with keys in [start, end)
+func (m *memtable) iterator(start, end string) iterator {
+	next, stop := iter.Pull2(m.tree.Scan(start, end))
+	return &memIterator{next: next, stop: stop}
+}
+
+func decodeMemValue(key, val string) entry {
+	if val[0] == kindDelete {
+		return entry{key: key, deleted: true}
+	}
+	return entry{key: key, value: []byte(val[1:])}
+}
+
+// memIterator adapts a memtable scan to an iterator
+type memIterator struct {
+	next func() (string, string, bool)
+	stop func()
+	cur  entry
+}
+
+func (it *memIterator) Next() bool {
+	key, val, ok := it.next()
+	if !ok {
+		return false
+	}
+	it.cur = decodeMemValue(key, val)
+	return true
+}
+
+func (it *memIterator) Entry() entry { return it.cur }
+func (it *memIterator) Err() error   { return nil }
+func (it *memIterator) Close()       { it.stop() }
@@ -0,0 +1,481 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync/atomic"
+
+	"github.com/yashagw/kvdb/internal/bloom"
+)
+
+// An SSTable is an immutable file of entries sorted by key:
+//
+//	[data block]... [index block] [footer]
+//
+// Every block is followed by a crc32 of its contents. A data block
+// holds entries as [kind:1][key_size:uvarint][val_size:uvarint][key][val],
+// and the index block holds the smallest key in the table and the last
+// key, offset and size of each data block. The footer is
+// [index_off:8][index_size:8][count:8][magic:8].
+//
+// The bloom filter over the table's keys lives in a sidecar file next
+// to it (0000000001.sst has 0000000001.bloom).
+
+const (
+	tableMagic = "LSMSST02"
+	footerSize = 3*8 + len(tableMagic)
+)
+
+var errCorruptTable = errors.New("corrupt sstable")
+
+// blockHandle locates a data block and the last key in it
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	size    uint64 // Including the crc
+}
+
+// tableWriter writes entries, which must come in key order, to a new SSTable
+type tableWriter struct {
+	path      string
+	file      *os.File
+	w         *bufio.Writer
+	offset    uint64
+	blockSize int
+	bloomFPR  float64
+
+	block    []byte
+	lastKey  string
+	smallest string
+	handles  []blockHandle
+	hashes   []uint64
+	count    uint64
+}
+
+func newTableWriter(path string, cfg *Config) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sstable %s: %w", path, err)
+	}
+
+	return &tableWriter{
+		path:      path,
+		file:      file,
+		w:         bufio.NewWriter(file),
+		blockSize: cfg.BlockSize,
+		bloomFPR:  cfg.BloomFPR,
+	}, nil
+}
+
+// add appends an entry to the table
+func (w *tableWriter) add(e entry) error {
+	if w.count == 0 {
+		w.smallest = e.key
+	}
+
+	kind := kindPut
+	if e.deleted {
+		kind = kindDelete
+	}
+	w.block = append(w.block, kind)
+	w.block = binary.AppendUvarint(w.block, uint64(len(e.key)))
+	w.block = binary.AppendUvarint(w.block, uint64(len(e.value)))
+	w.block = append(w.block, e.key...)
+	w.block = append(w.block, e.value...)
+	w.lastKey = e.key
+	w.count++
+	if w.bloomFPR > 0 {
+		w.hashes = append(w.hashes, bloom.Hash(e.key))
+	}
+
+	if len(w.block) >= w.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// size returns roughly how big the table is so far
+func (w *tableWriter) size() int64 {
+	return int64(w.offset) + int64(len(w.block))
+}
+
+// flushBlock writes out the current data block
+func (w *tableWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+
+	offset, size, err := w.writeBlock(w.block)
+	if err != nil {
+		return err
+	}
+	w.handles = append(w.handles, blockHandle{lastKey: w.lastKey, offset: offset, size: size})
+	w.block = w.block[:0]
+	return nil
+}
+
+// writeBlock writes data followed by its crc, returns where it went
+func (w *tableWriter) writeBlock(data []byte) (offset, size uint64, err error) {
+	offset = w.offset
+	if _, err := w.w.Write(data); err != nil {
+		return 0, 0, err
+	}
+	if err := binary.Write(w.w, binary.LittleEndian, crc32.ChecksumIEEE(data)); err != nil {
+		return 0, 0, err
+	}
+	size = uint64(len(data) + 4)
+	w.offset += size
+	return offset, size, nil
+}
+
+// finish writes the index and footer, syncs the file and writes the
+// bloom filter next to it
+func (w *tableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	index := binary.AppendUvarint(nil, uint64(len(w.smallest)))
+	index = append(index, w.smallest...)
+	for _, h := range w.handles {
+		index = binary.AppendUvarint(index, uint64(len(h.lastKey)))
+		index = append(index, h.lastKey...)
+		index = binary.AppendUvarint(index, h.offset)
+		index = binary.AppendUvarint(index, h.size)
+	}
+	indexOffset, indexSize, err := w.writeBlock(index)
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, 0, footerSize)
+	footer = binary.LittleEndian.AppendUint64(footer, indexOffset)
+	footer = binary.LittleEndian.AppendUint64(footer, indexSize)
+	footer = binary.LittleEndian.AppendUint64(footer, w.count)
+	footer = append(footer, tableMagic...)
+	if _, err := w.w.Write(footer); err != nil {
+		return err
+	}
+
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync sstable: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.bloomFPR > 0 {
+		filter := bloom.New(len(w.hashes), w.bloomFPR)
+		for _, h := range w.hashes {
+			filter.AddHash(h)
+		}
+		if err := filter.WriteFile(bloom.SidecarPath(w.path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort gives up on the table and removes its files
+func (w *tableWriter) abort() {
+	w.file.Close()
+	os.Remove(w.path)
+	os.Remove(bloom.SidecarPath(w.path))
+}
+
+// table is an open SSTable. The index and filter are kept in
+// memory, data blocks are read from the file as needed.
+type table struct {
+	id       uint64
+	path     string
+	file     *os.File
+	handles  []blockHandle
+	filter   *bloom.Filter // Nil without a sidecar filter
+	smallest string
+	largest  string
+	size     int64
+	count    uint64
+
+	// refs counts the version holding the table plus any scans
+	// reading it. Once compaction replaces the table it's marked
+	// obsolete, and the last unref deletes the file.
+	refs     atomic.Int32
+	obsolete atomic.Bool
+
+	blockReads atomic.Int64 // Data blocks read, for tests
+}
+
+// openTable opens the SSTable at path and reads its index and filter
+func openTable(path string, id uint64) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sstable %s: %w", path, err)
+	}
+
+	t := &table{id: id, path: path, file: file}
+	if err := t.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load sstable %s: %w", path, err)
+	}
+	t.refs.Store(1)
+	return t, nil
+}
+
+func (t *table) load() error {
+	stat, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	t.size = stat.Size()
+	if t.size < int64(footerSize) {
+		return errCorruptTable
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := t.file.ReadAt(footer, t.size-int64(footerSize)); err != nil {
+		return err
+	}
+	if string(footer[24:]) != tableMagic {
+		return errCorruptTable
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexSize := binary.LittleEndian.Uint64(footer[8:])
+	t.count = binary.LittleEndian.Uint64(footer[16:])
+
+	// The filter only saves reads, without a usable one every lookup
+	// just goes to the data blocks. It's missing if filters were off
+	// when the table was written, anything else is worth a warning.
+	filter, err := bloom.ReadFile(bloom.SidecarPath(t.path))
+	switch {
+	case err == nil:
+		t.filter = filter
+	case !errors.Is(err, fs.ErrNotExist):
+		log.Printf("lsm: reading %s without its bloom filter: %v", t.path, err)
+	}
+
+	index, err := t.readBlock(indexOffset, indexSize)
+	if err != nil {
+		return err
+	}
+	smallest, index, err := readString(index)
+	if err != nil {
+		return err
+	}
+	t.smallest = smallest
+	for len(index) > 0 {
+		var h blockHandle
+		if h.lastKey, index, err = readString(index); err != nil {
+			return err
+		}
+		if h.offset, index, err = readUvarint(index); err != nil {
+			return err
+		}
+		if h.size, index, err = readUvarint(index); err != nil {
+			return err
+		}
+		t.handles = append(t.handles, h)
+	}
+	if len(t.handles) == 0 {
+		return errCorruptTable
+	}
+	t.largest = t.handles[len(t.handles)-1].lastKey
+
+	return nil
+}
+
+// readBlock reads a block and checks its crc
+func (t *table) readBlock(offset, size uint64) ([]byte, error) {
+	if size < 4 || offset+size > uint64(t.size) {
+		return nil, errCorruptTable
+	}
+
+	buf := make([]byte, size)
+	if _, err := t.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read block at %d: %w", offset, err)
+	}
+
+	data := buf[:size-4]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(buf[size-4:]) {
+		return nil, fmt.Errorf("%w: block at %d fails its checksum", errCorruptTable, offset)
+	}
+	return data, nil
+}
+
+// contains reports whether key is within the table's key range
+func (t *table) contains(key string) bool {
+	return key >= t.smallest && key <= t.largest
+}
+
+// overlaps reports whether the table has keys in [start, end],
+// an empty end means no upper bound
+func (t *table) overlaps(start, end string) bool {
+	return t.largest >= start && (end == "" || t.smallest <= end)
+}
+
+// get looks key up, the entry may be a tombstone
+func (t *table) get(key string) (entry, bool, error) {
+	if !t.contains(key) || (t.filter != nil && !t.filter.MayContain(key)) {
+		return entry{}, false, nil
+	}
+
+	// The first block whose last key isn't before key
+	i := sort.Search(len(t.handles), func(i int) bool {
+		return t.handles[i].lastKey >= key
+	})
+	if i == len(t.handles) {
+		return entry{}, false, nil
+	}
+
+	t.blockReads.Add(1)
+	block, err := t.readBlock(t.handles[i].offset, t.handles[i].size)
+	if err != nil {
+		return entry{}, false, err
+	}
+	for len(block) > 0 {
+		var e entry
+		if e, block, err = decodeEntry(block); err != nil {
+			return entry{}, false, err
+		}
+		if e.key == key {
+			return e, true, nil
+		}
+		if e.key > key {
+			break
+		}
+	}
+	return entry{}, false, nil
+}
+
+// iterator walks the entries with keys in [start, end), an empty end
+// means no upper bound
+func (t *table) iterator(start, end string) iterator {
+	block := sort.Search(len(t.handles), func(i int) bool {
+		return t.handles[i].lastKey >= start
+	})
+	return &tableIterator{table: t, block: block, start: start, end: end}
+}
+
+func (t *table) ref() {
+	t.refs.Add(1)
+}
+
+// unref drops a reference, closing the file (and deleting it and its
+// filter if the table is obsolete) once nothing holds it
+func (t *table) unref() error {
+	if t.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	if err := t.file.Close(); err != nil {
+		return err
+	}
+	if !t.obsolete.Load() {
+		return nil
+	}
+	if err := os.Remove(bloom.SidecarPath(t.path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(t.path)
+}
+
+// tableIterator reads a table one block at a time
+type tableIterator struct {
+	table      *table
+	block      int    // Next block to read
+	data       []byte // Rest of the current block
+	start, end string
+	cur        entry
+	err        error
+}
+
+func (it *tableIterator) Next() bool {
+	for {
+		if it.err != nil {
+			return false
+		}
+		if len(it.data) == 0 {
+			if it.block >= len(it.table.handles) {
+				return false
+			}
+			h := it.table.handles[it.block]
+			it.data, it.err = it.table.readBlock(h.offset, h.size)
+			it.block++
+			continue
+		}
+
+		it.cur, it.data, it.err = decodeEntry(it.data)
+		if it.err != nil {
+			return false
+		}
+		if it.cur.key < it.start {
+			continue
+		}
+		if it.end != "" && it.cur.key >= it.end {
+			it.block = len(it.table.handles)
+			it.data = nil
+			return false
+		}
+		return true
+	}
+}
+
+func (it *tableIterator) Entry() entry { return it.cur }
+func (it *tableIterator) Err() error   { return it.err }
+func (it *tableIterator) Close()       {}
+
+// decodeEntry decodes the entry at the start of data and returns the rest
+func decodeEntry(data []byte) (entry, []byte, error) {
+	if len(data) < 1 {
+		return entry{}, nil, errCorruptTable
+	}
+	kind := data[0]
+	data = data[1:]
+
+	keySize, data, err := readUvarint(data)
+	if err != nil {
+		return entry{}, nil, err
+	}
+	valSize, data, err := readUvarint(data)
+	if err != nil {
+		return entry{}, nil, err
+	}
+	if keySize+valSize > uint64(len(data)) {
+		return entry{}, nil, errCorruptTable
+	}
+
+	e := entry{
+		key:     string(data[:keySize]),
+		value:   data[keySize : keySize+valSize : keySize+valSize],
+		deleted: kind == kindDelete,
+	}
+	return e, data[keySize+valSize:], nil
+}
+
+func readUvarint(data []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, errCorruptTable
+	}
+	return v, data[n:], nil
+}
+
+func readString(data []byte) (string, []byte, error) {
+	size, data, err := readUvarint(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if size > uint64(len(data)) {
+		return "", nil, errCorruptTable
+	}
+	return string(data[:size]), data[size:], nil
+}
@@ -0,0 +1,128 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert"
+	"github.com/yashagw/kvdb/internal/bloom"
+)
+
+// writeTestTable writes n entries, every tenth one a tombstone
+func writeTestTable(t *testing.T, path string, n int) *table {
+	w, err := newTableWriter(path, smallConfig())
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		e := entry{key: fmt.Sprintf("k%05d", i*2), value: []byte(fmt.Sprintf("v%d", i))}
+		if i%10 == 0 {
+			e = entry{key: e.key, deleted: true}
+		}
+		assert.NoError(t, w.add(e))
+	}
+	assert.NoError(t, w.finish())
+
+	tbl, err := openTable(path, 1)
+	assert.NoError(t, err)
+	return tbl
+}
+
+func TestTable(t *testing.T) {
+	tbl := writeTestTable(t, filepath.Join(t.TempDir(), "1.sst"), 1000)
+	defer tbl.unref()
+
+	assert.Equal(t, "k00000", tbl.smallest)
+	assert.Equal(t, "k01998", tbl.largest)
+	assert.Equal(t, uint64(1000), tbl.count)
+	assert.True(t, len(tbl.handles) > 10)
+
+	e, ok, err := tbl.get("k00010")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v5", string(e.value))
+
+	e, ok, err = tbl.get("k00020")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, e.deleted)
+
+	// Between keys, and outside the table's range
+	for _, key := range []string{"k00011", "a", "z"} {
+		_, ok, err = tbl.get(key)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+
+	// Iterate a range that starts and ends between keys
+	it := tbl.iterator("k00101", "k00111")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Entry().key)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []string{"k00102", "k00104", "k00106", "k00108", "k00110"}, keys)
+}
+
+func TestTableCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.sst")
+	tbl := writeTestTable(t, path, 1000)
+	tbl.unref()
+
+	// Flip a byte in the first data block
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[10] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	tbl, err = openTable(path, 1)
+	assert.NoError(t, err)
+	defer tbl.unref()
+	_, _, err = tbl.get("k00002")
+	assert.Error(t, err)
+
+	// A truncated file doesn't open at all
+	assert.NoError(t, os.WriteFile(path, data[:len(data)-3], 0644))
+	_, err = openTable(path, 1)
+	assert.Error(t, err)
+}
+
+// TestTableBloomFilter checks lookups of missing keys skip the data
+// blocks, and that a table without its filter still works
+func TestTableBloomFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.sst")
+	tbl := writeTestTable(t, path, 1000)
+	assert.True(t, tbl.filter != nil)
+
+	// Odd keys sit between the table's even ones, so only the filter
+	// can rule them out
+	for i := 0; i < 1000; i++ {
+		_, ok, err := tbl.get(fmt.Sprintf("k%05d", i*2+1))
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+	reads := tbl.blockReads.Load()
+	t.Logf("%d of 1000 missing keys read a block", reads)
+	assert.True(t, reads < 30, "%d block reads", reads)
+	tbl.unref()
+
+	assert.NoError(t, os.Remove(bloom.SidecarPath(path)))
+	tbl, err := openTable(path, 1)
+	assert.NoError(t, err)
+	defer tbl.unref()
+	assert.True(t, tbl.filter == nil)
+	e, ok, err := tbl.get("k00002")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v1", string(e.value))
+
+	// A damaged filter is ignored the same way
+	assert.NoError(t, os.WriteFile(bloom.SidecarPath(path), []byte("garbage"), 0644))
+	damaged, err := openTable(path, 1)
+	assert.NoError(t, err)
+	defer damaged.unref()
+	assert.True(t, damaged.filter == nil)
+	_, ok, err = damaged.get("k00002")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
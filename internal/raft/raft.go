@@ -0,0 +1,584 @@
+// Package raft keeps a state machine in step across a cluster of
+// nodes with the Raft consensus algorithm: the nodes elect a leader,
+// the leader replicates the commands it's given to a log on every node,
+// and once a majority have a command it's committed and every node
+// applies it. Nodes that fall too far behind are sent a snapshot.
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned by Propose on a node that isn't the
+	// leader, wrapped in a NotLeaderError
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrLeadershipLost is returned by Propose when the node stopped
+	// being the leader before the command was applied, so it may not
+	// have been
+	ErrLeadershipLost = errors.New("leadership lost")
+
+	// ErrStopped is returned by Propose once the node is stopped
+	ErrStopped = errors.New("node stopped")
+)
+
+// NotLeaderError is returned by Propose on a node that isn't the leader
+type NotLeaderError struct {
+	Leader string // The leader the node last heard from, empty if none
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the leader, no leader known"
+	}
+	return fmt.Sprintf("not the leader, the leader is %s", e.Leader)
+}
+
+func (e *NotLeaderError) Unwrap() error {
+	return ErrNotLeader
+}
+
+// State is the role a node plays in the cluster
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Entry is a command in the log
+type Entry struct {
+	Index uint64
+	Term  uint64 // The term of the leader that appended it
+	Data  []byte // The command, nil for the entry a new leader appends
+}
+
+// StateMachine is what a node applies committed commands to. Its
+// methods are only called one at a time, in log order.
+type StateMachine interface {
+	// Apply applies a committed command. What it returns is returned by
+	// Propose on the node the command was proposed on. An error means
+	// the command couldn't be applied at all, and the node stops
+	// applying entries rather than carry on without it.
+	Apply(e Entry) (any, error)
+
+	// Snapshot writes the state as of the last entry applied
+	Snapshot(w io.Writer) error
+
+	// Restore replaces the state with a snapshot's
+	Restore(r io.Reader) error
+}
+
+// Config holds configuration options for a node
+type Config struct {
+	ElectionTimeout   time.Duration // How long a follower waits to hear from a leader, randomised up to double
+	HeartbeatInterval time.Duration // How often a leader sends appends when it has nothing new
+	MaxEntries        int           // Most entries sent in one append
+	SnapshotThreshold uint64        // Entries applied since the last snapshot that trigger a new one, 0 for never
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		ElectionTimeout:   300 * time.Millisecond,
+		HeartbeatInterval: 50 * time.Millisecond,
+		MaxEntries:        256,
+		SnapshotThreshold: 10000,
+	}
+}
+
+// Status is a node's view of the cluster
+type Status struct {
+	ID            string
+	State         State
+	Term          uint64
+	Leader        string // Empty if the node doesn't know of one
+	LastIndex     uint64 // The last entry in the log
+	CommitIndex   uint64 // The last entry known to be committed
+	LastApplied   uint64 // The last entry applied to the state machine
+	SnapshotIndex uint64 // The last entry the latest snapshot covers
+}
+
+// Node is a member of a Raft cluster
+type Node struct {
+	id        string
+	peers     []string // The other members
+	config    *Config
+	sm        StateMachine
+	storage   Storage
+	transport Transport
+
+	mu       sync.Mutex
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+
+	// log[0] stands in for the entries the snapshot covers: its index
+	// and term are those of the last of them
+	log      []Entry
+	snapshot []byte
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// A snapshot from the leader for the applier to restore
+	pendingSnapshot []byte
+
+	electionDeadline time.Time
+
+	// Leader state
+	nextIndex  map[string]uint64 // The next entry to send to each peer
+	matchIndex map[string]uint64 // The last entry known to be on each peer
+	triggers   map[string]chan struct{}
+	waiters    map[uint64]*waiter
+
+	applyCond *sync.Cond
+	stopped   bool
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// waiter is a Propose call waiting for its entry to be applied
+type waiter struct {
+	term uint64
+	ch   chan proposeResult
+}
+
+type proposeResult struct {
+	value any
+	err   error
+}
+
+// New starts a node with the given ID in a cluster of members (which
+// may include id) and recovers its state from storage. The node sends
+// requests over transport, and the caller arranges for requests to it
+// to reach its Handler methods.
+func New(id string, members []string, sm StateMachine, storage Storage, transport Transport, cfg *Config) (*Node, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	state, snap, entries, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		id:        id,
+		config:    cfg,
+		sm:        sm,
+		storage:   storage,
+		transport: transport,
+		term:      state.Term,
+		votedFor:  state.VotedFor,
+		log:       []Entry{{}},
+		waiters:   make(map[uint64]*waiter),
+		done:      make(chan struct{}),
+	}
+	for _, member := range members {
+		if member != id && !slices.Contains(n.peers, member) {
+			n.peers = append(n.peers, member)
+		}
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+
+	if snap != nil {
+		if err := sm.Restore(bytes.NewReader(snap.Data)); err != nil {
+			return nil, fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		n.log[0] = Entry{Index: snap.Index, Term: snap.Term}
+		n.snapshot = snap.Data
+		n.commitIndex, n.lastApplied = snap.Index, snap.Index
+	}
+	n.log = append(n.log, entries...)
+	n.resetElectionDeadline()
+
+	n.wg.Add(2)
+	go n.ticker()
+	go n.applier()
+	return n, nil
+}
+
+// Stop stops the node. The caller still owns the storage.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.done)
+	n.applyCond.Broadcast()
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+// ID returns the node's ID
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Status returns the node's view of the cluster
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.id,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		LastIndex:     n.lastIndex(),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		SnapshotIndex: n.log[0].Index,
+	}
+}
+
+// Propose appends a command to the log if the node is the leader and
+// waits for it to be applied, returning what the state machine
+// returned. If ctx is done first the command may still be applied.
+func (n *Node) Propose(ctx context.Context, data []byte) (any, error) {
+	if data == nil {
+		data = []byte{} // nil is kept for the entries new leaders append
+	}
+
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return nil, &NotLeaderError{Leader: n.leader}
+	}
+	e, err := n.appendEntry(data)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	w := &waiter{term: e.Term, ch: make(chan proposeResult, 1)}
+	n.waiters[e.Index] = w
+	n.mu.Unlock()
+
+	select {
+	case r := <-w.ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	case <-n.done:
+		return nil, ErrStopped
+	}
+}
+
+// appendEntry appends an entry of the current term to the leader's log
+// and sends it out. mu must be held.
+func (n *Node) appendEntry(data []byte) (Entry, error) {
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Data: data}
+	if err := n.storage.Append([]Entry{e}); err != nil {
+		return e, fmt.Errorf("failed to append entry: %w", err)
+	}
+	n.log = append(n.log, e)
+	n.advanceCommit()
+	n.triggerAll()
+	return e, nil
+}
+
+// lastIndex returns the index of the last entry. mu must be held.
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// termAt returns the term of the entry at index, which must be between
+// the snapshot's last entry and the last entry. mu must be held.
+func (n *Node) termAt(index uint64) uint64 {
+	return n.log[index-n.log[0].Index].Term
+}
+
+// saveState saves the term and vote. mu must be held.
+func (n *Node) saveState() error {
+	if err := n.storage.SaveState(HardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		log.Printf("raft %s: failed to save state: %v", n.id, err)
+		return err
+	}
+	return nil
+}
+
+// resetElectionDeadline picks when to start an election if no leader
+// is heard from. mu must be held.
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + rand.N(n.config.ElectionTimeout)
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// stepDown makes the node a follower, moving on to term if it's newer.
+// mu must be held.
+func (n *Node) stepDown(term uint64) error {
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		n.leader = ""
+		if err := n.saveState(); err != nil {
+			return err
+		}
+	}
+	n.state = Follower
+	return nil
+}
+
+// ticker starts elections when the leader has gone quiet
+func (n *Node) ticker() {
+	defer n.wg.Done()
+
+	t := time.NewTicker(n.config.HeartbeatInterval / 5)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-t.C:
+		}
+
+		n.mu.Lock()
+		if n.state != Leader && time.Now().After(n.electionDeadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// startElection becomes a candidate in a new term and asks the peers
+// for their votes. mu must be held.
+func (n *Node) startElection() {
+	n.resetElectionDeadline()
+	n.state = Candidate
+	n.term++
+	n.votedFor, n.leader = n.id, ""
+	if err := n.saveState(); err != nil {
+		n.state = Follower
+		return
+	}
+
+	term := n.term
+	req := &VoteRequest{Term: term, Candidate: n.id, LastIndex: n.lastIndex(), LastTerm: n.termAt(n.lastIndex())}
+	votes := 1
+	if votes > (len(n.peers)+1)/2 {
+		n.becomeLeader()
+		return
+	}
+
+	for _, peer := range n.peers {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+			defer cancel()
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if n.stopped || n.state != Candidate || n.term != term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes > (len(n.peers)+1)/2 {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+// becomeLeader takes over as leader and appends an entry of the new
+// term, which commits the entries of earlier terms along with it. mu
+// must be held.
+func (n *Node) becomeLeader() {
+	n.state, n.leader = Leader, n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.triggers = make(map[string]chan struct{})
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.triggers[peer] = make(chan struct{}, 1)
+		n.wg.Add(1)
+		go n.replicator(peer, n.term, n.triggers[peer])
+	}
+
+	if _, err := n.appendEntry(nil); err != nil {
+		log.Printf("raft %s: %v", n.id, err)
+		n.stepDown(n.term)
+	}
+}
+
+// triggerAll wakes up the leader's replicators. mu must be held.
+func (n *Node) triggerAll() {
+	for _, trigger := range n.triggers {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// advanceCommit commits the entries a majority have, as long as the
+// last of them is from the leader's term. Earlier terms' entries can't
+// be committed by counting alone, as a new leader may overwrite them.
+// mu must be held.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count > (len(n.peers)+1)/2 {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			n.triggerAll() // Let the followers know
+			return
+		}
+	}
+}
+
+// applier applies committed entries to the state machine, restores
+// snapshots from the leader and takes snapshots of its own
+func (n *Node) applier() {
+	defer n.wg.Done()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		for !n.stopped && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			return
+		}
+
+		if snap := n.pendingSnapshot; snap != nil {
+			n.pendingSnapshot = nil
+			index := n.log[0].Index
+			n.mu.Unlock()
+			err := n.sm.Restore(bytes.NewReader(snap))
+			n.mu.Lock()
+			if err != nil {
+				log.Printf("raft %s: failed to restore snapshot, no longer applying entries: %v", n.id, err)
+				return
+			}
+			n.lastApplied = index
+			for i, w := range n.waiters {
+				if i <= index {
+					w.ch <- proposeResult{err: ErrLeadershipLost}
+					delete(n.waiters, i)
+				}
+			}
+			continue
+		}
+
+		start := n.lastApplied + 1 - n.log[0].Index
+		end := n.commitIndex + 1 - n.log[0].Index
+		entries := slices.Clone(n.log[start:end])
+		n.mu.Unlock()
+		results := make([]any, len(entries))
+		applied := len(entries)
+		var applyErr error
+		for i, e := range entries {
+			if e.Data == nil {
+				continue
+			}
+			if results[i], applyErr = n.sm.Apply(e); applyErr != nil {
+				applied = i
+				break
+			}
+		}
+		n.mu.Lock()
+
+		if applied > 0 {
+			n.lastApplied = entries[applied-1].Index
+		}
+		for i, e := range entries[:applied] {
+			w := n.waiters[e.Index]
+			if w == nil {
+				continue
+			}
+			delete(n.waiters, e.Index)
+			if w.term == e.Term {
+				w.ch <- proposeResult{value: results[i]}
+			} else {
+				w.ch <- proposeResult{err: ErrLeadershipLost}
+			}
+		}
+		if applyErr != nil {
+			e := entries[applied]
+			if w := n.waiters[e.Index]; w != nil {
+				delete(n.waiters, e.Index)
+				w.ch <- proposeResult{err: applyErr}
+			}
+			log.Printf("raft %s: failed to apply entry %d, no longer applying entries: %v", n.id, e.Index, applyErr)
+			return
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// maybeSnapshot takes a snapshot and drops the entries it covers once
+// enough have been applied since the last one. Only the applier calls
+// it, so the state machine is as of lastApplied. mu must be held.
+func (n *Node) maybeSnapshot() {
+	threshold := n.config.SnapshotThreshold
+	if threshold == 0 || n.lastApplied-n.log[0].Index < threshold || n.pendingSnapshot != nil {
+		return
+	}
+
+	index := n.lastApplied
+	term := n.termAt(index)
+	n.mu.Unlock()
+	var buf bytes.Buffer
+	err := n.sm.Snapshot(&buf)
+	n.mu.Lock()
+	if err != nil {
+		log.Printf("raft %s: failed to take snapshot: %v", n.id, err)
+		return
+	}
+	if n.pendingSnapshot != nil || n.log[0].Index >= index {
+		return // The leader sent a newer one in the meantime
+	}
+
+	snap := &Snapshot{Index: index, Term: term, Data: buf.Bytes()}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		log.Printf("raft %s: failed to save snapshot: %v", n.id, err)
+		return
+	}
+	n.log = append([]Entry{{Index: index, Term: term}}, n.log[index-n.log[0].Index+1:]...)
+	n.snapshot = snap.Data
+}
@@ -0,0 +1,347 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+)
+
+// commandLog is a state machine that keeps every command applied
+type commandLog struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (l *commandLog) Apply(e Entry) (any, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if string(e.Data) == "fail" {
+		return nil, errors.New("can't apply")
+	}
+	l.commands = append(l.commands, string(e.Data))
+	return len(l.commands), nil
+}
+
+func (l *commandLog) Snapshot(w io.Writer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return json.NewEncoder(w).Encode(l.commands)
+}
+
+func (l *commandLog) Restore(r io.Reader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commands = nil
+	return json.NewDecoder(r).Decode(&l.commands)
+}
+
+func (l *commandLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.commands)
+}
+
+// cluster is a set of nodes on an in-memory network
+type cluster struct {
+	t        *testing.T
+	net      *Network
+	ids      []string
+	config   *Config
+	nodes    map[string]*Node
+	logs     map[string]*commandLog
+	storages map[string]Storage
+}
+
+func testConfig() *Config {
+	return &Config{
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		MaxEntries:        16,
+	}
+}
+
+// newCluster starts size nodes with in-memory storage
+func newCluster(t *testing.T, size int, cfg *Config) *cluster {
+	c := &cluster{
+		t:        t,
+		net:      NewNetwork(),
+		config:   cfg,
+		nodes:    make(map[string]*Node),
+		logs:     make(map[string]*commandLog),
+		storages: make(map[string]Storage),
+	}
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		c.ids = append(c.ids, id)
+		c.storages[id] = NewMemoryStorage()
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+// start starts the node id afresh from its storage
+func (c *cluster) start(id string) {
+	c.t.Helper()
+	c.logs[id] = &commandLog{}
+	n, err := New(id, c.ids, c.logs[id], c.storages[id], c.net.Transport(id), c.config)
+	assert.NoError(c.t, err)
+	c.nodes[id] = n
+	c.net.Register(id, n)
+}
+
+// waitLeader waits for exactly one of the given nodes to lead in the
+// highest term any of them are in
+func (c *cluster) waitLeader(ids ...string) *Node {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		var leaders []*Node
+		var term, leaderTerm uint64
+		for _, id := range ids {
+			status := c.nodes[id].Status()
+			term = max(term, status.Term)
+			if status.State == Leader {
+				leaders = append(leaders, c.nodes[id])
+				leaderTerm = status.Term
+			}
+		}
+		if len(leaders) == 1 && leaderTerm == term {
+			return leaders[0]
+		}
+	}
+	c.t.Fatalf("no leader elected among %v", ids)
+	return nil
+}
+
+// propose proposes command on the leader among ids, retrying if
+// leadership changes
+func (c *cluster) propose(command string, ids ...string) {
+	c.t.Helper()
+	for attempt := 0; attempt < 10; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.waitLeader(ids...).Propose(ctx, []byte(command))
+		cancel()
+		if err == nil {
+			return
+		}
+	}
+	c.t.Fatalf("failed to commit %q", command)
+}
+
+// waitApplied waits until every one of ids has applied want
+func (c *cluster) waitApplied(want []string, ids ...string) {
+	c.t.Helper()
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	for _, id := range ids {
+		var got []string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if got = c.logs[id].get(); slices.Equal(got, want) {
+				break
+			}
+		}
+		assert.Equal(c.t, want, got, "node %s", id)
+	}
+}
+
+// others returns the IDs other than id
+func (c *cluster) others(id string) []string {
+	return slices.DeleteFunc(slices.Clone(c.ids), func(other string) bool { return other == id })
+}
+
+func TestElection(t *testing.T) {
+	c := newCluster(t, 3, testConfig())
+	leader := c.waitLeader()
+	term := leader.Status().Term
+
+	// Everyone learns who the leader is from its heartbeats
+	for _, id := range c.others(leader.ID()) {
+		for deadline := time.Now().Add(time.Second); c.nodes[id].Status().Leader != leader.ID() && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(t, leader.ID(), c.nodes[id].Status().Leader)
+	}
+
+	// Cut off from the others, the leader is replaced in a later term
+	c.net.Partition([]string{leader.ID()})
+	newLeader := c.waitLeader(c.others(leader.ID())...)
+	assert.NotEqual(t, leader.ID(), newLeader.ID())
+	assert.True(t, newLeader.Status().Term > term)
+
+	// And steps down once it hears of it
+	c.net.Heal()
+	final := c.waitLeader()
+	assert.NotEqual(t, Leader, leader.Status().State, "old leader still leads after %s took over", final.ID())
+}
+
+func TestReplication(t *testing.T) {
+	c := newCluster(t, 3, testConfig())
+	leader := c.waitLeader()
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		command := fmt.Sprint("cmd", i)
+		result, err := leader.Propose(context.Background(), []byte(command))
+		assert.NoError(t, err)
+		want = append(want, command)
+		assert.Equal(t, len(want), result)
+	}
+	c.waitApplied(want)
+
+	// Followers point at the leader
+	follower := c.nodes[c.others(leader.ID())[0]]
+	_, err := follower.Propose(context.Background(), []byte("x"))
+	assert.True(t, errors.Is(err, ErrNotLeader), "got %v", err)
+	var notLeader *NotLeaderError
+	assert.True(t, errors.As(err, &notLeader))
+	assert.Equal(t, leader.ID(), notLeader.Leader)
+
+	status := leader.Status()
+	assert.Equal(t, status.LastIndex, status.CommitIndex)
+}
+
+func TestApplyFailure(t *testing.T) {
+	c := newCluster(t, 3, testConfig())
+	c.propose("a")
+	c.waitApplied([]string{"a"})
+	leader := c.waitLeader()
+
+	// An entry the state machine can't apply stops every node applying,
+	// rather than leave it out
+	_, err := leader.Propose(context.Background(), []byte("fail"))
+	assert.EqualError(t, err, "can't apply")
+	applied := leader.Status().LastApplied
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = leader.Propose(ctx, []byte("b"))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	for _, id := range c.ids {
+		assert.Equal(t, []string{"a"}, c.logs[id].get(), "node %s", id)
+		assert.True(t, c.nodes[id].Status().LastApplied <= applied, "node %s", id)
+		assert.True(t, c.nodes[id].Status().CommitIndex > applied, "node %s", id)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	c := newCluster(t, 5, testConfig())
+	c.propose("a")
+	c.waitApplied([]string{"a"})
+
+	// The leader and one follower end up in the minority, where nothing
+	// commits
+	oldLeader := c.waitLeader()
+	minority := []string{oldLeader.ID(), c.others(oldLeader.ID())[0]}
+	majority := slices.DeleteFunc(slices.Clone(c.ids), func(id string) bool { return slices.Contains(minority, id) })
+	c.net.Partition(minority, majority)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err := oldLeader.Propose(ctx, []byte("lost"))
+	cancel()
+	assert.Error(t, err)
+
+	// The majority carries on without them
+	c.propose("b", majority...)
+	c.propose("c", majority...)
+	c.waitApplied([]string{"a", "b", "c"}, majority...)
+	assert.Equal(t, []string{"a"}, c.logs[oldLeader.ID()].get())
+
+	// Once healed the minority drops the entry that never committed and
+	// catches up
+	c.net.Heal()
+	c.propose("d")
+	c.waitApplied([]string{"a", "b", "c", "d"})
+}
+
+func TestSnapshot(t *testing.T) {
+	cfg := testConfig()
+	cfg.SnapshotThreshold = 10
+	c := newCluster(t, 3, cfg)
+	leader := c.waitLeader()
+
+	// A follower misses enough that the leader drops the entries it
+	// needs from its log
+	lagging := c.others(leader.ID())[0]
+	c.net.Partition([]string{lagging})
+	var want []string
+	for i := 0; i < 50; i++ {
+		command := fmt.Sprint("cmd", i)
+		c.propose(command, c.others(lagging)...)
+		want = append(want, command)
+	}
+	leader = c.waitLeader(c.others(lagging)...)
+	assert.True(t, leader.Status().SnapshotIndex > 0)
+
+	c.net.Heal()
+	c.waitApplied(want)
+	assert.True(t, c.nodes[lagging].Status().SnapshotIndex > 0)
+
+	// A restarted node restores its own snapshot and replays the rest
+	c.nodes[lagging].Stop()
+	c.start(lagging)
+	c.waitApplied(want, lagging)
+}
+
+func TestRestart(t *testing.T) {
+	c := newCluster(t, 3, testConfig())
+	c.propose("a")
+	c.propose("b")
+	c.waitApplied([]string{"a", "b"})
+	term := c.waitLeader().Status().Term
+
+	// Every node is stopped and started again from its storage
+	for _, id := range c.ids {
+		c.nodes[id].Stop()
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	c.propose("c")
+	c.waitApplied([]string{"a", "b", "c"})
+	assert.True(t, c.waitLeader().Status().Term > term)
+}
+
+func TestBitcaskStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStorage(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SaveState(HardState{Term: 3, VotedFor: "n2"}))
+	var entries []Entry
+	for i := uint64(1); i <= 12; i++ {
+		entries = append(entries, Entry{Index: i, Term: 1 + i/5, Data: []byte(fmt.Sprint("cmd", i))})
+	}
+	entries[0].Data = nil
+	assert.NoError(t, s.Append(entries[:8]))
+	assert.NoError(t, s.Append(entries[8:]))
+	assert.NoError(t, s.TruncateFrom(11))
+	assert.NoError(t, s.SaveSnapshot(&Snapshot{Index: 4, Term: 1, Data: []byte("snap")}))
+	assert.NoError(t, s.Close())
+
+	s, err = OpenStorage(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+	state, snap, loaded, err := s.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, HardState{Term: 3, VotedFor: "n2"}, state)
+	assert.Equal(t, &Snapshot{Index: 4, Term: 1, Data: []byte("snap")}, snap)
+	assert.Equal(t, entries[4:10], loaded)
+}
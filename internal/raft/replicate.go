@@ -0,0 +1,267 @@
+package raft
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// replicator sends the leader's log to a peer for as long as the node
+// leads in term, as soon as there's something new or at least every
+// HeartbeatInterval
+func (n *Node) replicator(peer string, term uint64, trigger chan struct{}) {
+	defer n.wg.Done()
+
+	for {
+		more, leading := n.replicateTo(peer, term)
+		if !leading {
+			return
+		}
+		if more {
+			continue
+		}
+
+		select {
+		case <-n.done:
+			return
+		case <-trigger:
+		case <-time.After(n.config.HeartbeatInterval):
+		}
+	}
+}
+
+// replicateTo sends the peer the entries it's missing, or a snapshot
+// if they've been dropped from the log. It returns whether there's
+// more to send straight away and whether the node still leads in term.
+func (n *Node) replicateTo(peer string, term uint64) (more, leading bool) {
+	n.mu.Lock()
+	if n.state != Leader || n.term != term {
+		n.mu.Unlock()
+		return false, false
+	}
+	next := n.nextIndex[peer]
+	if next <= n.log[0].Index {
+		return n.sendSnapshot(peer, term)
+	}
+
+	end := min(n.lastIndex()+1, next+uint64(n.config.MaxEntries))
+	req := &AppendRequest{
+		Term:      term,
+		Leader:    n.id,
+		PrevIndex: next - 1,
+		PrevTerm:  n.termAt(next - 1),
+		Entries:   slices.Clone(n.log[next-n.log[0].Index : end-n.log[0].Index]),
+		Commit:    n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false, true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false, false
+	}
+	if n.state != Leader || n.term != term {
+		return false, false
+	}
+
+	if !resp.Success {
+		if resp.ConflictIndex == 0 {
+			return false, true
+		}
+		n.nextIndex[peer] = max(1, min(resp.ConflictIndex, req.PrevIndex))
+		return true, true
+	}
+	match := req.PrevIndex + uint64(len(req.Entries))
+	n.matchIndex[peer] = max(n.matchIndex[peer], match)
+	n.nextIndex[peer] = match + 1
+	n.advanceCommit()
+	return n.nextIndex[peer] <= n.lastIndex(), true
+}
+
+// sendSnapshot sends the peer the latest snapshot. mu must be held, and
+// is released.
+func (n *Node) sendSnapshot(peer string, term uint64) (more, leading bool) {
+	req := &SnapshotRequest{
+		Term:      term,
+		Leader:    n.id,
+		LastIndex: n.log[0].Index,
+		LastTerm:  n.log[0].Term,
+		Data:      n.snapshot,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false, true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false, false
+	}
+	if n.state != Leader || n.term != term {
+		return false, false
+	}
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIndex)
+	n.nextIndex[peer] = req.LastIndex + 1
+	n.advanceCommit()
+	return n.nextIndex[peer] <= n.lastIndex(), true
+}
+
+// RequestVote grants the candidate the node's vote if it hasn't voted
+// for anyone else this term and the candidate's log is at least as up
+// to date as its own, so a leader always has every committed entry
+func (n *Node) RequestVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &VoteResponse{Term: n.term}
+	if n.stopped || req.Term < n.term {
+		return resp
+	}
+	if req.Term > n.term {
+		if err := n.stepDown(req.Term); err != nil {
+			return resp
+		}
+		resp.Term = n.term
+	}
+
+	lastIndex := n.lastIndex()
+	lastTerm := n.termAt(lastIndex)
+	upToDate := req.LastTerm > lastTerm || req.LastTerm == lastTerm && req.LastIndex >= lastIndex
+	if !upToDate || n.votedFor != "" && n.votedFor != req.Candidate {
+		return resp
+	}
+
+	n.votedFor = req.Candidate
+	if err := n.saveState(); err != nil {
+		n.votedFor = ""
+		return resp
+	}
+	n.resetElectionDeadline()
+	resp.Granted = true
+	return resp
+}
+
+// AppendEntries appends the leader's entries to the log, dropping any
+// of its own that conflict, as long as the entry before them matches
+func (n *Node) AppendEntries(req *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &AppendResponse{Term: n.term}
+	if n.stopped || req.Term < n.term {
+		return resp
+	}
+	if err := n.stepDown(req.Term); err != nil {
+		return resp
+	}
+	resp.Term = n.term
+	n.leader = req.Leader
+	n.resetElectionDeadline()
+
+	base := n.log[0].Index
+	prevIndex, prevTerm, entries := req.PrevIndex, req.PrevTerm, req.Entries
+	if prevIndex < base {
+		// The entries the snapshot covers are committed, so they match
+		skip := base - prevIndex
+		if uint64(len(entries)) <= skip {
+			resp.Success = true
+			return resp
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = base, n.log[0].Term
+	}
+
+	if prevIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		index := prevIndex
+		for index > base+1 && n.termAt(index-1) == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := n.storage.TruncateFrom(e.Index); err != nil {
+				return resp
+			}
+			n.log = n.log[:e.Index-base]
+		}
+		if err := n.storage.Append(entries[i:]); err != nil {
+			return resp
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	// Only what matches the leader's log is known to be committed
+	if commit := min(req.Commit, req.PrevIndex+uint64(len(req.Entries))); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.applyCond.Broadcast()
+	}
+	resp.Success = true
+	return resp
+}
+
+// InstallSnapshot replaces the log up to the snapshot's last entry with
+// the snapshot, keeping any entries after it that match, and has the
+// applier restore it
+func (n *Node) InstallSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &SnapshotResponse{Term: n.term}
+	if n.stopped || req.Term < n.term {
+		return resp
+	}
+	if err := n.stepDown(req.Term); err != nil {
+		return resp
+	}
+	resp.Term = n.term
+	n.leader = req.Leader
+	n.resetElectionDeadline()
+
+	if req.LastIndex <= n.commitIndex {
+		return resp // Already has everything it covers
+	}
+
+	base := n.log[0].Index
+	var rest []Entry
+	if req.LastIndex <= n.lastIndex() && n.termAt(req.LastIndex) == req.LastTerm {
+		rest = n.log[req.LastIndex-base+1:]
+	} else if err := n.storage.TruncateFrom(base + 1); err != nil {
+		return resp
+	}
+	snap := &Snapshot{Index: req.LastIndex, Term: req.LastTerm, Data: req.Data}
+	if err := n.storage.SaveSnapshot(snap); err != nil {
+		return resp
+	}
+
+	n.log = append([]Entry{{Index: req.LastIndex, Term: req.LastTerm}}, rest...)
+	n.snapshot = req.Data
+	n.commitIndex = req.LastIndex
+	n.pendingSnapshot = req.Data
+	n.applyCond.Broadcast()
+	return resp
+}
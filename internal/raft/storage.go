@@ -0,0 +1,253 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// HardState is what a node has to remember across restarts to never
+// vote twice in a term
+type HardState struct {
+	Term     uint64
+	VotedFor string
+}
+
+// Snapshot is the state machine's state as of an entry of the log
+type Snapshot struct {
+	Index uint64 // The last entry the snapshot covers
+	Term  uint64 // The term of that entry
+	Data  []byte
+}
+
+// Storage saves a node's state and log so it survives restarts. Every
+// method must be durable by the time it returns.
+type Storage interface {
+	// Load returns everything saved: the hard state, the latest
+	// snapshot (nil if there's none) and the entries after it
+	Load() (HardState, *Snapshot, []Entry, error)
+	SaveState(state HardState) error
+
+	// Append saves entries that follow the last one saved
+	Append(entries []Entry) error
+
+	// TruncateFrom deletes the entry at index and the ones after it
+	TruncateFrom(index uint64) error
+
+	// SaveSnapshot saves a snapshot and deletes the entries it covers
+	SaveSnapshot(s *Snapshot) error
+}
+
+// MemoryStorage keeps everything in memory, for tests. A node started
+// again with the same storage picks up where it left off.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	state    HardState
+	snapshot *Snapshot
+	entries  []Entry
+}
+
+// NewMemoryStorage returns an empty storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, s.snapshot, slices.Clone(s.entries), nil
+}
+
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *MemoryStorage) TruncateFrom(index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = slices.DeleteFunc(s.entries, func(e Entry) bool { return e.Index >= index })
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = snap
+	s.entries = slices.DeleteFunc(s.entries, func(e Entry) bool { return e.Index <= snap.Index })
+	return nil
+}
+
+// Keys BitcaskStorage keeps its data under
+const (
+	stateKey    = "state"
+	snapshotKey = "snapshot"
+	entryPrefix = "log/"
+)
+
+// BitcaskStorage keeps a node's state and log in a Bitcask database,
+// an entry per key, with every write synced
+type BitcaskStorage struct {
+	db *bitcask.Bitcask
+}
+
+// OpenStorage opens the storage in dir, creating it if needed
+func OpenStorage(dir string) (*BitcaskStorage, error) {
+	cfg := bitcask.DefaultConfig()
+	cfg.SyncWrites = true
+	db, err := bitcask.Open(dir, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft storage: %w", err)
+	}
+	return &BitcaskStorage{db: db}, nil
+}
+
+// Close closes the database
+func (s *BitcaskStorage) Close() error {
+	return s.db.Close()
+}
+
+// entryKey returns the key of the entry at index, which sorts in index
+// order
+func entryKey(index uint64) string {
+	return fmt.Sprintf("%s%020d", entryPrefix, index)
+}
+
+// encodeEntry returns an entry's term, whether it has data and its
+// data
+func encodeEntry(e Entry) []byte {
+	buf := binary.BigEndian.AppendUint64(nil, e.Term)
+	if e.Data == nil {
+		return append(buf, 0)
+	}
+	return append(append(buf, 1), e.Data...)
+}
+
+// decodeEntry reads an entry written by encodeEntry
+func decodeEntry(index uint64, value []byte) (Entry, error) {
+	if len(value) < 9 {
+		return Entry{}, fmt.Errorf("entry %d is only %d bytes", index, len(value))
+	}
+	e := Entry{Index: index, Term: binary.BigEndian.Uint64(value)}
+	if value[8] == 1 {
+		e.Data = value[9:]
+	}
+	return e, nil
+}
+
+func (s *BitcaskStorage) Load() (HardState, *Snapshot, []Entry, error) {
+	var state HardState
+	value, err := s.db.Get(stateKey)
+	if err == nil {
+		err = json.Unmarshal(value, &state)
+	}
+	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+		return state, nil, nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	var snap *Snapshot
+	value, err = s.db.Get(snapshotKey)
+	switch {
+	case err == nil && len(value) >= 16:
+		snap = &Snapshot{
+			Index: binary.BigEndian.Uint64(value),
+			Term:  binary.BigEndian.Uint64(value[8:]),
+			Data:  value[16:],
+		}
+	case err == nil:
+		return state, nil, nil, fmt.Errorf("snapshot is only %d bytes", len(value))
+	case !errors.Is(err, bitcask.ErrKeyNotFound):
+		return state, nil, nil, fmt.Errorf("failed to load snapshot: %w", err)
+	}
+
+	var entries []Entry
+	err = s.db.Scan(entryPrefix, entryPrefix[:len(entryPrefix)-1]+"0", func(key string, value []byte) bool {
+		var index uint64
+		if _, err = fmt.Sscanf(strings.TrimPrefix(key, entryPrefix), "%d", &index); err != nil {
+			return false
+		}
+		var e Entry
+		if e, err = decodeEntry(index, value); err != nil {
+			return false
+		}
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		return state, nil, nil, fmt.Errorf("failed to load log: %w", err)
+	}
+	return state, snap, entries, nil
+}
+
+func (s *BitcaskStorage) SaveState(state HardState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(stateKey, value)
+}
+
+func (s *BitcaskStorage) Append(entries []Entry) error {
+	var b bitcask.Batch
+	for _, e := range entries {
+		b.Put(entryKey(e.Index), encodeEntry(e))
+	}
+	_, err := s.db.Apply(&b)
+	return err
+}
+
+// entryKeys returns the keys of the entries for which keep returns
+// true
+func (s *BitcaskStorage) entryKeys(keep func(key string) bool) []string {
+	var keys []string
+	for _, key := range s.db.Keys() {
+		if strings.HasPrefix(key, entryPrefix) && keep(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (s *BitcaskStorage) TruncateFrom(index uint64) error {
+	from := entryKey(index)
+	var b bitcask.Batch
+	for _, key := range s.entryKeys(func(key string) bool { return key >= from }) {
+		b.Delete(key)
+	}
+	_, err := s.db.Apply(&b)
+	return err
+}
+
+// SaveSnapshot saves the snapshot and deletes the entries it covers in
+// one batch, then merges the database to reclaim their space
+func (s *BitcaskStorage) SaveSnapshot(snap *Snapshot) error {
+	value := binary.BigEndian.AppendUint64(nil, snap.Index)
+	value = binary.BigEndian.AppendUint64(value, snap.Term)
+	value = append(value, snap.Data...)
+
+	upTo := entryKey(snap.Index)
+	var b bitcask.Batch
+	b.Put(snapshotKey, value)
+	for _, key := range s.entryKeys(func(key string) bool { return key <= upTo }) {
+		b.Delete(key)
+	}
+	if _, err := s.db.Apply(&b); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return s.db.Merge()
+}
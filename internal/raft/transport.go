@@ -0,0 +1,171 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnreachable is returned by a transport when a node can't be
+// reached
+var ErrUnreachable = errors.New("node unreachable")
+
+// VoteRequest asks for a node's vote in an election
+type VoteRequest struct {
+	Term      uint64
+	Candidate string
+	LastIndex uint64 // Index of the candidate's last entry
+	LastTerm  uint64 // Term of the candidate's last entry
+}
+
+// VoteResponse answers a VoteRequest
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest carries entries from the leader, or none as a
+// heartbeat
+type AppendRequest struct {
+	Term      uint64
+	Leader    string
+	PrevIndex uint64 // Index of the entry before Entries
+	PrevTerm  uint64 // Term of the entry before Entries
+	Entries   []Entry
+	Commit    uint64 // The leader's commit index
+}
+
+// AppendResponse answers an AppendRequest
+type AppendResponse struct {
+	Term    uint64
+	Success bool
+
+	// Where the leader should go back to after a failure: the first
+	// entry of the conflicting term, or after the last entry if the
+	// log is too short. 0 if there's no hint.
+	ConflictIndex uint64
+}
+
+// SnapshotRequest sends a snapshot to a node that is too far behind
+// for the entries it needs to still be in the leader's log
+type SnapshotRequest struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64 // Index of the last entry the snapshot covers
+	LastTerm  uint64 // Term of that entry
+	Data      []byte
+}
+
+// SnapshotResponse answers a SnapshotRequest
+type SnapshotResponse struct {
+	Term uint64
+}
+
+// Transport sends requests to the other nodes
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// Handler handles the requests a Transport delivers. Node implements
+// it.
+type Handler interface {
+	RequestVote(req *VoteRequest) *VoteResponse
+	AppendEntries(req *AppendRequest) *AppendResponse
+	InstallSnapshot(req *SnapshotRequest) *SnapshotResponse
+}
+
+// Network connects nodes in memory, for tests. It can be split into
+// partitions whose nodes only reach each other.
+type Network struct {
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	partition map[string]int // Which partition each node is in, 0 if none was given
+}
+
+// NewNetwork returns a network with every node connected
+func NewNetwork() *Network {
+	return &Network{handlers: make(map[string]Handler), partition: make(map[string]int)}
+}
+
+// Register delivers requests for id to h, in place of any handler
+// registered before
+func (nw *Network) Register(id string, h Handler) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	nw.handlers[id] = h
+}
+
+// Partition splits the network: the nodes of each group only reach
+// each other, and nodes in no group only reach each other
+func (nw *Network) Partition(groups ...[]string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	nw.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			nw.partition[id] = i + 1
+		}
+	}
+}
+
+// Heal reconnects every node
+func (nw *Network) Heal() {
+	nw.Partition()
+}
+
+// Transport returns the transport the node from uses
+func (nw *Network) Transport(from string) Transport {
+	return &memTransport{nw: nw, from: from}
+}
+
+// handler returns the handler of to if from can reach it
+func (nw *Network) handler(from, to string) (Handler, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	h, ok := nw.handlers[to]
+	if !ok || nw.partition[from] != nw.partition[to] {
+		return nil, fmt.Errorf("%w: %s from %s", ErrUnreachable, to, from)
+	}
+	return h, nil
+}
+
+// memTransport sends requests over a Network
+type memTransport struct {
+	nw   *Network
+	from string
+}
+
+// call delivers a request with fn, dropping the response if the
+// network was partitioned while it was handled
+func call[T any](ctx context.Context, t *memTransport, to string, fn func(Handler) T) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	h, err := t.nw.handler(t.from, to)
+	if err != nil {
+		return zero, err
+	}
+	resp := fn(h)
+	if _, err := t.nw.handler(t.from, to); err != nil {
+		return zero, err
+	}
+	return resp, nil
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error) {
+	return call(ctx, t, to, func(h Handler) *VoteResponse { return h.RequestVote(req) })
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error) {
+	return call(ctx, t, to, func(h Handler) *AppendResponse { return h.AppendEntries(req) })
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	return call(ctx, t, to, func(h Handler) *SnapshotResponse { return h.InstallSnapshot(req) })
+}
@@ -0,0 +1,217 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// Status is how far a follower has got
+type Status struct {
+	Position  bitcask.Position // Where in the primary's log the follower has applied up to
+	Connected bool             // Whether it's streaming from the primary
+	CaughtUp  bool             // Whether it had applied all the primary had written, as of the last chunk
+	Err       error            // Why it last lost the primary, nil if it never did
+}
+
+// Follower keeps a database in step with a primary's by applying the
+// records of its log. The database is made read-only, so only the
+// primary's writes change it.
+type Follower struct {
+	db     *bitcask.Bitcask
+	client kvdbpb.ReplicationClient
+	config *Config
+
+	// Keys the follower had when it started over from the beginning of
+	// the log that it hasn't seen since. Any left once it's caught up
+	// were deleted on the primary. Only touched by Run.
+	stale map[string]bool
+
+	mu     sync.Mutex // Guards status
+	status Status
+}
+
+// NewFollower returns a follower that applies the log of the primary
+// at the other end of conn to db, starting from the position saved in
+// cfg.PositionFile if there is one
+func NewFollower(db *bitcask.Bitcask, conn grpc.ClientConnInterface, cfg *Config) (*Follower, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	f := &Follower{db: db, client: kvdbpb.NewReplicationClient(conn), config: cfg}
+	if cfg.PositionFile != "" {
+		data, err := os.ReadFile(cfg.PositionFile)
+		if err == nil {
+			_, err = fmt.Sscanf(string(data), "%d %d", &f.status.Position.FileID, &f.status.Position.Offset)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read position: %w", err)
+		}
+	}
+	if f.status.Position == (bitcask.Position{}) {
+		f.startOver()
+	}
+
+	db.SetReadOnly(true)
+	return f, nil
+}
+
+// Status returns how far the follower has got
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// Run follows the primary until ctx is done, reconnecting after
+// RetryInterval whenever the stream breaks. It returns ctx's error, or
+// the error if the records can't be applied or are too big to send.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := status.FromError(err); !ok && !errors.Is(err, io.EOF) {
+			return err // Not the connection's fault
+		}
+		if status.Code(err) == codes.ResourceExhausted {
+			// The same chunk would only fail again, and every write
+			// after it would never arrive
+			return fmt.Errorf("records too big to replicate: %w", err)
+		}
+
+		f.mu.Lock()
+		f.status.Connected = false
+		f.status.Err = err
+		f.mu.Unlock()
+		log.Printf("replication: lost primary, retrying in %s: %v", f.config.RetryInterval, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.config.RetryInterval):
+		}
+	}
+}
+
+// follow streams the log from where the follower got to and applies
+// it until the stream breaks
+func (f *Follower) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A chunk holds at least one whole record or batch however big it
+	// is, so it's only limited by what gRPC can send
+	pos := f.Status().Position
+	stream, err := f.client.Follow(ctx, &kvdbpb.FollowRequest{FileId: pos.FileID, Offset: pos.Offset},
+		grpc.MaxCallRecvMsgSize(math.MaxInt32))
+	if err != nil {
+		return err
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if chunk.Restarted {
+			f.startOver()
+		}
+
+		records := make([]bitcask.LogRecord, len(chunk.Records))
+		for i, r := range chunk.Records {
+			records[i] = bitcask.LogRecord{
+				Key:       string(r.Key),
+				Value:     r.Value,
+				Timestamp: r.Timestamp,
+				Expires:   r.Expires,
+				Delete:    r.Delete,
+				Batch:     r.Batch,
+			}
+			delete(f.stale, records[i].Key)
+		}
+		if err := f.db.ApplyLog(records); err != nil {
+			return fmt.Errorf("failed to apply records: %w", err)
+		}
+		if chunk.CaughtUp && f.stale != nil {
+			if err := f.deleteStale(); err != nil {
+				return err
+			}
+		}
+
+		// While starting over the saved position stays where it was,
+		// so a restart starts over again rather than leave stale keys
+		next := bitcask.Position{FileID: chunk.FileId, Offset: chunk.Offset}
+		if f.stale == nil {
+			if err := f.savePosition(next); err != nil {
+				return err
+			}
+		}
+
+		f.mu.Lock()
+		f.status.Position = next
+		f.status.Connected = true
+		f.status.CaughtUp = chunk.CaughtUp
+		f.mu.Unlock()
+	}
+}
+
+// startOver notes the keys the follower has before it applies the
+// whole log again
+func (f *Follower) startOver() {
+	f.stale = make(map[string]bool)
+	for _, key := range f.db.Keys() {
+		f.stale[key] = true
+	}
+}
+
+// deleteStale deletes the keys that weren't in the log when the
+// follower started over
+func (f *Follower) deleteStale() error {
+	records := make([]bitcask.LogRecord, 0, len(f.stale))
+	now := time.Now().UnixNano()
+	for key := range f.stale {
+		records = append(records, bitcask.LogRecord{Key: key, Timestamp: now, Delete: true})
+	}
+	if err := f.db.ApplyLog(records); err != nil {
+		return fmt.Errorf("failed to delete stale keys: %w", err)
+	}
+	f.stale = nil
+	return nil
+}
+
+// savePosition makes the records applied so far durable and then
+// saves the position after them. A crash in between only means some
+// records are applied twice, which leaves the same data.
+func (f *Follower) savePosition(pos bitcask.Position) error {
+	if f.config.PositionFile == "" {
+		return nil
+	}
+	if err := f.db.Sync(); err != nil {
+		return err
+	}
+
+	tmp := f.config.PositionFile + ".tmp"
+	if err := os.WriteFile(tmp, fmt.Appendf(nil, "%d %d\n", pos.FileID, pos.Offset), 0644); err != nil {
+		return fmt.Errorf("failed to save position: %w", err)
+	}
+	if err := os.Rename(tmp, f.config.PositionFile); err != nil {
+		return fmt.Errorf("failed to save position: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// primary is a database served over gRPC on a loopback port
+type primary struct {
+	db   *bitcask.Bitcask
+	addr string
+	gs   *grpc.Server
+}
+
+// startPrimary opens a database in dir and serves it on addr, a free
+// port if it's empty
+func startPrimary(t *testing.T, dir, addr string, cfg *bitcask.Config) *primary {
+	t.Helper()
+
+	db, err := bitcask.Open(dir, cfg)
+	assert.NoError(t, err)
+	p := &primary{db: db}
+	p.serve(t, addr)
+	t.Cleanup(func() {
+		p.gs.Stop()
+		db.Close()
+	})
+	return p
+}
+
+// serve starts serving the database on addr, a free port if it's empty
+func (p *primary) serve(t *testing.T, addr string) {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	p.addr = ln.Addr().String()
+
+	p.gs = grpc.NewServer()
+	NewServer(p.db, &Config{ChunkSize: 256}).Register(p.gs)
+	go p.gs.Serve(ln)
+}
+
+// follower is a database following a primary
+type follower struct {
+	*Follower
+	db   *bitcask.Bitcask
+	stop func()
+}
+
+// startFollower opens a database in dir and follows the primary at
+// addr from the position saved in dir
+func startFollower(t *testing.T, dir, addr string) *follower {
+	t.Helper()
+
+	db, err := bitcask.Open(dir, nil)
+	assert.NoError(t, err)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+
+	cfg := &Config{RetryInterval: 10 * time.Millisecond, PositionFile: filepath.Join(dir, "replication.pos")}
+	f, err := NewFollower(db, conn, cfg)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.Run(ctx) }()
+
+	fl := &follower{Follower: f, db: db}
+	stopped := false
+	fl.stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		assert.True(t, errors.Is(<-done, context.Canceled))
+		conn.Close()
+		assert.NoError(t, db.Close())
+	}
+	t.Cleanup(fl.stop)
+	return fl
+}
+
+// waitInSync waits until the follower has the same keys, values and
+// versions as the primary
+func waitInSync(t *testing.T, p *primary, f *follower) {
+	t.Helper()
+
+	var diff string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if diff = compare(p.db, f.db); diff == "" && f.Status().CaughtUp {
+			return
+		}
+	}
+	t.Fatalf("follower didn't catch up: %s (status %+v)", diff, f.Status())
+}
+
+// compare returns how b's data differs from a's, empty if it doesn't
+func compare(a, b *bitcask.Bitcask) string {
+	if len(a.Keys()) != len(b.Keys()) {
+		return fmt.Sprintf("%d keys, not %d", len(b.Keys()), len(a.Keys()))
+	}
+	for _, key := range a.Keys() {
+		want, wantVersion, err := a.GetWithVersion(key)
+		if err != nil {
+			return err.Error()
+		}
+		got, version, err := b.GetWithVersion(key)
+		if err != nil {
+			return err.Error()
+		}
+		if string(got) != string(want) || version != wantVersion {
+			return fmt.Sprintf("%s is %q at %d, not %q at %d", key, got, version, want, wantVersion)
+		}
+	}
+	return ""
+}
+
+func TestFollow(t *testing.T) {
+	p := startPrimary(t, t.TempDir(), "", nil)
+	assert.NoError(t, p.db.Put("before", []byte("1")))
+
+	f := startFollower(t, t.TempDir(), p.addr)
+	waitInSync(t, p, f)
+
+	// New writes stream through as they're made
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, p.db.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))))
+	}
+	var b bitcask.Batch
+	b.Put("a", []byte("1"))
+	b.Put("b", []byte("2"))
+	b.Delete("key000")
+	_, err := p.db.Apply(&b)
+	assert.NoError(t, err)
+	assert.NoError(t, p.db.PutWithTTL("temp", []byte("v"), time.Hour))
+	waitInSync(t, p, f)
+
+	expiry, err := f.db.Expiry("temp")
+	assert.NoError(t, err)
+	want, err := p.db.Expiry("temp")
+	assert.NoError(t, err)
+	assert.True(t, want.Equal(expiry))
+
+	// The follower only serves reads
+	err = f.db.Put("mine", []byte("v"))
+	assert.True(t, errors.Is(err, bitcask.ErrReadOnly), "got %v", err)
+
+	status := f.Status()
+	assert.True(t, status.Connected)
+	assert.Equal(t, p.db.Stats().ActiveFileID, status.Position.FileID)
+}
+
+func TestBigValues(t *testing.T) {
+	p := startPrimary(t, t.TempDir(), "", nil)
+	f := startFollower(t, t.TempDir(), p.addr)
+
+	// Over gRPC's default limit of 4MB
+	big := bytes.Repeat([]byte("x"), 5<<20)
+	assert.NoError(t, p.db.Put("big", big))
+	assert.NoError(t, p.db.Put("after", []byte("v")))
+	waitInSync(t, p, f)
+
+	// A chunk that can't be sent at all stops the follower rather than
+	// failing over and over
+	db, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.Put("big", big))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	gs := grpc.NewServer(grpc.MaxSendMsgSize(1 << 20))
+	NewServer(db, nil).Register(gs)
+	go gs.Serve(ln)
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	fdb, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer fdb.Close()
+	fl, err := NewFollower(fdb, conn, &Config{RetryInterval: time.Hour})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = fl.Run(ctx)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "got %v", err)
+}
+
+func TestCatchUp(t *testing.T) {
+	cfg := bitcask.DefaultConfig()
+	cfg.MaxFileSize = 1024 // Rotate often
+	p := startPrimary(t, t.TempDir(), "", cfg)
+	followerDir := t.TempDir()
+	f := startFollower(t, followerDir, p.addr)
+
+	assert.NoError(t, p.db.Put("k", []byte("v")))
+	waitInSync(t, p, f)
+
+	// While the follower is down the primary fills several files
+	f.stop()
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, p.db.Put(fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))))
+	}
+	assert.True(t, p.db.Stats().DataFiles > 3)
+
+	f = startFollower(t, followerDir, p.addr)
+	waitInSync(t, p, f)
+
+	// And while the primary is down it carries on serving reads, then
+	// reconnects
+	p.gs.Stop()
+	value, err := f.db.Get("key001")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.NoError(t, p.db.Delete("key001"))
+	p.serve(t, p.addr)
+	waitInSync(t, p, f)
+	assert.True(t, f.Status().Err != nil)
+}
+
+func TestStartOverAfterMerge(t *testing.T) {
+	p := startPrimary(t, t.TempDir(), "", nil)
+	followerDir := t.TempDir()
+	f := startFollower(t, followerDir, p.addr)
+
+	assert.NoError(t, p.db.Put("kept", []byte("1")))
+	assert.NoError(t, p.db.Put("gone", []byte("2")))
+	waitInSync(t, p, f)
+	f.stop()
+
+	// The merge drops the tombstone, so the follower can only learn
+	// the key is gone by reading the whole log again
+	assert.NoError(t, p.db.Delete("gone"))
+	assert.NoError(t, p.db.Put("new", []byte("3")))
+	assert.NoError(t, p.db.Merge())
+
+	f = startFollower(t, followerDir, p.addr)
+	waitInSync(t, p, f)
+	_, err := f.db.Get("gone")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+
+	// Having caught up it follows on from the merged files as usual
+	assert.NoError(t, p.db.Put("after", []byte("4")))
+	waitInSync(t, p, f)
+}
@@ -0,0 +1,121 @@
+// Package replication keeps read-only followers in step with a
+// primary by streaming its Bitcask log to them over gRPC. A follower
+// remembers where in the log it got to, a data file ID and an offset,
+// and picks up from there when it reconnects.
+package replication
+
+import (
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// Config holds configuration options for replication
+type Config struct {
+	ChunkSize     int           // Bytes of keys and values the primary sends at a time, a batch can go over
+	RetryInterval time.Duration // How long a follower waits to reconnect after losing its primary
+	PositionFile  string        // Where a follower saves its position, none to start over after every restart
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		ChunkSize:     1024 * 1024, // 1MB
+		RetryInterval: time.Second,
+	}
+}
+
+// Server implements the Replication service (see
+// grpcapi/kvdbpb/replication.proto) over a primary's database
+type Server struct {
+	kvdbpb.UnimplementedReplicationServer
+	db     *bitcask.Bitcask
+	config *Config
+}
+
+// NewServer returns a server for db. The caller still owns db and
+// closes it after the gRPC server.
+func NewServer(db *bitcask.Bitcask, cfg *Config) *Server {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	return &Server{db: db, config: cfg}
+}
+
+// Register registers the Replication service on gs
+func (s *Server) Register(gs *grpc.Server) {
+	kvdbpb.RegisterReplicationServer(gs, s)
+}
+
+// Follow streams the log from the position asked for, or from the
+// beginning if a merge deleted it, and then every write as it's made
+func (s *Server) Follow(req *kvdbpb.FollowRequest, stream grpc.ServerStreamingServer[kvdbpb.LogChunk]) error {
+	// Watching before reading means a write made after the end of the
+	// log was read still wakes the stream up
+	w := s.db.Watch("")
+	defer func() { w.Close() }()
+
+	pos := bitcask.Position{FileID: req.FileId, Offset: req.Offset}
+	restarted, caughtUp := false, false
+	for {
+		records, next, err := s.db.ReadLog(pos, s.config.ChunkSize)
+		if errors.Is(err, bitcask.ErrPositionGone) && pos != (bitcask.Position{}) {
+			pos, restarted = bitcask.Position{}, true
+			continue
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		if len(records) == 0 && next != pos {
+			pos = next // Skipped to the next file
+			continue
+		}
+		if len(records) > 0 || !caughtUp {
+			chunk := &kvdbpb.LogChunk{
+				Records:   make([]*kvdbpb.LogRecord, len(records)),
+				FileId:    next.FileID,
+				Offset:    next.Offset,
+				Restarted: restarted,
+				CaughtUp:  len(records) == 0,
+			}
+			for i, rec := range records {
+				chunk.Records[i] = &kvdbpb.LogRecord{
+					Key:       []byte(rec.Key),
+					Value:     rec.Value,
+					Timestamp: rec.Timestamp,
+					Expires:   rec.Expires,
+					Delete:    rec.Delete,
+					Batch:     rec.Batch,
+				}
+			}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			pos, restarted, caughtUp = next, false, chunk.CaughtUp
+			continue
+		}
+
+		// Wait for the next write
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+
+		case _, ok := <-w.Events():
+			if !ok {
+				if w.Err() == nil {
+					return status.Error(codes.Unavailable, "database closed")
+				}
+				// Fell behind, but everything is read from the log
+				// anyway
+				w = s.db.Watch("")
+			}
+		}
+	}
+}
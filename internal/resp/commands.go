@@ -0,0 +1,520 @@
+package resp
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/glob"
+)
+
+// command is a Redis command. arity counts the command name like Redis
+// does: N means exactly N arguments, -N at least N.
+type command struct {
+	arity int
+	fn    func(s *Server, sess *session, args [][]byte)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":    {-1, cmdPing},
+		"get":     {2, cmdGet},
+		"set":     {-3, cmdSet},
+		"del":     {-2, cmdDel},
+		"exists":  {-2, cmdExists},
+		"keys":    {2, cmdKeys},
+		"scan":    {-2, cmdScan},
+		"mget":    {-2, cmdMget},
+		"mset":    {-3, cmdMset},
+		"expire":  {3, cmdExpire},
+		"ttl":     {2, cmdTTL},
+		"info":    {-1, cmdInfo},
+		"hello":   {-1, cmdHello},
+		"select":  {2, cmdSelect},
+		"command": {-1, cmdCommand},
+		"quit":    {1, cmdQuit},
+	}
+}
+
+// dispatch runs one command and writes its reply
+func (s *Server) dispatch(sess *session, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		sess.w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		sess.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+	cmd.fn(s, sess, args)
+}
+
+// dbError writes an error from the database
+func dbError(sess *session, err error) {
+	if errors.Is(err, bitcask.ErrReadOnly) {
+		sess.w.error("READONLY You can't write against a read only replica.")
+		return
+	}
+	sess.w.error("ERR " + err.Error())
+}
+
+// parseInt parses an integer argument, writing the error reply if it
+// isn't one
+func parseInt(sess *session, arg []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		sess.w.error("ERR value is not an integer or out of range")
+		return 0, false
+	}
+	return n, true
+}
+
+// ttlDuration converts n units to a duration, false if it overflows
+func ttlDuration(n int64, unit time.Duration) (time.Duration, bool) {
+	if n > math.MaxInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// exists reports whether key is in the database without reading its
+// value
+func (s *Server) exists(key string) (bool, error) {
+	_, err := s.db.Expiry(key)
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PING [message]
+func cmdPing(s *Server, sess *session, args [][]byte) {
+	switch len(args) {
+	case 1:
+		sess.w.simple("PONG")
+	case 2:
+		sess.w.bulk(args[1])
+	default:
+		sess.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// GET key
+func cmdGet(s *Server, sess *session, args [][]byte) {
+	value, err := s.db.Get(string(args[1]))
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		sess.w.null()
+	case err != nil:
+		dbError(sess, err)
+	default:
+		sess.w.bulk(value)
+	}
+}
+
+// SET key value [NX | XX] [EX seconds | PX milliseconds | KEEPTTL]
+func cmdSet(s *Server, sess *session, args [][]byte) {
+	key, value := string(args[1]), args[2]
+
+	var ttl time.Duration
+	var nx, xx, keepTTL bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "KEEPTTL" && ttl == 0:
+			keepTTL = true
+		case (opt == "EX" || opt == "PX") && ttl == 0 && !keepTTL && i+1 < len(args):
+			i++
+			n, ok := parseInt(sess, args[i])
+			if !ok {
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if ttl, ok = ttlDuration(n, unit); !ok || n <= 0 {
+				sess.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+		default:
+			sess.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if nx || xx || keepTTL {
+		expiry, err := s.db.Expiry(key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+			dbError(sess, err)
+			return
+		}
+		if (nx && exists) || (xx && !exists) {
+			sess.w.null()
+			return
+		}
+		if keepTTL && !expiry.IsZero() {
+			ttl = time.Until(expiry)
+			if ttl <= 0 {
+				ttl = time.Nanosecond // Expired since the check, let it go
+			}
+		}
+	}
+
+	if err := s.db.PutWithTTL(key, value, ttl); err != nil {
+		dbError(sess, err)
+		return
+	}
+	sess.w.simple("OK")
+}
+
+// DEL key [key ...]
+func cmdDel(s *Server, sess *session, args [][]byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var deleted int64
+	for _, key := range args[1:] {
+		err := s.db.Delete(string(key))
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			dbError(sess, err)
+			return
+		}
+		deleted++
+	}
+	sess.w.int(deleted)
+}
+
+// EXISTS key [key ...], a key given twice counts twice
+func cmdExists(s *Server, sess *session, args [][]byte) {
+	var count int64
+	for _, key := range args[1:] {
+		exists, err := s.exists(string(key))
+		if err != nil {
+			dbError(sess, err)
+			return
+		}
+		if exists {
+			count++
+		}
+	}
+	sess.w.int(count)
+}
+
+// KEYS pattern
+func cmdKeys(s *Server, sess *session, args [][]byte) {
+	pattern := string(args[1])
+
+	var keys []string
+	for _, key := range s.db.Keys() {
+		if glob.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	sess.w.array(len(keys))
+	for _, key := range keys {
+		sess.w.bulkString(key)
+	}
+}
+
+// scanHash orders keys for SCAN
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// Keys are visited in order of their hash and the cursor is the hash
+// to carry on from, so like in Redis a key that's there for the whole
+// scan is returned, whatever else is added or deleted meanwhile. Keys
+// sharing a hash are returned together.
+func cmdScan(s *Server, sess *session, args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		sess.w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count, typ := "*", int64(10), ""
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			sess.w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			n, ok := parseInt(sess, args[i+1])
+			if !ok {
+				return
+			}
+			if n < 1 {
+				sess.w.error("ERR syntax error")
+				return
+			}
+			count = n
+		case "TYPE":
+			typ = strings.ToLower(string(args[i+1]))
+		default:
+			sess.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	type hashedKey struct {
+		hash uint64
+		key  string
+	}
+	var candidates []hashedKey
+	for _, key := range s.db.Keys() {
+		if h := scanHash(key); h >= cursor {
+			candidates = append(candidates, hashedKey{h, key})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b hashedKey) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(a.key, b.key))
+	})
+
+	// COUNT is how many keys to look at, not how many to return
+	n := min(int(count), len(candidates))
+	for n < len(candidates) && n > 0 && candidates[n].hash == candidates[n-1].hash {
+		n++
+	}
+
+	next := uint64(0)
+	if n < len(candidates) {
+		next = candidates[n-1].hash + 1
+	}
+
+	var keys []string
+	for _, c := range candidates[:n] {
+		if glob.Match(pattern, c.key) && (typ == "" || typ == "string") {
+			keys = append(keys, c.key)
+		}
+	}
+
+	sess.w.array(2)
+	sess.w.bulkString(strconv.FormatUint(next, 10))
+	sess.w.array(len(keys))
+	for _, key := range keys {
+		sess.w.bulkString(key)
+	}
+}
+
+// MGET key [key ...]
+func cmdMget(s *Server, sess *session, args [][]byte) {
+	values := make([][]byte, len(args)-1)
+	found := make([]bool, len(args)-1)
+	for i, key := range args[1:] {
+		value, err := s.db.Get(string(key))
+		if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
+			dbError(sess, err)
+			return
+		}
+		values[i], found[i] = value, err == nil
+	}
+
+	sess.w.array(len(values))
+	for i, value := range values {
+		if found[i] {
+			sess.w.bulk(value)
+		} else {
+			sess.w.null()
+		}
+	}
+}
+
+// MSET key value [key value ...]
+func cmdMset(s *Server, sess *session, args [][]byte) {
+	if len(args)%2 != 1 {
+		sess.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	// One batch, so readers and a crash see all of the keys or none
+	var b bitcask.Batch
+	for i := 1; i < len(args); i += 2 {
+		b.Put(string(args[i]), args[i+1])
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.db.Apply(&b); err != nil {
+		dbError(sess, err)
+		return
+	}
+	sess.w.simple("OK")
+}
+
+// EXPIRE key seconds, a time that isn't positive deletes the key
+func cmdExpire(s *Server, sess *session, args [][]byte) {
+	key := string(args[1])
+	seconds, ok := parseInt(sess, args[2])
+	if !ok {
+		return
+	}
+	ttl, ok := ttlDuration(seconds, time.Second)
+	if !ok {
+		sess.w.error("ERR invalid expire time in 'expire' command")
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var err error
+	if ttl <= 0 {
+		err = s.db.Delete(key)
+	} else {
+		err = s.db.Expire(key, ttl)
+	}
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		sess.w.int(0)
+	case err != nil:
+		dbError(sess, err)
+	default:
+		sess.w.int(1)
+	}
+}
+
+// TTL key, -2 if the key doesn't exist and -1 if it never expires
+func cmdTTL(s *Server, sess *session, args [][]byte) {
+	expiry, err := s.db.Expiry(string(args[1]))
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		sess.w.int(-2)
+	case err != nil:
+		dbError(sess, err)
+	case expiry.IsZero():
+		sess.w.int(-1)
+	default:
+		// Rounded to the nearest second like Redis
+		ms := time.Until(expiry).Milliseconds()
+		sess.w.int((max(ms, 0) + 500) / 1000)
+	}
+}
+
+// INFO [section ...]
+func cmdInfo(s *Server, sess *session, args [][]byte) {
+	sections := map[string]bool{}
+	for _, arg := range args[1:] {
+		sections[strings.ToLower(string(arg))] = true
+	}
+	all := len(sections) == 0 || sections["all"] || sections["everything"] || sections["default"]
+
+	var b strings.Builder
+	section := func(name string, lines ...string) {
+		if !all && !sections[strings.ToLower(name)] {
+			return
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", name)
+		for _, line := range lines {
+			b.WriteString(line)
+			b.WriteString("\r\n")
+		}
+	}
+
+	section("Server",
+		"kvdb_engine:bitcask",
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(time.Since(s.started).Seconds())),
+	)
+	section("Clients",
+		fmt.Sprintf("connected_clients:%d", s.clients()),
+	)
+	section("Stats",
+		fmt.Sprintf("total_connections_received:%d", s.connections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", s.commands.Load()),
+	)
+	section("Keyspace",
+		fmt.Sprintf("db0:keys=%d", len(s.db.Keys())),
+	)
+
+	sess.w.bulkString(b.String())
+}
+
+// HELLO [protover], switches the connection to RESP2 or RESP3
+func cmdHello(s *Server, sess *session, args [][]byte) {
+	if len(args) > 2 {
+		// AUTH and SETNAME aren't supported
+		sess.w.error("ERR syntax error")
+		return
+	}
+	if len(args) == 2 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil || (proto != 2 && proto != 3) {
+			sess.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		sess.w.proto = proto
+	}
+
+	sess.w.mapLen(7)
+	sess.w.bulkString("server")
+	sess.w.bulkString("kvdb")
+	sess.w.bulkString("version")
+	sess.w.bulkString("1.0.0")
+	sess.w.bulkString("proto")
+	sess.w.int(int64(sess.w.proto))
+	sess.w.bulkString("id")
+	sess.w.int(sess.id)
+	sess.w.bulkString("mode")
+	sess.w.bulkString("standalone")
+	sess.w.bulkString("role")
+	sess.w.bulkString("master")
+	sess.w.bulkString("modules")
+	sess.w.array(0)
+}
+
+// SELECT index, there's only database 0
+func cmdSelect(s *Server, sess *session, args [][]byte) {
+	if string(args[1]) != "0" {
+		sess.w.error("ERR DB index is out of range")
+		return
+	}
+	sess.w.simple("OK")
+}
+
+// COMMAND, clients such as redis-cli ask for it on connect. There are
+// no command docs to give.
+func cmdCommand(s *Server, sess *session, args [][]byte) {
+	sess.w.array(0)
+}
+
+// QUIT
+func cmdQuit(s *Server, sess *session, args [][]byte) {
+	sess.w.simple("OK")
+	sess.quit = true
+}
@@ -0,0 +1,231 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits on what a client can send, the same as Redis's defaults
+const (
+	maxBulkLen   = 512 << 20 // Largest bulk string
+	maxArrayLen  = 1 << 20   // Most arguments in one command
+	maxInlineLen = 64 << 10  // Longest inline command, also the read buffer size
+)
+
+// ErrProtocol is returned when a client sends something that isn't RESP
+var ErrProtocol = errors.New("protocol error")
+
+// A command is sent as an array of bulk strings
+//
+//	*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n
+//
+// or, for typing into telnet, inline as words on a line
+//
+//	GET key\r\n
+
+// reader reads commands from a client
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReaderSize(r, maxInlineLen)}
+}
+
+// buffered reports whether more of a pipeline has already arrived
+func (r *reader) buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// readCommand reads the next command, which may be empty
+func (r *reader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return splitInline(line)
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArrayLen {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	args := make([][]byte, 0, max(n, 0))
+	for range n {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads one $<len>\r\n<data>\r\n argument
+func (r *reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, fmt.Errorf("%w: expected '$', got %q", ErrProtocol, line)
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLen {
+		return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+
+	// The buffer grows as the data arrives rather than being sized by
+	// the length, so a client can't make the server allocate 512MB
+	// by only claiming to send it
+	var buf bytes.Buffer
+	buf.Grow(min(n+2, maxInlineLen))
+	if _, err := io.CopyN(&buf, r.r, int64(n+2)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data := buf.Bytes()
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return data[:n], nil
+}
+
+// readLine reads a line without its \r\n (or bare \n)
+func (r *reader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, fmt.Errorf("%w: line too long", ErrProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// splitInline splits an inline command into words. Words may be
+// quoted with "" to include spaces.
+func splitInline(line []byte) ([][]byte, error) {
+	var args [][]byte
+	for i := 0; i < len(line); {
+		switch {
+		case line[i] == ' ' || line[i] == '\t':
+			i++
+		case line[i] == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				end++
+			}
+			if end == len(line) {
+				return nil, fmt.Errorf("%w: unbalanced quotes in request", ErrProtocol)
+			}
+			args = append(args, line[i+1:end])
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+				end++
+			}
+			args = append(args, line[i:end])
+			i = end
+		}
+	}
+
+	// The line is reused by the next read
+	for i, arg := range args {
+		args[i] = append([]byte(nil), arg...)
+	}
+	return args, nil
+}
+
+// writer writes replies in RESP2, or RESP3 once a client asks for it
+// with HELLO 3. Errors stick in the bufio.Writer until flush.
+type writer struct {
+	w     *bufio.Writer
+	proto int // 2 or 3
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
+
+// simple writes a status reply such as +OK
+func (w *writer) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// error writes an error reply. msg starts with a code such as ERR.
+func (w *writer) error(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+// int writes an integer reply
+func (w *writer) int(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+// bulk writes a binary safe string reply
+func (w *writer) bulk(b []byte) {
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(b)))
+	w.w.WriteString("\r\n")
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+// bulkString writes a string reply
+func (w *writer) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+// null writes a missing value
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+	} else {
+		w.w.WriteString("$-1\r\n")
+	}
+}
+
+// array starts an array of n replies
+func (w *writer) array(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+// mapLen starts a map of n key-value pairs, which RESP2 sends as a
+// flat array
+func (w *writer) mapLen(n int) {
+	if w.proto == 3 {
+		w.w.WriteByte('%')
+		w.w.WriteString(strconv.Itoa(n))
+		w.w.WriteString("\r\n")
+	} else {
+		w.array(2 * n)
+	}
+}
@@ -0,0 +1,179 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server serves a Bitcask database to Redis clients. Each connection
+// gets its own goroutine, and commands a client pipelines are answered
+// in one write.
+type Server struct {
+	db      *bitcask.Bitcask
+	started time.Time
+
+	// writeMu is held by every write command, so the ones that read
+	// before writing, like SET NX, see no other write in between
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup // Running connection goroutines
+
+	nextID      atomic.Int64 // Client IDs for HELLO
+	connections atomic.Int64 // Connections accepted so far
+	commands    atomic.Int64 // Commands processed so far
+}
+
+// New returns a server for db. The caller still owns db and closes it
+// after the server.
+func New(db *bitcask.Bitcask) *Server {
+	return &Server{
+		db:        db,
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients
+// until Close
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts clients on ln until Close, which makes it return
+// ErrServerClosed. ln is closed when Serve returns.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		s.connections.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting clients, closes every connection and waits
+// for their goroutines to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// clients returns the number of connected clients
+func (s *Server) clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// session is the state of one client connection
+type session struct {
+	id   int64
+	w    *writer
+	quit bool // Set by QUIT, the connection closes after the reply
+}
+
+// serveConn reads commands from conn and answers them until the
+// client goes away or the server closes
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := newReader(conn)
+	sess := &session{id: s.nextID.Add(1), w: newWriter(conn)}
+
+	for !sess.quit {
+		args, err := r.readCommand()
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				msg := strings.TrimPrefix(err.Error(), ErrProtocol.Error()+": ")
+				sess.w.error("ERR Protocol error: " + msg)
+				sess.w.flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("resp: reading from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.commands.Add(1)
+		s.dispatch(sess, args)
+
+		// Replies to a pipeline go out together once it's all read
+		if !r.buffered() || sess.quit {
+			if err := sess.w.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"github.com/yashagw/kvdb/internal/bitcask"
+)
+
+// startServer serves a fresh database on a loopback port
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	db, err := bitcask.Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv := New(db)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+
+	t.Cleanup(func() {
+		assert.NoError(t, srv.Close())
+		assert.True(t, errors.Is(<-done, ErrServerClosed))
+		assert.NoError(t, db.Close())
+	})
+	return srv, ln.Addr().String()
+}
+
+// client talks RESP over a raw socket
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encode returns a command as a RESP array of bulk strings
+func encode(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+// send writes raw bytes to the server
+func (c *client) send(raw string) {
+	_, err := io.WriteString(c.conn, raw)
+	assert.NoError(c.t, err)
+}
+
+// do sends a command and returns its raw reply
+func (c *client) do(args ...string) string {
+	c.send(encode(args...))
+	return c.reply()
+}
+
+// reply reads one complete reply and returns it as sent
+func (c *client) reply() string {
+	line, err := c.r.ReadString('\n')
+	assert.NoError(c.t, err)
+
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	switch line[0] {
+	case '$':
+		if n < 0 {
+			return line
+		}
+		buf := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, buf)
+		assert.NoError(c.t, err)
+		return line + string(buf)
+	case '*', '%':
+		if line[0] == '%' {
+			n *= 2
+		}
+		for range n {
+			line += c.reply()
+		}
+	}
+	return line
+}
+
+// bulk returns the reply for a bulk string
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// array returns the reply for an array of bulk strings
+func array(items ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(items))
+	for _, item := range items {
+		s += bulk(item)
+	}
+	return s
+}
+
+func TestPing(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "+PONG\r\n", c.do("PING"))
+	assert.Equal(t, bulk("hello"), c.do("ping", "hello"))
+
+	// Inline commands, as typed into telnet
+	c.send("PING\r\n")
+	assert.Equal(t, "+PONG\r\n", c.reply())
+	c.send("ECHO_IS_UNKNOWN \"a b\"\n")
+	assert.Equal(t, "-ERR unknown command 'ECHO_IS_UNKNOWN'\r\n", c.reply())
+}
+
+func TestStrings(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "$-1\r\n", c.do("GET", "k"))
+	assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v1"))
+	assert.Equal(t, bulk("v1"), c.do("GET", "k"))
+
+	// Values are binary safe
+	binary := "line\r\nbreak\x00"
+	assert.Equal(t, "+OK\r\n", c.do("SET", "bin", binary))
+	assert.Equal(t, bulk(binary), c.do("GET", "bin"))
+
+	assert.Equal(t, "$-1\r\n", c.do("SET", "k", "v2", "NX"))
+	assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v2", "XX"))
+	assert.Equal(t, "$-1\r\n", c.do("SET", "new", "v", "XX"))
+	assert.Equal(t, bulk("v2"), c.do("GET", "k"))
+
+	assert.Equal(t, "+OK\r\n", c.do("MSET", "a", "1", "b", "2"))
+	assert.Equal(t, "*3\r\n"+bulk("1")+"$-1\r\n"+bulk("2"), c.do("MGET", "a", "missing", "b"))
+
+	// MSET writes all of its keys or none
+	long := strings.Repeat("k", 65*1024)
+	assert.True(t, strings.HasPrefix(c.do("MSET", "c", "3", long, "v"), "-ERR "))
+	assert.Equal(t, "$-1\r\n", c.do("GET", "c"))
+
+	assert.Equal(t, ":3\r\n", c.do("EXISTS", "a", "b", "a", "missing"))
+	assert.Equal(t, ":2\r\n", c.do("DEL", "a", "b", "missing"))
+	assert.Equal(t, ":0\r\n", c.do("EXISTS", "a", "b"))
+
+	assert.Equal(t, "-ERR wrong number of arguments for 'get' command\r\n", c.do("GET"))
+	assert.Equal(t, "-ERR wrong number of arguments for 'mset' command\r\n", c.do("MSET", "a", "1", "b"))
+	assert.Equal(t, "-ERR syntax error\r\n", c.do("SET", "k", "v", "NX", "XX"))
+	assert.Equal(t, "-ERR unknown command 'FLUSHALL'\r\n", c.do("FLUSHALL"))
+}
+
+func TestExpire(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, ":-2\r\n", c.do("TTL", "k"))
+	assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v"))
+	assert.Equal(t, ":-1\r\n", c.do("TTL", "k"))
+
+	assert.Equal(t, ":1\r\n", c.do("EXPIRE", "k", "100"))
+	assert.Equal(t, ":100\r\n", c.do("TTL", "k"))
+	assert.Equal(t, ":0\r\n", c.do("EXPIRE", "missing", "100"))
+
+	// KEEPTTL keeps it, a plain SET clears it
+	assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v2", "KEEPTTL"))
+	assert.Equal(t, ":100\r\n", c.do("TTL", "k"))
+	assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v3"))
+	assert.Equal(t, ":-1\r\n", c.do("TTL", "k"))
+
+	assert.Equal(t, "+OK\r\n", c.do("SET", "ex", "v", "EX", "10"))
+	assert.Equal(t, ":10\r\n", c.do("TTL", "ex"))
+	assert.Equal(t, "+OK\r\n", c.do("SET", "px", "v", "PX", "50"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "$-1\r\n", c.do("GET", "px"))
+	assert.Equal(t, ":-2\r\n", c.do("TTL", "px"))
+
+	// A time that isn't positive deletes the key
+	assert.Equal(t, ":1\r\n", c.do("EXPIRE", "k", "0"))
+	assert.Equal(t, ":0\r\n", c.do("EXISTS", "k"))
+
+	assert.Equal(t, "-ERR invalid expire time in 'set' command\r\n", c.do("SET", "k", "v", "EX", "0"))
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", c.do("EXPIRE", "ex", "soon"))
+}
+
+func TestKeysAndScan(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	want := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user:%03d", i)
+		assert.Equal(t, "+OK\r\n", c.do("SET", key, "v"))
+		want[key] = true
+	}
+	assert.Equal(t, "+OK\r\n", c.do("SET", "other", "v"))
+
+	assert.Equal(t, array("user:010", "user:011", "user:012", "user:013", "user:014",
+		"user:015", "user:016", "user:017", "user:018", "user:019"), c.do("KEYS", "user:01?"))
+	assert.Equal(t, array("other"), c.do("KEYS", "[ou]*[^0-9]"))
+	assert.Equal(t, array("user:005", "user:006"), c.do("KEYS", "user:00[5-6]"))
+
+	// Scanning returns every key once, a few at a time
+	seen := map[string]bool{}
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		assert.True(t, calls < 100, "scan doesn't end")
+
+		c.send(encode("SCAN", cursor, "MATCH", "user:*", "COUNT", "7"))
+		line, err := c.r.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "*2\r\n", line)
+
+		next := c.reply()
+		cursor = strings.Split(next, "\r\n")[1]
+
+		keys := c.reply()
+		parts := strings.Split(keys, "\r\n")
+		for i := 2; i < len(parts); i += 2 {
+			assert.False(t, seen[parts[i]], "%s returned twice", parts[i])
+			seen[parts[i]] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, want, seen)
+
+	assert.Equal(t, "-ERR invalid cursor\r\n", c.do("SCAN", "abc"))
+}
+
+func TestPipelining(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	// A thousand commands in one write, answered in order
+	var b strings.Builder
+	for i := 0; i < 500; i++ {
+		b.WriteString(encode("SET", fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i)))
+		b.WriteString(encode("GET", fmt.Sprintf("k%d", i)))
+	}
+	c.send(b.String())
+
+	for i := 0; i < 500; i++ {
+		assert.Equal(t, "+OK\r\n", c.reply())
+		assert.Equal(t, bulk(fmt.Sprintf("v%d", i)), c.reply())
+	}
+}
+
+func TestRESP3(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	hello := c.do("HELLO", "3")
+	assert.True(t, strings.HasPrefix(hello, "%7\r\n"+bulk("server")+bulk("kvdb")), hello)
+	assert.Contains(t, hello, bulk("proto")+":3\r\n")
+
+	// Nulls look different in RESP3
+	assert.Equal(t, "_\r\n", c.do("GET", "missing"))
+	assert.Equal(t, "*1\r\n_\r\n", c.do("MGET", "missing"))
+
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", c.do("HELLO", "4"))
+
+	// Back to RESP2, where the map is a flat array
+	assert.True(t, strings.HasPrefix(c.do("HELLO", "2"), "*14\r\n"))
+	assert.Equal(t, "$-1\r\n", c.do("GET", "missing"))
+}
+
+func TestInfo(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+
+	c.do("MSET", "a", "1", "b", "2")
+	info := c.do("INFO")
+	for _, want := range []string{"# Server\r\n", "connected_clients:1\r\n", "db0:keys=2\r\n"} {
+		assert.Contains(t, info, want)
+	}
+
+	keyspace := c.do("INFO", "keyspace")
+	assert.NotContains(t, keyspace, "# Server")
+	assert.Contains(t, keyspace, "# Keyspace\r\ndb0:keys=2\r\n")
+}
+
+func TestProtocolErrors(t *testing.T) {
+	_, addr := startServer(t)
+
+	c := dial(t, addr)
+	c.send("*1\r\n$4\r\nPING\r\n*1\r\n+PING\r\n")
+	assert.Equal(t, "+PONG\r\n", c.reply())
+	assert.Equal(t, "-ERR Protocol error: expected '$', got \"+PING\"\r\n", c.reply())
+
+	// The server hangs up after a protocol error
+	_, err := c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	c = dial(t, addr)
+	c.send("*1\r\n$-5\r\n")
+	assert.Equal(t, "-ERR Protocol error: invalid bulk length\r\n", c.reply())
+
+	c = dial(t, addr)
+	assert.Equal(t, "+OK\r\n", c.do("QUIT"))
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBulkLength(t *testing.T) {
+	// Claiming a big argument costs only what's actually sent
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := newReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$500000000\r\nabc")).readCommand()
+	runtime.ReadMemStats(&after)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "got %v", err)
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 1<<20, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+
+	args, err := newReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$0\r\n\r\n")).readCommand()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(args))
+	assert.Equal(t, 0, len(args[1]))
+}
+
+func TestEmptyValue(t *testing.T) {
+	dir := t.TempDir()
+	serve := func(f func(c *client)) {
+		db, err := bitcask.Open(dir, nil)
+		assert.NoError(t, err)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		srv := New(db)
+		go srv.Serve(ln)
+		f(dial(t, ln.Addr().String()))
+		assert.NoError(t, srv.Close())
+		assert.NoError(t, db.Close())
+	}
+
+	// SET k "" stores an empty string, which survives a restart
+	serve(func(c *client) {
+		assert.Equal(t, "+OK\r\n", c.do("SET", "k", ""))
+		assert.Equal(t, bulk(""), c.do("GET", "k"))
+	})
+	serve(func(c *client) {
+		assert.Equal(t, bulk(""), c.do("GET", "k"))
+		assert.Equal(t, ":1\r\n", c.do("EXISTS", "k"))
+	})
+}
+
+func TestConcurrentClients(t *testing.T) {
+	srv, addr := startServer(t)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			c := dial(t, addr)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("c%d:%d", n, i)
+				assert.Equal(t, "+OK\r\n", c.do("SET", key, key))
+				assert.Equal(t, bulk(key), c.do("GET", key))
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	c := dial(t, addr)
+	assert.Equal(t, "*800", strings.SplitN(c.do("KEYS", "c*"), "\r\n", 2)[0])
+
+	// Close hangs up on connected clients
+	assert.NoError(t, srv.Close())
+	_, err := c.r.ReadByte()
+	assert.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/client"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// maxPasses is how many times a rebalance streams a range again for
+// keys that were written while they were being moved
+const maxPasses = 5
+
+// server is a connection to one of the ring's nodes, whose names are
+// their gRPC addresses
+type server struct {
+	conn  *grpc.ClientConn
+	kv    *client.Client
+	shard kvdbpb.ShardingClient
+}
+
+// Client routes each request to the server the ring says owns the key
+type Client struct {
+	opts []grpc.DialOption
+
+	mu      sync.RWMutex // Guards ring and servers
+	ring    *Ring
+	servers map[string]*server
+}
+
+// NewClient returns a client for the servers of ring, whose nodes are
+// gRPC addresses. Without options it connects in plaintext.
+func NewClient(ring *Ring, opts ...grpc.DialOption) (*Client, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	c := &Client{opts: opts, ring: ring, servers: make(map[string]*server)}
+	if err := c.connect(ring); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect connects to the nodes of ring the client isn't connected to
+// yet. mu must be held, or the client not yet shared.
+func (c *Client) connect(ring *Ring) error {
+	for _, node := range ring.Nodes() {
+		if c.servers[node] != nil {
+			continue
+		}
+		conn, err := grpc.NewClient(node, c.opts...)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", node, err)
+		}
+		c.servers[node] = &server{conn: conn, kv: client.New(conn), shard: kvdbpb.NewShardingClient(conn)}
+	}
+	return nil
+}
+
+// Close closes the connections to every server
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for node, s := range c.servers {
+		errs = append(errs, s.conn.Close())
+		delete(c.servers, node)
+	}
+	return errors.Join(errs...)
+}
+
+// Ring returns the ring the client routes by
+func (c *Client) Ring() *Ring {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring
+}
+
+// route returns the server that owns key
+func (c *Client) route(key string) (*client.Client, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := c.servers[c.ring.Owner(key)]
+	if s == nil {
+		return nil, errors.New("no servers on the ring")
+	}
+	return s.kv, nil
+}
+
+// Get returns the value of key
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	kv, err := c.route(key)
+	if err != nil {
+		return nil, err
+	}
+	return kv.Get(ctx, key)
+}
+
+// GetWithVersion returns the value of key along with its version on
+// the server that owns it
+func (c *Client) GetWithVersion(ctx context.Context, key string) ([]byte, int64, error) {
+	kv, err := c.route(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return kv.GetWithVersion(ctx, key)
+}
+
+// Put stores a key-value pair
+func (c *Client) Put(ctx context.Context, key string, value []byte) error {
+	return c.PutWithTTL(ctx, key, value, 0)
+}
+
+// PutWithTTL stores a key-value pair that expires after ttl, or never
+// if ttl isn't positive
+func (c *Client) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	kv, err := c.route(key)
+	if err != nil {
+		return err
+	}
+	return kv.PutWithTTL(ctx, key, value, ttl)
+}
+
+// Delete removes key, returning bitcask.ErrKeyNotFound if it doesn't
+// exist
+func (c *Client) Delete(ctx context.Context, key string) error {
+	kv, err := c.route(key)
+	if err != nil {
+		return err
+	}
+	return kv.Delete(ctx, key)
+}
+
+// RebalanceStats is what a rebalance did
+type RebalanceStats struct {
+	Ranges int // Hash ranges that changed owner
+	Moved  int // Keys moved to their new owner
+}
+
+// SetRing switches the client over to route by ring, connecting to
+// any new servers and closing the connections to those that left
+func (c *Client) SetRing(ring *Ring) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.connect(ring); err != nil {
+		return err
+	}
+	c.ring = ring
+	for node, s := range c.servers {
+		if !slices.Contains(ring.Nodes(), node) {
+			s.conn.Close()
+			delete(c.servers, node)
+		}
+	}
+	return nil
+}
+
+// Rebalance moves the keys whose owner is different on to than on
+// from. For each range that changed hands the old owner streams its
+// keys, and each is written to the new owner at the same version,
+// unless it has a newer one there, then deleted from the old one as
+// long as it wasn't written again in between. Keys that were are
+// picked up by streaming the range again. Versions are write times, so
+// which write wins relies on the servers' clocks agreeing.
+//
+// It doesn't change how the client routes requests. Writes made
+// through from while it runs, or before every client has switched to
+// to, can land on old owners after their keys moved, so running it
+// again once they have moves those too.
+func (c *Client) Rebalance(ctx context.Context, from, to *Ring) (RebalanceStats, error) {
+	c.mu.Lock()
+	err := c.connect(from)
+	if err == nil {
+		err = c.connect(to)
+	}
+	servers := make(map[string]*server, len(c.servers))
+	for node, s := range c.servers {
+		servers[node] = s
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return RebalanceStats{}, err
+	}
+
+	// One stream per pair of servers
+	type pair struct{ from, to string }
+	ranges := make(map[pair][]*kvdbpb.HashRange)
+	var pairs []pair
+	moves := from.Moves(to)
+	for _, m := range moves {
+		p := pair{m.From, m.To}
+		if ranges[p] == nil {
+			pairs = append(pairs, p)
+		}
+		ranges[p] = append(ranges[p], &kvdbpb.HashRange{Start: m.Range.Start, End: m.Range.End})
+	}
+
+	stats := RebalanceStats{Ranges: len(moves)}
+	for _, p := range pairs {
+		for pass := 1; ; pass++ {
+			moved, retry, err := moveRanges(ctx, servers[p.from], servers[p.to], ranges[p])
+			stats.Moved += moved
+			if err != nil {
+				return stats, fmt.Errorf("failed to move keys from %s to %s: %w", p.from, p.to, err)
+			}
+			if retry == 0 {
+				break
+			}
+			if pass == maxPasses {
+				return stats, fmt.Errorf("%d keys from %s to %s kept changing while being moved", retry, p.from, p.to)
+			}
+		}
+	}
+	return stats, nil
+}
+
+// moveRanges streams the keys in ranges from src and moves each to
+// dst. It returns how many were moved and how many were written on src
+// while being moved, so need moving again.
+func moveRanges(ctx context.Context, src, dst *server, ranges []*kvdbpb.HashRange) (moved, retry int, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each key comes in a message of its own however big its value, so
+	// it's only limited by what gRPC can send. The new owner's limit
+	// is what its MaxValueSize allows, see grpcapi.MaxMessageSize.
+	stream, err := src.shard.StreamRanges(ctx, &kvdbpb.StreamRangesRequest{Ranges: ranges},
+		grpc.MaxCallRecvMsgSize(math.MaxInt32))
+	if err != nil {
+		return 0, 0, err
+	}
+	for {
+		rec, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return moved, retry, nil
+		}
+		if err != nil {
+			return moved, retry, err
+		}
+
+		ok, err := moveKey(ctx, src, dst, rec)
+		if err != nil {
+			return moved, retry, err
+		}
+		if ok {
+			moved++
+		} else {
+			retry++
+		}
+	}
+}
+
+// moveKey writes a key to dst at the version it has on src, unless dst
+// has a newer one, and deletes it from src if it's still at that
+// version. It returns false if it was written on src in between and
+// needs moving again. If it was deleted on src instead, it's deleted
+// from dst too unless written there since.
+func moveKey(ctx context.Context, src, dst *server, rec *kvdbpb.ShardRecord) (bool, error) {
+	key := string(rec.Key)
+	if rec.Expires != 0 && time.Now().UnixNano() >= rec.Expires {
+		return true, nil // Expired on the way
+	}
+	if _, err := dst.shard.Ingest(ctx, &kvdbpb.IngestRequest{Records: []*kvdbpb.ShardRecord{rec}}); err != nil {
+		return false, err
+	}
+
+	var b client.Batch
+	b.Require(key, rec.Version)
+	b.Delete(key)
+	_, err := src.kv.Apply(ctx, &b)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, bitcask.ErrConflict) {
+		return false, err
+	}
+
+	// Written or deleted on src since it was streamed
+	if _, err := src.kv.Get(ctx, key); errors.Is(err, bitcask.ErrKeyNotFound) {
+		var undo client.Batch
+		undo.Require(key, rec.Version)
+		undo.Delete(key)
+		if _, err := dst.kv.Apply(ctx, &undo); err != nil && !errors.Is(err, bitcask.ErrConflict) {
+			return false, err
+		}
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,175 @@
+// Package sharding spreads keys over several kvdb servers, so the
+// dataset isn't bound by one machine's RAM. A consistent hash ring with
+// virtual nodes maps each key to a server, a client routes requests by
+// it, and a rebalance moves the keys whose server changes when one
+// joins or leaves.
+package sharding
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+)
+
+// DefaultVirtualNodes is how many points each server gets on the ring
+// unless told otherwise. More points spread keys more evenly.
+const DefaultVirtualNodes = 128
+
+// Hash returns where key falls on the ring: FNV-1a, with its bits
+// mixed so that similar keys land far apart
+func Hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+// mix is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// HashRange is the hashes after Start up to and including End. If Start
+// isn't below End the range wraps around past the largest hash, so
+// Start == End is the whole ring.
+type HashRange struct {
+	Start, End uint64
+}
+
+// Contains reports whether hash is in the range
+func (r HashRange) Contains(hash uint64) bool {
+	if r.Start < r.End {
+		return hash > r.Start && hash <= r.End
+	}
+	return hash > r.Start || hash <= r.End
+}
+
+// point is one of a node's virtual nodes
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent hash ring. A key belongs to the node of the
+// first point at or after its hash, so adding or removing a node only
+// moves the keys next to its points. Rings are never changed, With and
+// Without return new ones.
+type Ring struct {
+	vnodes int
+	nodes  []string // Sorted
+	points []point  // Sorted by hash
+}
+
+// NewRing returns a ring of nodes with vnodes points each, or
+// DefaultVirtualNodes if vnodes isn't positive
+func NewRing(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{vnodes: vnodes}
+	for _, node := range nodes {
+		if !slices.Contains(r.nodes, node) {
+			r.nodes = append(r.nodes, node)
+		}
+	}
+	slices.Sort(r.nodes)
+
+	for _, node := range r.nodes {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, point{hash: Hash(fmt.Sprintf("%s#%d", node, i)), node: node})
+		}
+	}
+	// Ties go the same way on every ring
+	slices.SortFunc(r.points, func(a, b point) int {
+		if a.hash != b.hash {
+			return cmp.Compare(a.hash, b.hash)
+		}
+		return cmp.Compare(a.node, b.node)
+	})
+	return r
+}
+
+// Nodes returns the nodes on the ring, sorted
+func (r *Ring) Nodes() []string {
+	return slices.Clone(r.nodes)
+}
+
+// VirtualNodes returns how many points each node has
+func (r *Ring) VirtualNodes() int {
+	return r.vnodes
+}
+
+// With returns the ring with node added
+func (r *Ring) With(node string) *Ring {
+	return NewRing(r.vnodes, append(r.Nodes(), node)...)
+}
+
+// Without returns the ring with node removed
+func (r *Ring) Without(node string) *Ring {
+	return NewRing(r.vnodes, slices.DeleteFunc(r.Nodes(), func(n string) bool { return n == node })...)
+}
+
+// Owner returns the node key belongs to, empty if the ring has none
+func (r *Ring) Owner(key string) string {
+	return r.ownerOf(Hash(key))
+}
+
+// ownerOf returns the node of the first point at or after hash
+func (r *Ring) ownerOf(hash uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0 // Wrap around
+	}
+	return r.points[i].node
+}
+
+// Move is a range of hashes whose keys belong to a different node on
+// another ring
+type Move struct {
+	Range    HashRange
+	From, To string
+}
+
+// Moves returns the ranges whose keys belong to a different node on to
+// than on r. Between two neighbouring points of either ring every hash
+// has the same owner on both, so it's enough to compare the owners at
+// each point.
+func (r *Ring) Moves(to *Ring) []Move {
+	if len(r.points) == 0 || len(to.points) == 0 {
+		return nil
+	}
+
+	var bounds []uint64
+	for _, p := range r.points {
+		bounds = append(bounds, p.hash)
+	}
+	for _, p := range to.points {
+		bounds = append(bounds, p.hash)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	var moves []Move
+	for i, end := range bounds {
+		start := bounds[(i+len(bounds)-1)%len(bounds)]
+		from, dest := r.ownerOf(end), to.ownerOf(end)
+		if from == dest {
+			continue
+		}
+		if n := len(moves); n > 0 && moves[n-1].From == from && moves[n-1].To == dest && moves[n-1].Range.End == start {
+			moves[n-1].Range.End = end
+			continue
+		}
+		moves = append(moves, Move{Range: HashRange{Start: start, End: end}, From: from, To: dest})
+	}
+	return moves
+}
@@ -0,0 +1,88 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi/kvdbpb"
+)
+
+// Server implements the Sharding service (see
+// grpcapi/kvdbpb/sharding.proto) over a server's database
+type Server struct {
+	kvdbpb.UnimplementedShardingServer
+	db *bitcask.Bitcask
+}
+
+// NewServer returns a server for db. The caller still owns db and
+// closes it after the gRPC server.
+func NewServer(db *bitcask.Bitcask) *Server {
+	return &Server{db: db}
+}
+
+// Register registers the Sharding service on gs
+func (s *Server) Register(gs *grpc.Server) {
+	kvdbpb.RegisterShardingServer(gs, s)
+}
+
+// StreamRanges sends every key whose hash is in one of the ranges. Only
+// the keys are hashed up front, values are read as they're sent.
+func (s *Server) StreamRanges(req *kvdbpb.StreamRangesRequest, stream grpc.ServerStreamingServer[kvdbpb.ShardRecord]) error {
+	ranges := make([]HashRange, len(req.Ranges))
+	for i, r := range req.Ranges {
+		ranges[i] = HashRange{Start: r.Start, End: r.End}
+	}
+
+	for _, key := range s.db.Keys() {
+		hash := Hash(key)
+		if !slices.ContainsFunc(ranges, func(r HashRange) bool { return r.Contains(hash) }) {
+			continue
+		}
+
+		value, version, err := s.db.GetWithVersion(key)
+		var expiry time.Time
+		if err == nil {
+			expiry, err = s.db.Expiry(key)
+		}
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			continue // Deleted or expired since the keys were listed
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		rec := &kvdbpb.ShardRecord{Key: []byte(key), Value: value, Version: version}
+		if !expiry.IsZero() {
+			rec.Expires = expiry.UnixNano()
+		}
+		if err := stream.Send(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ingest writes the records with their versions and expiry kept, so a
+// key's version is the time it was last written wherever it moves.
+// Those no newer than the key's version here are skipped. A read-only
+// database, such as a replication follower's, takes none.
+func (s *Server) Ingest(ctx context.Context, req *kvdbpb.IngestRequest) (*kvdbpb.IngestResponse, error) {
+	if s.db.ReadOnly() {
+		return nil, status.Error(codes.PermissionDenied, bitcask.ErrReadOnly.Error())
+	}
+	records := make([]bitcask.LogRecord, len(req.Records))
+	for i, rec := range req.Records {
+		records[i] = bitcask.LogRecord{Key: string(rec.Key), Value: rec.Value, Timestamp: rec.Version, Expires: rec.Expires}
+	}
+	if err := s.db.ApplyLogIfNewer(records); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &kvdbpb.IngestResponse{}, nil
+}
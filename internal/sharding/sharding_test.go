@@ -0,0 +1,264 @@
+package sharding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert"
+	"google.golang.org/grpc"
+
+	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/grpcapi"
+)
+
+func TestRingSpread(t *testing.T) {
+	ring := NewRing(0, "a", "b", "c", "d")
+	counts := make(map[string]int)
+	for i := 0; i < 100000; i++ {
+		counts[ring.Owner(fmt.Sprint("key", i))]++
+	}
+	assert.Equal(t, 4, len(counts))
+	for node, n := range counts {
+		assert.True(t, n > 18000 && n < 32000, "%s has %d of 100000 keys", node, n)
+	}
+
+	// The same nodes in any order make the same ring
+	other := NewRing(0, "d", "c", "b", "a", "a")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		assert.Equal(t, ring.Owner(key), other.Owner(key))
+	}
+	assert.Equal(t, "", NewRing(0).Owner("key"))
+}
+
+func TestRingMoves(t *testing.T) {
+	from := NewRing(16, "a", "b", "c")
+	for _, to := range []*Ring{from.With("d"), from.Without("b"), NewRing(16, "x"), from} {
+		moves := from.Moves(to)
+
+		// Keys move exactly when their owner changes, and only to or from
+		// the node that joined or left
+		changed := 0
+		for i := 0; i < 20000; i++ {
+			key := fmt.Sprint("key", i)
+			hash := Hash(key)
+			var move *Move
+			for j := range moves {
+				if moves[j].Range.Contains(hash) {
+					assert.True(t, move == nil, "%s is in two moves", key)
+					move = &moves[j]
+				}
+			}
+
+			owner, newOwner := from.Owner(key), to.Owner(key)
+			if owner == newOwner {
+				assert.True(t, move == nil, "%s moves from %s but stays put", key, owner)
+				continue
+			}
+			changed++
+			assert.True(t, move != nil, "%s moves from %s to %s outside any range", key, owner, newOwner)
+			assert.Equal(t, owner, move.From)
+			assert.Equal(t, newOwner, move.To)
+		}
+
+		if len(to.Nodes()) == 4 {
+			// About a quarter of the keys go to the new node
+			assert.True(t, changed > 3000 && changed < 7000, "%d of 20000 keys moved", changed)
+		}
+		if to == from {
+			assert.Equal(t, 0, len(moves))
+		}
+	}
+}
+
+// startServer serves a new database over gRPC on a loopback port,
+// with the message limit kvdb-server sets
+func startServer(t *testing.T) (*bitcask.Bitcask, string) {
+	t.Helper()
+
+	cfg := bitcask.DefaultConfig()
+	db, err := bitcask.Open(t.TempDir(), cfg)
+	assert.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	gs := grpc.NewServer(grpc.MaxRecvMsgSize(grpcapi.MaxMessageSize(cfg.MaxValueSize)))
+	grpcapi.New(db).Register(gs)
+	NewServer(db).Register(gs)
+	go gs.Serve(ln)
+	t.Cleanup(func() {
+		gs.Stop()
+		db.Close()
+	})
+	return db, ln.Addr().String()
+}
+
+// checkPlacement checks every key is only on the server that owns it
+func checkPlacement(t *testing.T, ring *Ring, dbs map[string]*bitcask.Bitcask, keys int) {
+	t.Helper()
+
+	total := 0
+	for node, db := range dbs {
+		for _, key := range db.Keys() {
+			owner := ring.Owner(key)
+			assert.Equal(t, owner, node, "%s is on %s", key, node)
+		}
+		total += len(db.Keys())
+	}
+	assert.Equal(t, keys, total)
+}
+
+func TestClient(t *testing.T) {
+	dbs := make(map[string]*bitcask.Bitcask)
+	var nodes []string
+	for i := 0; i < 4; i++ {
+		db, addr := startServer(t)
+		dbs[addr] = db
+		nodes = append(nodes, addr)
+	}
+
+	// Three servers to start with
+	ring := NewRing(32, nodes[:3]...)
+	c, err := NewClient(ring)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	const keys = 500
+	for i := 0; i < keys; i++ {
+		assert.NoError(t, c.Put(ctx, fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))))
+	}
+	assert.NoError(t, c.PutWithTTL(ctx, "temp", []byte("v"), time.Hour))
+	assert.NoError(t, c.Delete(ctx, "key000"))
+	err = c.Delete(ctx, "key000")
+	assert.True(t, errors.Is(err, bitcask.ErrKeyNotFound), "got %v", err)
+	checkPlacement(t, ring, map[string]*bitcask.Bitcask{nodes[0]: dbs[nodes[0]], nodes[1]: dbs[nodes[1]], nodes[2]: dbs[nodes[2]]}, keys)
+
+	// A fourth joins and takes its share, the TTL moving with the key
+	bigger := ring.With(nodes[3])
+	stats, err := c.Rebalance(ctx, ring, bigger)
+	assert.NoError(t, err)
+	assert.NoError(t, c.SetRing(bigger))
+	assert.True(t, stats.Moved > 0 && stats.Moved < keys/2, "moved %d keys", stats.Moved)
+	assert.Equal(t, len(dbs[nodes[3]].Keys()), stats.Moved)
+	checkPlacement(t, bigger, dbs, keys)
+	assert.Equal(t, bigger, c.Ring())
+
+	expiry, err := dbs[bigger.Owner("temp")].Expiry("temp")
+	assert.NoError(t, err)
+	assert.True(t, time.Until(expiry) > 59*time.Minute)
+
+	// Then the first leaves and hands its keys on
+	smaller := bigger.Without(nodes[0])
+	_, err = c.Rebalance(ctx, bigger, smaller)
+	assert.NoError(t, err)
+	assert.NoError(t, c.SetRing(smaller))
+	assert.Equal(t, 0, len(dbs[nodes[0]].Keys()))
+	checkPlacement(t, smaller, dbs, keys)
+
+	for i := 1; i < keys; i++ {
+		value, err := c.Get(ctx, fmt.Sprintf("key%03d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i), string(value))
+	}
+}
+
+func TestRebalanceBigValue(t *testing.T) {
+	a, addrA := startServer(t)
+	b, addrB := startServer(t)
+	from, to := NewRing(16, addrA), NewRing(16, addrA, addrB)
+
+	// Over gRPC's default limit of 4MB, on a key that moves
+	key := "key"
+	for i := 0; to.Owner(key) != addrB; i++ {
+		key = fmt.Sprint("key", i)
+	}
+	big := bytes.Repeat([]byte("x"), 5<<20)
+	assert.NoError(t, a.Put(key, big))
+
+	c, err := NewClient(from)
+	assert.NoError(t, err)
+	defer c.Close()
+	stats, err := c.Rebalance(context.Background(), from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Moved)
+
+	value, err := b.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, big, value)
+	assert.Equal(t, 0, len(a.Keys()))
+}
+
+func TestRebalanceWhileWriting(t *testing.T) {
+	dbs := make(map[string]*bitcask.Bitcask)
+	var nodes []string
+	for i := 0; i < 3; i++ {
+		db, addr := startServer(t)
+		dbs[addr] = db
+		nodes = append(nodes, addr)
+	}
+
+	ring := NewRing(32, nodes[:2]...)
+	c, err := NewClient(ring)
+	assert.NoError(t, err)
+	defer c.Close()
+	ctx := context.Background()
+	const keys = 300
+	for i := 0; i < keys; i++ {
+		assert.NoError(t, c.Put(ctx, fmt.Sprint("key", i), []byte("0")))
+	}
+
+	// Writes through the old ring carry on during the rebalance. Each
+	// lands on the old owner, which keeps it until it's moved.
+	writer, err := NewClient(ring)
+	assert.NoError(t, err)
+	defer writer.Close()
+	stop := make(chan struct{})
+	done := make(chan map[string]string)
+	go func() {
+		last := make(map[string]string)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				done <- last
+				return
+			default:
+			}
+			key := fmt.Sprint("key", rand.N(keys))
+			if writer.Put(ctx, key, []byte(fmt.Sprint(i))) == nil {
+				last[key] = fmt.Sprint(i)
+			}
+		}
+	}()
+
+	bigger := ring.With(nodes[2])
+	_, err = c.Rebalance(ctx, ring, bigger)
+	assert.NoError(t, err)
+	close(stop)
+	last := <-done
+
+	// Once the writer has switched over, writes that landed on old
+	// owners after their keys moved are swept up by a second rebalance
+	assert.NoError(t, writer.SetRing(bigger))
+	assert.NoError(t, c.SetRing(bigger))
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("key", i)
+		assert.NoError(t, writer.Put(ctx, key, []byte("new")))
+		last[key] = "new"
+	}
+	_, err = c.Rebalance(ctx, ring, bigger)
+	assert.NoError(t, err)
+
+	checkPlacement(t, bigger, dbs, keys)
+	for key, want := range last {
+		value, err := c.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(value), "%s", key)
+	}
+}
@@ -1,21 +1,29 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
-	"github.com/yashagw/kvdb/internal/bitcask"
+	"github.com/yashagw/kvdb/internal/engine"
 )
 
 func main() {
-	// Clean up any existing data
-	dbPath := "./my_database"
-	os.RemoveAll(dbPath)
+	engineName := flag.String("engine", "bitcask", "storage engine: "+strings.Join(engine.Names(), ", "))
+	flag.Parse()
+
+	// The demo works on a throwaway directory, use cmd/kvdb to work
+	// on a real one
+	dbPath, err := os.MkdirTemp("", "kvdb-demo-")
+	if err != nil {
+		log.Fatal("Failed to create directory:", err)
+	}
 	defer os.RemoveAll(dbPath)
 
 	// Open database
-	db, err := bitcask.Open(dbPath, bitcask.DefaultConfig())
+	db, err := engine.Open(*engineName, dbPath)
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
@@ -37,16 +45,18 @@ func main() {
 
 	// List all keys
 	fmt.Println("\nAll keys:")
-	keys := db.Keys()
-	for _, key := range keys {
-		fmt.Printf("- %s\n", key)
-	}
+	printKeys(db)
 
 	// Delete a key
 	db.Delete("age")
 	fmt.Println("\nAfter deleting 'age':")
-	keys = db.Keys()
-	for _, key := range keys {
+	printKeys(db)
+}
+
+// printKeys lists every key in order
+func printKeys(db engine.Engine) {
+	db.Scan("", "", func(key string, value []byte) bool {
 		fmt.Printf("- %s\n", key)
-	}
+		return true
+	})
 }